@@ -0,0 +1,208 @@
+// Package audit keeps an append-only JSON-lines record of actions taken
+// through ccc, optionally hash-chained so edits and deletions in the middle
+// of the log can be detected.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Entry is one audited action
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`            // telegram:@user, api:<agent>, terminal, ...
+	Command string    `json:"command"`          // /kill, /c, send, prompt, ...
+	Target  string    `json:"target,omitempty"` // Session or host acted on
+	Args    string    `json:"args,omitempty"`
+	Result  string    `json:"result"` // ok, error: ..., denied, ...
+	Prev    string    `json:"prev,omitempty"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// MaxArgs is the longest Args value stored
+const MaxArgs = 500
+
+// Log appends entries to a file
+type Log struct {
+	Path  string
+	Chain bool // Link each entry to the previous one with a SHA-256 hash
+}
+
+// Append writes e under an exclusive lock, so hook processes and the
+// listener can log at once without breaking the chain
+func (l Log) Append(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	if len(e.Args) > MaxArgs {
+		e.Args = e.Args[:MaxArgs] + "…"
+	}
+	e.Prev, e.Hash = "", ""
+
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	if l.Chain {
+		e.Prev = lastHash(f)
+		e.Hash = Hash(e)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Hash returns the chain hash of e: SHA-256 over the previous hash and the
+// entry without its own hash
+func Hash(e Entry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(append([]byte(e.Prev+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastHash returns the hash of the last chained entry in f ("" if none)
+func lastHash(f *os.File) string {
+	st, err := f.Stat()
+	if err != nil || st.Size() == 0 {
+		return ""
+	}
+	// Entries are short; look at the tail first and the whole file if the
+	// tail has no chained entry
+	for _, size := range []int64{64 << 10, st.Size()} {
+		if size > st.Size() {
+			size = st.Size()
+		}
+		buf := make([]byte, size)
+		if _, err := f.ReadAt(buf, st.Size()-size); err != nil && err != io.EOF {
+			return ""
+		}
+		lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+		for i := len(lines) - 1; i >= 0; i-- {
+			var e Entry
+			if json.Unmarshal(lines[i], &e) == nil && e.Hash != "" {
+				return e.Hash
+			}
+		}
+		if size == st.Size() {
+			break
+		}
+	}
+	return ""
+}
+
+// Read returns the last n entries of the log (all when n <= 0)
+func Read(path string, n int) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		entries = append(entries, e)
+		if n > 0 && len(entries) > 2*n {
+			entries = append(entries[:0], entries[len(entries)-n:]...)
+		}
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, scanner.Err()
+}
+
+// Verify checks the hash chain and returns the number of chained entries.
+// Entries written before the chain was turned on are skipped. The first
+// chained entry must start a new chain, so removing the entries before it is
+// detected, and every entry after it must be chained.
+func Verify(path string) (int, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	chained := 0
+	prev := ""
+	line := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line++
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return chained, fmt.Errorf("line %d: not an audit entry", line)
+		}
+		if e.Hash == "" {
+			if chained > 0 {
+				return chained, fmt.Errorf("line %d: entry is not chained (it was added by hand or the chain was turned off)", line)
+			}
+			continue
+		}
+		if chained == 0 && e.Prev != "" {
+			return chained, fmt.Errorf("line %d: chain does not start here (entries before it were removed)", line)
+		}
+		if chained > 0 && e.Prev != prev {
+			return chained, fmt.Errorf("line %d: chain broken (an entry before it was changed or removed)", line)
+		}
+		if Hash(e) != e.Hash {
+			return chained, fmt.Errorf("line %d: entry was modified", line)
+		}
+		prev = e.Hash
+		chained++
+	}
+	return chained, scanner.Err()
+}
+
+// Format renders an entry as one line:
+// "2026-03-10 14:02:11 telegram:@alice /kill myproject → ok"
+func (e Entry) Format() string {
+	var b strings.Builder
+	b.WriteString(e.Time.Local().Format("2006-01-02 15:04:05"))
+	b.WriteString(" " + e.Actor + " " + e.Command)
+	if e.Target != "" {
+		b.WriteString(" " + e.Target)
+	}
+	if e.Args != "" {
+		args := strings.ReplaceAll(e.Args, "\n", " ⏎ ")
+		if len(args) > 80 {
+			args = args[:80] + "…"
+		}
+		fmt.Fprintf(&b, " %q", args)
+	}
+	b.WriteString(" → " + e.Result)
+	return b.String()
+}
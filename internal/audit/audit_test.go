@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := Log{Path: path}
+	l.Append(Entry{Actor: "telegram:@alice", Command: "/kill", Target: "web", Result: "ok"})
+	l.Append(Entry{Actor: "api:ci", Command: "send", Target: "web", Args: strings.Repeat("x", MaxArgs+10), Result: "ok"})
+	l.Append(Entry{Actor: "terminal", Command: "prompt", Target: "api", Result: "ok"})
+
+	all, err := Read(path, 0)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Read() = %d entries, %v", len(all), err)
+	}
+	if all[0].Actor != "telegram:@alice" || all[0].Time.IsZero() || all[0].Hash != "" {
+		t.Errorf("first entry = %+v", all[0])
+	}
+	if len(all[1].Args) != MaxArgs+len("…") {
+		t.Errorf("args not capped: %d bytes", len(all[1].Args))
+	}
+	last, _ := Read(path, 2)
+	if len(last) != 2 || last[1].Command != "prompt" {
+		t.Errorf("Read(2) = %+v", last)
+	}
+
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode = %v", st.Mode().Perm())
+	}
+
+	e := Entry{Time: time.Date(2026, 3, 10, 14, 2, 11, 0, time.Local), Actor: "telegram:@alice", Command: "/c", Args: "ls\n-la", Result: "ok"}
+	if got := e.Format(); got != `2026-03-10 14:02:11 telegram:@alice /c "ls ⏎ -la" → ok` {
+		t.Errorf("Format() = %s", got)
+	}
+}
+
+func TestHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	Log{Path: path}.Append(Entry{Actor: "terminal", Command: "prompt", Result: "ok"}) // Before the chain was turned on
+	l := Log{Path: path, Chain: true}
+	for _, cmd := range []string{"/kill", "/c", "/update"} {
+		if err := l.Append(Entry{Actor: "telegram:@alice", Command: cmd, Result: "ok"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := Verify(path); n != 3 || err != nil {
+		t.Fatalf("Verify() = %d, %v", n, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	// Editing an entry
+	tampered := bytes.Replace(data, []byte(`"/c"`), []byte(`"/ls"`), 1)
+	os.WriteFile(path, tampered, 0600)
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 3: entry was modified") {
+		t.Errorf("Verify() after edit = %v", err)
+	}
+
+	// Removing an entry
+	removed := append(append(append([]byte{}, lines[0]...), '\n'), bytes.Join([][]byte{lines[1], lines[3]}, []byte("\n"))...)
+	os.WriteFile(path, append(removed, '\n'), 0600)
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "chain broken") {
+		t.Errorf("Verify() after removal = %v", err)
+	}
+
+	// Removing the first chained entries
+	os.WriteFile(path, append(bytes.Join([][]byte{lines[0], lines[2], lines[3]}, []byte("\n")), '\n'), 0600)
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 2: chain does not start here") {
+		t.Errorf("Verify() after prefix removal = %v", err)
+	}
+
+	// Adding an unchained entry after the chain started
+	os.WriteFile(path, data, 0600)
+	Log{Path: path}.Append(Entry{Actor: "terminal", Command: "prompt", Result: "ok"})
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "line 5: entry is not chained") {
+		t.Errorf("Verify() after unchained entry = %v", err)
+	}
+}
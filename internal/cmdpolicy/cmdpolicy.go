@@ -0,0 +1,626 @@
+// Package cmdpolicy decides whether a shell command sent through /c or /rc
+// may run: allow and deny patterns, a working-directory jail, per-role
+// limits and patterns that need confirmation first.
+package cmdpolicy
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Local is the host name of commands run with /c
+const Local = "local"
+
+// DefaultDangerous are the patterns that need confirmation when the policy
+// sets none
+var DefaultDangerous = []string{
+	`\brm\s+(-\w+\s+)*-\w*[rf]`,
+	`\bsudo\b`,
+	`\b(shutdown|reboot|halt|poweroff)\b`,
+	`\bmkfs\b`,
+	`\bdd\b.*\bof=`,
+	`\bgit\s+push\b.*(\s-f\b|--force)`,
+	`\bgit\s+(reset\s+--hard|clean\s+-\w*f)`,
+	`\b(chmod|chown)\s+-R\b`,
+	`\bkill(all)?\s+-9\b`,
+	`(?i)\bdrop\s+(table|database)\b`,
+}
+
+// DefaultEnv are the environment variables kept when the environment is
+// scrubbed
+var DefaultEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "TERM", "TZ"}
+
+// Role limits what the users assigned to it may run
+type Role struct {
+	Allow     []string      // Replaces the global allow list when set
+	Deny      []string      // Added to the global deny list
+	Hosts     []string      // Hosts usable with /rc, Local for /c (empty: all)
+	Jail      string        // Overrides the global jail
+	MaxOutput int           // Overrides the global output cap
+	Timeout   time.Duration // Overrides the global timeout
+	PerHour   int           // Commands per hour per user (0: no limit)
+}
+
+// Options configures a Policy
+type Options struct {
+	Allow     []string // When set, every command in a chain must start with a match
+	Deny      []string // Commands containing a match are rejected
+	Dangerous []string // Commands containing a match need confirmation (nil: DefaultDangerous)
+	Jail      string   // Directory commands run in and may not name paths outside of
+	KeepEnv   []string // Variables kept besides DefaultEnv
+	MaxOutput int      // Output cap in bytes (0: none)
+	Timeout   time.Duration
+	Roles     map[string]Role
+}
+
+// pattern is a compiled rule with its source for messages
+type pattern struct {
+	src string
+	re  *regexp.Regexp
+}
+
+type role struct {
+	Role
+	allow, deny []pattern
+}
+
+// Policy checks commands against the configured rules
+type Policy struct {
+	allow, deny, dangerous []pattern
+	jail                   string
+	keepEnv                []string
+	maxOutput              int
+	timeout                time.Duration
+	roles                  map[string]*role
+
+	mu   sync.Mutex
+	runs map[string][]time.Time // user -> recent runs, for PerHour
+}
+
+// New compiles opts. Any invalid pattern is an error: a policy with a
+// skipped deny rule would allow more than intended.
+func New(opts Options) (*Policy, error) {
+	p := &Policy{
+		jail:      cleanJail(opts.Jail),
+		maxOutput: opts.MaxOutput,
+		timeout:   opts.Timeout,
+		roles:     map[string]*role{},
+		runs:      map[string][]time.Time{},
+	}
+	var errs []string
+	compile := func(kind string, srcs []string, anchor bool) []pattern {
+		var out []pattern
+		for _, src := range srcs {
+			expr := src
+			if anchor {
+				expr = `^\s*(?:` + src + `)`
+			}
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s pattern %q: %v", kind, src, err))
+				continue
+			}
+			out = append(out, pattern{src, re})
+		}
+		return out
+	}
+	p.allow = compile("allow", opts.Allow, true)
+	p.deny = compile("deny", opts.Deny, false)
+	dangerous := opts.Dangerous
+	if dangerous == nil {
+		dangerous = DefaultDangerous
+	}
+	p.dangerous = compile("dangerous", dangerous, false)
+	for name, r := range opts.Roles {
+		cr := &role{Role: r}
+		cr.Jail = cleanJail(r.Jail)
+		cr.allow = compile("role "+name+" allow", r.Allow, true)
+		cr.deny = compile("role "+name+" deny", r.Deny, false)
+		p.roles[name] = cr
+	}
+	for _, name := range opts.KeepEnv {
+		if !envNameRe.MatchString(name) {
+			errs = append(errs, fmt.Sprintf("keep_env %q is not a variable name", name))
+			continue
+		}
+		p.keepEnv = append(p.keepEnv, name)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return p, nil
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func cleanJail(jail string) string {
+	if jail == "" {
+		return ""
+	}
+	return filepath.Clean(jail)
+}
+
+// Decision is the outcome of Check
+type Decision struct {
+	Allowed   bool
+	Confirm   bool   // Matched a dangerous pattern: run only once the user confirms
+	Rule      string // The rule that rejected the command or asks for confirmation
+	Jail      string // Directory to run in ("" for the default)
+	MaxOutput int
+	Timeout   time.Duration
+}
+
+// Check decides whether command may run on host ("local" for /c) for a user
+// with the given role ("" for the global rules only)
+func (p *Policy) Check(roleName string, host string, command string) Decision {
+	d := Decision{Jail: p.jail, MaxOutput: p.maxOutput, Timeout: p.timeout}
+	allow, deny := p.allow, p.deny
+	if roleName != "" {
+		r := p.roles[roleName]
+		if r == nil {
+			d.Rule = fmt.Sprintf("role %q is not defined", roleName)
+			return d
+		}
+		if len(r.Hosts) > 0 && !contains(r.Hosts, host) {
+			d.Rule = fmt.Sprintf("role %s may not run commands on %s (allowed: %s)", roleName, host, strings.Join(r.Hosts, ", "))
+			return d
+		}
+		if len(r.allow) > 0 {
+			allow = r.allow
+		}
+		deny = append(deny[:len(deny):len(deny)], r.deny...)
+		if r.Jail != "" {
+			d.Jail = r.Jail
+		}
+		if r.MaxOutput > 0 {
+			d.MaxOutput = r.MaxOutput
+		}
+		if r.Timeout > 0 {
+			d.Timeout = r.Timeout
+		}
+	}
+
+	for _, pt := range deny {
+		if pt.re.MatchString(command) {
+			d.Rule = fmt.Sprintf("deny pattern %q", pt.src)
+			return d
+		}
+	}
+	tokens := lex(command)
+	if len(allow) > 0 {
+		for _, sub := range []string{"$(", "`", "<(", ">("} {
+			if strings.Contains(command, sub) {
+				d.Rule = "command substitution is not allowed with an allow list"
+				return d
+			}
+		}
+		for _, tok := range tokens {
+			if tok.kind == target && tok.write && tok.text != "/dev/null" {
+				d.Rule = fmt.Sprintf("redirecting output to %s is not allowed with an allow list", tok.raw)
+				return d
+			}
+		}
+		for _, seg := range segments(tokens) {
+			if !matchAny(allow, seg) {
+				d.Rule = fmt.Sprintf("%q matches no allow pattern", seg)
+				return d
+			}
+		}
+	}
+	if d.Jail != "" {
+		if cd := leavesJail(tokens); cd != "" {
+			d.Rule = fmt.Sprintf("jail %s: %q may leave it (cd needs a directory inside the jail)", d.Jail, cd)
+			return d
+		}
+		if path, variable := outsideJail(tokens, d.Jail); variable {
+			d.Rule = fmt.Sprintf("jail %s: %s uses a variable, which may name a path outside of it", d.Jail, path)
+			return d
+		} else if path != "" {
+			d.Rule = fmt.Sprintf("jail %s: %s is outside of it", d.Jail, path)
+			return d
+		}
+	}
+
+	d.Allowed = true
+	for _, pt := range p.dangerous {
+		if pt.re.MatchString(command) {
+			d.Confirm = true
+			d.Rule = fmt.Sprintf("dangerous pattern %q", pt.src)
+			break
+		}
+	}
+	return d
+}
+
+// Spend counts a command run by user against the hourly limit of their
+// role and returns an error once the limit is reached
+func (p *Policy) Spend(user string, roleName string, now time.Time) error {
+	r := p.roles[roleName]
+	if r == nil || r.PerHour <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	recent := p.runs[user][:0]
+	for _, t := range p.runs[user] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= r.PerHour {
+		p.runs[user] = recent
+		return fmt.Errorf("role %s: limit of %d commands per hour reached", roleName, r.PerHour)
+	}
+	p.runs[user] = append(recent, now)
+	return nil
+}
+
+// Segments splits a command line into the simple commands of its chain
+// (separated by ;, &&, ||, |, & and newlines), without their redirections
+func Segments(command string) []string {
+	return segments(lex(command))
+}
+
+func segments(tokens []token) []string {
+	var out []string
+	var words []string
+	flush := func() {
+		if len(words) > 0 {
+			out = append(out, strings.Join(words, " "))
+			words = nil
+		}
+	}
+	for _, tok := range tokens {
+		switch tok.kind {
+		case word:
+			words = append(words, tok.raw)
+		case separator:
+			flush()
+		}
+	}
+	flush()
+	return out
+}
+
+// leavesJail returns the first directory change that may leave the jail
+// without naming a path: cd without a directory (to $HOME), cd - (to
+// $OLDPWD), pushd without one and popd. "" if there is none.
+func leavesJail(tokens []token) string {
+	var words []string
+	check := func() string {
+		if len(words) == 0 {
+			return ""
+		}
+		switch words[0] {
+		case "cd", "pushd":
+			var dirs []string
+			for _, w := range words[1:] {
+				if w == "-" || !strings.HasPrefix(w, "-") {
+					dirs = append(dirs, w)
+				}
+			}
+			if len(dirs) == 0 || dirs[0] == "-" {
+				return strings.Join(words, " ")
+			}
+		case "popd":
+			return strings.Join(words, " ")
+		}
+		return ""
+	}
+	for _, tok := range tokens {
+		switch tok.kind {
+		case word:
+			words = append(words, tok.text)
+		case separator:
+			if cd := check(); cd != "" {
+				return cd
+			}
+			words = nil
+		}
+	}
+	return check()
+}
+
+// outsideJail returns the first path named by the command that lies outside
+// jail ("" if none). Paths starting with ~ count as outside, as do the
+// values of options written together with them (-C/etc, --dir=/etc). Words
+// using a variable can name any path: they are returned with variable set.
+func outsideJail(tokens []token, jail string) (path string, variable bool) {
+	for _, tok := range tokens {
+		if tok.kind != word && tok.kind != target || tok.text == "/dev/null" {
+			continue
+		}
+		if tok.variable {
+			return tok.raw, true
+		}
+		candidates := []string{tok.text}
+		if _, value, ok := strings.Cut(tok.text, "="); ok {
+			candidates = append(candidates, value)
+		}
+		if len(tok.text) > 2 && tok.text[0] == '-' && tok.text[1] != '-' {
+			candidates = append(candidates, tok.text[2:])
+		}
+		for _, c := range candidates {
+			if strings.HasPrefix(c, "~") {
+				return c, false
+			}
+			var path string
+			switch {
+			case filepath.IsAbs(c):
+				path = filepath.Clean(c)
+			case c == ".." || strings.HasPrefix(c, "../") || strings.Contains(c, "/../") || strings.HasSuffix(c, "/.."):
+				path = filepath.Join(jail, c)
+			default:
+				continue
+			}
+			if path != jail && !strings.HasPrefix(path, jail+"/") {
+				return c, false
+			}
+		}
+	}
+	return "", false
+}
+
+// Token kinds
+const (
+	word      = iota
+	separator // ;, &&, ||, |, |&, &, newline, ( and )
+	redirect  // >, >>, 2>, &>, <, <<, >&, ...
+	target    // The word a redirection applies to
+)
+
+// token is a shell word or operator
+type token struct {
+	kind     int
+	raw      string // As written, quotes included
+	text     string // With quotes and escapes removed
+	variable bool   // Contains $ outside single quotes
+	write    bool   // Target of an output redirection to a file
+}
+
+// lex splits a command line into words, control operators and redirections
+// the way a POSIX shell would, closely enough to check paths and chains
+func lex(command string) []token {
+	var tokens []token
+	var cur *token
+	var raw, text strings.Builder
+	pending := -1 // Kind of the next word: target after a redirection
+	writes := false
+	endWord := func() {
+		if cur == nil {
+			return
+		}
+		cur.raw, cur.text = raw.String(), text.String()
+		if pending == target {
+			cur.kind, cur.write = target, writes
+			redir := strings.TrimLeft(tokens[len(tokens)-1].raw, "0123456789")
+			// >&2 and <&- duplicate or close descriptors rather than name files
+			if strings.HasSuffix(redir, "&") && isFD(cur.text) {
+				cur.write = false
+			}
+			if strings.HasPrefix(redir, "<<") && redir != "<<<" {
+				cur.kind = redirect // Here-document delimiter
+			}
+			pending = -1
+		}
+		tokens = append(tokens, *cur)
+		cur = nil
+		raw.Reset()
+		text.Reset()
+	}
+	op := func(kind int, s string) {
+		endWord()
+		if kind == separator {
+			pending = -1
+		}
+		tokens = append(tokens, token{kind: kind, raw: s, text: s})
+	}
+	startWord := func() {
+		if cur == nil {
+			cur = &token{kind: word}
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := func(k int) rune {
+			if i+k < len(runes) {
+				return runes[i+k]
+			}
+			return 0
+		}
+		switch {
+		case r == ' ' || r == '\t':
+			endWord()
+		case r == '\n' || r == ';' || r == '(' || r == ')':
+			op(separator, string(r))
+		case r == '|':
+			if n := next(1); n == '|' || n == '&' {
+				op(separator, string(r)+string(n))
+				i++
+			} else {
+				op(separator, "|")
+			}
+		case r == '&' && next(1) == '&':
+			op(separator, "&&")
+			i++
+		case r == '&' && next(1) == '>':
+			s := "&>"
+			i++
+			if next(1) == '>' {
+				s += ">"
+				i++
+			}
+			op(redirect, s)
+			pending, writes = target, true
+		case r == '&':
+			op(separator, "&")
+		case r == '>' || r == '<' || cur == nil && fdRedirect(runes[i:]):
+			s, write := redirection(runes[i:])
+			i += len(s) - 1 // Operators are ASCII
+			if next(1) == '(' {
+				// Process substitution: its commands are checked like a subshell's
+				op(separator, s)
+				continue
+			}
+			op(redirect, s)
+			pending, writes = target, write
+		case r == '\'':
+			startWord()
+			j := i + 1
+			for j < len(runes) && runes[j] != '\'' {
+				j++
+			}
+			raw.WriteString(string(runes[i:min(j+1, len(runes))]))
+			text.WriteString(string(runes[i+1 : min(j, len(runes))]))
+			i = j
+		case r == '"':
+			startWord()
+			raw.WriteRune(r)
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					raw.WriteRune(runes[i])
+					i++
+				} else if runes[i] == '$' && i+1 < len(runes) && expands(runes[i+1]) {
+					cur.variable = true
+				}
+				raw.WriteRune(runes[i])
+				text.WriteRune(runes[i])
+			}
+			if i < len(runes) {
+				raw.WriteRune('"')
+			}
+		case r == '\\' && i+1 < len(runes):
+			startWord()
+			raw.WriteRune(r)
+			raw.WriteRune(runes[i+1])
+			text.WriteRune(runes[i+1])
+			i++
+		default:
+			startWord()
+			if r == '$' && expands(next(1)) {
+				cur.variable = true
+			}
+			raw.WriteRune(r)
+			text.WriteRune(r)
+		}
+	}
+	endWord()
+	return tokens
+}
+
+// redirection returns the redirection operator s starts with, including a
+// leading descriptor number, and whether it writes to its target
+func redirection(s []rune) (string, bool) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	rest := string(s[i:])
+	for _, op := range []string{">>", ">|", ">&", "<<<", "<<-", "<<", "<&", "<>", ">", "<"} {
+		if strings.HasPrefix(rest, op) {
+			return string(s[:i]) + op, strings.Contains(op, ">")
+		}
+	}
+	return string(s[:i]), false
+}
+
+// fdRedirect reports whether s starts with a file descriptor number
+// followed by a redirection, as in 2>err.log
+func fdRedirect(s []rune) bool {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return i > 0 && i < len(s) && (s[i] == '>' || s[i] == '<')
+}
+
+// expands reports whether $ followed by r starts an expansion
+func expands(r rune) bool {
+	return r == '_' || r == '{' || r == '(' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isFD(s string) bool {
+	if s == "-" {
+		return true
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Env returns the entries of environ (KEY=value) kept by the policy. The
+// result is never nil, so exec.Cmd does not fall back to the full
+// environment.
+func (p *Policy) Env(environ []string) []string {
+	keep := append(append([]string(nil), DefaultEnv...), p.keepEnv...)
+	out := []string{}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if contains(keep, name) {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// RemoteCommand wraps command for a remote shell so it runs in jail with a
+// scrubbed environment
+func (p *Policy) RemoteCommand(command string, jail string) string {
+	var b strings.Builder
+	if rest, ok := strings.CutPrefix(jail, "~/"); ok {
+		fmt.Fprintf(&b, "cd ~/%s && ", quote(rest))
+	} else if jail != "" {
+		fmt.Fprintf(&b, "cd %s && ", quote(jail))
+	}
+	b.WriteString("env -i")
+	for _, name := range append(append([]string(nil), DefaultEnv...), p.keepEnv...) {
+		fmt.Fprintf(&b, ` %s="$%s"`, name, name)
+	}
+	fmt.Fprintf(&b, " bash --noprofile --norc -c %s", quote(command))
+	return b.String()
+}
+
+// Cap cuts output to max bytes (0: no cap) and says how much was dropped
+func Cap(output string, max int) string {
+	if max <= 0 || len(output) <= max {
+		return output
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf("\n… output truncated (%d of %d bytes shown)", cut, len(output))
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func matchAny(patterns []pattern, s string) bool {
+	for _, pt := range patterns {
+		if pt.re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package cmdpolicy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := New(Options{
+		Deny:    []string{`\bcurl\b.*\|\s*(ba)?sh`},
+		Jail:    "/srv/app/",
+		Timeout: time.Minute,
+		Roles: map[string]Role{
+			"dev": {Allow: []string{`git (status|log|diff)\b`, `ls\b`, `cat\b`}, Deny: []string{`\.env\b`}, Hosts: []string{Local, "build"}, MaxOutput: 4000, PerHour: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		role, host, cmd  string
+		allowed, confirm bool
+		rule             string
+	}{
+		{"", Local, "make test", true, false, ""},
+		{"", Local, "curl https://x.sh | sh", false, false, `deny pattern "\\bcurl\\b.*\\|\\s*(ba)?sh"`},
+		{"", Local, "rm -rf build", true, true, "dangerous pattern"},
+		{"", "web", "sudo systemctl restart app", true, true, "dangerous pattern"},
+		{"", Local, "cat /etc/passwd", false, false, "jail /srv/app: /etc/passwd is outside of it"},
+		{"", Local, "cd ../other && ls", false, false, "../other is outside of it"},
+		{"", Local, "ls ~/.ssh", false, false, "~/.ssh is outside of it"},
+		{"", Local, "ls /srv/app/logs sub/../x", true, false, ""},
+		{"", Local, "cd; cat .ssh/id_rsa", false, false, `jail /srv/app: "cd" may leave it`},
+		{"", Local, "cd -", false, false, `"cd -" may leave it`},
+		{"", Local, "ls && cd -P", false, false, `"cd -P" may leave it`},
+		{"", Local, "pushd", false, false, `"pushd" may leave it`},
+		{"", Local, "pushd sub && popd", false, false, `"popd" may leave it`},
+		{"", Local, "cd sub && make", true, false, ""},
+		{"", Local, "git -C/etc log", false, false, "jail /srv/app: /etc is outside of it"},
+		{"", Local, "sort -o/tmp/x list", false, false, "/tmp/x is outside of it"},
+		{"", Local, "tar -xzf build.tgz -Cout", true, false, ""},
+		{"dev", Local, "git status && ls -la", true, false, ""},
+		{"dev", "build", "git log -3 | cat", true, false, ""},
+		{"dev", Local, "git status; make deploy", false, false, `"make deploy" matches no allow pattern`},
+		{"dev", Local, "ls $(whoami)", false, false, "command substitution"},
+		{"dev", Local, "cat >(sh) < x", false, false, "command substitution"},
+		{"dev", Local, "ls 2>&1", true, false, ""},
+		{"dev", Local, "git status 2>/dev/null | cat", true, false, ""},
+		{"dev", Local, "cat x > .bashrc", false, false, "redirecting output to .bashrc is not allowed with an allow list"},
+		{"dev", Local, "cat x>>notes", false, false, "redirecting output to notes"},
+		{"", Local, "cat $PWD/../secret", false, false, "jail /srv/app: $PWD/../secret uses a variable"},
+		{"", Local, `cat "${HOME}"/.ssh/id_rsa`, false, false, "uses a variable"},
+		{"", Local, "grep -c 'x$' log 2>&1", true, false, ""},
+		{"", Local, "make > /tmp/out", false, false, "/tmp/out is outside of it"},
+		{"", Local, "cat < /etc/shadow", false, false, "/etc/shadow is outside of it"},
+		{"dev", Local, "cat .env", false, false, `deny pattern "\\.env\\b"`},
+		{"dev", "prod", "ls", false, false, "role dev may not run commands on prod (allowed: local, build)"},
+		{"ops", Local, "ls", false, false, `role "ops" is not defined`},
+	}
+	for _, c := range cases {
+		d := p.Check(c.role, c.host, c.cmd)
+		if d.Allowed != c.allowed || d.Confirm != c.confirm || !strings.Contains(d.Rule, c.rule) {
+			t.Errorf("Check(%q, %q, %q) = %+v", c.role, c.host, c.cmd, d)
+		}
+	}
+
+	if d := p.Check("dev", Local, "ls"); d.MaxOutput != 4000 || d.Timeout != time.Minute || d.Jail != "/srv/app" {
+		t.Errorf("dev limits = %+v", d)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	cases := map[string]string{
+		"ls 2>&1 | grep x":             "ls|grep x",
+		"make &> log && echo 'a;b'":    "make|echo 'a;b'",
+		"cat <<EOF\nhi\nEOF":           "cat|hi|EOF",
+		`echo "x | y" >&2; git status`: `echo "x | y"|git status`,
+	}
+	for in, want := range cases {
+		if got := strings.Join(Segments(in), "|"); got != want {
+			t.Errorf("Segments(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSpend(t *testing.T) {
+	p, _ := New(Options{Roles: map[string]Role{"dev": {PerHour: 2}}})
+	now := time.Now()
+	if p.Spend("alice", "dev", now) != nil || p.Spend("alice", "dev", now.Add(time.Minute)) != nil {
+		t.Fatal("first two commands rejected")
+	}
+	if err := p.Spend("alice", "dev", now.Add(2*time.Minute)); err == nil || err.Error() != "role dev: limit of 2 commands per hour reached" {
+		t.Errorf("third command: %v", err)
+	}
+	if p.Spend("bob", "dev", now) != nil {
+		t.Error("limit is per user")
+	}
+	if p.Spend("alice", "dev", now.Add(61*time.Minute)) != nil {
+		t.Error("limit did not reset after an hour")
+	}
+	if p.Spend("alice", "", now) != nil {
+		t.Error("no role, no limit")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Options{Deny: []string{"("}}); err == nil {
+		t.Error("New() accepted an invalid deny pattern")
+	}
+	if _, err := New(Options{KeepEnv: []string{"A;rm"}}); err == nil {
+		t.Error("New() accepted an invalid variable name")
+	}
+	p, _ := New(Options{Dangerous: []string{}})
+	if d := p.Check("", Local, "rm -rf /tmp/x"); d.Confirm {
+		t.Error("an empty dangerous list still asked for confirmation")
+	}
+}
+
+func TestEnvAndRemote(t *testing.T) {
+	p, _ := New(Options{KeepEnv: []string{"GOPATH"}})
+	env := p.Env([]string{"PATH=/bin", "AWS_SECRET_ACCESS_KEY=x", "GOPATH=/go", "HOME=/root", "TELEGRAM_TOKEN=y"})
+	if strings.Join(env, " ") != "PATH=/bin GOPATH=/go HOME=/root" {
+		t.Errorf("Env() = %v", env)
+	}
+	got := p.RemoteCommand("echo 'hi'", "~/app")
+	if !strings.HasPrefix(got, "cd ~/'app' && env -i PATH=\"$PATH\" HOME=\"$HOME\"") || !strings.HasSuffix(got, ` GOPATH="$GOPATH" bash --noprofile --norc -c 'echo '\''hi'\'''`) {
+		t.Errorf("RemoteCommand() = %s", got)
+	}
+}
+
+func TestCap(t *testing.T) {
+	if Cap("short", 10) != "short" || Cap("anything", 0) != "anything" {
+		t.Error("Cap() changed output under the limit")
+	}
+	if got := Cap("ééé", 3); got != "é\n… output truncated (2 of 6 bytes shown)" {
+		t.Errorf("Cap() = %q", got)
+	}
+}
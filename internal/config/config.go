@@ -6,6 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"github.com/kidandcat/ccc/internal/secrets"
 )
 
 // SessionInfo stores information about a session
@@ -14,6 +17,134 @@ type SessionInfo struct {
 	Path    string `json:"path"`
 	Host    string `json:"host,omitempty"`    // Remote host name or "" for local
 	Deleted bool   `json:"deleted,omitempty"` // Soft-deleted (killed but topic preserved)
+
+	Archived bool `json:"archived,omitempty"` // Topic closed and history compressed by /archive
+
+	LiveProgress    *bool `json:"live_progress,omitempty"`     // Per-session override of Config.LiveProgress
+	StatusMessageID int   `json:"status_message_id,omitempty"` // Pinned status message in the topic
+
+	IdleTimeoutHours *int `json:"idle_timeout_hours,omitempty"` // Per-session override of Config.IdleTimeoutHours (0 = never)
+	Sleeping         bool `json:"sleeping,omitempty"`           // Stopped for being idle; the next message restarts it
+
+	AutoRestart *RestartPolicy `json:"auto_restart,omitempty"` // Replaces Config.AutoRestart for this session
+
+	Tags        []string `json:"tags,omitempty"`        // Labels for selecting groups of sessions (tag:<name>)
+	Description string   `json:"description,omitempty"` // Free-text note shown in /list and /status
+
+	Container   bool   `json:"container,omitempty"`    // Run Claude inside a container (see Config.Container)
+	ContainerID string `json:"container_id,omitempty"` // Last container started for the session
+
+	Record bool `json:"record,omitempty"` // Record the terminal to ~/.ccc/recordings (asciicast v2)
+
+	Vocabulary   string `json:"vocabulary,omitempty"`    // Transcription prompt for this session's voice messages
+	VoiceReplies *bool  `json:"voice_replies,omitempty"` // Per-session override of spoken replies (see Config.VoiceReplies)
+}
+
+// Pipeline forwards finished turns of some sessions to another session
+type Pipeline struct {
+	Name     string `json:"name"`
+	From     string `json:"from"`               // Source sessions: names, globs, host:<name>, tag:<name>
+	To       string `json:"to"`                 // Target session
+	Match    string `json:"match,omitempty"`    // Regular expression the response must match (default: any)
+	Template string `json:"template,omitempty"` // Prompt for the target: {session}, {response}, {match}, {1}..{9}
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// RestartPolicy controls automatic restarts of crashed Claude processes
+type RestartPolicy struct {
+	Disabled          bool `json:"disabled,omitempty"`
+	MaxRestarts       int  `json:"max_restarts,omitempty"`        // Restarts within the window before giving up (default: 5)
+	WindowMinutes     int  `json:"window_minutes,omitempty"`      // Crash-loop window (default: 30)
+	BackoffSeconds    int  `json:"backoff_seconds,omitempty"`     // Delay before the first restart, doubled each time (default: 10)
+	MaxBackoffSeconds int  `json:"max_backoff_seconds,omitempty"` // Longest delay (default: 600)
+}
+
+// ContainerConfig configures container-isolated sessions
+type ContainerConfig struct {
+	Runtime   string   `json:"runtime,omitempty"`    // "docker" or "podman" (default: first installed)
+	Image     string   `json:"image,omitempty"`      // Image with Claude Code installed
+	Network   string   `json:"network,omitempty"`    // Container network (default: none; "open" for the runtime default)
+	CPUs      string   `json:"cpus,omitempty"`       // CPU limit, e.g. "2"
+	Memory    string   `json:"memory,omitempty"`     // Memory limit, e.g. "4g"
+	PidsLimit int      `json:"pids_limit,omitempty"` // Process limit (default: 1024)
+	Mounts    []string `json:"mounts,omitempty"`     // Extra bind mounts: src[:dst][:ro]
+	Env       []string `json:"env,omitempty"`        // Extra variables: KEY=VALUE, or KEY to pass through
+}
+
+// TranscriptionConfig selects and tunes the voice transcription provider
+type TranscriptionConfig struct {
+	Provider   string `json:"provider,omitempty"`    // "command", "whisper", "whisper-cpp" or "openai" (default: command if transcription_cmd is set, else whisper)
+	Language   string `json:"language,omitempty"`    // Language hint, e.g. "en" (default: auto-detect)
+	Prompt     string `json:"prompt,omitempty"`      // Vocabulary prompt for every session
+	MaxSeconds int    `json:"max_seconds,omitempty"` // Longest voice message to transcribe (default: 600)
+	Confirm    bool   `json:"confirm,omitempty"`     // Post transcriptions with Send / Edit / Cancel buttons before sending
+
+	// whisper and whisper-cpp
+	WhisperBin   string `json:"whisper_bin,omitempty"`   // CLI binary (default: whisper, or whisper-cli for whisper-cpp)
+	WhisperModel string `json:"whisper_model,omitempty"` // Model name for whisper, ggml model file for whisper-cpp
+	Threads      int    `json:"threads,omitempty"`       // whisper-cpp threads
+
+	// openai
+	BaseURL string `json:"base_url,omitempty"` // OpenAI-compatible API (default: https://api.openai.com/v1)
+	APIKey  string `json:"api_key,omitempty"`  // API key (default: $OPENAI_API_KEY)
+	Model   string `json:"model,omitempty"`    // Model (default: whisper-1)
+}
+
+// RedactionConfig tunes the masking of secrets in text and files sent to
+// Telegram
+type RedactionConfig struct {
+	Disabled  bool     `json:"disabled,omitempty"`   // Send everything unmasked
+	Patterns  []string `json:"patterns,omitempty"`   // Extra regular expressions to mask (a (?P<secret>...) group masks only that part)
+	Allow     []string `json:"allow,omitempty"`      // Regular expressions for strings never masked
+	Entropy   float64  `json:"entropy,omitempty"`    // Bits per character above which long random strings are masked (default: 4.5, negative: off)
+	MinLength int      `json:"min_length,omitempty"` // Shortest string checked for entropy (default: 32)
+}
+
+// CommandPolicy restricts what /c and /rc may run and lets teammates use
+// them without full shell access
+type CommandPolicy struct {
+	Allow     []string                `json:"allow,omitempty"`      // When set, every command in a chain must start with a match
+	Deny      []string                `json:"deny,omitempty"`       // Commands containing a match are rejected
+	Dangerous []string                `json:"dangerous,omitempty"`  // Commands containing a match need confirmation (default: rm -rf, sudo, reboot, git push --force...)
+	Jail      string                  `json:"jail,omitempty"`       // Directory commands run in and may not name paths outside of
+	KeepEnv   []string                `json:"keep_env,omitempty"`   // Environment variables kept besides PATH, HOME, USER, LANG...
+	MaxOutput int                     `json:"max_output,omitempty"` // Output cap in bytes (0 = none)
+	Timeout   int                     `json:"timeout,omitempty"`    // Seconds (default: 120 for /c, 30 for /rc)
+	Roles     map[string]*CommandRole `json:"roles,omitempty"`      // Role name -> limits
+	Users     map[string]string       `json:"users,omitempty"`      // Numeric Telegram user ID -> role
+	OwnerRole string                  `json:"owner_role,omitempty"` // Role of the bot owner (default: global rules only)
+}
+
+// CommandRole limits what the users assigned to it may run with /c and /rc
+type CommandRole struct {
+	Allow     []string `json:"allow,omitempty"`      // Replaces the global allow list
+	Deny      []string `json:"deny,omitempty"`       // Added to the global deny list
+	Hosts     []string `json:"hosts,omitempty"`      // Hosts usable with /rc, "local" for /c (default: all)
+	Jail      string   `json:"jail,omitempty"`       // Overrides the global jail
+	MaxOutput int      `json:"max_output,omitempty"` // Overrides the global output cap
+	Timeout   int      `json:"timeout,omitempty"`    // Overrides the global timeout (seconds)
+	PerHour   int      `json:"per_hour,omitempty"`   // Commands per hour per user (0 = no limit)
+}
+
+// VoiceReplyConfig configures spoken summaries of Claude's answers
+type VoiceReplyConfig struct {
+	Enabled  bool     `json:"enabled,omitempty"`   // Voice replies in every session
+	Users    []string `json:"users,omitempty"`     // Telegram usernames whose prompts get voice replies (see /voicereply)
+	MaxChars int      `json:"max_chars,omitempty"` // Length of the spoken summary (default: 600)
+
+	Command string `json:"command,omitempty"`  // TTS command: text on stdin, audio to {out} or stdout
+	BaseURL string `json:"base_url,omitempty"` // OpenAI-compatible /audio/speech endpoint, used without a command
+	APIKey  string `json:"api_key,omitempty"`  // API key (default: $OPENAI_API_KEY)
+	Model   string `json:"model,omitempty"`    // TTS model (default: tts-1)
+	Voice   string `json:"voice,omitempty"`    // Voice (default: alloy)
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
 }
 
 // HostInfo stores information about a remote host
@@ -24,7 +155,7 @@ type HostInfo struct {
 
 // Config stores bot configuration and session mappings
 type Config struct {
-	BotToken         string                  `json:"bot_token"`
+	BotToken         string                  `json:"bot_token,omitempty"`
 	ChatID           int64                   `json:"chat_id"`                     // Private chat for simple commands
 	GroupID          int64                   `json:"group_id,omitempty"`          // Group with topics for sessions
 	Sessions         map[string]*SessionInfo `json:"sessions,omitempty"`          // session name -> session info
@@ -32,6 +163,59 @@ type Config struct {
 	TranscriptionCmd string                  `json:"transcription_cmd,omitempty"` // Command for audio transcription
 	Away             bool                    `json:"away"`
 
+	Transcription *TranscriptionConfig `json:"transcription,omitempty"`  // Voice transcription provider and hints
+	VoiceReplies  *VoiceReplyConfig    `json:"voice_replies,omitempty"`  // Spoken replies sent with Stop messages
+	Redaction     *RedactionConfig     `json:"redaction,omitempty"`      // Secret masking for outbound text and files (on by default)
+	CommandPolicy *CommandPolicy       `json:"command_policy,omitempty"` // Rules for /c and /rc (unrestricted, owner only when unset)
+	ConfirmSkip   []int64              `json:"confirm_skip,omitempty"`   // Telegram user IDs whose destructive commands run without confirmation
+
+	// Bot token and API keys kept out of plaintext (see ccc secrets)
+	BotTokenFile string       `json:"bot_token_file,omitempty"` // File holding the bot token
+	Secrets      *secrets.Box `json:"secrets,omitempty"`        // Passphrase-encrypted bot token and API keys
+
+	// Live progress: keep one "working…" message per turn and edit it in place
+	LiveProgress         bool `json:"live_progress,omitempty"`          // Enable live progress for all sessions
+	LiveProgressInterval int  `json:"live_progress_interval,omitempty"` // Min seconds between edits (default: 5)
+
+	CodeFileLines int `json:"code_file_lines,omitempty"` // Send code blocks with at least this many lines as files (0 = inline)
+
+	// Pinned status message per session topic
+	PinnedStatus     bool `json:"pinned_status,omitempty"`      // Keep a pinned, live-updated status message in each topic
+	StatusTopicNames bool `json:"status_topic_names,omitempty"` // Prefix topic names with a state emoji
+
+	// Token usage and cost tracking
+	DailyBudget  float64               `json:"daily_budget_usd,omitempty"` // Daily spend limit in USD (0 = none)
+	BudgetAction string                `json:"budget_action,omitempty"`    // "warn" (default) or "pause" when over budget
+	ModelPrices  map[string]ModelPrice `json:"model_prices,omitempty"`     // Price overrides by model name prefix
+
+	// Idle shutdown and crash recovery
+	IdleTimeoutHours int            `json:"idle_timeout_hours,omitempty"` // Stop sessions idle this many hours (0 = never)
+	AutoRestart      *RestartPolicy `json:"auto_restart,omitempty"`       // Restart crashed Claude processes (default: enabled)
+
+	// Broadcast prompts
+	BroadcastConcurrency int `json:"broadcast_concurrency,omitempty"` // Sessions prompted at once by /broadcast (default: 4)
+
+	// Session recordings
+	RecordingMaxDays int `json:"recording_max_days,omitempty"` // Delete recordings not written to for this many days (default: 30)
+	RecordingMaxMB   int `json:"recording_max_mb,omitempty"`   // Delete the oldest recordings above this total size (default: 1024)
+
+	// Monitoring and logs
+	MetricsListen string `json:"metrics_listen,omitempty"` // Address for /metrics, /healthz and /readyz, e.g. "127.0.0.1:9464" (off when empty)
+	LogLevel      string `json:"log_level,omitempty"`      // debug, info (default), warn or error
+	LogMaxMB      int    `json:"log_max_mb,omitempty"`     // Rotate ~/.ccc/ccc.log above this size (default: 10)
+	LogFiles      int    `json:"log_files,omitempty"`      // Rotated log files kept (default: 3)
+
+	// Audit log (~/.ccc/audit.jsonl)
+	AuditHashChain bool `json:"audit_hash_chain,omitempty"` // Chain entries with SHA-256 hashes so edits and deletions can be detected
+
+	// Session-to-session pipelines
+	Pipelines       []Pipeline `json:"pipelines,omitempty"`
+	PipelineMaxHops int        `json:"pipeline_max_hops,omitempty"` // Forwards in a row before a chain stops (default: 3)
+
+	// Local session backend
+	SessionBackend string           `json:"session_backend,omitempty"` // "tmux" (default) or "pty"
+	Container      *ContainerConfig `json:"container,omitempty"`       // Settings for container sessions
+
 	// Remote hosts configuration (server mode)
 	Hosts map[string]*HostInfo `json:"hosts,omitempty"` // host name -> host info
 
@@ -39,6 +223,11 @@ type Config struct {
 	Mode     string `json:"mode,omitempty"`      // "client" or "" (server/standalone)
 	Server   string `json:"server,omitempty"`    // SSH target for server (client mode)
 	HostName string `json:"host_name,omitempty"` // This machine's identifier
+
+	tokenSource string            // Where BotToken came from (see TokenSource)
+	resolved    map[string]bool   // Secret fields not read from their plaintext config value
+	plain       map[string]string // Plaintext config values of the resolved fields, written back by Save
+	secretsErr  error
 }
 
 // Path returns the config file path (~/.ccc.json)
@@ -137,8 +326,8 @@ func Load() (*Config, error) {
 				Path:    sessionPath,
 			}
 		}
-		// Save migrated config
-		Save(&config)
+		// Save migrated config (without the lock: Update may be holding it)
+		save(&config)
 	} else {
 		// Parse with new format
 		if err := json.Unmarshal(data, &config); err != nil {
@@ -152,17 +341,68 @@ func Load() (*Config, error) {
 	if config.Hosts == nil {
 		config.Hosts = make(map[string]*HostInfo)
 	}
+	config.resolveSecrets()
 
 	return &config, nil
 }
 
-// Save saves config to disk with proper permissions (0600)
+// Save saves config to disk with proper permissions (0600). Secrets loaded
+// from the environment, a file, the secrets section or the keyring are not
+// written back in plaintext.
 func Save(config *Config) error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	unlock, err := lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return save(config)
+}
+
+// Update reloads the config, applies fn and saves the result while holding
+// the config lock, so writers in other goroutines and processes don't
+// overwrite each other's changes. Nothing is saved when fn fails.
+func Update(fn func(*Config) error) (*Config, error) {
+	unlock, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	config, err := Load()
 	if err != nil {
+		return nil, err
+	}
+	if err := fn(config); err != nil {
+		return nil, err
+	}
+	return config, save(config)
+}
+
+// lock takes an exclusive flock on ~/.ccc.json.lock
+func lock() (func(), error) {
+	f, err := os.OpenFile(Path()+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func save(config *Config) error {
+	data, err := json.MarshalIndent(config.plaintext(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(Path(), data, 0600); err != nil {
 		return err
 	}
-	return os.WriteFile(Path(), data, 0600)
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(Path(), 0600)
 }
 
 // GetProjectsDir returns the base directory for projects
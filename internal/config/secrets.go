@@ -0,0 +1,240 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kidandcat/ccc/internal/secrets"
+)
+
+// Environment variables read when loading secrets
+const (
+	BotTokenEnv   = "CCC_BOT_TOKEN"  // Bot token, overrides every other source
+	PassphraseEnv = "CCC_PASSPHRASE" // Passphrase of the secrets section
+)
+
+// SecretNames are the values that can be kept in the secrets section
+var SecretNames = []string{"bot_token", "transcription.api_key", "voice_replies.api_key"}
+
+// secretFields maps secret names to the config fields they fill. API keys
+// are only filled when their section exists.
+func (c *Config) secretFields() map[string]*string {
+	fields := map[string]*string{"bot_token": &c.BotToken}
+	if c.Transcription != nil {
+		fields["transcription.api_key"] = &c.Transcription.APIKey
+	}
+	if c.VoiceReplies != nil {
+		fields["voice_replies.api_key"] = &c.VoiceReplies.APIKey
+	}
+	return fields
+}
+
+// TokenSource says where the bot token was loaded from: "env", "file",
+// "secrets", "config" (plaintext bot_token), "keyring" or "" (none)
+func (c *Config) TokenSource() string {
+	return c.tokenSource
+}
+
+// SecretsError returns why bot_token_file, the secrets section or the
+// keyring could not be read (nil if they could)
+func (c *Config) SecretsError() error {
+	return c.secretsErr
+}
+
+// Plaintext returns the secrets stored unencrypted in the config file
+func (c *Config) Plaintext() []string {
+	var names []string
+	for name := range c.secretFields() {
+		if c.plaintextValue(name) != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *Config) plaintextValue(name string) string {
+	if c.resolved[name] {
+		return c.plain[name]
+	}
+	if field := c.secretFields()[name]; field != nil {
+		return *field
+	}
+	return ""
+}
+
+// resolve records that name was filled from somewhere else than its
+// plaintext config value, keeping that value for Save
+func (c *Config) resolve(name string, value string) {
+	field := c.secretFields()[name]
+	if field == nil {
+		return
+	}
+	if c.resolved == nil {
+		c.resolved = map[string]bool{}
+		c.plain = map[string]string{}
+	}
+	if !c.resolved[name] {
+		c.plain[name] = *field
+		c.resolved[name] = true
+	}
+	*field = value
+}
+
+// resolveSecrets fills the bot token from, in order of precedence,
+// CCC_BOT_TOKEN, bot_token_file, the secrets section, the plaintext
+// bot_token and the OS keyring. API keys come from the secrets section.
+func (c *Config) resolveSecrets() {
+	var errs []error
+	if c.BotToken != "" {
+		c.tokenSource = "config"
+	}
+	if c.Secrets != nil {
+		if values, err := c.OpenSecrets(os.Getenv(PassphraseEnv)); err != nil {
+			errs = append(errs, err)
+		} else {
+			for name, value := range values {
+				if value == "" {
+					continue
+				}
+				c.resolve(name, value)
+				if name == "bot_token" {
+					c.tokenSource = "secrets"
+				}
+			}
+		}
+	}
+	if c.BotTokenFile != "" {
+		data, err := os.ReadFile(ExpandPath(c.BotTokenFile))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bot_token_file: %v", err))
+		} else if token := strings.TrimSpace(string(data)); token != "" {
+			c.resolve("bot_token", token)
+			c.tokenSource = "file"
+		}
+	}
+	if token := strings.TrimSpace(os.Getenv(BotTokenEnv)); token != "" {
+		c.resolve("bot_token", token)
+		c.tokenSource = "env"
+	}
+	if c.BotToken == "" && secrets.KeyringAvailable() {
+		if token, err := secrets.KeyringLookup(secrets.KeyringAccount); err != nil {
+			errs = append(errs, fmt.Errorf("keyring: %v", err))
+		} else if token != "" {
+			c.resolve("bot_token", token)
+			c.tokenSource = "keyring"
+		}
+	}
+	c.secretsErr = errors.Join(errs...)
+}
+
+// OpenSecrets decrypts the secrets section
+func (c *Config) OpenSecrets(passphrase string) (map[string]string, error) {
+	if c.Secrets == nil {
+		return map[string]string{}, nil
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("secrets are encrypted: set %s", PassphraseEnv)
+	}
+	values, err := secrets.Open(c.Secrets, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %v", err)
+	}
+	return values, nil
+}
+
+// SealSecrets encrypts the plaintext secrets and set (name -> value) into
+// the secrets section, merged with the values already there, and removes
+// their plaintext copies. It returns the names stored.
+func (c *Config) SealSecrets(passphrase string, set map[string]string) ([]string, error) {
+	values, err := c.OpenSecrets(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	for name := range set {
+		if !isSecretName(name) {
+			return nil, fmt.Errorf("unknown secret %q (known: %s)", name, strings.Join(SecretNames, ", "))
+		}
+	}
+	moved := c.Plaintext()
+	for _, name := range moved {
+		values[name] = c.plaintextValue(name)
+	}
+	for name, value := range set {
+		values[name] = value
+	}
+	box, err := secrets.Seal(values, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	c.Secrets = box
+	for _, name := range moved {
+		c.ForgetPlaintext(name)
+	}
+	if c.tokenSource == "config" {
+		c.tokenSource = "secrets"
+	}
+	for name, value := range set {
+		// CCC_BOT_TOKEN, bot_token_file and the keyring still win over the box
+		if name == "bot_token" && c.tokenSource != "" && c.tokenSource != "secrets" {
+			continue
+		}
+		c.resolve(name, value)
+		if name == "bot_token" {
+			c.tokenSource = "secrets"
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ForgetPlaintext drops the plaintext copy of a secret so Save no longer
+// writes it, while keeping the value in use
+func (c *Config) ForgetPlaintext(name string) {
+	field := c.secretFields()[name]
+	if field == nil {
+		return
+	}
+	c.resolve(name, *field)
+	c.plain[name] = ""
+}
+
+func isSecretName(name string) bool {
+	for _, n := range SecretNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// plaintext returns a copy of c as it should be written to disk, with the
+// resolved secrets replaced by their plaintext config values
+func (c *Config) plaintext() *Config {
+	if len(c.resolved) == 0 {
+		return c
+	}
+	out := *c
+	if c.Transcription != nil {
+		t := *c.Transcription
+		out.Transcription = &t
+	}
+	if c.VoiceReplies != nil {
+		v := *c.VoiceReplies
+		out.VoiceReplies = &v
+	}
+	fields := out.secretFields()
+	for name := range c.resolved {
+		if field := fields[name]; field != nil {
+			*field = c.plain[name]
+		}
+	}
+	return &out
+}
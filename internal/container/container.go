@@ -0,0 +1,180 @@
+// Package container runs session programs inside Docker or Podman
+// containers with the project mounted and resource limits applied.
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Runtimes are the supported container runtimes in order of preference
+var Runtimes = []string{"docker", "podman"}
+
+// DefaultPidsLimit caps the number of processes when no limit is configured
+const DefaultPidsLimit = 1024
+
+// FindRuntime returns the path of the preferred runtime, or of the first
+// installed one when preferred is empty
+func FindRuntime(preferred string) (string, error) {
+	candidates := Runtimes
+	if preferred != "" {
+		candidates = []string{preferred}
+	}
+	for _, name := range candidates {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	if preferred != "" {
+		return "", fmt.Errorf("container runtime %s not found", preferred)
+	}
+	return "", fmt.Errorf("no container runtime found (install docker or podman)")
+}
+
+// Mount is a bind mount
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ParseMount parses "src[:dst][:ro]"; dst defaults to src
+func ParseMount(s string) (Mount, error) {
+	parts := strings.Split(s, ":")
+	m := Mount{Source: parts[0]}
+	if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		m.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	switch len(parts) {
+	case 1:
+		m.Target = m.Source
+	case 2:
+		m.Target = parts[1]
+	default:
+		return Mount{}, fmt.Errorf("invalid mount %q (want src[:dst][:ro])", s)
+	}
+	if !filepath.IsAbs(m.Source) || !filepath.IsAbs(m.Target) {
+		return Mount{}, fmt.Errorf("invalid mount %q: paths must be absolute", s)
+	}
+	return m, nil
+}
+
+// Options describes the container of one session
+type Options struct {
+	Runtime   string            // Runtime binary (docker or podman)
+	Name      string            // Container name
+	Image     string            // Image to run
+	WorkDir   string            // Project directory, mounted at the same path
+	Mounts    []Mount           // Additional bind mounts
+	Env       []string          // KEY=VALUE pairs
+	User      string            // uid:gid to run as (docker)
+	Network   string            // Network ("" = runtime default, "none" = no network)
+	CPUs      string            // CPU limit, e.g. "2"
+	Memory    string            // Memory limit, e.g. "4g"
+	PidsLimit int               // Process limit (default: DefaultPidsLimit)
+	CIDFile   string            // File the runtime writes the container ID to
+	Labels    map[string]string // Container labels
+	Command   []string          // Program and arguments
+}
+
+// IsPodman reports whether runtime is Podman
+func IsPodman(runtime string) bool {
+	return strings.HasPrefix(filepath.Base(runtime), "podman")
+}
+
+// RunArgs returns the arguments for running opts interactively on the
+// caller's terminal; the container is removed when the program exits
+func RunArgs(opts Options) []string {
+	args := []string{"run", "--rm", "-i", "-t",
+		"--name", opts.Name,
+		"--workdir", opts.WorkDir,
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+	}
+	if IsPodman(opts.Runtime) {
+		// Rootless Podman maps the calling user into the container
+		args = append(args, "--userns", "keep-id")
+	} else if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	if opts.CIDFile != "" {
+		args = append(args, "--cidfile", opts.CIDFile)
+	}
+	if opts.Network != "" {
+		args = append(args, "--network", opts.Network)
+	}
+	if opts.CPUs != "" {
+		args = append(args, "--cpus", opts.CPUs)
+	}
+	if opts.Memory != "" {
+		args = append(args, "--memory", opts.Memory)
+	}
+	pids := opts.PidsLimit
+	if pids <= 0 {
+		pids = DefaultPidsLimit
+	}
+	args = append(args, "--pids-limit", fmt.Sprint(pids))
+
+	keys := make([]string, 0, len(opts.Labels))
+	for k := range opts.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+opts.Labels[k])
+	}
+
+	mounts := append([]Mount{{Source: opts.WorkDir, Target: opts.WorkDir}}, opts.Mounts...)
+	for _, m := range mounts {
+		v := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			v += ":ro"
+		}
+		args = append(args, "--volume", v)
+	}
+	for _, e := range opts.Env {
+		args = append(args, "--env", e)
+	}
+
+	args = append(args, opts.Image)
+	return append(args, opts.Command...)
+}
+
+// WaitCID waits for the runtime to write the container ID to cidFile
+func WaitCID(cidFile string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if data, err := os.ReadFile(cidFile); err == nil {
+			if id := strings.TrimSpace(string(data)); id != "" {
+				return id, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("container did not start within %s", timeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Remove force-removes a container by ID or name
+func Remove(runtime string, id string) error {
+	out, err := exec.Command(runtime, "rm", "-f", id).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s rm: %v: %s", filepath.Base(runtime), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ShortID returns the 12-character form of a container ID
+func ShortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,114 @@
+package container
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Mount
+	}{
+		{"/data", Mount{Source: "/data", Target: "/data"}},
+		{"/data:ro", Mount{Source: "/data", Target: "/data", ReadOnly: true}},
+		{"/host/cache:/cache", Mount{Source: "/host/cache", Target: "/cache"}},
+		{"/host/cache:/cache:ro", Mount{Source: "/host/cache", Target: "/cache", ReadOnly: true}},
+	}
+	for _, tt := range tests {
+		got, err := ParseMount(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMount(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"relative", "/a:/b:/c", "/a:b"} {
+		if _, err := ParseMount(bad); err == nil {
+			t.Errorf("ParseMount(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	opts := Options{
+		Runtime: "/usr/bin/docker",
+		Name:    "ccc-claude-app",
+		Image:   "claude:latest",
+		WorkDir: "/home/u/app",
+		Mounts:  []Mount{{Source: "/home/u/.claude", Target: "/home/u/.claude"}, {Source: "/home/u/bin/ccc", Target: "/home/u/bin/ccc", ReadOnly: true}},
+		Env:     []string{"HOME=/home/u"},
+		User:    "1000:1000",
+		Network: "none",
+		Memory:  "4g",
+		CIDFile: "/tmp/app.cid",
+		Labels:  map[string]string{"ccc.session": "app"},
+		Command: []string{"claude", "-c"},
+	}
+	got := strings.Join(RunArgs(opts), " ")
+	for _, want := range []string{
+		"run --rm -i -t --name ccc-claude-app --workdir /home/u/app",
+		"--cap-drop ALL",
+		"--user 1000:1000",
+		"--cidfile /tmp/app.cid",
+		"--network none",
+		"--memory 4g",
+		"--pids-limit 1024",
+		"--label ccc.session=app",
+		"--volume /home/u/app:/home/u/app --volume /home/u/.claude:/home/u/.claude --volume /home/u/bin/ccc:/home/u/bin/ccc:ro",
+		"--env HOME=/home/u claude:latest claude -c",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("RunArgs() = %q\nmissing %q", got, want)
+		}
+	}
+	if strings.Contains(got, "--cpus") {
+		t.Errorf("unset CPU limit should be omitted: %q", got)
+	}
+
+	opts.Runtime = "/usr/bin/podman"
+	got = strings.Join(RunArgs(opts), " ")
+	if !strings.Contains(got, "--userns keep-id") || strings.Contains(got, "--user ") {
+		t.Errorf("podman should map the user with keep-id: %q", got)
+	}
+}
+
+func TestWaitCID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.cid")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile(path, []byte("0123456789abcdef\n"), 0600)
+	}()
+	id, err := WaitCID(path, 2*time.Second)
+	if err != nil || id != "0123456789abcdef" {
+		t.Fatalf("WaitCID() = %q, %v", id, err)
+	}
+	if ShortID(id) != "0123456789ab" {
+		t.Errorf("ShortID() = %q", ShortID(id))
+	}
+	if _, err := WaitCID(filepath.Join(t.TempDir(), "missing"), 300*time.Millisecond); err == nil {
+		t.Error("expected timeout")
+	}
+}
+
+func TestRelay(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "hook.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go ServeRelay(ln, func(req RelayRequest) RelayResponse {
+		return RelayResponse{Stdout: append([]byte(req.Hook+":"), req.Input...), Code: 2}
+	})
+
+	resp, err := CallRelay(socket, RelayRequest{Hook: "hook-permission", Input: []byte(`{"tool_name":"Bash"}`)})
+	if err != nil || string(resp.Stdout) != `hook-permission:{"tool_name":"Bash"}` || resp.Code != 2 {
+		t.Errorf("CallRelay() = %+v, %v", resp, err)
+	}
+	if _, err := CallRelay(socket, RelayRequest{Hook: "hook", Input: make([]byte, maxRelayInput+1)}); err == nil {
+		t.Error("CallRelay() sent an oversized input")
+	}
+}
@@ -0,0 +1,68 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// RelayEnv names the socket ccc hooks forward to. The container sees neither
+// the ccc config nor its sockets, so hooks run on the host through the
+// session's relay. The listener serves one too, for sessions started without
+// the secrets in their environment.
+const RelayEnv = "CCC_HOOK_RELAY"
+
+// maxRelayInput caps a relayed hook's stdin
+const maxRelayInput = 4 << 20
+
+// RelayRequest is a hook invocation forwarded from inside a container
+type RelayRequest struct {
+	Hook  string `json:"hook"`  // ccc subcommand, e.g. "hook-permission"
+	Input []byte `json:"input"` // The hook's stdin
+}
+
+// RelayResponse is the result of the hook run on the host
+type RelayResponse struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+	Code   int    `json:"code"`
+}
+
+// ServeRelay answers relay requests on ln with run until ln is closed
+func ServeRelay(ln net.Listener, run func(RelayRequest) RelayResponse) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var req RelayRequest
+			if err := json.NewDecoder(io.LimitReader(conn, maxRelayInput*2)).Decode(&req); err != nil {
+				return
+			}
+			json.NewEncoder(conn).Encode(run(req))
+		}()
+	}
+}
+
+// CallRelay runs a hook through the relay listening on socket
+func CallRelay(socket string, req RelayRequest) (RelayResponse, error) {
+	if len(req.Input) > maxRelayInput {
+		return RelayResponse{}, fmt.Errorf("hook input too large (%d bytes)", len(req.Input))
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return RelayResponse{}, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return RelayResponse{}, err
+	}
+	var resp RelayResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return RelayResponse{}, fmt.Errorf("relay: %v", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,251 @@
+// Package dispatch queues outbound Telegram API calls. It applies per-chat and
+// global rate limits, honours retry_after from 429 responses, retries network
+// errors with exponential backoff and keeps calls to the same topic in order.
+// Limits are tracked in memory, so each process using a Dispatcher has its own
+// budget; calls that exceed Telegram's shared limit are caught by the 429
+// handling.
+package dispatch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outcome is the result of a single attempt of a call
+type Outcome struct {
+	RetryAfter time.Duration // Telegram asked us to wait (HTTP 429)
+	Err        error         // Error of this attempt (nil on success)
+	Temporary  bool          // Err is transient (network, 5xx) and worth retrying
+}
+
+// Options configures rate limits and retries. Zero values use defaults that
+// follow the Telegram Bot API guidance.
+type Options struct {
+	GlobalPerSecond float64       // All chats combined (default: 25)
+	ChatPerMinute   float64       // Private chats (default: 60)
+	GroupPerMinute  float64       // Groups and supergroups (default: 20)
+	Burst           float64       // Requests a chat may send back to back (default: 5)
+	MaxRetries      int           // Retries for temporary errors and 429s (default: 5)
+	BaseBackoff     time.Duration // First retry delay, doubled each time (default: 500ms)
+	MaxBackoff      time.Duration // Backoff cap (default: 30s)
+}
+
+// Dispatcher runs calls through per-topic FIFO queues
+type Dispatcher struct {
+	opts   Options
+	global *bucket
+
+	mu     sync.Mutex
+	chats  map[int64]*bucket
+	queues map[queueKey]*queue
+
+	depth int64 // queued or running calls
+	sleep func(time.Duration)
+}
+
+type queueKey struct {
+	chatID   int64
+	threadID int64
+}
+
+type queue struct {
+	jobs []*job
+}
+
+type job struct {
+	chatID int64
+	fn     func() Outcome
+	done   chan error
+}
+
+// New creates a dispatcher
+func New(opts Options) *Dispatcher {
+	if opts.GlobalPerSecond <= 0 {
+		opts.GlobalPerSecond = 25
+	}
+	if opts.ChatPerMinute <= 0 {
+		opts.ChatPerMinute = 60
+	}
+	if opts.GroupPerMinute <= 0 {
+		opts.GroupPerMinute = 20
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 5
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	return &Dispatcher{
+		opts:   opts,
+		global: newBucket(opts.GlobalPerSecond, opts.GlobalPerSecond),
+		chats:  make(map[int64]*bucket),
+		queues: make(map[queueKey]*queue),
+		sleep:  time.Sleep,
+	}
+}
+
+// Do queues fn behind earlier calls for the same chat and topic and blocks
+// until it has completed (including retries). fn may be called several times.
+func (d *Dispatcher) Do(chatID int64, threadID int64, fn func() Outcome) error {
+	atomic.AddInt64(&d.depth, 1)
+	defer atomic.AddInt64(&d.depth, -1)
+
+	j := &job{chatID: chatID, fn: fn, done: make(chan error, 1)}
+	key := queueKey{chatID, threadID}
+
+	d.mu.Lock()
+	q, running := d.queues[key]
+	if !running {
+		q = &queue{}
+		d.queues[key] = q
+	}
+	q.jobs = append(q.jobs, j)
+	d.mu.Unlock()
+
+	if !running {
+		go d.run(key, q)
+	}
+	return <-j.done
+}
+
+// Depth returns the number of calls waiting or in flight
+func (d *Dispatcher) Depth() int {
+	return int(atomic.LoadInt64(&d.depth))
+}
+
+// Queued returns the number of calls for one chat and topic that have not
+// started yet
+func (d *Dispatcher) Queued(chatID int64, threadID int64) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if q := d.queues[queueKey{chatID, threadID}]; q != nil {
+		return len(q.jobs)
+	}
+	return 0
+}
+
+// run drains one topic queue; the worker exits when the queue is empty
+func (d *Dispatcher) run(key queueKey, q *queue) {
+	for {
+		d.mu.Lock()
+		if len(q.jobs) == 0 {
+			delete(d.queues, key)
+			d.mu.Unlock()
+			return
+		}
+		j := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		d.mu.Unlock()
+
+		j.done <- d.execute(j)
+	}
+}
+
+func (d *Dispatcher) execute(j *job) error {
+	chat := d.chatBucket(j.chatID)
+	backoff := d.opts.BaseBackoff
+
+	for attempt := 0; ; attempt++ {
+		d.wait(chat)
+		d.wait(d.global)
+
+		out := j.fn()
+		switch {
+		case out.RetryAfter > 0:
+			if attempt >= d.opts.MaxRetries {
+				return fmt.Errorf("rate limited: retry after %s", out.RetryAfter)
+			}
+			chat.block(out.RetryAfter)
+		case out.Err != nil && out.Temporary:
+			if attempt >= d.opts.MaxRetries {
+				return out.Err
+			}
+			d.sleep(backoff)
+			if backoff *= 2; backoff > d.opts.MaxBackoff {
+				backoff = d.opts.MaxBackoff
+			}
+		default:
+			return out.Err
+		}
+	}
+}
+
+func (d *Dispatcher) wait(b *bucket) {
+	for {
+		w := b.take(time.Now())
+		if w <= 0 {
+			return
+		}
+		d.sleep(w)
+	}
+}
+
+func (d *Dispatcher) chatBucket(chatID int64) *bucket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b, ok := d.chats[chatID]
+	if !ok {
+		perMinute := d.opts.ChatPerMinute
+		if chatID < 0 {
+			perMinute = d.opts.GroupPerMinute
+		}
+		b = newBucket(perMinute/60, d.opts.Burst)
+		d.chats[chatID] = b
+	}
+	return b
+}
+
+// bucket is a token bucket that can also be blocked for a fixed time
+type bucket struct {
+	mu           sync.Mutex
+	rate         float64 // tokens per second
+	capacity     float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func newBucket(rate float64, capacity float64) *bucket {
+	return &bucket{rate: rate, capacity: capacity, tokens: capacity}
+}
+
+// take consumes a token and returns 0, or returns how long to wait
+func (b *bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Before(b.blockedUntil) {
+		return b.blockedUntil.Sub(now)
+	}
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// block stops the bucket from handing out tokens for d
+func (b *bucket) block(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := time.Now().Add(d); until.After(b.blockedUntil) {
+		b.blockedUntil = until
+		b.last = until
+	}
+	b.tokens = 1 // first call after the block goes out immediately
+}
@@ -0,0 +1,140 @@
+package dispatch
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderWithinTopic(t *testing.T) {
+	d := New(Options{Burst: 100, ChatPerMinute: 60000, GroupPerMinute: 60000, GlobalPerSecond: 1000})
+
+	var mu sync.Mutex
+	var got []int
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		i := i
+		// Enqueue sequentially so the expected order is well defined
+		started := make(chan struct{})
+		go func() {
+			defer wg.Done()
+			close(started)
+			d.Do(-100, 7, func() Outcome {
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				got = append(got, i)
+				mu.Unlock()
+				return Outcome{}
+			})
+		}()
+		<-started
+		time.Sleep(2 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("calls ran out of order: %v", got)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	d := New(Options{})
+	attempts := 0
+	start := time.Now()
+	err := d.Do(1, 0, func() Outcome {
+		attempts++
+		if attempts == 1 {
+			return Outcome{RetryAfter: 50 * time.Millisecond}
+		}
+		return Outcome{}
+	})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("retry did not wait for retry_after")
+	}
+}
+
+func TestTemporaryErrorBackoff(t *testing.T) {
+	d := New(Options{MaxRetries: 3, BaseBackoff: time.Millisecond})
+	var delays []time.Duration
+	d.sleep = func(dur time.Duration) { delays = append(delays, dur) }
+
+	attempts := 0
+	err := d.Do(1, 0, func() Outcome {
+		attempts++
+		return Outcome{Err: errors.New("connection reset"), Temporary: true}
+	})
+	if err == nil || err.Error() != "connection reset" {
+		t.Errorf("Do() error = %v, want connection reset", err)
+	}
+	if attempts != 4 {
+		t.Errorf("attempts = %d, want 4 (1 + 3 retries)", attempts)
+	}
+	if len(delays) != 3 || delays[0] != time.Millisecond || delays[2] != 4*time.Millisecond {
+		t.Errorf("backoff delays = %v, want [1ms 2ms 4ms]", delays)
+	}
+}
+
+func TestPermanentErrorNotRetried(t *testing.T) {
+	d := New(Options{})
+	attempts := 0
+	err := d.Do(1, 0, func() Outcome {
+		attempts++
+		return Outcome{Err: errors.New("bad request")}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("attempts = %d err = %v, want 1 attempt with error", attempts, err)
+	}
+}
+
+func TestBucketRateLimit(t *testing.T) {
+	b := newBucket(1, 2) // 1 token/s, burst of 2
+	now := time.Now()
+	if b.take(now) != 0 || b.take(now) != 0 {
+		t.Fatal("burst tokens should be available immediately")
+	}
+	if w := b.take(now); w <= 0 || w > time.Second {
+		t.Errorf("third take wait = %v, want (0, 1s]", w)
+	}
+	if w := b.take(now.Add(time.Second)); w != 0 {
+		t.Errorf("take after refill wait = %v, want 0", w)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	d := New(Options{})
+	release := make(chan struct{})
+	running := make(chan struct{})
+	go d.Do(1, 0, func() Outcome {
+		close(running)
+		<-release
+		return Outcome{}
+	})
+	<-running
+	if d.Depth() != 1 {
+		t.Errorf("Depth() = %d, want 1", d.Depth())
+	}
+	go d.Do(1, 0, func() Outcome { return Outcome{} })
+	for i := 0; i < 100 && d.Queued(1, 0) != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if d.Queued(1, 0) != 1 || d.Queued(1, 5) != 0 {
+		t.Errorf("Queued() = %d, %d, want 1, 0", d.Queued(1, 0), d.Queued(1, 5))
+	}
+	close(release)
+	for i := 0; i < 100 && d.Depth() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if d.Depth() != 0 {
+		t.Errorf("Depth() = %d after completion, want 0", d.Depth())
+	}
+}
@@ -0,0 +1,172 @@
+// Package logging writes ccc's structured logs: JSON lines (log/slog) in a
+// size-rotated file shared by the listener and hook processes, with a
+// readable copy for the console, and reads them back for `ccc logs`.
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Attribute keys shared by all components
+const (
+	ComponentKey = "component" // Subsystem that logged the entry (hook, api, telegram...)
+	SessionKey   = "session"   // ccc session name
+	IDKey        = "cid"       // Correlation ID of the prompt or request being handled
+)
+
+const (
+	DefaultMaxBytes = 10 << 20 // Rotate the log above 10 MB
+	DefaultFiles    = 3        // Rotated files kept (ccc.log.1 ... ccc.log.3)
+)
+
+// Options configures a logger
+type Options struct {
+	Path     string     // Log file
+	Level    slog.Level // Minimum level written
+	MaxBytes int64      // Rotate above this size (default: DefaultMaxBytes)
+	Files    int        // Rotated files kept (default: DefaultFiles)
+	Console  io.Writer  // Optional readable copy of each entry
+}
+
+// New returns a JSON logger writing to a rotating file
+func New(opts Options) *slog.Logger {
+	var w io.Writer = &RotatingFile{Path: opts.Path, MaxBytes: opts.MaxBytes, Files: opts.Files}
+	if opts.Console != nil {
+		w = io.MultiWriter(w, Pretty(opts.Console))
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: opts.Level}))
+}
+
+// ParseLevel parses debug, info, warn or error; empty means info
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// NewID returns a short random correlation ID
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RotatingFile appends to a file and rotates it by size. The file is opened
+// for each write so several processes can share it and each notices the
+// others' rotations; an flock on <path>.lock keeps two processes from
+// rotating at once.
+type RotatingFile struct {
+	Path     string
+	MaxBytes int64
+	Files    int
+
+	mu sync.Mutex
+}
+
+// Write appends p, rotating first if it would grow the file past MaxBytes
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lock, err := os.OpenFile(f.Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	maxBytes := f.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if st, err := os.Stat(f.Path); err == nil && st.Size() > 0 && st.Size()+int64(len(p)) > maxBytes {
+		f.rotate()
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Write(p)
+}
+
+// rotate shifts ccc.log.N-1 to ccc.log.N and the live file to ccc.log.1
+func (f *RotatingFile) rotate() {
+	files := f.Files
+	if files <= 0 {
+		files = DefaultFiles
+	}
+	os.Remove(fmt.Sprintf("%s.%d", f.Path, files))
+	for i := files - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i+1))
+	}
+	os.Rename(f.Path, f.Path+".1")
+}
+
+// Files returns the existing log files for path, oldest first
+func Files(path string, files int) []string {
+	if files <= 0 {
+		files = DefaultFiles
+	}
+	var out []string
+	for i := files; i >= 1; i-- {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err == nil {
+			out = append(out, name)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		out = append(out, path)
+	}
+	return out
+}
+
+// prettyWriter reformats JSON log lines as readable text
+type prettyWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// Pretty returns a writer that prints each JSON log line written to it in
+// the format of Entry.Format
+func Pretty(w io.Writer) io.Writer {
+	return &prettyWriter{w: w}
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := p.buf[:i]
+		p.buf = p.buf[i+1:]
+		if e, ok := ParseEntry(line); ok {
+			fmt.Fprintln(p.w, e.Format())
+		} else {
+			fmt.Fprintf(p.w, "%s\n", line)
+		}
+	}
+	return len(b), nil
+}
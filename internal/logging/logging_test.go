@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoggerAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ccc.log")
+	var console bytes.Buffer
+	logger := New(Options{Path: path, Level: slog.LevelDebug, Console: &console})
+
+	hook := logger.With(ComponentKey, "hook")
+	hook.Info("response delivered", SessionKey, "api", IDKey, "abc123", "chars", 42)
+	hook.Debug("parsed transcript", SessionKey, "web")
+	logger.With(ComponentKey, "telegram").Warn("request failed", "method", "sendMessage", "error", "Too Many Requests")
+
+	all := ReadFiles(Files(path, 0), Filter{Level: slog.LevelDebug}, 0)
+	if len(all) != 3 {
+		t.Fatalf("read %d entries, want 3", len(all))
+	}
+	e := all[0]
+	if e.Component != "hook" || e.Session != "api" || e.ID != "abc123" || e.Msg != "response delivered" || e.Attrs["chars"] != float64(42) {
+		t.Errorf("entry = %+v", e)
+	}
+
+	if got := ReadFiles(Files(path, 0), Filter{Component: "HOOK"}, 0); len(got) != 1 {
+		t.Errorf("component filter (info) matched %d entries, want 1", len(got))
+	}
+	if got := ReadFiles(Files(path, 0), Filter{Session: "web", Level: slog.LevelDebug}, 0); len(got) != 1 || got[0].Msg != "parsed transcript" {
+		t.Errorf("session filter = %+v", got)
+	}
+	if got := ReadFiles(Files(path, 0), Filter{Level: slog.LevelWarn}, 0); len(got) != 1 || got[0].Component != "telegram" {
+		t.Errorf("level filter = %+v", got)
+	}
+	if got := ReadFiles(Files(path, 0), Filter{Level: slog.LevelDebug}, 2); len(got) != 2 || got[1].Component != "telegram" {
+		t.Errorf("last 2 = %+v", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(console.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "INFO  hook      [abc123] response delivered session=api chars=42") {
+		t.Errorf("console output:\n%s", console.String())
+	}
+	if !strings.Contains(lines[2], `error="Too Many Requests"`) {
+		t.Errorf("quoted value missing: %s", lines[2])
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ccc.log")
+	f := &RotatingFile{Path: path, MaxBytes: 100, Files: 2}
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := Files(path, 2)
+	want := []string{path + ".2", path + ".1", path}
+	if fmt.Sprint(files) != fmt.Sprint(want) {
+		t.Fatalf("Files() = %v, want %v", files, want)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("kept more rotated files than configured")
+	}
+	for _, name := range files {
+		if st, _ := os.Stat(name); st.Size() != 60 {
+			t.Errorf("%s has %d bytes, want 60", name, st.Size())
+		}
+	}
+}
+
+func TestRotationShared(t *testing.T) {
+	// Separate RotatingFiles stand in for processes sharing the log
+	path := filepath.Join(t.TempDir(), "ccc.log")
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f := &RotatingFile{Path: path, MaxBytes: 600, Files: 100}
+			for i := 0; i < 50; i++ {
+				f.Write(line)
+			}
+		}()
+	}
+	wg.Wait()
+	var total int64
+	for _, name := range Files(path, 100) {
+		st, _ := os.Stat(name)
+		if st.Size() > 600 {
+			t.Errorf("%s has %d bytes, over the limit", name, st.Size())
+		}
+		total += st.Size()
+	}
+	if total != 4*50*60 {
+		t.Errorf("logs hold %d bytes, want %d", total, 4*50*60)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		if got, err := ParseLevel(in); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) succeeded")
+	}
+}
+
+func TestFollow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ccc.log")
+	os.WriteFile(path, []byte("old\n"), 0600)
+
+	stop := make(chan struct{})
+	got := make(chan string, 10)
+	go Follow(path, 5*time.Millisecond, stop, func(line []byte) { got <- string(line) })
+	defer close(stop)
+	time.Sleep(20 * time.Millisecond)
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString("new 1\nnew")
+	time.Sleep(20 * time.Millisecond)
+	f.WriteString(" 2\n")
+	f.Close()
+	for _, want := range []string{"new 1", "new 2"} {
+		select {
+		case line := <-got:
+			if line != want {
+				t.Errorf("line = %q, want %q", line, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	// A rotated (smaller) file is read from the start
+	os.WriteFile(path, []byte("fresh\n"), 0600)
+	select {
+	case line := <-got:
+		if line != "fresh" {
+			t.Errorf("after rotation line = %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out after rotation")
+	}
+}
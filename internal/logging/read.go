@@ -0,0 +1,206 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is one parsed log line
+type Entry struct {
+	Time      time.Time
+	Level     slog.Level
+	Msg       string
+	Component string
+	Session   string
+	ID        string
+	Attrs     map[string]interface{} // Remaining attributes
+}
+
+// ParseEntry parses a JSON log line written by New
+func ParseEntry(line []byte) (Entry, bool) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(line), &raw); err != nil {
+		return Entry{}, false
+	}
+	e := Entry{Attrs: raw}
+	take := func(key string) string {
+		v, _ := raw[key].(string)
+		delete(raw, key)
+		return v
+	}
+	t, err := time.Parse(time.RFC3339Nano, take(slog.TimeKey))
+	if err != nil {
+		return Entry{}, false
+	}
+	e.Time = t
+	e.Level.UnmarshalText([]byte(take(slog.LevelKey)))
+	e.Msg = take(slog.MessageKey)
+	e.Component = take(ComponentKey)
+	e.Session = take(SessionKey)
+	e.ID = take(IDKey)
+	return e, true
+}
+
+// Format renders an entry as one readable line:
+// "2026-03-10 14:02:11 INFO  hook      [3f9a0c12e4b1] msg key=value"
+func (e Entry) Format() string {
+	var b strings.Builder
+	b.WriteString(e.Time.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, " %-5s %-9s", e.Level, e.Component)
+	if e.ID != "" {
+		fmt.Fprintf(&b, " [%s]", e.ID)
+	}
+	b.WriteString(" " + e.Msg)
+	if e.Session != "" {
+		fmt.Fprintf(&b, " session=%s", quoteValue(e.Session))
+	}
+	keys := make([]string, 0, len(e.Attrs))
+	for k := range e.Attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", k, quoteValue(e.Attrs[k]))
+	}
+	return b.String()
+}
+
+// quoteValue quotes values containing spaces or quotes
+func quoteValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = fmt.Sprint(v)
+	default:
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\n\t") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// Filter selects log entries; zero fields match everything
+type Filter struct {
+	Session   string
+	Component string
+	ID        string
+	Level     slog.Level // Minimum level (the zero value is info)
+	Since     time.Time
+}
+
+// Match reports whether e passes the filter
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.Session != "" && e.Session != f.Session:
+		return false
+	case f.Component != "" && !strings.EqualFold(e.Component, f.Component):
+		return false
+	case f.ID != "" && e.ID != f.ID:
+		return false
+	case e.Level < f.Level:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	}
+	return true
+}
+
+// Read returns the entries in r that match f, keeping only the last n when
+// n > 0. Lines that are not log entries are skipped.
+func Read(r io.Reader, f Filter, n int) []Entry {
+	var out []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		e, ok := ParseEntry(scanner.Bytes())
+		if !ok || !f.Match(e) {
+			continue
+		}
+		out = append(out, e)
+		if n > 0 && len(out) > 2*n {
+			out = append(out[:0], out[len(out)-n:]...)
+		}
+	}
+	if n > 0 && len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// ReadFiles is Read over several files in order
+func ReadFiles(paths []string, f Filter, n int) []Entry {
+	var out []Entry
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		out = append(out, Read(file, f, n)...)
+		file.Close()
+	}
+	if n > 0 && len(out) > n {
+		out = out[len(out)-n:]
+	}
+	return out
+}
+
+// Follow calls fn for each line appended to path after the call, until stop
+// is closed. It checks for new data every poll interval and starts over
+// from the beginning when the file is rotated.
+func Follow(path string, poll time.Duration, stop <-chan struct{}, fn func(line []byte)) {
+	var offset int64
+	if st, err := os.Stat(path); err == nil {
+		offset = st.Size()
+	}
+	var partial []byte
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if st.Size() < offset {
+			offset, partial = 0, nil // Rotated
+		}
+		if st.Size() == offset {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		file.Seek(offset, io.SeekStart)
+		data, _ := io.ReadAll(file)
+		file.Close()
+		offset += int64(len(data))
+
+		data = append(partial, data...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			fn(data[:i])
+			data = data[i+1:]
+		}
+		partial = append([]byte(nil), data...)
+	}
+}
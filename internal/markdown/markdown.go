@@ -0,0 +1,259 @@
+// Package markdown converts Claude's Markdown output to Telegram-safe HTML
+// and splits the result into messages without breaking entities.
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CodeBlock is a fenced code block extracted from a message
+type CodeBlock struct {
+	Lang  string
+	Code  string
+	Lines int
+}
+
+var (
+	fenceRe   = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+	headingRe = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	bulletRe  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedRe = regexp.MustCompile(`^(\s*)(\d+[.)])\s+(.*)$`)
+	ruleRe    = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	tableRe   = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	linkRe    = regexp.MustCompile(`^\[([^\]\n]+)\]\(([^)\s]+)\)`)
+)
+
+// ToHTML converts Markdown to the HTML subset supported by the Telegram Bot API
+// (parse_mode=HTML). Everything that is not recognized as markup is escaped.
+func ToHTML(md string) string {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var out []string
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		// Fenced code block (unterminated fences run to the end of the text)
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			var code []string
+			j := i + 1
+			for ; j < len(lines); j++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[j]), m[1]) {
+					break
+				}
+				code = append(code, lines[j])
+			}
+			out = append(out, codeBlockHTML(m[2], strings.Join(code, "\n")))
+			i = j
+			continue
+		}
+
+		// Tables can't be rendered by Telegram; keep them aligned in monospace
+		if tableRe.MatchString(line) {
+			j := i
+			for j < len(lines) && tableRe.MatchString(lines[j]) {
+				j++
+			}
+			out = append(out, "<pre>"+html.EscapeString(strings.Join(lines[i:j], "\n"))+"</pre>")
+			i = j - 1
+			continue
+		}
+
+		// Blockquote (consecutive "> " lines)
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			var quoted []string
+			j := i
+			for j < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[j]), ">") {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[j]), ">")
+				quoted = append(quoted, inline(strings.TrimPrefix(q, " ")))
+				j++
+			}
+			out = append(out, "<blockquote>"+strings.Join(quoted, "\n")+"</blockquote>")
+			i = j - 1
+			continue
+		}
+
+		switch {
+		case headingRe.MatchString(line):
+			out = append(out, "<b>"+inline(headingRe.FindStringSubmatch(line)[1])+"</b>")
+		case ruleRe.MatchString(line):
+			out = append(out, "──────────")
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+"• "+inline(m[2]))
+		case orderedRe.MatchString(line):
+			m := orderedRe.FindStringSubmatch(line)
+			out = append(out, m[1]+m[2]+" "+inline(m[3]))
+		default:
+			out = append(out, inline(line))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func codeBlockHTML(lang string, code string) string {
+	if lang != "" {
+		return fmt.Sprintf(`<pre><code class="language-%s">%s</code></pre>`, html.EscapeString(lang), html.EscapeString(code))
+	}
+	return "<pre>" + html.EscapeString(code) + "</pre>"
+}
+
+// inline converts inline markup: `code`, **bold**, __bold__, *italic*,
+// _italic_, ~~strike~~ and [text](url) links
+func inline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		rest := s[i:]
+
+		switch {
+		case rest[0] == '`':
+			if end := strings.Index(rest[1:], "`"); end > 0 {
+				b.WriteString("<code>" + html.EscapeString(rest[1:end+1]) + "</code>")
+				i += end + 2
+				continue
+			}
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__"):
+			if inner, n := delimited(s, i, rest[:2]); n > 0 {
+				b.WriteString("<b>" + inline(inner) + "</b>")
+				i += n
+				continue
+			}
+		case strings.HasPrefix(rest, "~~"):
+			if inner, n := delimited(s, i, "~~"); n > 0 {
+				b.WriteString("<s>" + inline(inner) + "</s>")
+				i += n
+				continue
+			}
+		case rest[0] == '*' || rest[0] == '_':
+			if inner, n := delimited(s, i, rest[:1]); n > 0 {
+				b.WriteString("<i>" + inline(inner) + "</i>")
+				i += n
+				continue
+			}
+		case rest[0] == '[':
+			if m := linkRe.FindStringSubmatch(rest); m != nil && isSafeURL(m[2]) {
+				b.WriteString(`<a href="` + html.EscapeString(m[2]) + `">` + inline(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+		b.WriteString(html.EscapeString(string(r)))
+		i += size
+	}
+	return b.String()
+}
+
+// delimited finds an emphasis span opening at s[i:] with the given marker.
+// The content must not start or end with a space, and underscore markers must
+// sit on word boundaries so snake_case identifiers stay untouched.
+// Returns the inner text and the total length consumed, or 0 if not a span.
+func delimited(s string, i int, marker string) (string, int) {
+	start := i + len(marker)
+	if start >= len(s) || s[start] == ' ' {
+		return "", 0
+	}
+	if marker[0] == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", 0
+	}
+
+	for j := start + 1; j+len(marker) <= len(s); j++ {
+		if s[j:j+len(marker)] != marker || s[j-1] == ' ' {
+			continue
+		}
+		after := j + len(marker)
+		// "**" is not a closing "*"
+		if len(marker) == 1 && after < len(s) && s[after] == marker[0] {
+			j++
+			continue
+		}
+		if marker[0] == '_' && after < len(s) && isWordByte(s[after]) {
+			continue
+		}
+		return s[start:j], after - i
+	}
+	return "", 0
+}
+
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func isSafeURL(u string) bool {
+	lower := strings.ToLower(u)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "tg://")
+}
+
+// ExtractCodeBlocks removes fenced code blocks with at least minLines lines
+// from md, replacing each with a short "📎 name" reference. Returns the new
+// text and the extracted blocks in order. Names are generated by FileName.
+func ExtractCodeBlocks(md string, minLines int) (string, []CodeBlock) {
+	if minLines <= 0 {
+		return md, nil
+	}
+	lines := strings.Split(md, "\n")
+	var out []string
+	var blocks []CodeBlock
+
+	for i := 0; i < len(lines); i++ {
+		m := fenceRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			out = append(out, lines[i])
+			continue
+		}
+		j := i + 1
+		for j < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[j]), m[1]) {
+			j++
+		}
+		code := lines[i+1 : j]
+		if len(code) < minLines {
+			end := j + 1
+			if end > len(lines) {
+				end = len(lines)
+			}
+			out = append(out, lines[i:end]...)
+		} else {
+			block := CodeBlock{Lang: m[2], Code: strings.Join(code, "\n") + "\n", Lines: len(code)}
+			out = append(out, fmt.Sprintf("📎 %s (%d lines)", FileName(block, len(blocks)+1), block.Lines))
+			blocks = append(blocks, block)
+		}
+		i = j
+	}
+	return strings.Join(out, "\n"), blocks
+}
+
+// FileName returns the attachment name for the n-th extracted code block
+func FileName(block CodeBlock, n int) string {
+	return fmt.Sprintf("snippet-%d.%s", n, extensionFor(block.Lang))
+}
+
+func extensionFor(lang string) string {
+	switch strings.ToLower(lang) {
+	case "go", "golang":
+		return "go"
+	case "python", "py":
+		return "py"
+	case "javascript", "js":
+		return "js"
+	case "typescript", "ts":
+		return "ts"
+	case "bash", "sh", "shell", "zsh":
+		return "sh"
+	case "json":
+		return "json"
+	case "yaml", "yml":
+		return "yaml"
+	case "rust", "rs":
+		return "rs"
+	case "diff", "patch":
+		return "diff"
+	case "html", "css", "sql", "java", "c", "cpp", "rb", "swift", "kt", "toml", "md":
+		return strings.ToLower(lang)
+	}
+	return "txt"
+}
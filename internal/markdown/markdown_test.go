@@ -0,0 +1,116 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain text is escaped", "a < b && c > d", "a &lt; b &amp;&amp; c &gt; d"},
+		{"bold", "this is **important**", "this is <b>important</b>"},
+		{"italic", "*really* and _truly_", "<i>really</i> and <i>truly</i>"},
+		{"snake_case untouched", "call get_last_message_id now", "call get_last_message_id now"},
+		{"inline code escapes", "use `a<b>` here", "use <code>a&lt;b&gt;</code> here"},
+		{"no markup inside code", "`**not bold**`", "<code>**not bold**</code>"},
+		{"strikethrough", "~~old~~ new", "<s>old</s> new"},
+		{"link", "see [docs](https://example.com/a?b=1&c=2)", `see <a href="https://example.com/a?b=1&amp;c=2">docs</a>`},
+		{"unsafe link kept literal", "[x](javascript:alert)", "[x](javascript:alert)"},
+		{"heading", "## Summary", "<b>Summary</b>"},
+		{"bullets", "- one\n* two", "• one\n• two"},
+		{"ordered list", "1. first", "1. first"},
+		{"unmatched star", "2 * 3 = 6", "2 * 3 = 6"},
+		{"code fence", "```go\nif a < b {\n}\n```", "<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>"},
+		{"code fence without lang", "```\n**x**\n```", "<pre>**x**</pre>"},
+		{"unterminated fence", "```\nx", "<pre>x</pre>"},
+		{"blockquote", "> quoted **text**\n> more", "<blockquote>quoted <b>text</b>\nmore</blockquote>"},
+		{"table", "| a | b |\n|---|---|\n| 1 | 2 |", "<pre>| a | b |\n|---|---|\n| 1 | 2 |</pre>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToHTML(tt.input); got != tt.expected {
+				t.Errorf("ToHTML(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitShort(t *testing.T) {
+	chunks := Split("<b>hi</b>", 100)
+	if len(chunks) != 1 || chunks[0] != "<b>hi</b>" {
+		t.Errorf("Split() = %q, want single chunk", chunks)
+	}
+}
+
+func TestSplitClosesAndReopensCodeBlock(t *testing.T) {
+	var code []string
+	for i := 0; i < 100; i++ {
+		code = append(code, "fmt.Println(&quot;line&quot;)")
+	}
+	input := "intro\n<pre><code class=\"language-go\">" + strings.Join(code, "\n") + "</code></pre>\noutro"
+
+	chunks := Split(input, 1000)
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	for i, c := range chunks {
+		if len(c) > 1000 {
+			t.Errorf("chunk %d is %d bytes, want <= 1000", i, len(c))
+		}
+		if strings.Count(c, "<pre>") != strings.Count(c, "</pre>") || strings.Count(c, "<code") != strings.Count(c, "</code>") {
+			t.Errorf("chunk %d has unbalanced tags: %q", i, c)
+		}
+		if i > 0 && i < len(chunks)-1 && !strings.HasPrefix(c, "<pre><code class=\"language-go\">") {
+			t.Errorf("chunk %d does not reopen the code block: %q", i, c[:40])
+		}
+	}
+
+	var joined strings.Builder
+	for _, c := range chunks {
+		joined.WriteString(StripTags(c))
+	}
+	if strings.Count(joined.String(), `fmt.Println("line")`) != 100 {
+		t.Errorf("lines lost or broken while splitting")
+	}
+}
+
+func TestSplitLongWordKeepsEntities(t *testing.T) {
+	input := strings.Repeat("a&amp;", 500)
+	for _, c := range Split(input, 200) {
+		if strings.HasSuffix(c, "&") || strings.HasSuffix(c, "&amp") || strings.HasPrefix(c, "amp;") {
+			t.Fatalf("entity split across chunks: %q", c)
+		}
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	got := StripTags(`<b>a</b> &lt;tag&gt; <a href="https://x.y">link</a>`)
+	if got != "a <tag> link" {
+		t.Errorf("StripTags() = %q", got)
+	}
+}
+
+func TestExtractCodeBlocks(t *testing.T) {
+	md := "short:\n```sh\nls\n```\nlong:\n```python\na = 1\nb = 2\nc = 3\n```\ndone"
+
+	text, blocks := ExtractCodeBlocks(md, 3)
+	if len(blocks) != 1 {
+		t.Fatalf("extracted %d blocks, want 1", len(blocks))
+	}
+	if blocks[0].Lang != "python" || blocks[0].Lines != 3 || blocks[0].Code != "a = 1\nb = 2\nc = 3\n" {
+		t.Errorf("unexpected block: %+v", blocks[0])
+	}
+	want := "short:\n```sh\nls\n```\nlong:\n📎 snippet-1.py (3 lines)\ndone"
+	if text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	if text, blocks := ExtractCodeBlocks(md, 0); text != md || blocks != nil {
+		t.Errorf("minLines=0 should disable extraction")
+	}
+}
@@ -0,0 +1,172 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// splitReserve is kept free in every chunk for closing tags
+const splitReserve = 64
+
+var tagRe = regexp.MustCompile(`<(/?)([a-z]+)[^>]*>`)
+
+type piece struct {
+	text  string
+	tag   bool
+	close bool
+	name  string
+}
+
+type openTag struct {
+	name string
+	raw  string
+}
+
+// Split splits Telegram HTML into chunks of at most maxLen bytes. It prefers
+// to break at newlines, then spaces, never breaks inside a tag or an entity,
+// and closes any open tags (e.g. <pre>) at the end of a chunk, reopening them
+// at the start of the next one.
+func Split(s string, maxLen int) []string {
+	if len(s) <= maxLen {
+		return []string{s}
+	}
+	budget := maxLen - splitReserve
+	pieces := tokenize(s, budget/2)
+
+	var chunks []string
+	var open []openTag
+	for i := 0; i < len(pieces); {
+		prefix := openers(open)
+		size := len(prefix)
+		stack := append([]openTag(nil), open...)
+
+		nlAt, spaceAt := -1, -1
+		var nlStack, spaceStack []openTag
+		j := i
+		for ; j < len(pieces); j++ {
+			p := pieces[j]
+			if size+len(p.text) > budget && j > i {
+				break
+			}
+			size += len(p.text)
+			stack = apply(stack, p)
+			if p.tag || size <= budget/2 {
+				continue
+			}
+			if strings.HasSuffix(p.text, "\n") {
+				nlAt, nlStack = j+1, append([]openTag(nil), stack...)
+			} else if strings.HasSuffix(p.text, " ") {
+				spaceAt, spaceStack = j+1, append([]openTag(nil), stack...)
+			}
+		}
+		if j < len(pieces) {
+			if nlAt > 0 {
+				j, stack = nlAt, nlStack
+			} else if spaceAt > 0 {
+				j, stack = spaceAt, spaceStack
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString(prefix)
+		for k := i; k < j; k++ {
+			b.WriteString(pieces[k].text)
+		}
+		body := strings.TrimRight(b.String(), " \n")
+		if strings.TrimSpace(StripTags(body)) != "" {
+			chunks = append(chunks, body+closers(stack))
+		}
+		open = stack
+		i = j
+	}
+	return chunks
+}
+
+// tokenize breaks HTML into tags and text pieces. Text pieces end after each
+// newline or space and are never longer than maxText bytes; entities such as
+// &amp; are kept whole.
+func tokenize(s string, maxText int) []piece {
+	var pieces []piece
+	addText := func(t string) {
+		for len(t) > 0 {
+			n := strings.IndexAny(t, "\n ")
+			if n < 0 {
+				n = len(t)
+			} else {
+				n++
+			}
+			if n > maxText {
+				n = safeCut(t, maxText)
+			}
+			pieces = append(pieces, piece{text: t[:n]})
+			t = t[n:]
+		}
+	}
+
+	last := 0
+	for _, m := range tagRe.FindAllStringSubmatchIndex(s, -1) {
+		addText(s[last:m[0]])
+		pieces = append(pieces, piece{
+			text:  s[m[0]:m[1]],
+			tag:   true,
+			close: m[3] > m[2],
+			name:  s[m[4]:m[5]],
+		})
+		last = m[1]
+	}
+	addText(s[last:])
+	return pieces
+}
+
+// safeCut returns a cut position <= n that doesn't split a rune or an entity
+func safeCut(t string, n int) int {
+	for n > 0 && !utf8.RuneStart(t[n]) {
+		n--
+	}
+	if amp := strings.LastIndex(t[:n], "&"); amp >= 0 && !strings.Contains(t[amp:n], ";") {
+		n = amp
+	}
+	if n == 0 {
+		_, n = utf8.DecodeRuneInString(t)
+	}
+	return n
+}
+
+func apply(stack []openTag, p piece) []openTag {
+	if !p.tag {
+		return stack
+	}
+	if !p.close {
+		return append(stack, openTag{name: p.name, raw: p.text})
+	}
+	for k := len(stack) - 1; k >= 0; k-- {
+		if stack[k].name == p.name {
+			return append(stack[:k:k], stack[k+1:]...)
+		}
+	}
+	return stack
+}
+
+func openers(stack []openTag) string {
+	var b strings.Builder
+	for _, t := range stack {
+		b.WriteString(t.raw)
+	}
+	return b.String()
+}
+
+func closers(stack []openTag) string {
+	var b strings.Builder
+	for k := len(stack) - 1; k >= 0; k-- {
+		b.WriteString("</" + stack[k].name + ">")
+	}
+	return b.String()
+}
+
+// StripTags converts Telegram HTML back to plain text (used when Telegram
+// rejects the markup)
+func StripTags(s string) string {
+	return html.UnescapeString(tagRe.ReplaceAllString(s, ""))
+}
@@ -0,0 +1,252 @@
+// Package metrics implements counters, gauges and histograms exported in the
+// Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds for network calls
+var DefaultBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry holds metrics in registration order
+type Registry struct {
+	mu      sync.Mutex
+	metrics []collector
+}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(c collector) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, c)
+	r.mu.Unlock()
+}
+
+// series keys values by their joined label values
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	keys   map[string][]string
+}
+
+func newSeries(name, help, kind string, labels []string) series {
+	return series{name: name, help: help, kind: kind, labels: labels, keys: map[string][]string{}}
+}
+
+// key returns the map key for label values, remembering the values
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", s.name, len(s.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := s.keys[k]; !ok {
+		s.keys[k] = append([]string(nil), values...)
+	}
+	return k
+}
+
+// sortedKeys returns the known keys in a stable order
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.keys))
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+}
+
+// Counter is a monotonically increasing value per label set
+type Counter struct {
+	series
+	values map[string]float64
+}
+
+// Counter registers a counter
+func (r *Registry) Counter(name string, help string, labels ...string) *Counter {
+	c := &Counter{series: newSeries(name, help, "counter", labels), values: map[string]float64{}}
+	r.add(c)
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Add adds v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	c.values[c.key(labelValues)] += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value, for tests
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.keys[k], "", ""), formatValue(c.values[k]))
+	}
+}
+
+// Sample is one value of a gauge
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc is a gauge whose samples are computed at scrape time
+type GaugeFunc struct {
+	series
+	collect func() []Sample
+}
+
+// GaugeFunc registers a gauge computed by collect on every scrape
+func (r *Registry) GaugeFunc(name string, help string, labels []string, collect func() []Sample) *GaugeFunc {
+	g := &GaugeFunc{series: newSeries(name, help, "gauge", labels), collect: collect}
+	r.add(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	samples := g.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+	g.header(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.Labels, "", ""), formatValue(s.Value))
+	}
+}
+
+// Histogram counts observations in cumulative buckets per label set
+type Histogram struct {
+	series
+	buckets []float64
+	counts  map[string][]uint64 // Per bucket, not cumulative
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// Histogram registers a histogram with the given upper bounds
+func (r *Registry) Histogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	h := &Histogram{
+		series:  newSeries(name, help, "histogram", labels),
+		buckets: b,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+	r.add(h)
+	return h
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(labelValues)
+	counts := h.counts[k]
+	if counts == nil {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[k] = counts
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			counts[i]++
+			break
+		}
+	}
+	h.sums[k] += v
+	h.totals[k]++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		values := h.keys[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += h.counts[k][i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatValue(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), h.totals[k])
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values, "", ""), formatValue(h.sums[k]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values, "", ""), h.totals[k])
+	}
+}
+
+// Write writes every metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]collector(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// formatLabels renders {a="x",b="y"}, with an optional extra label
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var parts []string
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		parts = append(parts, n+"="+strconv.Quote(v))
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+"="+strconv.Quote(extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("ccc_requests_total", "Requests.", "method", "result")
+	c.Inc("sendMessage", "ok")
+	c.Inc("sendMessage", "ok")
+	c.Inc("say \"hi\"", "error")
+	h := r.Histogram("ccc_latency_seconds", "Latency.", []float64{1, 0.1}, "host")
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(3, "a")
+	r.GaugeFunc("ccc_sessions", "Sessions.", []string{"state"}, func() []Sample {
+		return []Sample{{Labels: []string{"idle"}, Value: 2}, {Labels: []string{"busy"}, Value: 1}}
+	})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP ccc_requests_total Requests.
+# TYPE ccc_requests_total counter
+ccc_requests_total{method="say \"hi\"",result="error"} 1
+ccc_requests_total{method="sendMessage",result="ok"} 2
+# HELP ccc_latency_seconds Latency.
+# TYPE ccc_latency_seconds histogram
+ccc_latency_seconds_bucket{host="a",le="0.1"} 1
+ccc_latency_seconds_bucket{host="a",le="1"} 2
+ccc_latency_seconds_bucket{host="a",le="+Inf"} 3
+ccc_latency_seconds_sum{host="a"} 3.55
+ccc_latency_seconds_count{host="a"} 3
+# HELP ccc_sessions Sessions.
+# TYPE ccc_sessions gauge
+ccc_sessions{state="busy"} 1
+ccc_sessions{state="idle"} 2
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", rec.Header().Get("Content-Type"))
+	}
+	if c.Value("sendMessage", "ok") != 2 {
+		t.Errorf("Value() = %v", c.Value("sendMessage", "ok"))
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc() with the wrong number of labels did not panic")
+		}
+	}()
+	NewRegistry().Counter("x_total", "X.", "a").Inc()
+}
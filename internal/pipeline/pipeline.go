@@ -0,0 +1,140 @@
+// Package pipeline matches finished turns against forwarding rules and keeps
+// track of forwarding chains so that sessions can't prompt each other
+// forever.
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTemplate is the prompt sent to the target when a pipeline has none
+const DefaultTemplate = "Message from {session}:\n\n{response}"
+
+// DefaultMaxHops is how many forwards in a row a chain may take
+const DefaultMaxHops = 3
+
+// ChainTTL is how long a forwarded prompt counts as the origin of the
+// target's next turn
+const ChainTTL = time.Hour
+
+// Match reports whether response matches pattern (an empty pattern matches
+// everything) and returns the whole match followed by its groups
+func Match(pattern string, response string) ([]string, bool, error) {
+	if pattern == "" {
+		return nil, true, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid match %q: %v", pattern, err)
+	}
+	groups := re.FindStringSubmatch(response)
+	return groups, groups != nil, nil
+}
+
+// Render fills a template: {session} is the source session, {response} its
+// answer, {match} the text matched by the pattern and {1}..{9} its groups
+func Render(template string, session string, response string, groups []string) string {
+	if template == "" {
+		template = DefaultTemplate
+	}
+	pairs := []string{"{session}", session, "{response}", response}
+	match := ""
+	if len(groups) > 0 {
+		match = groups[0]
+	}
+	pairs = append(pairs, "{match}", match)
+	for i := 1; i <= 9; i++ {
+		g := ""
+		if i < len(groups) {
+			g = groups[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("{%d}", i), g)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// Chain is the list of sessions a forwarded prompt passed through
+type Chain struct {
+	Path []string  `json:"path"` // Oldest first, ending with the session that sent it
+	At   time.Time `json:"at"`
+}
+
+// State records, per session, the chain that produced its pending prompt
+type State struct {
+	Chains map[string]Chain `json:"chains"`
+}
+
+// Load reads the state; a missing file yields an empty state
+func Load(path string) (*State, error) {
+	s := &State{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.Chains == nil {
+		s.Chains = make(map[string]Chain)
+	}
+	return s, nil
+}
+
+// Save writes the state atomically, dropping expired chains
+func (s *State) Save(path string) error {
+	for name, c := range s.Chains {
+		if time.Since(c.At) > ChainTTL {
+			delete(s.Chains, name)
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Origin returns the chain that led to the current turn of session (nil if
+// the turn was not started by a pipeline)
+func (s *State) Origin(session string, now time.Time) []string {
+	c, ok := s.Chains[session]
+	if !ok || now.Sub(c.At) > ChainTTL {
+		return nil
+	}
+	return c.Path
+}
+
+// Next returns the chain for forwarding from session, given the chain that
+// started its turn, and whether it stays within maxHops forwards
+func Next(origin []string, session string, maxHops int) ([]string, bool) {
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	chain := append(append([]string(nil), origin...), session)
+	return chain, len(chain) <= maxHops
+}
+
+// Forwarded records that target was prompted through chain
+func (s *State) Forwarded(target string, chain []string, now time.Time) {
+	s.Chains[target] = Chain{Path: chain, At: now}
+}
+
+// Reset forgets the chain of a session (a person prompted it directly)
+func (s *State) Reset(session string) bool {
+	if _, ok := s.Chains[session]; !ok {
+		return false
+	}
+	delete(s.Chains, session)
+	return true
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	groups, ok, err := Match(`PR #(\d+) ready`, "Done. PR #42 ready for review")
+	if err != nil || !ok || groups[0] != "PR #42 ready" || groups[1] != "42" {
+		t.Errorf("Match() = %q, %v, %v", groups, ok, err)
+	}
+	if _, ok, _ := Match(`LGTM`, "needs work"); ok {
+		t.Error("non-matching response matched")
+	}
+	if _, ok, _ := Match("", "anything"); !ok {
+		t.Error("empty pattern should match everything")
+	}
+	if _, _, err := Match("(", "x"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestRender(t *testing.T) {
+	got := Render("Review PR {1} from {session} ({match}){2}", "api", "full text", []string{"PR #42 ready", "42"})
+	if got != "Review PR 42 from api (PR #42 ready)" {
+		t.Errorf("Render() = %q", got)
+	}
+	if got := Render("", "api", "All done", nil); got != "Message from api:\n\nAll done" {
+		t.Errorf("default template = %q", got)
+	}
+}
+
+func TestChainLimits(t *testing.T) {
+	now := time.Now()
+	s, _ := Load("")
+
+	// a (prompted by a person) -> b -> a -> b stops at 3 hops
+	chain, ok := Next(s.Origin("a", now), "a", 3)
+	if !ok || strings.Join(chain, ">") != "a" {
+		t.Fatalf("first hop = %v, %v", chain, ok)
+	}
+	s.Forwarded("b", chain, now)
+
+	chain, ok = Next(s.Origin("b", now), "b", 3)
+	if !ok || strings.Join(chain, ">") != "a>b" {
+		t.Fatalf("second hop = %v, %v", chain, ok)
+	}
+	s.Forwarded("a", chain, now)
+
+	chain, ok = Next(s.Origin("a", now), "a", 3)
+	if !ok {
+		t.Fatalf("third hop should be allowed: %v", chain)
+	}
+	s.Forwarded("b", chain, now)
+
+	if chain, ok = Next(s.Origin("b", now), "b", 3); ok {
+		t.Errorf("fourth hop should be refused: %v", chain)
+	}
+
+	// A direct prompt starts over; expired chains are ignored
+	if !s.Reset("b") || s.Origin("b", now) != nil {
+		t.Error("Reset() did not clear the chain")
+	}
+	if s.Origin("a", now.Add(2*ChainTTL)) != nil {
+		t.Error("expired chain still used")
+	}
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipelines.json")
+	s, _ := Load(path)
+	s.Forwarded("b", []string{"a"}, time.Now())
+	s.Forwarded("old", []string{"x"}, time.Now().Add(-2*ChainTTL))
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s2.Origin("b", time.Now()); len(got) != 1 || got[0] != "a" {
+		t.Errorf("reloaded chain = %v", got)
+	}
+	if _, ok := s2.Chains["old"]; ok {
+		t.Error("expired chain was saved")
+	}
+}
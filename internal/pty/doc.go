@@ -0,0 +1,8 @@
+// Package pty starts processes on a pseudo-terminal and provides the
+// terminal mode helpers needed to attach to one from a real terminal.
+package pty
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without pty support
+var ErrUnsupported = errors.New("pseudo-terminals are not supported on this platform")
@@ -0,0 +1,41 @@
+package pty
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+func open() (master *os.File, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var name [128]byte
+	for _, req := range []struct {
+		op  uintptr
+		arg uintptr
+	}{
+		{syscall.TIOCPTYGRANT, 0},
+		{syscall.TIOCPTYUNLK, 0},
+		{syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))},
+	} {
+		if err := ioctl(master.Fd(), req.op, req.arg); err != nil {
+			master.Close()
+			return nil, nil, err
+		}
+	}
+	path := string(name[:bytes.IndexByte(name[:], 0)])
+	slave, err = os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Start is not supported on this platform
+func Start(cmd *exec.Cmd, rows, cols int) (*os.File, error) { return nil, ErrUnsupported }
+
+// SetSize is not supported on this platform
+func SetSize(f *os.File, rows, cols int) error { return ErrUnsupported }
+
+// GetSize is not supported on this platform
+func GetSize(fd uintptr) (rows, cols int, err error) { return 0, 0, ErrUnsupported }
+
+// MakeRaw is not supported on this platform
+func MakeRaw(fd uintptr) (restore func(), err error) { return nil, ErrUnsupported }
+
+// IsTerminal always reports false on this platform
+func IsTerminal(fd uintptr) bool { return false }
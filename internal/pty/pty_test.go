@@ -0,0 +1,54 @@
+//go:build linux || darwin
+
+package pty
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "stty size; tty")
+	master, err := Start(cmd, 30, 100)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer master.Close()
+
+	// Reading the master fails with EIO once the child has exited
+	out, _ := io.ReadAll(master)
+	cmd.Wait()
+	if !strings.Contains(string(out), "30 100") {
+		t.Errorf("output = %q, want window size 30 100", out)
+	}
+	if strings.Contains(string(out), "not a tty") {
+		t.Errorf("child has no controlling terminal: %q", out)
+	}
+}
+
+func TestMakeRaw(t *testing.T) {
+	cmd := exec.Command("sleep", "1")
+	master, err := Start(cmd, 10, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		master.Close()
+	}()
+
+	if !IsTerminal(master.Fd()) {
+		t.Fatal("master should be a terminal")
+	}
+	restore, err := MakeRaw(master.Fd())
+	if err != nil {
+		t.Fatalf("MakeRaw() error = %v", err)
+	}
+	restore()
+	if rows, cols, err := GetSize(master.Fd()); err != nil || rows != 10 || cols != 10 {
+		t.Errorf("GetSize() = %d, %d, %v", rows, cols, err)
+	}
+}
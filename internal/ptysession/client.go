@@ -0,0 +1,190 @@
+package ptysession
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kidandcat/ccc/internal/pty"
+)
+
+// DetachKey ends an attach session (Ctrl-])
+const DetachKey = 0x1d
+
+func call(socket string, req Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socket, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// Alive reports whether a session server answers on socket
+func Alive(socket string) bool {
+	_, err := call(socket, Request{Op: "info"})
+	return err == nil
+}
+
+// GetInfo returns information about a session
+func GetInfo(socket string) (*Info, error) {
+	resp, err := call(socket, Request{Op: "info"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+// Send writes raw input (text, "\r" for Enter, escape sequences) to the program
+func Send(socket string, data string) error {
+	_, err := call(socket, Request{Op: "send", Data: data})
+	return err
+}
+
+// Capture returns up to history scrollback lines followed by the screen
+func Capture(socket string, history int) ([]string, error) {
+	resp, err := call(socket, Request{Op: "capture", Lines: history})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Lines, nil
+}
+
+// CaptureANSI is like Capture but keeps colors as escape sequences
+func CaptureANSI(socket string, history int) ([]string, error) {
+	resp, err := call(socket, Request{Op: "capture", Lines: history, ANSI: true})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Lines, nil
+}
+
+// Restart starts the session's restart command after the program exited
+func Restart(socket string) error {
+	_, err := call(socket, Request{Op: "restart"})
+	return err
+}
+
+// Kill stops the program and the session server
+func Kill(socket string) error {
+	_, err := call(socket, Request{Op: "kill"})
+	return err
+}
+
+// Attach connects a terminal to the session until DetachKey is pressed or
+// the session ends. in must be a terminal; it is put into raw mode.
+func Attach(socket string, in *os.File, out io.Writer) error {
+	rows, cols, err := pty.GetSize(in.Fd())
+	if err != nil {
+		return fmt.Errorf("not a terminal: %w", err)
+	}
+	conn, err := net.DialTimeout("unix", socket, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(Request{Op: "attach", Rows: rows, Cols: cols}); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	var resp Response
+	if json.Unmarshal(line, &resp) != nil || !resp.OK {
+		return fmt.Errorf("attach refused: %s", resp.Error)
+	}
+
+	restore, err := pty.MakeRaw(in.Fd())
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// Resize the session along with the terminal
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			if rows, cols, err := pty.GetSize(in.Fd()); err == nil {
+				size := make([]byte, 4)
+				binary.BigEndian.PutUint16(size, uint16(rows))
+				binary.BigEndian.PutUint16(size[2:], uint16(cols))
+				writeFrame(conn, frameResize, size)
+			}
+		}
+	}()
+
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(out, r)
+		close(ended)
+	}()
+
+	input := make(chan []byte)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			input <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	for {
+		select {
+		case <-ended:
+			return nil
+		case p, ok := <-input:
+			if !ok {
+				return nil
+			}
+			for i, b := range p {
+				if b == DetachKey {
+					writeFrame(conn, frameData, p[:i])
+					return nil
+				}
+			}
+			if err := writeFrame(conn, frameData, p); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	if len(payload) == 0 && kind == frameData {
+		return nil
+	}
+	frame := make([]byte, 3+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(payload)))
+	copy(frame[3:], payload)
+	_, err := w.Write(frame)
+	return err
+}
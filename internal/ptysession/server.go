@@ -0,0 +1,418 @@
+// Package ptysession runs a program on a pseudo-terminal behind a Unix
+// socket, as a lightweight replacement for a tmux session. The server keeps
+// the screen and scrollback in a terminal emulator; clients can send keys,
+// capture the screen, restart the program and attach interactively.
+package ptysession
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kidandcat/ccc/internal/pty"
+	"github.com/kidandcat/ccc/internal/vterm"
+)
+
+// Options configures a session server
+type Options struct {
+	Name       string   // Session name (informational)
+	Socket     string   // Unix socket path
+	Dir        string   // Working directory of the program
+	Command    []string // Program started with the session
+	Restart    []string // Program started by a restart (default: Command)
+	Env        []string // Environment (default: the server's)
+	Rows, Cols int      // Size while no client is attached (default: 50x200)
+	Scrollback int      // Scrollback lines (default: 5000)
+}
+
+// Request is a client request (one JSON line)
+type Request struct {
+	Op    string `json:"op"`              // info, send, capture, attach, restart, kill
+	Data  string `json:"data,omitempty"`  // send: bytes to write to the terminal
+	Lines int    `json:"lines,omitempty"` // capture: scrollback lines before the screen
+	ANSI  bool   `json:"ansi,omitempty"`  // capture: keep colors as escape sequences
+	Rows  int    `json:"rows,omitempty"`  // attach: client terminal size
+	Cols  int    `json:"cols,omitempty"`
+}
+
+// Response is the server's reply (one JSON line)
+type Response struct {
+	OK    bool     `json:"ok"`
+	Error string   `json:"error,omitempty"`
+	Lines []string `json:"lines,omitempty"`
+	Info  *Info    `json:"info,omitempty"`
+}
+
+// Info describes a session
+type Info struct {
+	Name     string    `json:"name"`
+	PID      int       `json:"pid,omitempty"`
+	Running  bool      `json:"running"` // The program is alive
+	Started  time.Time `json:"started"`
+	Activity time.Time `json:"activity"` // Last output or input
+	Dir      string    `json:"dir"`      // Current directory of the program
+	Clients  int       `json:"clients"`
+}
+
+// Frame types sent by attached clients: [type][uint16 length][payload]
+const (
+	frameData   = 'd'
+	frameResize = 'r' // payload: uint16 rows, uint16 cols
+)
+
+type server struct {
+	opts Options
+	term *vterm.Terminal
+
+	mu       sync.Mutex
+	master   *os.File
+	cmd      *exec.Cmd
+	running  bool
+	started  time.Time
+	activity time.Time
+	clients  map[net.Conn]bool
+
+	listener net.Listener
+	done     chan struct{}
+	killOnce sync.Once
+
+	restartMu sync.Mutex // Held across a restart's check and start, and by kill
+}
+
+// Serve starts the program and serves the socket until the session is killed
+func Serve(opts Options) error {
+	if opts.Rows <= 0 {
+		opts.Rows = 50
+	}
+	if opts.Cols <= 0 {
+		opts.Cols = 200
+	}
+	if opts.Scrollback <= 0 {
+		opts.Scrollback = 5000
+	}
+	if len(opts.Restart) == 0 {
+		opts.Restart = opts.Command
+	}
+	if len(opts.Command) == 0 {
+		return fmt.Errorf("no command")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Socket), 0700); err != nil {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", opts.Socket, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("session %s is already running", opts.Name)
+	}
+	os.Remove(opts.Socket)
+	ln, err := net.Listen("unix", opts.Socket)
+	if err != nil {
+		return err
+	}
+	os.Chmod(opts.Socket, 0600)
+	defer os.Remove(opts.Socket)
+
+	s := &server{
+		opts:     opts,
+		term:     vterm.New(opts.Rows, opts.Cols, opts.Scrollback),
+		clients:  make(map[net.Conn]bool),
+		listener: ln,
+		done:     make(chan struct{}),
+	}
+	s.term.Reply = func(b []byte) {
+		// Called with the terminal lock held; answer asynchronously
+		go s.write(b)
+	}
+	if err := s.start(opts.Command); err != nil {
+		ln.Close()
+		return err
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *server) start(command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = s.opts.Dir
+	cmd.Env = s.opts.Env
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+
+	rows, cols := s.term.Size()
+	master, err := pty.Start(cmd, rows, cols)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.master, s.cmd, s.running = master, cmd, true
+	s.started, s.activity = time.Now(), time.Now()
+	s.mu.Unlock()
+
+	go s.pump(master, cmd)
+	return nil
+}
+
+// pump copies program output into the emulator and attached clients until
+// the program exits
+func (s *server) pump(master *os.File, cmd *exec.Cmd) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			s.output(buf[:n])
+		}
+		if err != nil {
+			break
+		}
+	}
+	err := cmd.Wait()
+	status := "exited"
+	if err != nil {
+		status = err.Error()
+	}
+	s.output([]byte(fmt.Sprintf("\r\n\x1b[0m[process %s]\r\n", status)))
+
+	s.mu.Lock()
+	if s.master == master {
+		s.running = false
+		s.master = nil
+	}
+	master.Close()
+	s.mu.Unlock()
+}
+
+func (s *server) output(p []byte) {
+	// Update the emulator under s.mu so attach() sees either all or none of p
+	s.mu.Lock()
+	s.term.Write(p)
+	s.activity = time.Now()
+	clients := make([]net.Conn, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Write(p); err != nil {
+			s.detach(c)
+			c.Close()
+		}
+	}
+}
+
+// write sends input to the program
+func (s *server) write(p []byte) error {
+	s.mu.Lock()
+	master := s.master
+	s.activity = time.Now()
+	s.mu.Unlock()
+	if master == nil {
+		return fmt.Errorf("process is not running")
+	}
+	_, err := master.Write(p)
+	return err
+}
+
+func (s *server) resize(rows, cols int) {
+	if rows <= 0 || cols <= 0 {
+		return
+	}
+	s.term.Resize(rows, cols)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.master != nil {
+		pty.SetSize(s.master, rows, cols)
+	}
+}
+
+func (s *server) detach(c net.Conn) {
+	s.mu.Lock()
+	_, ok := s.clients[c]
+	delete(s.clients, c)
+	last := ok && len(s.clients) == 0
+	s.mu.Unlock()
+	if last {
+		s.resize(s.opts.Rows, s.opts.Cols)
+	}
+}
+
+func (s *server) info() *Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := &Info{
+		Name:     s.opts.Name,
+		Running:  s.running,
+		Started:  s.started,
+		Activity: s.activity,
+		Dir:      s.opts.Dir,
+		Clients:  len(s.clients),
+	}
+	if s.running && s.cmd.Process != nil {
+		info.PID = s.cmd.Process.Pid
+		if dir, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", info.PID)); err == nil {
+			info.Dir = dir
+		}
+	}
+	return info
+}
+
+// kill stops the program and the server. Later calls wait for the first.
+func (s *server) kill() {
+	s.killOnce.Do(s.stop)
+}
+
+func (s *server) stop() {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	s.mu.Lock()
+	cmd, running := s.cmd, s.running
+	s.mu.Unlock()
+	if running && cmd.Process != nil {
+		// The program leads its own process group (Setsid)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGHUP)
+		for i := 0; i < 30; i++ {
+			time.Sleep(100 * time.Millisecond)
+			s.mu.Lock()
+			running = s.running
+			s.mu.Unlock()
+			if !running {
+				break
+			}
+		}
+		if running {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+	close(s.done)
+	s.listener.Close()
+}
+
+// restart starts the restart command unless the program is running or the
+// session is being killed
+func (s *server) restart() error {
+	s.restartMu.Lock()
+	defer s.restartMu.Unlock()
+	select {
+	case <-s.done:
+		return fmt.Errorf("session is stopping")
+	default:
+	}
+	s.mu.Lock()
+	running := s.running
+	s.mu.Unlock()
+	if running {
+		return fmt.Errorf("process is still running")
+	}
+	return s.start(s.opts.Restart)
+}
+
+func (s *server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	enc := json.NewEncoder(conn)
+
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		enc.Encode(Response{Error: "invalid request"})
+		conn.Close()
+		return
+	}
+
+	switch req.Op {
+	case "info":
+		enc.Encode(Response{OK: true, Info: s.info()})
+	case "send":
+		if err := s.write([]byte(req.Data)); err != nil {
+			enc.Encode(Response{Error: err.Error()})
+		} else {
+			enc.Encode(Response{OK: true})
+		}
+	case "capture":
+		if req.ANSI {
+			enc.Encode(Response{OK: true, Lines: s.term.ANSILines(req.Lines)})
+		} else {
+			enc.Encode(Response{OK: true, Lines: s.term.Lines(req.Lines)})
+		}
+	case "restart":
+		if err := s.restart(); err != nil {
+			enc.Encode(Response{Error: err.Error()})
+		} else {
+			enc.Encode(Response{OK: true})
+		}
+	case "kill":
+		enc.Encode(Response{OK: true})
+		conn.Close()
+		s.kill()
+		return
+	case "attach":
+		enc.Encode(Response{OK: true})
+		s.attach(conn, r, req)
+		return
+	default:
+		enc.Encode(Response{Error: "unknown op"})
+	}
+	conn.Close()
+}
+
+// attach streams the terminal to conn and feeds its input frames to the
+// program until the client disconnects
+func (s *server) attach(conn net.Conn, r *bufio.Reader, req Request) {
+	defer conn.Close()
+
+	s.resize(req.Rows, req.Cols)
+	// Register before redrawing so no output falls in between; hold the
+	// lock so a concurrent output() can't write ahead of the redraw
+	s.mu.Lock()
+	s.clients[conn] = true
+	conn.Write(s.term.Redraw())
+	s.mu.Unlock()
+	defer s.detach(conn)
+
+	hdr := make([]byte, 3)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		switch hdr[0] {
+		case frameData:
+			s.write(payload)
+		case frameResize:
+			if len(payload) == 4 {
+				s.resize(int(binary.BigEndian.Uint16(payload)), int(binary.BigEndian.Uint16(payload[2:])))
+				conn.Write(s.term.Redraw())
+			}
+		}
+	}
+}
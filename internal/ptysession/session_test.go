@@ -0,0 +1,197 @@
+package ptysession
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func captured(socket string) string {
+	lines, _ := Capture(socket, 100)
+	return strings.Join(lines, "\n")
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "s.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(Options{
+			Name:    "test",
+			Socket:  socket,
+			Dir:     t.TempDir(),
+			Command: []string{"sh"},
+			Restart: []string{"sh", "-c", "echo restarted; exec sh"},
+			Rows:    10,
+			Cols:    40,
+		})
+	}()
+	waitFor(t, "server", func() bool { return Alive(socket) })
+
+	if err := Send(socket, "echo $((6*7))\r"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	waitFor(t, "command output", func() bool { return strings.Contains(captured(socket), "\n42") })
+
+	info, err := GetInfo(socket)
+	if err != nil || !info.Running || info.PID == 0 {
+		t.Fatalf("GetInfo() = %+v, %v", info, err)
+	}
+
+	// The program exits but the session (and its screen) stays
+	Send(socket, "exit\r")
+	waitFor(t, "exit", func() bool {
+		info, _ := GetInfo(socket)
+		return info != nil && !info.Running
+	})
+	if !strings.Contains(captured(socket), "[process exited]") {
+		t.Errorf("screen should note the exit:\n%s", captured(socket))
+	}
+	if err := Send(socket, "x"); err == nil {
+		t.Error("Send() to an exited program should fail")
+	}
+
+	if err := Restart(socket); err != nil {
+		t.Fatalf("Restart() error = %v", err)
+	}
+	waitFor(t, "restart output", func() bool { return strings.Contains(captured(socket), "restarted") })
+
+	if err := Kill(socket); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() = %v after kill", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after kill")
+	}
+	if Alive(socket) {
+		t.Error("socket still answers after kill")
+	}
+}
+
+func TestConcurrentRestartAndKill(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "s.sock")
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(Options{
+			Name:    "test",
+			Socket:  socket,
+			Dir:     t.TempDir(),
+			Command: []string{"true"},
+			Restart: []string{"sh"},
+		})
+	}()
+	waitFor(t, "server", func() bool { return Alive(socket) })
+	waitFor(t, "exit", func() bool {
+		info, _ := GetInfo(socket)
+		return info != nil && !info.Running
+	})
+
+	// Only one of several racing restarts may start the program
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- Restart(socket)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	started := 0
+	for err := range errs {
+		if err == nil {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("%d restarts started the program, want 1", started)
+	}
+
+	// Racing kills must not panic the server
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Kill(socket)
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve() = %v after kill", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after kill")
+	}
+}
+
+func TestAttachProtocol(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "s.sock")
+	go Serve(Options{Socket: socket, Command: []string{"sh"}, Rows: 10, Cols: 40})
+	waitFor(t, "server", func() bool { return Alive(socket) })
+	defer Kill(socket)
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	json.NewEncoder(conn).Encode(Request{Op: "attach", Rows: 20, Cols: 60})
+
+	var out bytes.Buffer
+	var mu sync.Mutex
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			mu.Lock()
+			out.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	has := func(s string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return strings.Contains(out.String(), s)
+		}
+	}
+
+	waitFor(t, "attach reply and redraw", has("\x1b[2J"))
+	writeFrame(conn, frameData, []byte("stty size\r"))
+	waitFor(t, "client size applied", has("20 60"))
+
+	info, _ := GetInfo(socket)
+	if info.Clients != 1 {
+		t.Errorf("Clients = %d, want 1", info.Clients)
+	}
+	conn.Close()
+	waitFor(t, "detach", func() bool {
+		info, _ := GetInfo(socket)
+		return info.Clients == 0
+	})
+}
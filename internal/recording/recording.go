@@ -0,0 +1,400 @@
+// Package recording writes terminal output to asciicast v2 files, trims and
+// prunes them, and plays them back.
+//
+// A recording is a JSON header line followed by one event per line:
+// [seconds since the header timestamp, "o", output].
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Header is the first line of an asciicast v2 file
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is one chunk of output
+type Event struct {
+	Time float64 // Seconds since Header.Timestamp
+	Data string
+}
+
+// MarshalJSON encodes the event as [time, "o", data]
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, "o", e.Data})
+}
+
+// UnmarshalJSON decodes [time, type, data]; non-output events get empty data
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("invalid event %s", b)
+	}
+	var kind string
+	if err := json.Unmarshal(raw[0], &e.Time); err != nil {
+		return err
+	}
+	json.Unmarshal(raw[1], &kind)
+	if kind != "o" {
+		e.Data = ""
+		return nil
+	}
+	return json.Unmarshal(raw[2], &e.Data)
+}
+
+// flushInterval is how often Record appends buffered output to the file
+const flushInterval = 200 * time.Millisecond
+
+// Record copies r (a pane's output, e.g. from tmux pipe-pane) into the
+// recording at path until r ends. The file is opened for every flush, so
+// Trim and Prune may replace or remove it while recording; a removed file is
+// started again with a new header.
+func Record(r io.Reader, path string, width int, height int, title string) error {
+	chunks := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				close(chunks)
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	w := &writer{path: path, width: width, height: height, title: title}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				w.flush(time.Now())
+				return <-errc
+			}
+			w.add(chunk, time.Now())
+		case <-ticker.C:
+			if err := w.flush(time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writer buffers events and appends them to the file
+type writer struct {
+	path          string
+	width, height int
+	title         string
+	pending       []byte // Incomplete UTF-8 sequence from the last chunk
+	events        []timedChunk
+}
+
+type timedChunk struct {
+	at   time.Time
+	data string
+}
+
+func (w *writer) add(chunk []byte, now time.Time) {
+	data := append(w.pending, chunk...)
+	// Keep a multi-byte character split across reads for the next chunk
+	cut := len(data)
+	for i := 1; i <= 3 && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				cut = len(data) - i
+			}
+			break
+		}
+	}
+	w.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		w.events = append(w.events, timedChunk{at: now, data: string(data[:cut])})
+	}
+}
+
+func (w *writer) flush(now time.Time) error {
+	if len(w.events) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0700); err != nil {
+		return err
+	}
+
+	header, err := ReadHeader(w.path)
+	var out []byte
+	if err != nil {
+		header = Header{Version: 2, Width: w.width, Height: w.height, Timestamp: w.events[0].at.Unix(), Title: w.title,
+			Env: map[string]string{"TERM": "xterm-256color"}}
+		line, _ := json.Marshal(header)
+		out = append(line, '\n')
+	}
+	start := time.Unix(header.Timestamp, 0)
+	for _, e := range w.events {
+		t := e.at.Sub(start).Seconds()
+		if t < 0 {
+			t = 0
+		}
+		line, _ := json.Marshal(Event{Time: float64(int64(t*1e6)) / 1e6, Data: e.data})
+		out = append(append(out, line...), '\n')
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(out); err != nil {
+		return err
+	}
+	w.events = w.events[:0]
+	return nil
+}
+
+// ReadHeader reads the header line of a recording
+func ReadHeader(path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return Header{}, fmt.Errorf("empty recording")
+	}
+	var h Header
+	if err := json.Unmarshal(line, &h); err != nil {
+		return Header{}, fmt.Errorf("invalid recording header: %v", err)
+	}
+	if h.Version != 2 {
+		return Header{}, fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	return h, nil
+}
+
+// Read parses a whole recording. Malformed event lines (e.g. a line cut
+// short by a crash) are skipped.
+func Read(r io.Reader) (Header, []Event, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !sc.Scan() {
+		return Header{}, nil, fmt.Errorf("empty recording")
+	}
+	var h Header
+	if err := json.Unmarshal(sc.Bytes(), &h); err != nil || h.Version != 2 {
+		return Header{}, nil, fmt.Errorf("not an asciicast v2 recording")
+	}
+	var events []Event
+	for sc.Scan() {
+		var e Event
+		if json.Unmarshal(sc.Bytes(), &e) == nil && e.Data != "" {
+			events = append(events, e)
+		}
+	}
+	return h, events, sc.Err()
+}
+
+// Write writes a complete recording
+func Write(w io.Writer, h Header, events []Event) error {
+	bw := bufio.NewWriter(w)
+	line, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	bw.Write(append(line, '\n'))
+	for _, e := range events {
+		line, _ := json.Marshal(e)
+		bw.Write(append(line, '\n'))
+	}
+	return bw.Flush()
+}
+
+// Since returns the events at or after t, with times made relative to a new
+// header timestamp at t (rounded down to the second)
+func Since(h Header, events []Event, t time.Time) (Header, []Event) {
+	start := time.Unix(h.Timestamp, 0)
+	if !t.After(start) {
+		return h, events
+	}
+	cut := t.Sub(start).Seconds()
+	shift := float64(t.Unix() - h.Timestamp)
+	h.Timestamp = t.Unix()
+	var out []Event
+	for _, e := range events {
+		if e.Time >= cut {
+			out = append(out, Event{Time: e.Time - shift, Data: e.Data})
+		}
+	}
+	return h, out
+}
+
+// Trim rewrites the recording at path to keep only output since t
+func Trim(path string, t time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	h, events, err := Read(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	h, events = Since(h, events, t)
+
+	var b strings.Builder
+	if err := Write(&b, h, events); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ParseSince parses a --since value: a duration before now ("90m", "2h",
+// "3d") or a local time ("15:04", "2006-01-02 15:04", RFC 3339)
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") && n > 0 {
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, now.Location()); err == nil {
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if t.After(now) {
+			t = t.AddDate(0, 0, -1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use e.g. 30m, 2h, 3d, 15:04 or \"2006-01-02 15:04\")", s)
+}
+
+// Duration returns how long a recording spans
+func Duration(events []Event) time.Duration {
+	if len(events) == 0 {
+		return 0
+	}
+	return time.Duration(events[len(events)-1].Time * float64(time.Second))
+}
+
+// PlayOptions controls playback
+type PlayOptions struct {
+	Since   time.Time     // Output before this is written at once, to rebuild the screen
+	Speed   float64       // Playback speed (default: 1)
+	MaxIdle time.Duration // Longest pause between events (default: 2s)
+	Sleep   func(time.Duration)
+}
+
+// Play writes the events to w with their original timing
+func Play(w io.Writer, h Header, events []Event, opts PlayOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 2 * time.Second
+	}
+	if opts.Sleep == nil {
+		opts.Sleep = time.Sleep
+	}
+	skip := -1.0
+	if !opts.Since.IsZero() {
+		skip = opts.Since.Sub(time.Unix(h.Timestamp, 0)).Seconds()
+	}
+
+	last := 0.0
+	for _, e := range events {
+		if e.Time >= skip {
+			delay := time.Duration((e.Time - last) / opts.Speed * float64(time.Second))
+			if delay > opts.MaxIdle {
+				delay = opts.MaxIdle
+			}
+			if delay > 0 {
+				opts.Sleep(delay)
+			}
+		}
+		last = e.Time
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune removes recordings in dir last written more than maxAge ago, then
+// the least recently written ones while the directory holds more than
+// maxBytes. Files written within keep are never removed. Zero limits are
+// ignored. It returns the removed files.
+func Prune(dir string, maxAge time.Duration, maxBytes int64, keep time.Duration, now time.Time) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		path string
+		mod  time.Time
+		size int64
+	}
+	var files []file
+	var total int64
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		files = append(files, file{m, fi.ModTime(), fi.Size()})
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+
+	var removed []string
+	for _, f := range files {
+		if now.Sub(f.mod) < keep {
+			continue
+		}
+		tooOld := maxAge > 0 && now.Sub(f.mod) > maxAge
+		tooBig := maxBytes > 0 && total > maxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.Remove(f.path); err == nil {
+			removed = append(removed, f.path)
+			total -= f.size
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,148 @@
+package recording
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.cast")
+	// "é" is split across two reads and must not be mangled
+	r, w, _ := os.Pipe()
+	go func() {
+		w.Write([]byte("hello \xc3"))
+		time.Sleep(2 * flushInterval)
+		w.Write([]byte("\xa9\r\n"))
+		w.Close()
+	}()
+	if err := Record(r, path, 120, 40, "demo"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h, events, err := Read(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != 2 || h.Width != 120 || h.Height != 40 || h.Title != "demo" {
+		t.Errorf("header = %+v", h)
+	}
+	var out strings.Builder
+	for _, e := range events {
+		out.WriteString(e.Data)
+	}
+	if out.String() != "hello é\r\n" {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestSinceAndTrim(t *testing.T) {
+	h := Header{Version: 2, Width: 80, Height: 24, Timestamp: 1000}
+	events := []Event{{0.5, "a"}, {10.25, "b"}, {20, "c"}}
+
+	h2, kept := Since(h, events, time.Unix(1010, 0))
+	if h2.Timestamp != 1010 || len(kept) != 2 || kept[0].Time != 0.25 || kept[1].Data != "c" {
+		t.Errorf("Since() = %+v %+v", h2, kept)
+	}
+	if _, all := Since(h, events, time.Unix(900, 0)); len(all) != 3 {
+		t.Errorf("Since() before the start dropped events: %+v", all)
+	}
+
+	path := filepath.Join(t.TempDir(), "s.cast")
+	var b bytes.Buffer
+	Write(&b, h, events)
+	b.WriteString("[21, \"o\", \"trunc") // Cut short by a crash
+	os.WriteFile(path, b.Bytes(), 0600)
+	if err := Trim(path, time.Unix(1015, 0)); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	h3, rest, err := Read(bytes.NewReader(data))
+	if err != nil || h3.Timestamp != 1015 || len(rest) != 1 || rest[0].Time != 5 {
+		t.Errorf("after Trim: %+v %+v %v", h3, rest, err)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	h := Header{Version: 2, Timestamp: 1000}
+	events := []Event{{0, "a"}, {1, "b"}, {60, "c"}, {61, "d"}}
+	var slept []time.Duration
+	var out bytes.Buffer
+	err := Play(&out, h, events, PlayOptions{
+		Since: time.Unix(1030, 0),
+		Speed: 2,
+		Sleep: func(d time.Duration) { slept = append(slept, d) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "abcd" {
+		t.Errorf("output = %q", out.String())
+	}
+	// Events before Since are written at once, idle time is capped
+	want := []time.Duration{2 * time.Second, 500 * time.Millisecond}
+	if len(slept) != len(want) || slept[0] != want[0] || slept[1] != want[1] {
+		t.Errorf("sleeps = %v, want %v", slept, want)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(name string, size int, age time.Duration) {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, make([]byte, size), 0600)
+		os.Chtimes(p, now.Add(-age), now.Add(-age))
+	}
+	write("old.cast", 10, 30*24*time.Hour)
+	write("big.cast", 100, 2*time.Hour)
+	write("mid.cast", 100, time.Hour)
+	write("active.cast", 100, time.Second)
+	write("notes.txt", 10, 30*24*time.Hour)
+
+	removed, err := Prune(dir, 7*24*time.Hour, 250, time.Minute, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range removed {
+		names = append(names, filepath.Base(r))
+	}
+	if strings.Join(names, ",") != "old.cast,big.cast" {
+		t.Errorf("removed %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("Prune() removed a file that is not a recording")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"30m", now.Add(-30 * time.Minute)},
+		{"2d", now.AddDate(0, 0, -2)},
+		{"09:15", time.Date(2026, 3, 10, 9, 15, 0, 0, time.Local)},
+		{"13:00", time.Date(2026, 3, 9, 13, 0, 0, 0, time.Local)},
+		{"2026-03-01 08:00", time.Date(2026, 3, 1, 8, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseSince(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseSince("yesterday-ish", now); err == nil {
+		t.Error("ParseSince() accepted an invalid value")
+	}
+}
@@ -0,0 +1,223 @@
+// Package redact masks secrets (cloud keys, tokens, private keys and other
+// high-entropy strings) in text before it leaves the machine.
+package redact
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Detector finds one kind of secret. When the expression has a group named
+// "secret" only that group is masked, so surrounding context
+// ("AWS_SECRET_ACCESS_KEY=") stays readable.
+type Detector struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+// privateKeyBase64 is the body of a PEM block
+const privateKeyBase64 = `[\sA-Za-z0-9+/=]*`
+
+// Builtin detectors, applied in order
+var Builtin = []Detector{
+	// PEM private keys; a block cut off by message splitting is masked up to
+	// the end of the text, and its tail from the start of the next part
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----(?:[\sA-Za-z0-9+/=:,\-]*?-----END [A-Z0-9 ]*PRIVATE KEY-----|` + privateKeyBase64 + `\z)`)},
+	{"private-key", regexp.MustCompile(`\A` + privateKeyBase64 + `-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-key", regexp.MustCompile(`(?i)aws.{0,20}?(?:secret|private).{0,20}?["']?\s*[=:]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})\b`)},
+	{"github-token", regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36,255}\b|\bgithub_pat_[A-Za-z0-9_]{22,255}\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}\b`)},
+	{"api-key", regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_\-]{20,}\b`)},
+	{"telegram-token", regexp.MustCompile(`\b\d{8,10}:AA[A-Za-z0-9_\-]{33}\b`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{8,}\.eyJ[A-Za-z0-9_\-]{8,}\.[A-Za-z0-9_\-]{8,}`)},
+	// .env and shell style assignments to secret-looking variables
+	{"secret", regexp.MustCompile(`(?m)^\s*(?:export\s+)?[A-Z0-9_]*(?:PASSWORD|PASSWD|SECRET|TOKEN|API_KEY|APIKEY|PRIVATE_KEY|ACCESS_KEY)[A-Z0-9_]*\s*[=:]\s*["']?(?P<secret>[^\s"']{6,})`)},
+	{"url-password", regexp.MustCompile(`\b[a-z][a-z0-9+.\-]*://[^\s:/@]+:(?P<secret>[^\s/@]{3,})@`)},
+}
+
+// Defaults for the high-entropy detector
+const (
+	DefaultEntropy   = 4.5 // Bits per character
+	DefaultMinLength = 32
+)
+
+// tokenRe finds candidate strings for the entropy check
+var tokenRe = regexp.MustCompile(`[A-Za-z0-9+/=_\-]{16,}`)
+
+// Options configures a Redactor
+type Options struct {
+	Patterns  []string // Extra regular expressions to mask (a "secret" group masks only that part)
+	Allow     []string // Matches of these expressions are never masked
+	Entropy   float64  // Entropy threshold in bits per character (0: DefaultEntropy, <0: off)
+	MinLength int      // Shortest string checked for entropy (default: DefaultMinLength)
+}
+
+// Redactor masks secrets in text
+type Redactor struct {
+	detectors []Detector
+	allow     []*regexp.Regexp
+	entropy   float64
+	minLength int
+}
+
+// New builds a Redactor with the builtin detectors plus opts.Patterns. On a
+// bad pattern it returns the error together with a Redactor that skips it.
+func New(opts Options) (*Redactor, error) {
+	r := &Redactor{
+		detectors: append([]Detector(nil), Builtin...),
+		entropy:   opts.Entropy,
+		minLength: opts.MinLength,
+	}
+	if r.entropy == 0 {
+		r.entropy = DefaultEntropy
+	}
+	if r.minLength <= 0 {
+		r.minLength = DefaultMinLength
+	}
+
+	var errs []string
+	for _, p := range opts.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("pattern %q: %v", p, err))
+			continue
+		}
+		r.detectors = append(r.detectors, Detector{"custom", re})
+	}
+	for _, p := range opts.Allow {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("allow %q: %v", p, err))
+			continue
+		}
+		r.allow = append(r.allow, re)
+	}
+	if len(errs) > 0 {
+		return r, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return r, nil
+}
+
+// Placeholder returns the text that replaces a secret of a kind
+func Placeholder(kind string) string {
+	return "[REDACTED:" + kind + "]"
+}
+
+// Redact masks secrets in text and returns the result and how many were
+// masked
+func (r *Redactor) Redact(text string) (string, int) {
+	count := 0
+	for _, d := range r.detectors {
+		text = r.replace(text, d, &count)
+	}
+	if r.entropy > 0 {
+		text = tokenRe.ReplaceAllStringFunc(text, func(tok string) string {
+			if len(tok) < r.minLength || strings.HasPrefix(tok, "REDACTED") || r.allowed(tok) || !HighEntropy(tok, r.entropy) {
+				return tok
+			}
+			count++
+			return Placeholder("high-entropy")
+		})
+	}
+	return text, count
+}
+
+// RedactBytes is Redact for file contents; binary data is returned as is
+func (r *Redactor) RedactBytes(data []byte) ([]byte, int) {
+	if !utf8.Valid(data) {
+		return data, 0
+	}
+	text, n := r.Redact(string(data))
+	if n == 0 {
+		return data, 0
+	}
+	return []byte(text), n
+}
+
+// replace masks all matches of one detector
+func (r *Redactor) replace(text string, d Detector, count *int) string {
+	group := d.Re.SubexpIndex("secret")
+	matches := d.Re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if group > 0 && m[2*group] >= 0 {
+			start, end = m[2*group], m[2*group+1]
+		}
+		if start < last || r.allowed(text[start:end]) || strings.HasPrefix(text[start:end], "[REDACTED:") {
+			continue
+		}
+		b.WriteString(text[last:start])
+		b.WriteString(Placeholder(d.Name))
+		last = end
+		*count++
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// allowed reports whether s matches an allow pattern
+func (r *Redactor) allowed(s string) bool {
+	for _, re := range r.allow {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// HighEntropy reports whether s looks like a random secret: it mixes
+// letters and digits and its Shannon entropy is at least threshold bits per
+// character
+func HighEntropy(s string, threshold float64) bool {
+	var letters, digits bool
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = true
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			letters = true
+		}
+	}
+	return letters && digits && Entropy(s) >= threshold
+}
+
+// Entropy returns the Shannon entropy of s in bits per character
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// Notice describes a redaction count for a message footer ("" for none)
+func Notice(n int) string {
+	switch n {
+	case 0:
+		return ""
+	case 1:
+		return "🔒 1 secret redacted"
+	default:
+		return fmt.Sprintf("🔒 %d secrets redacted", n)
+	}
+}
@@ -0,0 +1,219 @@
+// Package secrets keeps credentials out of the plaintext config: a
+// passphrase-encrypted box (PBKDF2-SHA256 + AES-256-GCM) and the OS keyring
+// through the secret-tool CLI.
+package secrets
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDF names the key derivation used by Box
+const KDF = "pbkdf2-sha256"
+
+// DefaultIterations is the PBKDF2 work factor for new boxes
+const DefaultIterations = 600000
+
+// ErrPassphrase is returned when a box can't be opened with the passphrase
+var ErrPassphrase = errors.New("wrong passphrase or corrupted secrets")
+
+// Box holds encrypted name -> value pairs as stored in the config
+type Box struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`  // base64
+	Nonce      string `json:"nonce"` // base64
+	Data       string `json:"data"`  // base64 AES-256-GCM ciphertext of a JSON object
+}
+
+// Seal encrypts values with a key derived from passphrase
+func Seal(values map[string]string, passphrase string) (*Box, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt, DefaultIterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Box{
+		KDF:        KDF,
+		Iterations: DefaultIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Data:       base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plain, []byte(KDF))),
+	}, nil
+}
+
+// cache keeps what Open and KeyringLookup found, since the config is loaded
+// for every hook and command and both are slow: deriving the key takes a
+// large fraction of a second and a keyring lookup starts a process
+var cache struct {
+	sync.Mutex
+	opened  map[string]map[string]string // openKey -> values
+	keyring map[string]string            // account -> secret
+}
+
+// openKey identifies a box and passphrase without keeping the passphrase
+func openKey(b *Box, passphrase string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s\x00%s\x00%s", passphrase, b.KDF, b.Iterations, b.Salt, b.Nonce, b.Data)))
+	return hex.EncodeToString(sum[:])
+}
+
+func copyValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
+
+// Open decrypts a box. Boxes opened before in this process are not
+// decrypted again.
+func Open(b *Box, passphrase string) (map[string]string, error) {
+	key := openKey(b, passphrase)
+	cache.Lock()
+	values, ok := cache.opened[key]
+	cache.Unlock()
+	if ok {
+		return copyValues(values), nil
+	}
+	values, err := open(b, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	cache.Lock()
+	if cache.opened == nil {
+		cache.opened = map[string]map[string]string{}
+	}
+	cache.opened[key] = values
+	cache.Unlock()
+	return copyValues(values), nil
+}
+
+func open(b *Box, passphrase string) (map[string]string, error) {
+	if b.KDF != KDF || b.Iterations <= 0 {
+		return nil, fmt.Errorf("unsupported secrets format %q", b.KDF)
+	}
+	salt, err1 := base64.StdEncoding.DecodeString(b.Salt)
+	nonce, err2 := base64.StdEncoding.DecodeString(b.Nonce)
+	data, err3 := base64.StdEncoding.DecodeString(b.Data)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("invalid secrets encoding: %v", err)
+	}
+	gcm, err := newGCM(passphrase, salt, b.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrPassphrase
+	}
+	plain, err := gcm.Open(nil, nonce, data, []byte(KDF))
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring attributes of the bot token, as passed to secret-tool
+const (
+	KeyringService = "ccc"
+	KeyringAccount = "bot_token"
+)
+
+// KeyringAvailable reports whether the secret-tool CLI is installed
+func KeyringAvailable() bool {
+	_, err := exec.LookPath("secret-tool")
+	return err == nil
+}
+
+// KeyringLookup returns the secret stored for account ("" if none). The
+// keyring is asked once per process.
+func KeyringLookup(account string) (string, error) {
+	cache.Lock()
+	value, ok := cache.keyring[account]
+	cache.Unlock()
+	if ok {
+		return value, nil
+	}
+	if !KeyringAvailable() {
+		return "", errors.New("secret-tool not found")
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", KeyringService, "account", account)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// secret-tool exits 1 without output when nothing is stored
+		if stdout.Len() != 0 {
+			return "", err
+		}
+	} else {
+		value = strings.TrimSpace(stdout.String())
+	}
+	rememberKeyring(account, value)
+	return value, nil
+}
+
+func rememberKeyring(account string, value string) {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.keyring == nil {
+		cache.keyring = map[string]string{}
+	}
+	cache.keyring[account] = value
+}
+
+// KeyringStore saves a secret for account in the keyring
+func KeyringStore(account string, value string) error {
+	if !KeyringAvailable() {
+		return errors.New("secret-tool not found (install libsecret-tools)")
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label=ccc "+account, "service", KeyringService, "account", account)
+	cmd.Stdin = strings.NewReader(value)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	rememberKeyring(account, value)
+	return nil
+}
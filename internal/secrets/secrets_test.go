@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestPBKDF2(t *testing.T) {
+	cases := []struct {
+		iterations int
+		want       string
+	}{
+		{1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+	}
+	for _, c := range cases {
+		if got := hex.EncodeToString(pbkdf2.Key([]byte("password"), []byte("salt"), c.iterations, 32, sha256.New)); got != c.want {
+			t.Errorf("PBKDF2(%d) = %s, want %s", c.iterations, got, c.want)
+		}
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	values := map[string]string{"bot_token": "123456789:AAtest", "transcription.api_key": "sk-test"}
+	box, err := Seal(values, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box.KDF != KDF || box.Iterations != DefaultIterations || box.Data == "" {
+		t.Errorf("box = %+v", box)
+	}
+
+	got, err := Open(box, "correct horse")
+	if err != nil || got["bot_token"] != values["bot_token"] || got["transcription.api_key"] != values["transcription.api_key"] {
+		t.Errorf("Open() = %v, %v", got, err)
+	}
+	if _, err := Open(box, "wrong"); err != ErrPassphrase {
+		t.Errorf("Open() with the wrong passphrase = %v", err)
+	}
+
+	// Opened boxes are cached; callers get their own copy
+	got["bot_token"] = "changed"
+	if cached, err := Open(box, "correct horse"); err != nil || cached["bot_token"] != values["bot_token"] {
+		t.Errorf("Open() again = %v, %v", cached, err)
+	}
+	if _, err := Open(box, "wrong"); err != ErrPassphrase {
+		t.Errorf("Open() with the wrong passphrase after a cached open = %v", err)
+	}
+
+	again, _ := Seal(values, "correct horse")
+	if again.Salt == box.Salt || again.Data == box.Data {
+		t.Error("Seal() reused its salt")
+	}
+	if _, err := Seal(values, ""); err == nil {
+		t.Error("Seal() accepted an empty passphrase")
+	}
+}
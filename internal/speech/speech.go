@@ -0,0 +1,191 @@
+// Package speech turns Claude's answers into voice messages: it shortens
+// Markdown to speakable text, synthesizes it with a TTS command or an
+// OpenAI-compatible endpoint, and encodes the audio as OGG/Opus.
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	fenceRe   = regexp.MustCompile("(?s)```.*?(```|$)")
+	linkRe    = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	urlRe     = regexp.MustCompile(`https?://\S+`)
+	headingRe = regexp.MustCompile(`(?m)^\s{0,3}(#{1,6}|>|[-*+]|\d+[.)])\s+`)
+	markRe    = regexp.MustCompile("\\*+|`+|~~|__")
+	spaceRe   = regexp.MustCompile(`\s+`)
+)
+
+// Speakable strips Markdown from text: code blocks become "(code omitted)",
+// links keep their text, and formatting marks and URLs are dropped
+func Speakable(text string) string {
+	text = fenceRe.ReplaceAllString(text, " (code omitted). ")
+	text = linkRe.ReplaceAllString(text, "$1")
+	text = urlRe.ReplaceAllString(text, "")
+	text = headingRe.ReplaceAllString(text, "")
+	text = markRe.ReplaceAllString(text, "")
+	return strings.TrimSpace(spaceRe.ReplaceAllString(text, " "))
+}
+
+// Summarize returns the speakable text cut to at most maxChars characters,
+// ending at a sentence boundary where possible
+func Summarize(text string, maxChars int) string {
+	text = Speakable(text)
+	if maxChars <= 0 || utf8.RuneCountInString(text) <= maxChars {
+		return text
+	}
+	runes := []rune(text)[:maxChars]
+	cut := string(runes)
+	best := -1
+	for _, end := range []string{". ", "! ", "? ", "… "} {
+		if i := strings.LastIndex(cut, end); i >= 0 && i+len(end)-1 > best {
+			best = i + len(end) - 1
+		}
+	}
+	// Keep at least half the budget, else cut at a word
+	if best >= len(cut)/2 {
+		return strings.TrimSpace(cut[:best])
+	}
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:") + "…"
+}
+
+// Synthesizer turns text into audio in any format ffmpeg can read
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string) ([]byte, error)
+}
+
+// Command runs a shell command with the text on stdin. If the command
+// contains {out}, it is replaced with a file path the audio must be written
+// to; otherwise the audio is read from stdout.
+//
+//	espeak-ng --stdin -w {out}
+//	piper --model ~/voices/en_US-lessac-medium.onnx --output_file {out}
+type Command struct {
+	Cmd string
+}
+
+func (c Command) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	cmdline := c.Cmd
+	var out string
+	if strings.Contains(cmdline, "{out}") {
+		dir, err := os.MkdirTemp("", "ccc-tts-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		out = filepath.Join(dir, "speech.wav")
+		cmdline = strings.ReplaceAll(cmdline, "{out}", shellQuote(out))
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdline)
+	cmd.Stdin = strings.NewReader(text)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+	if out != "" {
+		return os.ReadFile(out)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("TTS command produced no audio")
+	}
+	return stdout.Bytes(), nil
+}
+
+// HTTP posts the text to an OpenAI-compatible /audio/speech endpoint and
+// asks for Opus audio
+type HTTP struct {
+	BaseURL string // e.g. https://api.openai.com/v1
+	APIKey  string // Sent as a bearer token when set
+	Model   string // default: tts-1
+	Voice   string // default: alloy
+	Client  *http.Client
+}
+
+func (h HTTP) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	model, voice := h.Model, h.Voice
+	if model == "" {
+		model = "tts-1"
+	}
+	if voice == "" {
+		voice = "alloy"
+	}
+	body, _ := json.Marshal(map[string]string{"model": model, "voice": voice, "input": text, "response_format": "opus"})
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(h.BaseURL, "/")+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 20<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// IsOggOpus reports whether audio is already an Ogg container with Opus
+func IsOggOpus(audio []byte) bool {
+	head := audio
+	if len(head) > 128 {
+		head = head[:128]
+	}
+	return bytes.HasPrefix(audio, []byte("OggS")) && bytes.Contains(head, []byte("OpusHead"))
+}
+
+// EncodeOpus converts audio to OGG/Opus with ffmpeg, as Telegram voice
+// messages require. Audio that already is OGG/Opus is returned unchanged.
+func EncodeOpus(ctx context.Context, ffmpeg string, audio []byte) ([]byte, error) {
+	if IsOggOpus(audio) {
+		return audio, nil
+	}
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, ffmpeg, "-loglevel", "error", "-i", "pipe:0", "-ac", "1", "-c:a", "libopus", "-b:a", "32k", "-application", "voip", "-f", "ogg", "pipe:1")
+	cmd.Stdin = bytes.NewReader(audio)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
+}
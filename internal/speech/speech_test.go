@@ -0,0 +1,73 @@
+package speech
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSpeakable(t *testing.T) {
+	in := "## Done\n\nI fixed **two** bugs in `parse_args`:\n\n- one\n- two\n\n```go\nfunc main() {}\n```\n\nSee [the docs](https://example.com) or https://x.dev/y."
+	want := "Done I fixed two bugs in parse_args: one two (code omitted). See the docs or"
+	if got := Speakable(in); got != want {
+		t.Errorf("Speakable() = %q, want %q", got, want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	text := "All tests pass now. I also updated the README with the new flag. Let me know if you want a release."
+	if got := Summarize(text, 0); got != text {
+		t.Errorf("Summarize() without a limit = %q", got)
+	}
+	if got := Summarize(text, 70); got != "All tests pass now. I also updated the README with the new flag." {
+		t.Errorf("Summarize(70) = %q", got)
+	}
+	// No sentence end in the second half: cut at a word
+	if got := Summarize("Short. This sentence is much longer than the limit allows", 30); got != "Short. This sentence is much…" {
+		t.Errorf("Summarize(30) = %q", got)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	got, err := Command{Cmd: "tr a-z A-Z > {out}"}.Synthesize(context.Background(), "hello")
+	if err != nil || string(got) != "HELLO" {
+		t.Errorf("Synthesize() with {out} = %q, %v", got, err)
+	}
+	got, err = Command{Cmd: "cat"}.Synthesize(context.Background(), "raw")
+	if err != nil || string(got) != "raw" {
+		t.Errorf("Synthesize() from stdout = %q, %v", got, err)
+	}
+	if _, err := (Command{Cmd: "echo broken >&2; exit 1"}).Synthesize(context.Background(), "x"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("error = %v, want stderr included", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/audio/speech" || req["voice"] != "nova" || req["model"] != "tts-1" || req["response_format"] != "opus" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("OggS....OpusHead" + req["input"]))
+	}))
+	defer srv.Close()
+
+	audio, err := HTTP{BaseURL: srv.URL + "/v1", Voice: "nova"}.Synthesize(context.Background(), "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsOggOpus(audio) {
+		t.Errorf("audio %q not recognized as OGG/Opus", audio)
+	}
+	if _, err := (HTTP{BaseURL: srv.URL}).Synthesize(context.Background(), "hi"); err == nil {
+		t.Error("Synthesize() ignored an HTTP error")
+	}
+	if IsOggOpus([]byte("RIFF....WAVEfmt ")) {
+		t.Error("IsOggOpus() accepted WAV audio")
+	}
+}
@@ -0,0 +1,113 @@
+// Package supervisor decides when to restart a crashed session program,
+// backing off exponentially and giving up on crash loops.
+package supervisor
+
+import "time"
+
+// Defaults used for unset Policy fields
+const (
+	DefaultMaxRestarts = 5
+	DefaultWindow      = 30 * time.Minute
+	DefaultBackoff     = 10 * time.Second
+	DefaultMaxBackoff  = 10 * time.Minute
+)
+
+// Policy limits automatic restarts
+type Policy struct {
+	MaxRestarts int           // Restarts allowed within Window before giving up
+	Window      time.Duration // Crash-loop window
+	Backoff     time.Duration // Delay before the first restart, doubled for each further one
+	MaxBackoff  time.Duration // Upper bound of the delay
+}
+
+// WithDefaults fills unset fields
+func (p Policy) WithDefaults() Policy {
+	if p.MaxRestarts <= 0 {
+		p.MaxRestarts = DefaultMaxRestarts
+	}
+	if p.Window <= 0 {
+		p.Window = DefaultWindow
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultMaxBackoff
+	}
+	return p
+}
+
+// Delay returns the wait before restart number n+1, given n restarts
+// already made within the window
+func (p Policy) Delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 0; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Action is what the supervisor should do about a session
+type Action int
+
+const (
+	None    Action = iota // Running, waiting for the backoff, or given up
+	Restart               // Restart now
+	GiveUp                // Crash loop detected; notify once and stop restarting
+)
+
+// Tracker follows the crashes and restarts of one session
+type Tracker struct {
+	restarts  []time.Time // Restarts within the window, oldest first
+	crashedAt time.Time   // When the crash (or the last failed restart) was seen; zero while running
+	gaveUp    bool
+}
+
+// Running records that the program is up; a session that was given up on
+// and then restarted by hand is supervised again
+func (t *Tracker) Running() {
+	t.crashedAt = time.Time{}
+	t.gaveUp = false
+}
+
+// Crashed records that the program is down and returns the action to take
+func (t *Tracker) Crashed(p Policy, now time.Time) Action {
+	p = p.WithDefaults()
+	if t.gaveUp {
+		return None
+	}
+	if t.crashedAt.IsZero() {
+		t.crashedAt = now
+	}
+
+	kept := t.restarts[:0]
+	for _, r := range t.restarts {
+		if now.Sub(r) < p.Window {
+			kept = append(kept, r)
+		}
+	}
+	t.restarts = kept
+
+	if len(t.restarts) >= p.MaxRestarts {
+		t.gaveUp = true
+		return GiveUp
+	}
+	if now.Sub(t.crashedAt) < p.Delay(len(t.restarts)) {
+		return None
+	}
+	return Restart
+}
+
+// Restarted records a restart attempt; the next backoff is measured from it
+func (t *Tracker) Restarted(now time.Time) {
+	t.restarts = append(t.restarts, now)
+	t.crashedAt = now
+}
+
+// Attempts returns the number of restarts within the current window
+func (t *Tracker) Attempts() int {
+	return len(t.restarts)
+}
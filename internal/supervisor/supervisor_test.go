@@ -0,0 +1,53 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{Backoff: 10 * time.Second, MaxBackoff: time.Minute}.WithDefaults()
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for n, w := range want {
+		if got := p.Delay(n); got != w {
+			t.Errorf("Delay(%d) = %s, want %s", n, got, w)
+		}
+	}
+}
+
+func TestTrackerBackoffAndGiveUp(t *testing.T) {
+	p := Policy{MaxRestarts: 2, Window: time.Hour, Backoff: 10 * time.Second}
+	now := time.Now()
+	var tr Tracker
+
+	if a := tr.Crashed(p, now); a != None {
+		t.Fatalf("first detection = %v, want to wait for the backoff", a)
+	}
+	if a := tr.Crashed(p, now.Add(10*time.Second)); a != Restart {
+		t.Fatalf("after backoff = %v, want Restart", a)
+	}
+	tr.Restarted(now.Add(10 * time.Second))
+
+	// Still down: the second restart waits twice as long
+	if a := tr.Crashed(p, now.Add(25*time.Second)); a != None {
+		t.Fatalf("during second backoff = %v", a)
+	}
+	if a := tr.Crashed(p, now.Add(30*time.Second)); a != Restart {
+		t.Fatalf("after second backoff = %v", a)
+	}
+	tr.Restarted(now.Add(30 * time.Second))
+
+	if a := tr.Crashed(p, now.Add(time.Minute)); a != GiveUp {
+		t.Fatalf("crash loop = %v, want GiveUp", a)
+	}
+	if a := tr.Crashed(p, now.Add(2*time.Minute)); a != None {
+		t.Errorf("after giving up = %v, want None", a)
+	}
+
+	// Restarted by hand: supervised again once old restarts leave the window
+	tr.Running()
+	tr.Crashed(p, now.Add(2*time.Hour))
+	if a := tr.Crashed(p, now.Add(2*time.Hour+10*time.Second)); a != Restart {
+		t.Errorf("after recovery = %v, want Restart", a)
+	}
+}
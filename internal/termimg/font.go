@@ -0,0 +1,161 @@
+package termimg
+
+import "strings"
+
+// The bundled font: 5x7 glyphs for printable ASCII plus a few symbols the
+// Claude Code UI uses. Rows are separated by '|'; '#' is a lit pixel. Box
+// drawing and block characters are drawn as shapes instead (see shapes.go).
+var glyphSource = map[rune]string{
+	'!':  "..#..|..#..|..#..|..#..|..#..|.....|..#..",
+	'"':  ".#.#.|.#.#.|.#.#.|.....|.....|.....|.....",
+	'#':  ".#.#.|.#.#.|#####|.#.#.|#####|.#.#.|.#.#.",
+	'$':  "..#..|.####|#.#..|.###.|..#.#|####.|..#..",
+	'%':  "##...|##..#|...#.|..#..|.#...|#..##|...##",
+	'&':  ".##..|#..#.|#.#..|.#...|#.#.#|#..#.|.##.#",
+	'\'': "..#..|..#..|.#...|.....|.....|.....|.....",
+	'(':  "...#.|..#..|.#...|.#...|.#...|..#..|...#.",
+	')':  ".#...|..#..|...#.|...#.|...#.|..#..|.#...",
+	'*':  ".....|..#..|#.#.#|.###.|#.#.#|..#..|.....",
+	'+':  ".....|..#..|..#..|#####|..#..|..#..|.....",
+	',':  ".....|.....|.....|.....|.##..|..#..|.#...",
+	'-':  ".....|.....|.....|#####|.....|.....|.....",
+	'.':  ".....|.....|.....|.....|.....|.##..|.##..",
+	'/':  ".....|....#|...#.|..#..|.#...|#....|.....",
+	'0':  ".###.|#...#|#..##|#.#.#|##..#|#...#|.###.",
+	'1':  "..#..|.##..|..#..|..#..|..#..|..#..|.###.",
+	'2':  ".###.|#...#|....#|...#.|..#..|.#...|#####",
+	'3':  "#####|...#.|..#..|...#.|....#|#...#|.###.",
+	'4':  "...#.|..##.|.#.#.|#..#.|#####|...#.|...#.",
+	'5':  "#####|#....|####.|....#|....#|#...#|.###.",
+	'6':  "..##.|.#...|#....|####.|#...#|#...#|.###.",
+	'7':  "#####|....#|...#.|..#..|.#...|.#...|.#...",
+	'8':  ".###.|#...#|#...#|.###.|#...#|#...#|.###.",
+	'9':  ".###.|#...#|#...#|.####|....#|...#.|.##..",
+	':':  ".....|.##..|.##..|.....|.##..|.##..|.....",
+	';':  ".....|.##..|.##..|.....|.##..|..#..|.#...",
+	'<':  "...#.|..#..|.#...|#....|.#...|..#..|...#.",
+	'=':  ".....|.....|#####|.....|#####|.....|.....",
+	'>':  ".#...|..#..|...#.|....#|...#.|..#..|.#...",
+	'?':  ".###.|#...#|....#|...#.|..#..|.....|..#..",
+	'@':  ".###.|#...#|....#|.##.#|#.#.#|#.#.#|.###.",
+	'A':  ".###.|#...#|#...#|#####|#...#|#...#|#...#",
+	'B':  "####.|#...#|#...#|####.|#...#|#...#|####.",
+	'C':  ".###.|#...#|#....|#....|#....|#...#|.###.",
+	'D':  "###..|#..#.|#...#|#...#|#...#|#..#.|###..",
+	'E':  "#####|#....|#....|####.|#....|#....|#####",
+	'F':  "#####|#....|#....|####.|#....|#....|#....",
+	'G':  ".###.|#...#|#....|#.###|#...#|#...#|.####",
+	'H':  "#...#|#...#|#...#|#####|#...#|#...#|#...#",
+	'I':  ".###.|..#..|..#..|..#..|..#..|..#..|.###.",
+	'J':  "..###|...#.|...#.|...#.|...#.|#..#.|.##..",
+	'K':  "#...#|#..#.|#.#..|##...|#.#..|#..#.|#...#",
+	'L':  "#....|#....|#....|#....|#....|#....|#####",
+	'M':  "#...#|##.##|#.#.#|#.#.#|#...#|#...#|#...#",
+	'N':  "#...#|#...#|##..#|#.#.#|#..##|#...#|#...#",
+	'O':  ".###.|#...#|#...#|#...#|#...#|#...#|.###.",
+	'P':  "####.|#...#|#...#|####.|#....|#....|#....",
+	'Q':  ".###.|#...#|#...#|#...#|#.#.#|#..#.|.##.#",
+	'R':  "####.|#...#|#...#|####.|#.#..|#..#.|#...#",
+	'S':  ".####|#....|#....|.###.|....#|....#|####.",
+	'T':  "#####|..#..|..#..|..#..|..#..|..#..|..#..",
+	'U':  "#...#|#...#|#...#|#...#|#...#|#...#|.###.",
+	'V':  "#...#|#...#|#...#|#...#|#...#|.#.#.|..#..",
+	'W':  "#...#|#...#|#...#|#.#.#|#.#.#|#.#.#|.#.#.",
+	'X':  "#...#|#...#|.#.#.|..#..|.#.#.|#...#|#...#",
+	'Y':  "#...#|#...#|#...#|.#.#.|..#..|..#..|..#..",
+	'Z':  "#####|....#|...#.|..#..|.#...|#....|#####",
+	'[':  ".###.|.#...|.#...|.#...|.#...|.#...|.###.",
+	'\\': ".....|#....|.#...|..#..|...#.|....#|.....",
+	']':  ".###.|...#.|...#.|...#.|...#.|...#.|.###.",
+	'^':  "..#..|.#.#.|#...#|.....|.....|.....|.....",
+	'_':  ".....|.....|.....|.....|.....|.....|#####",
+	'`':  ".#...|..#..|...#.|.....|.....|.....|.....",
+	'a':  ".....|.....|.###.|....#|.####|#...#|.####",
+	'b':  "#....|#....|#.##.|##..#|#...#|#...#|####.",
+	'c':  ".....|.....|.###.|#....|#....|#...#|.###.",
+	'd':  "....#|....#|.##.#|#..##|#...#|#...#|.####",
+	'e':  ".....|.....|.###.|#...#|#####|#....|.###.",
+	'f':  "..##.|.#..#|.#...|###..|.#...|.#...|.#...",
+	'g':  ".####|#...#|#...#|#...#|.####|....#|.###.",
+	'h':  "#....|#....|#.##.|##..#|#...#|#...#|#...#",
+	'i':  "..#..|.....|.##..|..#..|..#..|..#..|.###.",
+	'j':  "...#.|.....|..##.|...#.|...#.|#..#.|.##..",
+	'k':  "#....|#....|#..#.|#.#..|##...|#.#..|#..#.",
+	'l':  ".##..|..#..|..#..|..#..|..#..|..#..|.###.",
+	'm':  ".....|.....|##.#.|#.#.#|#.#.#|#...#|#...#",
+	'n':  ".....|.....|#.##.|##..#|#...#|#...#|#...#",
+	'o':  ".....|.....|.###.|#...#|#...#|#...#|.###.",
+	'p':  "####.|#...#|#...#|#...#|####.|#....|#....",
+	'q':  ".####|#...#|#...#|#...#|.####|....#|....#",
+	'r':  ".....|.....|#.##.|##..#|#....|#....|#....",
+	's':  ".....|.....|.###.|#....|.###.|....#|####.",
+	't':  ".#...|.#...|###..|.#...|.#...|.#..#|..##.",
+	'u':  ".....|.....|#...#|#...#|#...#|#..##|.##.#",
+	'v':  ".....|.....|#...#|#...#|#...#|.#.#.|..#..",
+	'w':  ".....|.....|#...#|#...#|#.#.#|#.#.#|.#.#.",
+	'x':  ".....|.....|#...#|.#.#.|..#..|.#.#.|#...#",
+	'y':  "#...#|#...#|#...#|#...#|.####|....#|.###.",
+	'z':  ".....|.....|#####|...#.|..#..|.#...|#####",
+	'{':  "...#.|..#..|..#..|.#...|..#..|..#..|...#.",
+	'|':  "..#..|..#..|..#..|..#..|..#..|..#..|..#..",
+	'}':  ".#...|..#..|..#..|...#.|..#..|..#..|.#...",
+	'~':  ".....|.....|.#...|#.#.#|...#.|.....|.....",
+
+	'❯': ".#...|..#..|...#.|....#|...#.|..#..|.#...",
+	'›': ".....|.#...|..#..|...#.|..#..|.#...|.....",
+	'…': ".....|.....|.....|.....|.....|.....|#.#.#",
+	'·': ".....|.....|.....|..#..|.....|.....|.....",
+	'•': ".....|.....|.###.|.###.|.###.|.....|.....",
+	'✻': ".....|#.#.#|.###.|#####|.###.|#.#.#|.....",
+	'✽': ".....|#.#.#|.###.|#####|.###.|#.#.#|.....",
+	'✶': "..#..|..#..|#####|.###.|.#.#.|#...#|.....",
+	'✳': ".....|#.#.#|.###.|#####|.###.|#.#.#|.....",
+	'✢': "..#..|..#..|.###.|#####|.###.|..#..|..#..",
+	'✓': ".....|....#|...#.|#.#..|.#...|.....|.....",
+	'✔': ".....|....#|...##|#.##.|.##..|.....|.....",
+	'✗': ".....|#...#|.#.#.|..#..|.#.#.|#...#|.....",
+	'✘': ".....|#...#|.#.#.|..#..|.#.#.|#...#|.....",
+	'→': ".....|..#..|...#.|#####|...#.|..#..|.....",
+	'←': ".....|..#..|.#...|#####|.#...|..#..|.....",
+	'↑': "..#..|.###.|#.#.#|..#..|..#..|..#..|.....",
+	'↓': ".....|..#..|..#..|..#..|#.#.#|.###.|..#..",
+	'↵': "....#|....#|..#.#|.#..#|#####|.#...|..#..",
+	'⧉': "###..|#.###|#.#.#|###.#|..###|.....|.....",
+	'⏵': "#....|##...|###..|####.|###..|##...|#....",
+	'▶': "#....|##...|###..|####.|###..|##...|#....",
+	'⏸': ".....|##.##|##.##|##.##|##.##|##.##|.....",
+}
+
+// descenders are drawn two font pixels lower; their glyphs start at the
+// top of the lowercase letters
+var descenders = map[rune]bool{'g': true, 'p': true, 'q': true, 'y': true}
+
+// glyph is a 5x7 bitmap, one byte per row, bit 4 is the leftmost pixel
+type glyph [glyphHeight]uint8
+
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+var glyphs = parseGlyphs(glyphSource)
+
+func parseGlyphs(src map[rune]string) map[rune]glyph {
+	out := make(map[rune]glyph, len(src))
+	for r, s := range src {
+		rows := strings.Split(s, "|")
+		if len(rows) != glyphHeight {
+			continue
+		}
+		var g glyph
+		for y, row := range rows {
+			for x := 0; x < glyphWidth && x < len(row); x++ {
+				if row[x] == '#' {
+					g[y] |= 1 << (glyphWidth - 1 - x)
+				}
+			}
+		}
+		out[r] = g
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+// Package termimg renders terminal output (text with ANSI colors) to a PNG
+// image using a bundled bitmap font, so a screenshot keeps the colors and
+// the layout of the terminal UI.
+package termimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kidandcat/ccc/internal/vterm"
+)
+
+// Cell size in font pixels: a 5x7 glyph plus spacing
+const (
+	cellWidth  = 6
+	cellHeight = 10
+)
+
+// Default terminal colors
+var (
+	DefaultForeground = color.RGBA{0xd4, 0xd4, 0xd4, 0xff}
+	DefaultBackground = color.RGBA{0x1e, 0x1e, 0x1e, 0xff}
+)
+
+// Options controls rendering
+type Options struct {
+	Scale   int // Device pixels per font pixel (default: 2)
+	Padding int // Border around the text in font pixels (default: 4)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Scale <= 0 {
+		o.Scale = 2
+	}
+	if o.Padding <= 0 {
+		o.Padding = 4
+	}
+	return o
+}
+
+var escapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// Parse interprets captured terminal lines (as from "tmux capture-pane -e")
+// and returns them as cells. Trailing empty lines are dropped.
+func Parse(ansi string) [][]vterm.Cell {
+	lines := strings.Split(strings.ReplaceAll(ansi, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(escapeRe.ReplaceAllString(lines[len(lines)-1], "")) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// Wide characters take two cells, so twice the rune count always fits
+	cols := 1
+	for _, l := range lines {
+		if n := 2 * utf8.RuneCountInString(escapeRe.ReplaceAllString(l, "")); n > cols {
+			cols = n
+		}
+	}
+
+	term := vterm.New(len(lines), cols, 0)
+	for i, l := range lines {
+		if i > 0 {
+			term.Write([]byte("\x1b[0m\r\n"))
+		}
+		term.Write([]byte(l))
+	}
+	return term.Snapshot(0)
+}
+
+// Render draws cells as an image
+func Render(lines [][]vterm.Cell, opts Options) *image.RGBA {
+	opts = opts.withDefaults()
+	s := opts.Scale
+
+	cols := 1
+	for _, line := range lines {
+		if n := usedWidth(line); n > cols {
+			cols = n
+		}
+	}
+	rows := len(lines)
+	if rows == 0 {
+		rows = 1
+	}
+
+	pad := opts.Padding * s
+	cw, ch := cellWidth*s, cellHeight*s
+	img := image.NewRGBA(image.Rect(0, 0, cols*cw+2*pad, rows*ch+2*pad))
+	fill(img, img.Bounds(), DefaultBackground)
+
+	for y, line := range lines {
+		for x := 0; x < len(line) && x < cols; x++ {
+			c := line[x]
+			if c.Rune == 0 {
+				continue
+			}
+			width := 1
+			if x+1 < len(line) && line[x+1].Rune == 0 {
+				width = 2
+			}
+			r := image.Rect(pad+x*cw, pad+y*ch, pad+(x+width)*cw, pad+(y+1)*ch)
+			drawCell(img, c, r, s)
+		}
+	}
+	return img
+}
+
+// PNG renders captured terminal output as a PNG image
+func PNG(ansi string, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, Render(Parse(ansi), opts)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// usedWidth returns the number of cells up to the last one that shows
+// something (a character or a background color)
+func usedWidth(line []vterm.Cell) int {
+	n := len(line)
+	for n > 0 {
+		c := line[n-1]
+		if (c.Rune != ' ' && c.Rune != 0) || c.BG != vterm.DefaultColor || c.Attr&vterm.Reverse != 0 {
+			break
+		}
+		n--
+	}
+	return n
+}
+
+func drawCell(img *image.RGBA, c vterm.Cell, r image.Rectangle, scale int) {
+	fgc := c.FG
+	if c.Attr&vterm.Bold != 0 && fgc >= 0 && fgc < 8 {
+		fgc += 8
+	}
+	fg := resolve(fgc, DefaultForeground)
+	bg := resolve(c.BG, DefaultBackground)
+	if c.Attr&vterm.Reverse != 0 {
+		fg, bg = bg, fg
+	}
+	if c.Attr&vterm.Dim != 0 {
+		fg = blend(fg, bg, 60)
+	}
+	if bg != DefaultBackground {
+		fill(img, r, bg)
+	}
+	if c.Attr&vterm.Underline != 0 {
+		fill(img, image.Rect(r.Min.X, r.Max.Y-scale, r.Max.X, r.Max.Y), fg)
+	}
+
+	switch {
+	case c.Rune == ' ':
+	case drawShape(img, c.Rune, r, fg, bg, scale):
+	default:
+		g, ok := glyphs[c.Rune]
+		if !ok {
+			tofu(img, r, fg, scale)
+			return
+		}
+		// Wide cells center the glyph
+		x0 := r.Min.X + (r.Dx()-cellWidth*scale)/2
+		y0 := r.Min.Y + scale
+		if descenders[c.Rune] {
+			y0 += 2 * scale
+		}
+		for gy := 0; gy < glyphHeight; gy++ {
+			for gx := 0; gx < glyphWidth; gx++ {
+				if g[gy]&(1<<(glyphWidth-1-gx)) == 0 {
+					continue
+				}
+				px := image.Rect(x0+gx*scale, y0+gy*scale, x0+(gx+1)*scale, y0+(gy+1)*scale)
+				if c.Attr&vterm.Bold != 0 {
+					px.Max.X += scale / 2
+				}
+				fill(img, px, fg)
+			}
+		}
+	}
+}
+
+// resolve converts a terminal color to RGBA
+func resolve(c vterm.Color, def color.RGBA) color.RGBA {
+	switch {
+	case c == vterm.DefaultColor:
+		return def
+	case c.IsRGB():
+		r, g, b := c.Components()
+		return color.RGBA{r, g, b, 0xff}
+	}
+	return Palette(int(c))
+}
+
+var basicColors = [16]color.RGBA{
+	{0x00, 0x00, 0x00, 0xff}, {0xcd, 0x31, 0x31, 0xff}, {0x0d, 0xbc, 0x79, 0xff}, {0xe5, 0xe5, 0x10, 0xff},
+	{0x24, 0x72, 0xc8, 0xff}, {0xbc, 0x3f, 0xbc, 0xff}, {0x11, 0xa8, 0xcd, 0xff}, {0xe5, 0xe5, 0xe5, 0xff},
+	{0x66, 0x66, 0x66, 0xff}, {0xf1, 0x4c, 0x4c, 0xff}, {0x23, 0xd1, 0x8b, 0xff}, {0xf5, 0xf5, 0x43, 0xff},
+	{0x3b, 0x8e, 0xea, 0xff}, {0xd6, 0x70, 0xd6, 0xff}, {0x29, 0xb8, 0xdb, 0xff}, {0xff, 0xff, 0xff, 0xff},
+}
+
+// Palette returns a color of the xterm 256-color palette
+func Palette(i int) color.RGBA {
+	switch {
+	case i < 0 || i > 255:
+		return DefaultForeground
+	case i < 16:
+		return basicColors[i]
+	case i < 232:
+		levels := [6]uint8{0, 95, 135, 175, 215, 255}
+		i -= 16
+		return color.RGBA{levels[i/36], levels[i/6%6], levels[i%6], 0xff}
+	}
+	v := uint8(8 + 10*(i-232))
+	return color.RGBA{v, v, v, 0xff}
+}
@@ -0,0 +1,59 @@
+package termimg
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/kidandcat/ccc/internal/vterm"
+)
+
+func TestParse(t *testing.T) {
+	lines := Parse("\x1b[31mred\x1b[0m plain\n╭──╮\n\n\n")
+	if len(lines) != 2 {
+		t.Fatalf("Parse() returned %d lines, want 2", len(lines))
+	}
+	if vterm.LineText(lines[0]) != "red plain" || lines[0][0].FG != 1 || lines[0][4].FG != vterm.DefaultColor {
+		t.Errorf("first line = %q, colors %v/%v", vterm.LineText(lines[0]), lines[0][0].FG, lines[0][4].FG)
+	}
+	if vterm.LineText(lines[1]) != "╭──╮" {
+		t.Errorf("second line = %q", vterm.LineText(lines[1]))
+	}
+}
+
+func TestPNG(t *testing.T) {
+	data, err := PNG("\x1b[42m ok \x1b[0m ❯ hi ●\n│ box │", Options{Scale: 1, Padding: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 11 columns and 2 rows plus one font pixel of padding on each side
+	if b := img.Bounds(); b.Dx() != 11*cellWidth+2 || b.Dy() != 2*cellHeight+2 {
+		t.Errorf("image size = %v", b)
+	}
+	// The first cell has the green background
+	if got := color.RGBAModel.Convert(img.At(2, 2)).(color.RGBA); got != Palette(2) {
+		t.Errorf("background = %v, want %v", got, Palette(2))
+	}
+}
+
+func TestPalette(t *testing.T) {
+	if Palette(196) != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Palette(196) = %v", Palette(196))
+	}
+	if Palette(232) != (color.RGBA{8, 8, 8, 255}) {
+		t.Errorf("Palette(232) = %v", Palette(232))
+	}
+}
+
+func TestGlyphs(t *testing.T) {
+	for r := '!'; r <= '~'; r++ {
+		if _, ok := glyphs[r]; !ok {
+			t.Errorf("missing glyph for %q", r)
+		}
+	}
+}
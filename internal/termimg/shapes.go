@@ -0,0 +1,144 @@
+package termimg
+
+import (
+	"image"
+	"image/color"
+)
+
+// Box drawing segments of a cell: which edges the lines run to
+type boxLines struct {
+	up, down, left, right bool
+	heavy                 bool
+}
+
+var boxChars = map[rune]boxLines{}
+
+func init() {
+	add := func(runes string, b boxLines) {
+		for _, r := range runes {
+			boxChars[r] = b
+		}
+	}
+	add("─┄┈╌", boxLines{left: true, right: true})
+	add("━┅┉╍═", boxLines{left: true, right: true, heavy: true})
+	add("│┆┊╎", boxLines{up: true, down: true})
+	add("┃┇┋╏║", boxLines{up: true, down: true, heavy: true})
+	add("┌╭", boxLines{down: true, right: true})
+	add("┏╔", boxLines{down: true, right: true, heavy: true})
+	add("┐╮", boxLines{down: true, left: true})
+	add("┓╗", boxLines{down: true, left: true, heavy: true})
+	add("└╰⎿", boxLines{up: true, right: true})
+	add("┗╚", boxLines{up: true, right: true, heavy: true})
+	add("┘╯", boxLines{up: true, left: true})
+	add("┛╝", boxLines{up: true, left: true, heavy: true})
+	add("├", boxLines{up: true, down: true, right: true})
+	add("┣╠", boxLines{up: true, down: true, right: true, heavy: true})
+	add("┤", boxLines{up: true, down: true, left: true})
+	add("┫╣", boxLines{up: true, down: true, left: true, heavy: true})
+	add("┬", boxLines{left: true, right: true, down: true})
+	add("┳╦", boxLines{left: true, right: true, down: true, heavy: true})
+	add("┴", boxLines{left: true, right: true, up: true})
+	add("┻╩", boxLines{left: true, right: true, up: true, heavy: true})
+	add("┼", boxLines{up: true, down: true, left: true, right: true})
+	add("╋╬", boxLines{up: true, down: true, left: true, right: true, heavy: true})
+	add("╴", boxLines{left: true})
+	add("╵", boxLines{up: true})
+	add("╶", boxLines{right: true})
+	add("╷", boxLines{down: true})
+}
+
+// drawShape draws box drawing, block and circle characters over the cell
+// rectangle r and reports whether it knew the rune
+func drawShape(img *image.RGBA, ch rune, r image.Rectangle, fg, bg color.RGBA, scale int) bool {
+	w, h := r.Dx(), r.Dy()
+	if b, ok := boxChars[ch]; ok {
+		t := scale
+		if b.heavy {
+			t = 2 * scale
+		}
+		cx := r.Min.X + w/2 - t/2
+		cy := r.Min.Y + h/2 - t/2
+		if b.up {
+			fill(img, image.Rect(cx, r.Min.Y, cx+t, cy+t), fg)
+		}
+		if b.down {
+			fill(img, image.Rect(cx, cy, cx+t, r.Max.Y), fg)
+		}
+		if b.left {
+			fill(img, image.Rect(r.Min.X, cy, cx+t, cy+t), fg)
+		}
+		if b.right {
+			fill(img, image.Rect(cx, cy, r.Max.X, cy+t), fg)
+		}
+		return true
+	}
+
+	switch {
+	case ch == '█':
+		fill(img, r, fg)
+	case ch == '▀':
+		fill(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+h/2), fg)
+	case ch == '▌':
+		fill(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+w/2, r.Max.Y), fg)
+	case ch == '▐':
+		fill(img, image.Rect(r.Min.X+w/2, r.Min.Y, r.Max.X, r.Max.Y), fg)
+	case ch >= '▁' && ch <= '▇', ch == '▄':
+		// Lower eighths (▄ is four eighths)
+		eighths := int(ch-'▁') + 1
+		if ch == '▄' {
+			eighths = 4
+		}
+		fill(img, image.Rect(r.Min.X, r.Max.Y-h*eighths/8, r.Max.X, r.Max.Y), fg)
+	case ch == '░', ch == '▒', ch == '▓':
+		fill(img, r, blend(fg, bg, map[rune]int{'░': 25, '▒': 50, '▓': 75}[ch]))
+	case ch == '■', ch == '◼', ch == '▪':
+		m := w / 4
+		fill(img, image.Rect(r.Min.X+m, r.Min.Y+h/2-w/2+m, r.Max.X-m, r.Min.Y+h/2+w/2-m), fg)
+	case ch == '●', ch == '⏺', ch == '⬤', ch == '○', ch == '◯':
+		circle(img, r, fg, ch == '○' || ch == '◯', scale)
+	default:
+		return false
+	}
+	return true
+}
+
+// tofu draws the outline box used for characters the font lacks
+func tofu(img *image.RGBA, r image.Rectangle, fg color.RGBA, scale int) {
+	in := image.Rect(r.Min.X+scale, r.Min.Y+2*scale, r.Max.X-2*scale, r.Max.Y-2*scale)
+	fill(img, image.Rect(in.Min.X, in.Min.Y, in.Max.X, in.Min.Y+scale), fg)
+	fill(img, image.Rect(in.Min.X, in.Max.Y-scale, in.Max.X, in.Max.Y), fg)
+	fill(img, image.Rect(in.Min.X, in.Min.Y, in.Min.X+scale, in.Max.Y), fg)
+	fill(img, image.Rect(in.Max.X-scale, in.Min.Y, in.Max.X, in.Max.Y), fg)
+}
+
+func circle(img *image.RGBA, r image.Rectangle, c color.RGBA, outline bool, scale int) {
+	cx, cy := r.Min.X+r.Dx()/2, r.Min.Y+r.Dy()/2
+	rad := r.Dx()/2 - scale/2
+	if rad < 1 {
+		rad = 1
+	}
+	for y := cy - rad; y <= cy+rad; y++ {
+		for x := cx - rad; x <= cx+rad; x++ {
+			d := (x-cx)*(x-cx) + (y-cy)*(y-cy)
+			inner := (rad - scale) * (rad - scale)
+			if d <= rad*rad && (!outline || d >= inner) {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// blend mixes pct percent of a into b
+func blend(a, b color.RGBA, pct int) color.RGBA {
+	mix := func(x, y uint8) uint8 { return uint8((int(x)*pct + int(y)*(100-pct)) / 100) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
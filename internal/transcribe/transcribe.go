@@ -0,0 +1,272 @@
+// Package transcribe turns voice messages into text through pluggable
+// providers: an external command, the OpenAI Whisper CLI, whisper.cpp with a
+// local model, or an OpenAI-compatible HTTP endpoint.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options are hints for a single transcription
+type Options struct {
+	Language string // ISO 639-1 code, e.g. "en" ("" = auto-detect)
+	Prompt   string // Vocabulary and context that guide spelling
+}
+
+// Provider transcribes an audio file
+type Provider interface {
+	Name() string
+	Transcribe(ctx context.Context, audioPath string, opts Options) (string, error)
+	// Check reports whether the provider is usable, for "ccc doctor"
+	Check() error
+}
+
+// Command runs an executable with the audio path as its only argument and
+// reads the transcription from stdout. Hints are passed in the CCC_LANGUAGE
+// and CCC_PROMPT environment variables.
+type Command struct {
+	Path string
+}
+
+func (c Command) Name() string { return "command " + c.Path }
+
+func (c Command) Transcribe(ctx context.Context, audioPath string, opts Options) (string, error) {
+	cmd := exec.CommandContext(ctx, c.Path, audioPath)
+	cmd.Env = append(os.Environ(), "CCC_LANGUAGE="+opts.Language, "CCC_PROMPT="+opts.Prompt)
+	return runOutput(cmd)
+}
+
+func (c Command) Check() error { return lookPath(c.Path) }
+
+// Whisper runs the OpenAI Whisper Python CLI
+type Whisper struct {
+	Path  string // default: whisper
+	Model string // default: small
+}
+
+func (w Whisper) Name() string { return "whisper" }
+
+func (w Whisper) Transcribe(ctx context.Context, audioPath string, opts Options) (string, error) {
+	dir, err := os.MkdirTemp("", "ccc-whisper-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	model := w.Model
+	if model == "" {
+		model = "small"
+	}
+	args := []string{audioPath, "--model", model, "--output_format", "txt", "--output_dir", dir}
+	if opts.Language != "" {
+		args = append(args, "--language", opts.Language)
+	}
+	if opts.Prompt != "" {
+		args = append(args, "--initial_prompt", opts.Prompt)
+	}
+	if _, err := runOutput(exec.CommandContext(ctx, orDefault(w.Path, "whisper"), args...)); err != nil {
+		return "", fmt.Errorf("whisper failed: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	content, err := os.ReadFile(filepath.Join(dir, base+".txt"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (w Whisper) Check() error { return lookPath(orDefault(w.Path, "whisper")) }
+
+// WhisperCpp runs the whisper.cpp CLI, which must be installed, with a local
+// ggml model. Audio is converted to 16 kHz mono WAV with ffmpeg first, as
+// whisper.cpp requires.
+type WhisperCpp struct {
+	Binary  string // default: whisper-cli or whisper-cpp in PATH
+	Model   string // ggml model file, e.g. ~/models/ggml-base.bin
+	FFmpeg  string // default: ffmpeg
+	Threads int    // default: whisper.cpp's own
+}
+
+func (w WhisperCpp) Name() string { return "whisper.cpp" }
+
+func (w WhisperCpp) binary() string {
+	if w.Binary != "" {
+		return w.Binary
+	}
+	for _, name := range []string{"whisper-cli", "whisper-cpp"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return "whisper-cli"
+}
+
+// args builds the whisper.cpp command line
+func (w WhisperCpp) args(wavPath string, opts Options) []string {
+	lang := opts.Language
+	if lang == "" {
+		lang = "auto"
+	}
+	args := []string{"-m", w.Model, "-f", wavPath, "-l", lang, "-nt", "-np"}
+	if opts.Prompt != "" {
+		args = append(args, "--prompt", opts.Prompt)
+	}
+	if w.Threads > 0 {
+		args = append(args, "-t", strconv.Itoa(w.Threads))
+	}
+	return args
+}
+
+func (w WhisperCpp) Transcribe(ctx context.Context, audioPath string, opts Options) (string, error) {
+	wav := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + ".16k.wav"
+	defer os.Remove(wav)
+	convert := exec.CommandContext(ctx, orDefault(w.FFmpeg, "ffmpeg"), "-y", "-loglevel", "error", "-i", audioPath, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav)
+	if _, err := runOutput(convert); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	out, err := runOutput(exec.CommandContext(ctx, w.binary(), w.args(wav, opts)...))
+	if err != nil {
+		return "", fmt.Errorf("whisper.cpp failed: %w", err)
+	}
+	// One segment per line
+	return strings.Join(strings.Fields(out), " "), nil
+}
+
+func (w WhisperCpp) Check() error {
+	if w.Model == "" {
+		return fmt.Errorf("no model configured")
+	}
+	if _, err := os.Stat(w.Model); err != nil {
+		return fmt.Errorf("model not found: %s", w.Model)
+	}
+	if err := lookPath(orDefault(w.FFmpeg, "ffmpeg")); err != nil {
+		return err
+	}
+	return lookPath(w.binary())
+}
+
+// DefaultBaseURL is the OpenAI API
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// OpenAI posts the audio to an OpenAI-compatible /audio/transcriptions
+// endpoint: OpenAI, Groq, or a local server such as whisper.cpp's server or
+// faster-whisper-server
+type OpenAI struct {
+	BaseURL string // default: DefaultBaseURL
+	APIKey  string // Sent as a bearer token when set
+	Model   string // default: whisper-1
+	Client  *http.Client
+}
+
+func (o OpenAI) Name() string { return "openai " + orDefault(o.BaseURL, DefaultBaseURL) }
+
+func (o OpenAI) Transcribe(ctx context.Context, audioPath string, opts Options) (string, error) {
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return "", err
+	}
+	defer audio.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filepath.Base(audioPath))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return "", err
+	}
+	mw.WriteField("model", orDefault(o.Model, "whisper-1"))
+	mw.WriteField("response_format", "json")
+	if opts.Language != "" {
+		mw.WriteField("language", opts.Language)
+	}
+	if opts.Prompt != "" {
+		mw.WriteField("prompt", opts.Prompt)
+	}
+	mw.Close()
+
+	url := strings.TrimRight(orDefault(o.BaseURL, DefaultBaseURL), "/") + "/audio/transcriptions"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Text  string `json:"text"`
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if result.Error != nil {
+		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+func (o OpenAI) Check() error {
+	if o.APIKey == "" && orDefault(o.BaseURL, DefaultBaseURL) == DefaultBaseURL {
+		return fmt.Errorf("no API key (set api_key or OPENAI_API_KEY)")
+	}
+	return nil
+}
+
+// runOutput runs cmd and returns its trimmed stdout, with stderr in errors
+func runOutput(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func lookPath(path string) error {
+	if _, err := exec.LookPath(path); err != nil {
+		return fmt.Errorf("%s not found", path)
+	}
+	return nil
+}
+
+func orDefault(s string, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package transcribe
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "transcribe")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$CCC_LANGUAGE|$CCC_PROMPT|$(basename \"$1\")\"\n"), 0755)
+
+	got, err := Command{Path: script}.Transcribe(context.Background(), "/tmp/voice.ogg", Options{Language: "de", Prompt: "Kubernetes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "de|Kubernetes|voice.ogg" {
+		t.Errorf("Transcribe() = %q", got)
+	}
+
+	os.WriteFile(script, []byte("#!/bin/sh\necho 'no key' >&2\nexit 3\n"), 0755)
+	if _, err := (Command{Path: script}).Transcribe(context.Background(), "x.ogg", Options{}); err == nil || !strings.Contains(err.Error(), "no key") {
+		t.Errorf("error = %v, want stderr included", err)
+	}
+}
+
+func TestWhisperCppArgs(t *testing.T) {
+	w := WhisperCpp{Model: "/m/ggml-base.bin", Threads: 4}
+	got := strings.Join(w.args("a.wav", Options{Prompt: "ccc, tmux"}), " ")
+	if got != "-m /m/ggml-base.bin -f a.wav -l auto -nt -np --prompt ccc, tmux -t 4" {
+		t.Errorf("args = %q", got)
+	}
+	if err := (WhisperCpp{}).Check(); err == nil {
+		t.Error("Check() accepted a provider without a model")
+	}
+}
+
+func TestOpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" || r.Header.Get("Authorization") != "Bearer k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"message": "bad key"}})
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		audio, _ := io.ReadAll(f)
+		json.NewEncoder(w).Encode(map[string]string{
+			"text": " " + string(audio) + "|" + r.FormValue("model") + "|" + r.FormValue("language") + "|" + r.FormValue("prompt") + " ",
+		})
+	}))
+	defer srv.Close()
+
+	audio := filepath.Join(t.TempDir(), "voice.ogg")
+	os.WriteFile(audio, []byte("OggS"), 0600)
+
+	p := OpenAI{BaseURL: srv.URL + "/v1/", APIKey: "k", Model: "whisper-large-v3"}
+	got, err := p.Transcribe(context.Background(), audio, Options{Language: "en", Prompt: "ccc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "OggS|whisper-large-v3|en|ccc" {
+		t.Errorf("Transcribe() = %q", got)
+	}
+
+	p.APIKey = "wrong"
+	if _, err := p.Transcribe(context.Background(), audio, Options{}); err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("error = %v, want the API's message", err)
+	}
+	if err := (OpenAI{}).Check(); err == nil {
+		t.Error("Check() accepted the OpenAI API without a key")
+	}
+	if err := (OpenAI{BaseURL: "http://localhost:8080/v1"}).Check(); err != nil {
+		t.Errorf("Check() on a local server = %v", err)
+	}
+}
@@ -0,0 +1,458 @@
+// Package usage extracts token usage from Claude Code transcripts, prices it
+// and keeps running totals per session, day and model.
+package usage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tokens is a usage total (or the usage of a single message)
+type Tokens struct {
+	Input      int64   `json:"input"`
+	Output     int64   `json:"output"`
+	CacheWrite int64   `json:"cache_write"`
+	CacheRead  int64   `json:"cache_read"`
+	Cost       float64 `json:"cost"` // USD
+	Messages   int     `json:"messages"`
+}
+
+// Add accumulates o into t
+func (t *Tokens) Add(o Tokens) {
+	t.Input += o.Input
+	t.Output += o.Output
+	t.CacheWrite += o.CacheWrite
+	t.CacheRead += o.CacheRead
+	t.Cost += o.Cost
+	t.Messages += o.Messages
+}
+
+// Price is the cost of a model in USD per million tokens
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write"`
+	CacheRead  float64 `json:"cache_read"`
+}
+
+// DefaultPrices are list prices keyed by model name prefix. The longest
+// matching prefix wins, so specific versions can override a family.
+var DefaultPrices = map[string]Price{
+	"claude-opus-4-5":   {Input: 5, Output: 25, CacheWrite: 6.25, CacheRead: 0.50},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-3-opus":     {Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.50},
+	"claude-sonnet":     {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.30},
+	"claude-haiku-4-5":  {Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.10},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4, CacheWrite: 1, CacheRead: 0.08},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheWrite: 0.30, CacheRead: 0.03},
+}
+
+// PriceFor returns the price for a model, checking overrides before defaults
+func PriceFor(model string, overrides map[string]Price) (Price, bool) {
+	for _, table := range []map[string]Price{overrides, DefaultPrices} {
+		best := ""
+		for prefix := range table {
+			if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best != "" {
+			return table[best], true
+		}
+	}
+	return Price{}, false
+}
+
+// Cost prices a usage total for a model (0 for unknown models)
+func Cost(model string, t Tokens, overrides map[string]Price) float64 {
+	p, _ := PriceFor(model, overrides)
+	return (float64(t.Input)*p.Input +
+		float64(t.Output)*p.Output +
+		float64(t.CacheWrite)*p.CacheWrite +
+		float64(t.CacheRead)*p.CacheRead) / 1e6
+}
+
+// Entry is one transcript line relevant to usage: an assistant message with
+// usage data, or a user prompt (which starts a new turn)
+type Entry struct {
+	ID     string
+	Model  string
+	Time   time.Time
+	Prompt bool
+	Tokens Tokens
+}
+
+// ParseLines parses transcript JSONL. Lines that are not valid JSON (such as
+// a partial first line) are skipped.
+func ParseLines(data []byte) []Entry {
+	type line struct {
+		Type      string    `json:"type"`
+		RequestID string    `json:"requestId"`
+		Timestamp time.Time `json:"timestamp"`
+		Message   struct {
+			ID      string          `json:"id"`
+			Model   string          `json:"model"`
+			Content json.RawMessage `json:"content"`
+			Usage   *struct {
+				InputTokens              int64 `json:"input_tokens"`
+				OutputTokens             int64 `json:"output_tokens"`
+				CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+				CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+	}
+
+	var entries []Entry
+	for _, raw := range bytes.Split(data, []byte("\n")) {
+		var l line
+		if len(raw) == 0 || json.Unmarshal(raw, &l) != nil {
+			continue
+		}
+		switch {
+		case l.Type == "user" && isPrompt(l.Message.Content):
+			entries = append(entries, Entry{Prompt: true, Time: l.Timestamp})
+		case l.Type == "assistant" && l.Message.Usage != nil && l.Message.Model != "<synthetic>":
+			id := l.Message.ID
+			if id == "" {
+				id = l.RequestID
+			}
+			u := l.Message.Usage
+			entries = append(entries, Entry{
+				ID:    id,
+				Model: l.Message.Model,
+				Time:  l.Timestamp,
+				Tokens: Tokens{
+					Input:      u.InputTokens,
+					Output:     u.OutputTokens,
+					CacheWrite: u.CacheCreationInputTokens,
+					CacheRead:  u.CacheReadInputTokens,
+					Messages:   1,
+				},
+			})
+		}
+	}
+	return entries
+}
+
+// isPrompt reports whether user content is a typed prompt rather than a tool result
+func isPrompt(content json.RawMessage) bool {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return true
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(content, &blocks) != nil {
+		return false
+	}
+	for _, b := range blocks {
+		if b.Type == "tool_result" {
+			return false
+		}
+	}
+	return len(blocks) > 0
+}
+
+// Store keeps usage totals and per-transcript read positions
+type Store struct {
+	// session -> day (YYYY-MM-DD) -> model -> totals
+	Sessions map[string]map[string]map[string]*Tokens `json:"sessions"`
+	Cursors  map[string]*Cursor                       `json:"cursors,omitempty"`
+
+	BudgetWarned  string `json:"budget_warned,omitempty"`  // Day the budget warning was sent
+	BudgetResumed string `json:"budget_resumed,omitempty"` // Day the budget pause was lifted by hand
+}
+
+// Cursor tracks how far a transcript has been ingested
+type Cursor struct {
+	Offset int64    `json:"offset"`
+	Recent []string `json:"recent,omitempty"` // Recently counted message IDs (streaming duplicates)
+}
+
+const recentIDs = 64
+
+// Load reads a store, returning an empty one if the file doesn't exist
+func Load(path string) (*Store, error) {
+	s := &Store{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, err
+		}
+	}
+	if s.Sessions == nil {
+		s.Sessions = make(map[string]map[string]map[string]*Tokens)
+	}
+	if s.Cursors == nil {
+		s.Cursors = make(map[string]*Cursor)
+	}
+	return s, nil
+}
+
+// Save writes the store atomically
+func (s *Store) Save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Record adds usage for a session, day and model
+func (s *Store) Record(session string, day string, model string, t Tokens) {
+	days, ok := s.Sessions[session]
+	if !ok {
+		days = make(map[string]map[string]*Tokens)
+		s.Sessions[session] = days
+	}
+	models, ok := days[day]
+	if !ok {
+		models = make(map[string]*Tokens)
+		days[day] = models
+	}
+	total, ok := models[model]
+	if !ok {
+		total = &Tokens{}
+		models[model] = total
+	}
+	total.Add(t)
+}
+
+// Rename moves the usage recorded for a session to its new name, adding to
+// anything already recorded under that name
+func (s *Store) Rename(oldName string, newName string) {
+	days, ok := s.Sessions[oldName]
+	if !ok || oldName == newName {
+		return
+	}
+	delete(s.Sessions, oldName)
+	for day, models := range days {
+		for model, t := range models {
+			s.Record(newName, day, model, *t)
+		}
+	}
+}
+
+// Ingest reads the part of a transcript added since the last call, records
+// it for session and returns the usage of the latest turn (messages after the
+// last user prompt in the new part) per model.
+func (s *Store) Ingest(session string, transcriptPath string, prices map[string]Price) (map[string]Tokens, error) {
+	f, err := os.Open(transcriptPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cur, ok := s.Cursors[transcriptPath]
+	if !ok {
+		cur = &Cursor{}
+		s.Cursors[transcriptPath] = cur
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() < cur.Offset {
+		cur.Offset = 0 // Transcript was replaced
+	}
+	if _, err := f.Seek(cur.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	// Only consume complete lines; a partial last line is read next time
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	cur.Offset += int64(end + 1)
+
+	seen := make(map[string]bool, len(cur.Recent))
+	for _, id := range cur.Recent {
+		seen[id] = true
+	}
+
+	turn := make(map[string]Tokens)
+	for _, e := range ParseLines(data[:end+1]) {
+		if e.Prompt {
+			turn = make(map[string]Tokens)
+			continue
+		}
+		if e.ID != "" {
+			if seen[e.ID] {
+				continue
+			}
+			seen[e.ID] = true
+			cur.Recent = append(cur.Recent, e.ID)
+		}
+		e.Tokens.Cost = Cost(e.Model, e.Tokens, prices)
+		s.Record(session, e.Time.Local().Format("2006-01-02"), e.Model, e.Tokens)
+
+		t := turn[e.Model]
+		t.Add(e.Tokens)
+		turn[e.Model] = t
+	}
+	if len(cur.Recent) > recentIDs {
+		cur.Recent = cur.Recent[len(cur.Recent)-recentIDs:]
+	}
+	return turn, nil
+}
+
+// Report is an aggregate over a set of sessions and days
+type Report struct {
+	Session   string            `json:"session,omitempty"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Total     Tokens            `json:"total"`
+	ByModel   map[string]Tokens `json:"by_model,omitempty"`
+	ByDay     map[string]Tokens `json:"by_day,omitempty"`
+	BySession map[string]Tokens `json:"by_session,omitempty"`
+}
+
+// Report aggregates usage for a session ("" for all) between two days
+// (inclusive, YYYY-MM-DD)
+func (s *Store) Report(session string, from string, to string) Report {
+	r := Report{
+		Session:   session,
+		From:      from,
+		To:        to,
+		ByModel:   make(map[string]Tokens),
+		ByDay:     make(map[string]Tokens),
+		BySession: make(map[string]Tokens),
+	}
+	for name, days := range s.Sessions {
+		if session != "" && name != session {
+			continue
+		}
+		for day, models := range days {
+			if day < from || day > to {
+				continue
+			}
+			for model, t := range models {
+				r.Total.Add(*t)
+				for _, m := range []struct {
+					agg map[string]Tokens
+					key string
+				}{{r.ByModel, model}, {r.ByDay, day}, {r.BySession, name}} {
+					v := m.agg[m.key]
+					v.Add(*t)
+					m.agg[m.key] = v
+				}
+			}
+		}
+	}
+	return r
+}
+
+// DayCost returns the total cost of all sessions on a day
+func (s *Store) DayCost(day string) float64 {
+	return s.Report("", day, day).Total.Cost
+}
+
+// PeriodRange converts a period name into an inclusive day range ending at now:
+// today, yesterday, week (7 days), month (30 days), all, or "<n>d"
+func PeriodRange(period string, now time.Time) (from string, to string, err error) {
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+	switch period {
+	case "", "today":
+		return day(now), day(now), nil
+	case "yesterday":
+		y := now.AddDate(0, 0, -1)
+		return day(y), day(y), nil
+	case "week":
+		return day(now.AddDate(0, 0, -6)), day(now), nil
+	case "month":
+		return day(now.AddDate(0, 0, -29)), day(now), nil
+	case "all":
+		return "0000-00-00", "9999-99-99", nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(period, "d")); err == nil && strings.HasSuffix(period, "d") && n > 0 {
+		return day(now.AddDate(0, 0, -(n - 1))), day(now), nil
+	}
+	return "", "", fmt.Errorf("unknown period %q (use today, yesterday, week, month, all or <n>d)", period)
+}
+
+// Trailer lines: "💰 $0.0523 · 15,234 in / 1,120 out / 41,212 cache · claude-sonnet-4-5".
+// They are appended to Stop messages and parsed back when a remote client
+// forwards them to the server.
+var trailerRe = regexp.MustCompile(`^💰 \$([0-9.]+) · ([0-9,]+) in / ([0-9,]+) out / ([0-9,]+) cache · (\S+)$`)
+
+// FormatTrailer renders a turn's usage, one line per model (most expensive first)
+func FormatTrailer(turn map[string]Tokens) string {
+	models := make([]string, 0, len(turn))
+	for m, t := range turn {
+		if t.Messages > 0 {
+			models = append(models, m)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool { return turn[models[i]].Cost > turn[models[j]].Cost })
+
+	var lines []string
+	for _, m := range models {
+		t := turn[m]
+		lines = append(lines, fmt.Sprintf("💰 $%.4f · %s in / %s out / %s cache · %s",
+			t.Cost, Comma(t.Input), Comma(t.Output), Comma(t.CacheWrite+t.CacheRead), m))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SplitTrailer separates trailer lines from the end of a message. Cache
+// tokens come back as reads (the trailer doesn't keep the split); the cost
+// is taken as priced by the sender.
+func SplitTrailer(message string) (string, map[string]Tokens) {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	turn := make(map[string]Tokens)
+	i := len(lines)
+	for i > 0 {
+		m := trailerRe.FindStringSubmatch(lines[i-1])
+		if m == nil {
+			break
+		}
+		cost, _ := strconv.ParseFloat(m[1], 64)
+		turn[m[5]] = Tokens{
+			Input:     parseComma(m[2]),
+			Output:    parseComma(m[3]),
+			CacheRead: parseComma(m[4]),
+			Cost:      cost,
+			Messages:  1,
+		}
+		i--
+	}
+	if len(turn) == 0 {
+		return message, nil
+	}
+	return strings.TrimRight(strings.Join(lines[:i], "\n"), "\n"), turn
+}
+
+// Comma formats n with thousands separators
+func Comma(n int64) string {
+	if n < 0 {
+		return "-" + Comma(-n)
+	}
+	s := strconv.FormatInt(n, 10)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+func parseComma(s string) int64 {
+	n, _ := strconv.ParseInt(strings.ReplaceAll(s, ",", ""), 10, 64)
+	return n
+}
@@ -0,0 +1,158 @@
+package usage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const turn1 = `{"type":"user","timestamp":"2026-01-02T10:00:00Z","message":{"role":"user","content":"fix the bug"}}
+{"type":"assistant","timestamp":"2026-01-02T10:00:05Z","message":{"id":"msg_1","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"Looking"}],"usage":{"input_tokens":1000,"output_tokens":200,"cache_creation_input_tokens":5000,"cache_read_input_tokens":20000}}}
+{"type":"assistant","timestamp":"2026-01-02T10:00:05Z","message":{"id":"msg_1","model":"claude-sonnet-4-5-20250929","content":[{"type":"tool_use","name":"Bash"}],"usage":{"input_tokens":1000,"output_tokens":200,"cache_creation_input_tokens":5000,"cache_read_input_tokens":20000}}}
+{"type":"user","timestamp":"2026-01-02T10:00:07Z","message":{"role":"user","content":[{"type":"tool_result","content":"ok"}]}}
+{"type":"assistant","timestamp":"2026-01-02T10:00:09Z","message":{"id":"msg_2","model":"claude-haiku-4-5","content":[{"type":"text","text":"Done"}],"usage":{"input_tokens":100,"output_tokens":50}}}
+`
+
+const turn2 = `{"type":"user","timestamp":"2026-01-03T09:00:00Z","message":{"role":"user","content":[{"type":"text","text":"thanks"}]}}
+{"type":"assistant","timestamp":"2026-01-03T09:00:03Z","message":{"id":"msg_3","model":"claude-sonnet-4-5-20250929","content":[{"type":"text","text":"You're welcome"}],"usage":{"input_tokens":10,"output_tokens":5}}}
+`
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestPriceFor(t *testing.T) {
+	p, ok := PriceFor("claude-opus-4-5-20251101", nil)
+	if !ok || p.Input != 5 {
+		t.Errorf("opus 4.5 price = %+v, want input 5 (longest prefix)", p)
+	}
+	p, _ = PriceFor("claude-opus-4-1-20250805", nil)
+	if p.Input != 15 {
+		t.Errorf("opus 4.1 price = %+v, want input 15", p)
+	}
+	p, _ = PriceFor("claude-sonnet-4-5", map[string]Price{"claude-sonnet": {Input: 1}})
+	if p.Input != 1 {
+		t.Errorf("override not applied: %+v", p)
+	}
+	if _, ok := PriceFor("gpt-4", nil); ok {
+		t.Error("unknown model should have no price")
+	}
+}
+
+func TestCost(t *testing.T) {
+	got := Cost("claude-sonnet-4-5", Tokens{Input: 1_000_000, Output: 100_000, CacheWrite: 1_000_000, CacheRead: 1_000_000}, nil)
+	if want := 3 + 1.5 + 3.75 + 0.30; !approx(got, want) {
+		t.Errorf("Cost() = %v, want %v", got, want)
+	}
+}
+
+func TestIngestIncremental(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "t.jsonl")
+	os.WriteFile(path, []byte(turn1), 0600)
+
+	s, _ := Load(filepath.Join(dir, "usage.json"))
+	turn, err := s.Ingest("proj", path, nil)
+	if err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	sonnet := turn["claude-sonnet-4-5-20250929"]
+	if sonnet.Messages != 1 || sonnet.Input != 1000 || sonnet.CacheRead != 20000 {
+		t.Errorf("streaming duplicate not deduplicated: %+v", sonnet)
+	}
+	if turn["claude-haiku-4-5"].Output != 50 {
+		t.Errorf("haiku usage missing: %+v", turn)
+	}
+
+	// Append a second turn plus a partial line that must not be consumed yet
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(turn2 + `{"type":"assistant","timestamp":"2026-01-03T09:00:04Z","message":{"id":"msg_4"`)
+	f.Close()
+
+	turn, _ = s.Ingest("proj", path, nil)
+	if len(turn) != 1 || turn["claude-sonnet-4-5-20250929"].Input != 10 {
+		t.Errorf("second turn = %+v, want only msg_3", turn)
+	}
+
+	r := s.Report("proj", "0000-00-00", "9999-99-99")
+	if r.Total.Messages != 3 || r.Total.Input != 1110 {
+		t.Errorf("report total = %+v, want 3 messages / 1110 input", r.Total)
+	}
+	if len(r.ByDay) != 2 {
+		t.Errorf("expected usage on 2 days, got %v", r.ByDay)
+	}
+
+	// Save and reload keeps cursor position
+	storePath := filepath.Join(dir, "usage.json")
+	if err := s.Save(storePath); err != nil {
+		t.Fatal(err)
+	}
+	s2, _ := Load(storePath)
+	if turn, _ := s2.Ingest("proj", path, nil); len(turn) != 0 {
+		t.Errorf("reloaded store re-ingested old lines: %+v", turn)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, _ := Load("")
+	s.Record("old", "2026-01-02", "claude-haiku-4-5", Tokens{Input: 10})
+	s.Record("new", "2026-01-02", "claude-haiku-4-5", Tokens{Input: 5})
+	s.Rename("old", "new")
+	if _, ok := s.Sessions["old"]; ok {
+		t.Error("old name still has usage")
+	}
+	if got := s.Sessions["new"]["2026-01-02"]["claude-haiku-4-5"].Input; got != 15 {
+		t.Errorf("merged input = %d, want 15", got)
+	}
+}
+
+func TestTrailerRoundTrip(t *testing.T) {
+	turn := map[string]Tokens{
+		"claude-sonnet-4-5": {Input: 15234, Output: 1120, CacheWrite: 1200, CacheRead: 40012, Cost: 0.0523, Messages: 3},
+		"claude-haiku-4-5":  {Input: 10, Output: 5, Cost: 0.0001, Messages: 1},
+	}
+	trailer := FormatTrailer(turn)
+	want := "💰 $0.0523 · 15,234 in / 1,120 out / 41,212 cache · claude-sonnet-4-5\n💰 $0.0001 · 10 in / 5 out / 0 cache · claude-haiku-4-5"
+	if trailer != want {
+		t.Errorf("FormatTrailer() = %q, want %q", trailer, want)
+	}
+
+	body, parsed := SplitTrailer("✅ proj\n\nAll done.\n\n" + trailer)
+	if body != "✅ proj\n\nAll done." {
+		t.Errorf("body = %q", body)
+	}
+	if got := parsed["claude-sonnet-4-5"]; got.Input != 15234 || got.CacheRead != 41212 || !approx(got.Cost, 0.0523) {
+		t.Errorf("parsed sonnet = %+v", got)
+	}
+
+	if body, parsed := SplitTrailer("no usage here"); body != "no usage here" || parsed != nil {
+		t.Errorf("message without trailer changed: %q %v", body, parsed)
+	}
+}
+
+func TestPeriodRange(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct{ period, from, to string }{
+		{"", "2026-03-10", "2026-03-10"},
+		{"yesterday", "2026-03-09", "2026-03-09"},
+		{"week", "2026-03-04", "2026-03-10"},
+		{"3d", "2026-03-08", "2026-03-10"},
+	}
+	for _, tt := range tests {
+		from, to, err := PeriodRange(tt.period, now)
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("PeriodRange(%q) = %s..%s %v, want %s..%s", tt.period, from, to, err, tt.from, tt.to)
+		}
+	}
+	if _, _, err := PeriodRange("fortnight", now); err == nil {
+		t.Error("expected error for unknown period")
+	}
+}
+
+func TestComma(t *testing.T) {
+	for n, want := range map[int64]string{0: "0", 999: "999", 1000: "1,000", 1234567: "1,234,567", -4200: "-4,200"} {
+		if got := Comma(n); got != want {
+			t.Errorf("Comma(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
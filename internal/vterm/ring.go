@@ -0,0 +1,47 @@
+package vterm
+
+// ring is the scrollback: a fixed-capacity ring of lines, oldest dropped first
+type ring struct {
+	lines [][]Cell
+	start int
+	n     int
+}
+
+func newRing(capacity int) *ring {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &ring{lines: make([][]Cell, capacity)}
+}
+
+func (r *ring) push(line []Cell) {
+	if len(r.lines) == 0 {
+		return
+	}
+	if r.n < len(r.lines) {
+		r.lines[(r.start+r.n)%len(r.lines)] = line
+		r.n++
+		return
+	}
+	r.lines[r.start] = line
+	r.start = (r.start + 1) % len(r.lines)
+}
+
+// last returns copies of the newest n lines, oldest first
+func (r *ring) last(n int) [][]Cell {
+	if n > r.n {
+		n = r.n
+	}
+	out := make([][]Cell, 0, n)
+	for i := r.n - n; i < r.n; i++ {
+		out = append(out, append([]Cell(nil), r.lines[(r.start+i)%len(r.lines)]...))
+	}
+	return out
+}
+
+func (r *ring) clear() {
+	for i := range r.lines {
+		r.lines[i] = nil
+	}
+	r.start, r.n = 0, 0
+}
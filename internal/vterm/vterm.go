@@ -0,0 +1,831 @@
+// Package vterm is a small VT100/xterm screen emulator. It keeps the visible
+// screen as a grid of cells and the lines scrolled off the top in a bounded
+// scrollback ring, which is enough to render a terminal program (like the
+// Claude Code TUI) as text or redraw it for a newly attached terminal.
+package vterm
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Color is a terminal color: DefaultColor, a palette index (0-255) or an
+// RGB value (see RGB)
+type Color int32
+
+// DefaultColor is the terminal's default foreground or background
+const DefaultColor Color = -1
+
+const rgbFlag = 1 << 24
+
+// RGB returns a 24-bit color
+func RGB(r, g, b uint8) Color {
+	return Color(rgbFlag | int32(r)<<16 | int32(g)<<8 | int32(b))
+}
+
+// IsRGB reports whether c is a 24-bit color
+func (c Color) IsRGB() bool { return c >= 0 && c&rgbFlag != 0 }
+
+// Components returns the red, green and blue parts of an RGB color
+func (c Color) Components() (r, g, b uint8) {
+	return uint8(c >> 16), uint8(c >> 8), uint8(c)
+}
+
+// Attr is a set of text attributes
+type Attr uint8
+
+const (
+	Bold Attr = 1 << iota
+	Dim
+	Italic
+	Underline
+	Reverse
+)
+
+// Cell is one character position on the screen. Wide characters occupy two
+// cells; the second has Rune 0.
+type Cell struct {
+	Rune rune
+	FG   Color
+	BG   Color
+	Attr Attr
+}
+
+var blankCell = Cell{Rune: ' ', FG: DefaultColor, BG: DefaultColor}
+
+// Terminal is an emulated terminal. It is safe for concurrent use.
+type Terminal struct {
+	mu sync.Mutex
+
+	rows, cols int
+	screen     [][]Cell
+	main       [][]Cell // Saved main screen while the alternate screen is active
+	history    *ring
+
+	x, y     int
+	wrapNext bool
+	pen      Cell
+	saved    cursor
+	top, bot int // Scroll region (inclusive)
+	autoWrap bool
+	altOn    bool
+
+	// Parser state
+	state   int
+	params  []int
+	cur     int
+	hasCur  bool
+	private byte
+	utf     []byte
+	last    rune
+
+	// Reply is called with responses to terminal queries (cursor position,
+	// device attributes). It may be nil.
+	Reply func([]byte)
+}
+
+type cursor struct {
+	x, y int
+	pen  Cell
+}
+
+const (
+	stGround = iota
+	stEscape
+	stCSI
+	stOSC
+	stOSCEsc
+	stSkipOne
+	stDCS
+)
+
+// New creates a terminal with the given size and scrollback capacity (lines)
+func New(rows, cols, scrollback int) *Terminal {
+	if rows < 1 {
+		rows = 1
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	t := &Terminal{
+		rows:     rows,
+		cols:     cols,
+		history:  newRing(scrollback),
+		pen:      blankCell,
+		saved:    cursor{pen: blankCell},
+		autoWrap: true,
+	}
+	t.screen = t.blankScreen(rows, cols)
+	t.top, t.bot = 0, rows-1
+	return t
+}
+
+// Size returns the screen size
+func (t *Terminal) Size() (rows, cols int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rows, t.cols
+}
+
+func (t *Terminal) blankLine(cols int) []Cell {
+	line := make([]Cell, cols)
+	for i := range line {
+		line[i] = blankCell
+	}
+	return line
+}
+
+func (t *Terminal) blankScreen(rows, cols int) [][]Cell {
+	s := make([][]Cell, rows)
+	for i := range s {
+		s[i] = t.blankLine(cols)
+	}
+	return s
+}
+
+// erased returns a blank cell carrying the current background color
+func (t *Terminal) erased() Cell {
+	c := blankCell
+	c.BG = t.pen.BG
+	return c
+}
+
+// Write feeds terminal output to the emulator
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, b := range p {
+		t.feed(b)
+	}
+	return len(p), nil
+}
+
+func (t *Terminal) feed(b byte) {
+	switch t.state {
+	case stEscape:
+		t.escape(b)
+		return
+	case stCSI:
+		t.csiByte(b)
+		return
+	case stOSC:
+		switch b {
+		case 0x07:
+			t.state = stGround
+		case 0x1b:
+			t.state = stOSCEsc
+		}
+		return
+	case stOSCEsc, stSkipOne:
+		t.state = stGround
+		return
+	case stDCS:
+		if b == 0x1b {
+			t.state = stOSCEsc
+		}
+		return
+	}
+
+	// Ground state: assemble UTF-8 sequences
+	if len(t.utf) > 0 || b >= 0x80 {
+		t.utf = append(t.utf, b)
+		if !utf8.FullRune(t.utf) {
+			return
+		}
+		r, _ := utf8.DecodeRune(t.utf)
+		t.utf = t.utf[:0]
+		t.print(r)
+		return
+	}
+
+	switch {
+	case b == 0x1b:
+		t.state = stEscape
+	case b < 0x20 || b == 0x7f:
+		t.control(b)
+	default:
+		t.print(rune(b))
+	}
+}
+
+func (t *Terminal) control(b byte) {
+	switch b {
+	case '\b':
+		if t.x > 0 {
+			t.x--
+		}
+		t.wrapNext = false
+	case '\t':
+		next := (t.x/8 + 1) * 8
+		if next >= t.cols {
+			next = t.cols - 1
+		}
+		t.x = next
+	case '\n', 0x0b, 0x0c:
+		t.lineFeed()
+	case '\r':
+		t.x = 0
+		t.wrapNext = false
+	}
+}
+
+func (t *Terminal) escape(b byte) {
+	t.state = stGround
+	switch b {
+	case '[':
+		t.state = stCSI
+		t.params = t.params[:0]
+		t.cur, t.hasCur, t.private = 0, false, 0
+	case ']':
+		t.state = stOSC
+	case 'P', '_', '^', 'X':
+		t.state = stDCS
+	case '(', ')', '*', '+', '#', '%':
+		t.state = stSkipOne
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.x = 0
+		t.lineFeed()
+	case 'M':
+		t.reverseIndex()
+	case 'c':
+		t.reset()
+	}
+}
+
+func (t *Terminal) csiByte(b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		t.cur = t.cur*10 + int(b-'0')
+		t.hasCur = true
+	case b == ';' || b == ':':
+		t.params = append(t.params, t.param())
+		t.cur, t.hasCur = 0, false
+	case b == '?' || b == '>' || b == '=' || b == '<':
+		t.private = b
+	case b >= 0x20 && b <= 0x2f:
+		// Intermediate bytes: ignored
+	case b >= 0x40 && b <= 0x7e:
+		if t.hasCur || len(t.params) > 0 {
+			t.params = append(t.params, t.param())
+		}
+		t.state = stGround
+		t.csi(b)
+	case b == 0x1b:
+		t.state = stEscape
+	default:
+		if b < 0x20 {
+			t.control(b)
+		}
+	}
+}
+
+func (t *Terminal) param() int {
+	if !t.hasCur {
+		return -1
+	}
+	return t.cur
+}
+
+// arg returns parameter i, or def if missing or zero
+func (t *Terminal) arg(i, def int) int {
+	if i < len(t.params) && t.params[i] > 0 {
+		return t.params[i]
+	}
+	return def
+}
+
+func (t *Terminal) csi(final byte) {
+	if t.private == '?' {
+		if final == 'h' || final == 'l' {
+			t.decMode(final == 'h')
+		}
+		return
+	}
+	if t.private != 0 {
+		return
+	}
+
+	switch final {
+	case 'A':
+		t.moveTo(t.x, t.y-t.arg(0, 1))
+	case 'B', 'e':
+		t.moveTo(t.x, t.y+t.arg(0, 1))
+	case 'C', 'a':
+		t.moveTo(t.x+t.arg(0, 1), t.y)
+	case 'D':
+		t.moveTo(t.x-t.arg(0, 1), t.y)
+	case 'E':
+		t.moveTo(0, t.y+t.arg(0, 1))
+	case 'F':
+		t.moveTo(0, t.y-t.arg(0, 1))
+	case 'G', '`':
+		t.moveTo(t.arg(0, 1)-1, t.y)
+	case 'd':
+		t.moveTo(t.x, t.arg(0, 1)-1)
+	case 'H', 'f':
+		t.moveTo(t.arg(1, 1)-1, t.arg(0, 1)-1)
+	case 'J':
+		t.eraseDisplay(t.arg(0, 0))
+	case 'K':
+		t.eraseLine(t.arg(0, 0))
+	case 'L':
+		t.insertLines(t.arg(0, 1))
+	case 'M':
+		t.deleteLines(t.arg(0, 1))
+	case '@':
+		t.insertChars(t.arg(0, 1))
+	case 'P':
+		t.deleteChars(t.arg(0, 1))
+	case 'X':
+		n := t.arg(0, 1)
+		for i := t.x; i < t.x+n && i < t.cols; i++ {
+			t.screen[t.y][i] = t.erased()
+		}
+	case 'S':
+		for i := 0; i < t.arg(0, 1); i++ {
+			t.scrollUp(t.top, t.bot)
+		}
+	case 'T':
+		for i := 0; i < t.arg(0, 1); i++ {
+			t.scrollDown(t.top, t.bot)
+		}
+	case 'b':
+		if t.last != 0 {
+			for i := 0; i < t.arg(0, 1); i++ {
+				t.print(t.last)
+			}
+		}
+	case 'm':
+		t.sgr()
+	case 'r':
+		top, bot := t.arg(0, 1)-1, t.arg(1, t.rows)-1
+		if bot >= t.rows {
+			bot = t.rows - 1
+		}
+		if top < bot {
+			t.top, t.bot = top, bot
+			t.moveTo(0, 0)
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	case 'n':
+		if t.arg(0, 0) == 6 && t.Reply != nil {
+			t.Reply([]byte("\x1b[" + strconv.Itoa(t.y+1) + ";" + strconv.Itoa(t.x+1) + "R"))
+		}
+	case 'c':
+		if t.Reply != nil {
+			t.Reply([]byte("\x1b[?1;2c"))
+		}
+	}
+}
+
+func (t *Terminal) decMode(set bool) {
+	for _, p := range t.params {
+		switch p {
+		case 7:
+			t.autoWrap = set
+		case 47, 1047, 1049:
+			if set && !t.altOn {
+				if p == 1049 {
+					t.saveCursor()
+				}
+				t.main = t.screen
+				t.screen = t.blankScreen(t.rows, t.cols)
+				t.altOn = true
+			} else if !set && t.altOn {
+				t.screen = t.main
+				t.main = nil
+				t.altOn = false
+				if p == 1049 {
+					t.restoreCursor()
+				}
+			}
+		}
+	}
+}
+
+func (t *Terminal) sgr() {
+	if len(t.params) == 0 {
+		t.params = append(t.params, 0)
+	}
+	for i := 0; i < len(t.params); i++ {
+		p := t.params[i]
+		switch {
+		case p <= 0:
+			t.pen = blankCell
+		case p == 1:
+			t.pen.Attr |= Bold
+		case p == 2:
+			t.pen.Attr |= Dim
+		case p == 3:
+			t.pen.Attr |= Italic
+		case p == 4:
+			t.pen.Attr |= Underline
+		case p == 7:
+			t.pen.Attr |= Reverse
+		case p == 22:
+			t.pen.Attr &^= Bold | Dim
+		case p == 23:
+			t.pen.Attr &^= Italic
+		case p == 24:
+			t.pen.Attr &^= Underline
+		case p == 27:
+			t.pen.Attr &^= Reverse
+		case p >= 30 && p <= 37:
+			t.pen.FG = Color(p - 30)
+		case p == 39:
+			t.pen.FG = DefaultColor
+		case p >= 40 && p <= 47:
+			t.pen.BG = Color(p - 40)
+		case p == 49:
+			t.pen.BG = DefaultColor
+		case p >= 90 && p <= 97:
+			t.pen.FG = Color(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			t.pen.BG = Color(p - 100 + 8)
+		case p == 38 || p == 48:
+			c, n := t.extendedColor(i + 1)
+			i += n
+			if p == 38 {
+				t.pen.FG = c
+			} else {
+				t.pen.BG = c
+			}
+		}
+	}
+}
+
+// extendedColor parses "5;n" or "2;r;g;b" after a 38/48 parameter and
+// returns the color and number of parameters consumed
+func (t *Terminal) extendedColor(i int) (Color, int) {
+	if i >= len(t.params) {
+		return DefaultColor, 0
+	}
+	switch t.params[i] {
+	case 5:
+		if i+1 < len(t.params) {
+			return Color(clamp(t.params[i+1], 0, 255)), 2
+		}
+		return DefaultColor, 1
+	case 2:
+		if i+3 < len(t.params) {
+			return RGB(uint8(clamp(t.params[i+1], 0, 255)), uint8(clamp(t.params[i+2], 0, 255)), uint8(clamp(t.params[i+3], 0, 255))), 4
+		}
+		return DefaultColor, len(t.params) - i
+	}
+	return DefaultColor, 1
+}
+
+func (t *Terminal) print(r rune) {
+	w := runeWidth(r)
+	if w == 0 {
+		return
+	}
+	t.last = r
+	if t.wrapNext {
+		if t.autoWrap {
+			t.x = 0
+			t.lineFeed()
+		}
+		t.wrapNext = false
+	}
+	if w == 2 && t.x == t.cols-1 {
+		if t.autoWrap {
+			t.screen[t.y][t.x] = t.erased()
+			t.x = 0
+			t.lineFeed()
+		} else {
+			w = 1
+		}
+	}
+
+	c := t.pen
+	c.Rune = r
+	t.screen[t.y][t.x] = c
+	if w == 2 && t.x+1 < t.cols {
+		c.Rune = 0
+		t.screen[t.y][t.x+1] = c
+	}
+	if t.x+w >= t.cols {
+		t.x = t.cols - 1
+		t.wrapNext = true
+	} else {
+		t.x += w
+	}
+}
+
+func (t *Terminal) moveTo(x, y int) {
+	t.x = clamp(x, 0, t.cols-1)
+	t.y = clamp(y, 0, t.rows-1)
+	t.wrapNext = false
+}
+
+func (t *Terminal) lineFeed() {
+	if t.y == t.bot {
+		t.scrollUp(t.top, t.bot)
+	} else if t.y < t.rows-1 {
+		t.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	if t.y == t.top {
+		t.scrollDown(t.top, t.bot)
+	} else if t.y > 0 {
+		t.y--
+	}
+}
+
+// scrollUp moves lines top+1..bot up by one; the top line goes to the
+// scrollback if the whole main screen is scrolling
+func (t *Terminal) scrollUp(top, bot int) {
+	if top == 0 && !t.altOn {
+		t.history.push(trimLine(t.screen[top]))
+	}
+	copy(t.screen[top:bot], t.screen[top+1:bot+1])
+	t.screen[bot] = t.blankLine(t.cols)
+}
+
+func (t *Terminal) scrollDown(top, bot int) {
+	copy(t.screen[top+1:bot+1], t.screen[top:bot])
+	t.screen[top] = t.blankLine(t.cols)
+}
+
+func (t *Terminal) insertLines(n int) {
+	if t.y < t.top || t.y > t.bot {
+		return
+	}
+	for i := 0; i < n; i++ {
+		t.scrollDown(t.y, t.bot)
+	}
+	t.x = 0
+}
+
+func (t *Terminal) deleteLines(n int) {
+	if t.y < t.top || t.y > t.bot {
+		return
+	}
+	for i := 0; i < n; i++ {
+		copy(t.screen[t.y:t.bot], t.screen[t.y+1:t.bot+1])
+		t.screen[t.bot] = t.blankLine(t.cols)
+	}
+	t.x = 0
+}
+
+func (t *Terminal) insertChars(n int) {
+	line := t.screen[t.y]
+	n = min(n, t.cols-t.x)
+	copy(line[t.x+n:], line[t.x:t.cols-n])
+	for i := t.x; i < t.x+n; i++ {
+		line[i] = t.erased()
+	}
+}
+
+func (t *Terminal) deleteChars(n int) {
+	line := t.screen[t.y]
+	n = min(n, t.cols-t.x)
+	copy(line[t.x:], line[t.x+n:])
+	for i := t.cols - n; i < t.cols; i++ {
+		line[i] = t.erased()
+	}
+}
+
+func (t *Terminal) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.eraseLine(0)
+		for y := t.y + 1; y < t.rows; y++ {
+			t.clearLine(y)
+		}
+	case 1:
+		t.eraseLine(1)
+		for y := 0; y < t.y; y++ {
+			t.clearLine(y)
+		}
+	case 2:
+		for y := 0; y < t.rows; y++ {
+			t.clearLine(y)
+		}
+	case 3:
+		t.history.clear()
+	}
+}
+
+func (t *Terminal) eraseLine(mode int) {
+	from, to := t.x, t.cols
+	switch mode {
+	case 1:
+		from, to = 0, t.x+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < to && i < t.cols; i++ {
+		t.screen[t.y][i] = t.erased()
+	}
+	t.wrapNext = false
+}
+
+func (t *Terminal) clearLine(y int) {
+	for i := range t.screen[y] {
+		t.screen[y][i] = t.erased()
+	}
+}
+
+func (t *Terminal) saveCursor() {
+	t.saved = cursor{t.x, t.y, t.pen}
+}
+
+func (t *Terminal) restoreCursor() {
+	t.moveTo(t.saved.x, t.saved.y)
+	t.pen = t.saved.pen
+}
+
+func (t *Terminal) reset() {
+	t.screen = t.blankScreen(t.rows, t.cols)
+	t.main = nil
+	t.altOn = false
+	t.x, t.y, t.wrapNext = 0, 0, false
+	t.pen = blankCell
+	t.saved = cursor{pen: blankCell}
+	t.top, t.bot = 0, t.rows-1
+	t.autoWrap = true
+}
+
+// Resize changes the screen size. When the screen gets shorter, lines above
+// the cursor move to the scrollback so the cursor line stays visible.
+func (t *Terminal) Resize(rows, cols int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rows < 1 || cols < 1 || (rows == t.rows && cols == t.cols) {
+		return
+	}
+	resize := func(screen [][]Cell, keepHistory bool) [][]Cell {
+		shift := 0
+		if t.y >= rows {
+			shift = t.y - rows + 1
+		}
+		if keepHistory {
+			for i := 0; i < shift; i++ {
+				t.history.push(trimLine(screen[i]))
+			}
+		}
+		out := t.blankScreen(rows, cols)
+		for y := 0; y < rows && y+shift < len(screen); y++ {
+			copy(out[y], screen[y+shift])
+		}
+		return out
+	}
+	if t.altOn {
+		t.main = resize(t.main, true)
+		t.screen = resize(t.screen, false)
+	} else {
+		t.screen = resize(t.screen, true)
+	}
+	if t.y >= rows {
+		t.y = rows - 1
+	}
+	t.rows, t.cols = rows, cols
+	t.top, t.bot = 0, rows-1
+	t.moveTo(t.x, t.y)
+}
+
+// Lines returns up to history scrollback lines followed by the screen, as
+// plain text with trailing spaces removed
+func (t *Terminal) Lines(history int) []string {
+	var out []string
+	for _, line := range t.Snapshot(history) {
+		out = append(out, LineText(line))
+	}
+	return out
+}
+
+// Snapshot returns copies of up to history scrollback lines followed by the
+// screen lines. Scrollback lines may be shorter than the screen width.
+func (t *Terminal) Snapshot(history int) [][]Cell {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out [][]Cell
+	if !t.altOn {
+		out = append(out, t.history.last(history)...)
+	}
+	for _, line := range t.screen {
+		out = append(out, append([]Cell(nil), line...))
+	}
+	return out
+}
+
+// ANSILines is like Lines but keeps colors and attributes as escape
+// sequences (see LineANSI)
+func (t *Terminal) ANSILines(history int) []string {
+	var out []string
+	for _, line := range t.Snapshot(history) {
+		out = append(out, LineANSI(line))
+	}
+	return out
+}
+
+// LineANSI converts a line of cells to text with SGR sequences wherever the
+// colors or attributes change, ending with a reset
+func LineANSI(line []Cell) string {
+	var b strings.Builder
+	pen := blankCell
+	for _, c := range trimLine(line) {
+		if c.Rune == 0 {
+			continue
+		}
+		if c.FG != pen.FG || c.BG != pen.BG || c.Attr != pen.Attr {
+			b.WriteString(SGR(c))
+			pen = c
+		}
+		b.WriteRune(c.Rune)
+	}
+	b.WriteString("\x1b[0m")
+	return b.String()
+}
+
+// LineText converts a line of cells to text with trailing spaces removed
+func LineText(line []Cell) string {
+	var b strings.Builder
+	for _, c := range line {
+		if c.Rune != 0 {
+			b.WriteRune(c.Rune)
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// Redraw returns the escape sequences that repaint the current screen
+// (with colors) and place the cursor, for a freshly attached terminal
+func (t *Terminal) Redraw() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("\x1b[0m\x1b[H\x1b[2J")
+	for y, line := range t.screen {
+		if y > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(LineANSI(line))
+	}
+	b.WriteString("\x1b[" + strconv.Itoa(t.y+1) + ";" + strconv.Itoa(t.x+1) + "H")
+	return []byte(b.String())
+}
+
+// SGR returns the escape sequence that selects a cell's colors and attributes
+func SGR(c Cell) string {
+	codes := []string{"0"}
+	for _, a := range []struct {
+		attr Attr
+		code string
+	}{{Bold, "1"}, {Dim, "2"}, {Italic, "3"}, {Underline, "4"}, {Reverse, "7"}} {
+		if c.Attr&a.attr != 0 {
+			codes = append(codes, a.code)
+		}
+	}
+	color := func(col Color, base string) {
+		switch {
+		case col == DefaultColor:
+		case col.IsRGB():
+			r, g, bl := col.Components()
+			codes = append(codes, base+"8;2;"+strconv.Itoa(int(r))+";"+strconv.Itoa(int(g))+";"+strconv.Itoa(int(bl)))
+		default:
+			codes = append(codes, base+"8;5;"+strconv.Itoa(int(col)))
+		}
+	}
+	color(c.FG, "3")
+	color(c.BG, "4")
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// trimLine drops trailing blank cells with default background
+func trimLine(line []Cell) []Cell {
+	end := len(line)
+	for end > 0 && line[end-1] == blankCell {
+		end--
+	}
+	return append([]Cell(nil), line[:end]...)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,133 @@
+package vterm
+
+import (
+	"strings"
+	"testing"
+)
+
+func screenText(t *Terminal) string {
+	return strings.TrimRight(strings.Join(t.Lines(0), "\n"), "\n")
+}
+
+func TestPrintAndWrap(t *testing.T) {
+	term := New(3, 5, 10)
+	term.Write([]byte("hello world\r\nok"))
+	// "hello world" wraps over three rows, so the CRLF scrolls "hello" out
+	if got := strings.Join(term.Lines(10), "|"); got != "hello| worl|d|ok" {
+		t.Errorf("lines = %q, want hello| worl|d|ok", got)
+	}
+	if got := screenText(term); got != " worl\nd\nok" {
+		t.Errorf("screen = %q", got)
+	}
+}
+
+func TestCursorMovementAndErase(t *testing.T) {
+	term := New(4, 20, 10)
+	term.Write([]byte("line one\r\nline two\r\nline three"))
+	term.Write([]byte("\x1b[2;6H"))        // row 2, col 6
+	term.Write([]byte("\x1b[K2"))          // erase to end of line, write "2"
+	term.Write([]byte("\x1b[1;1H\x1b[2P")) // delete 2 chars at start of row 1
+	want := "ne one\nline 2\nline three"
+	if got := screenText(term); got != want {
+		t.Errorf("screen = %q, want %q", got, want)
+	}
+
+	term.Write([]byte("\x1b[2J\x1b[Hfresh"))
+	if got := screenText(term); got != "fresh" {
+		t.Errorf("after clear = %q", got)
+	}
+}
+
+func TestSGRColors(t *testing.T) {
+	term := New(2, 10, 0)
+	term.Write([]byte("\x1b[1;31mR\x1b[0m\x1b[38;5;208mO\x1b[48;2;1;2;3mB"))
+	line := term.Snapshot(0)[0]
+	if line[0].FG != 1 || line[0].Attr&Bold == 0 {
+		t.Errorf("cell 0 = %+v, want bold red", line[0])
+	}
+	if line[1].FG != 208 || line[1].Attr != 0 {
+		t.Errorf("cell 1 = %+v, want palette 208", line[1])
+	}
+	if r, g, b := line[2].BG.Components(); !line[2].BG.IsRGB() || r != 1 || g != 2 || b != 3 {
+		t.Errorf("cell 2 bg = %v, want rgb(1,2,3)", line[2].BG)
+	}
+	if !strings.Contains(string(term.Redraw()), "\x1b[0;1;38;5;1mR") {
+		t.Errorf("redraw lacks colors: %q", term.Redraw())
+	}
+}
+
+func TestAltScreenKeepsMainAndHistory(t *testing.T) {
+	term := New(2, 10, 10)
+	term.Write([]byte("a\r\nb\r\nc")) // "a" scrolls into history
+	term.Write([]byte("\x1b[?1049h\x1b[Hfull screen app"))
+	if got := term.Lines(10); len(got) != 2 {
+		t.Errorf("alternate screen should hide history, got %q", got)
+	}
+	term.Write([]byte("\x1b[?1049l"))
+	if got := strings.Join(term.Lines(10), "|"); got != "a|b|c" {
+		t.Errorf("after leaving alt screen = %q, want a|b|c", got)
+	}
+}
+
+func TestScrollRegion(t *testing.T) {
+	term := New(4, 10, 10)
+	term.Write([]byte("header\r\n1\r\n2\r\nfooter"))
+	term.Write([]byte("\x1b[2;3r\x1b[3;1H\n3")) // scroll rows 2-3 only
+	if got := strings.Join(term.Lines(10), "|"); got != "header|2|3|footer" {
+		t.Errorf("screen = %q, want header|2|3|footer (no history)", got)
+	}
+}
+
+func TestWideRunesAndUTF8Split(t *testing.T) {
+	term := New(1, 10, 0)
+	data := []byte("❯ 日本")
+	for _, b := range data {
+		term.Write([]byte{b}) // one byte at a time
+	}
+	if got := term.Lines(0)[0]; got != "❯ 日本" {
+		t.Errorf("line = %q", got)
+	}
+	if _, cols := term.Size(); cols != 10 {
+		t.Fatal("size changed")
+	}
+	line := term.Snapshot(0)[0]
+	if line[2].Rune != '日' || line[3].Rune != 0 || line[4].Rune != '本' {
+		t.Errorf("wide runes should take two cells: %+v", line[:6])
+	}
+}
+
+func TestResizeKeepsCursorLine(t *testing.T) {
+	term := New(4, 10, 10)
+	term.Write([]byte("1\r\n2\r\n3\r\n4"))
+	term.Resize(2, 10)
+	if got := strings.Join(term.Lines(10), "|"); got != "1|2|3|4" {
+		t.Errorf("after resize = %q", got)
+	}
+	if got := term.Lines(0); got[1] != "4" {
+		t.Errorf("cursor line should stay visible, screen = %q", got)
+	}
+}
+
+func TestCursorPositionReport(t *testing.T) {
+	term := New(5, 10, 0)
+	var reply string
+	term.Reply = func(b []byte) { reply = string(b) }
+	term.Write([]byte("\x1b[3;4H\x1b[6n"))
+	if reply != "\x1b[3;4R" {
+		t.Errorf("reply = %q", reply)
+	}
+}
+
+func TestRingDropsOldest(t *testing.T) {
+	r := newRing(3)
+	for _, s := range []string{"a", "b", "c", "d"} {
+		r.push([]Cell{{Rune: rune(s[0])}})
+	}
+	var got []string
+	for _, l := range r.last(5) {
+		got = append(got, LineText(l))
+	}
+	if strings.Join(got, "") != "bcd" {
+		t.Errorf("ring = %v, want b c d", got)
+	}
+}
@@ -3,11 +3,15 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
+	"log/slog"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/url"
@@ -23,7 +27,24 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/kidandcat/ccc/internal/audit"
+	"github.com/kidandcat/ccc/internal/cmdpolicy"
 	"github.com/kidandcat/ccc/internal/config"
+	"github.com/kidandcat/ccc/internal/container"
+	"github.com/kidandcat/ccc/internal/dispatch"
+	"github.com/kidandcat/ccc/internal/logging"
+	"github.com/kidandcat/ccc/internal/markdown"
+	"github.com/kidandcat/ccc/internal/metrics"
+	"github.com/kidandcat/ccc/internal/pipeline"
+	"github.com/kidandcat/ccc/internal/ptysession"
+	"github.com/kidandcat/ccc/internal/recording"
+	"github.com/kidandcat/ccc/internal/redact"
+	"github.com/kidandcat/ccc/internal/secrets"
+	"github.com/kidandcat/ccc/internal/speech"
+	"github.com/kidandcat/ccc/internal/supervisor"
+	"github.com/kidandcat/ccc/internal/termimg"
+	"github.com/kidandcat/ccc/internal/transcribe"
+	"github.com/kidandcat/ccc/internal/usage"
 )
 
 const version = "1.12.5"
@@ -32,6 +53,8 @@ const version = "1.12.5"
 type SessionInfo = config.SessionInfo
 type HostInfo = config.HostInfo
 type Config = config.Config
+type Pipeline = config.Pipeline
+type RestartPolicy = config.RestartPolicy
 
 // TelegramMessage represents a Telegram message
 type TelegramMessage struct {
@@ -50,6 +73,7 @@ type TelegramMessage struct {
 	Voice          *TelegramVoice   `json:"voice,omitempty"`
 	Photo          []TelegramPhoto  `json:"photo,omitempty"`
 	Caption        string           `json:"caption,omitempty"`
+	Date           int64            `json:"date"` // Unix time the message was sent
 }
 
 type TelegramVoice struct {
@@ -68,7 +92,8 @@ type TelegramPhoto struct {
 type CallbackQuery struct {
 	ID   string `json:"id"`
 	From struct {
-		ID int64 `json:"id"`
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
 	} `json:"from"`
 	Message *TelegramMessage `json:"message"`
 	Data    string           `json:"data"`
@@ -88,8 +113,12 @@ type TelegramUpdate struct {
 // TelegramResponse represents a response from Telegram API
 type TelegramResponse struct {
 	OK          bool            `json:"ok"`
+	ErrorCode   int             `json:"error_code,omitempty"`
 	Description string          `json:"description,omitempty"`
 	Result      json.RawMessage `json:"result,omitempty"`
+	Parameters  *struct {
+		RetryAfter int `json:"retry_after,omitempty"` // Seconds to wait after a 429
+	} `json:"parameters,omitempty"`
 }
 
 // TopicResult represents the result of creating a forum topic
@@ -129,7 +158,7 @@ type HookData struct {
 
 // APIRequest represents an incoming request on the Unix socket
 type APIRequest struct {
-	Cmd           string   `json:"cmd"`                      // ping, sessions, ask, send, history, screenshot, subscribe, questions, answer
+	Cmd           string   `json:"cmd"`                      // ping, sessions, ask, send, history, screenshot, subscribe, questions, answer, usage, broadcast
 	Session       string   `json:"session,omitempty"`        // session name
 	Text          string   `json:"text,omitempty"`           // message text
 	From          string   `json:"from,omitempty"`           // agent identifier
@@ -139,6 +168,11 @@ type APIRequest struct {
 	Sessions      []string `json:"sessions,omitempty"`       // for subscribe: session list
 	QuestionIndex int      `json:"question_index,omitempty"` // for answer: which question (0-based)
 	OptionIndex   int      `json:"option_index,omitempty"`   // for answer: which option (0-based)
+	Period        string   `json:"period,omitempty"`         // for usage: today, yesterday, week, month, all, <n>d
+	Selector      string   `json:"selector,omitempty"`       // for broadcast, sessions, activity: names, globs, host:<name>, tag:<name>, all
+	Concurrency   int      `json:"concurrency,omitempty"`    // for broadcast: sessions prompted at once
+	Format        string   `json:"format,omitempty"`         // for screenshot: text (default) or image
+	ID            string   `json:"id,omitempty"`             // correlation ID for logs (generated when empty)
 }
 
 // APIResponse represents a response on the Unix socket
@@ -155,15 +189,20 @@ type APIResponse struct {
 	UptimeSeconds  int64               `json:"uptime_seconds,omitempty"`
 	SessionsActive int                 `json:"sessions_active,omitempty"`
 	Questions      *PendingQuestionSet `json:"questions,omitempty"`
+	QueueDepth     *int                `json:"queue_depth,omitempty"`
+	Usage          *usage.Report       `json:"usage,omitempty"`
+	Results        []BroadcastResult   `json:"results,omitempty"`
+	Image          string              `json:"image,omitempty"` // base64 PNG (screenshot with format "image")
 }
 
 // ActivityInfo represents last message summary for a session
 type ActivityInfo struct {
-	Name          string `json:"name"`
-	LastMessageID int64  `json:"lastMessageId"`
-	LastMessageTs int64  `json:"lastMessageTs"`
-	LastFrom      string `json:"lastFrom,omitempty"`
-	LastText      string `json:"lastText,omitempty"`
+	Name          string   `json:"name"`
+	LastMessageID int64    `json:"lastMessageId"`
+	LastMessageTs int64    `json:"lastMessageTs"`
+	LastFrom      string   `json:"lastFrom,omitempty"`
+	LastText      string   `json:"lastText,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
 }
 
 // APIEvent represents a streaming event for subscribe
@@ -177,11 +216,13 @@ type APIEvent struct {
 
 // APISessionInfo represents session info in API response
 type APISessionInfo struct {
-	Name         string `json:"name"`
-	Host         string `json:"host"`                    // "local" or host name
-	Status       string `json:"status"`                  // "active", "idle"
-	Cwd          string `json:"cwd,omitempty"`           // project working directory
-	LastActivity int64  `json:"last_activity,omitempty"` // unix timestamp of last history entry
+	Name         string   `json:"name"`
+	Host         string   `json:"host"`                    // "local" or host name
+	Status       string   `json:"status"`                  // "active", "idle"
+	Cwd          string   `json:"cwd,omitempty"`           // project working directory
+	LastActivity int64    `json:"last_activity,omitempty"` // unix timestamp of last history entry
+	Tags         []string `json:"tags,omitempty"`
+	Description  string   `json:"description,omitempty"`
 }
 
 // HistoryMessage represents a message stored in history
@@ -452,6 +493,176 @@ func stopSocketServer() {
 	}
 }
 
+// Metrics and health: with metrics_listen set, the listener serves
+// Prometheus metrics on /metrics, liveness on /healthz and readiness on
+// /readyz. Counters are kept in every process but only the listener's are
+// exported; hook processes report their invocations over the socket.
+
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	telegramUpdatesTotal  = metricsRegistry.Counter("ccc_telegram_updates_total", "Telegram updates processed, by type.", "type")
+	telegramRequestsTotal = metricsRegistry.Counter("ccc_telegram_requests_total", "Telegram Bot API calls (messages sent, edits, uploads), by method and result.", "method", "result")
+	apiCommandsTotal      = metricsRegistry.Counter("ccc_api_commands_total", "Socket API commands, by command.", "cmd")
+	askDuration           = metricsRegistry.Histogram("ccc_ask_duration_seconds", "Time for ask commands to get Claude's response.", []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800})
+	sshDuration           = metricsRegistry.Histogram("ccc_ssh_duration_seconds", "SSH command latency, by host and result.", metrics.DefaultBuckets, "host", "result")
+	hookInvocationsTotal  = metricsRegistry.Counter("ccc_hook_invocations_total", "Claude hook invocations, by hook.", "hook")
+)
+
+// apiCommandNames are the socket API commands counted by name; others are
+// counted as "unknown"
+var apiCommandNames = map[string]bool{
+	"ping": true, "sessions": true, "ask": true, "send": true, "history": true, "activity": true,
+	"screenshot": true, "questions": true, "answer": true, "continue": true, "usage": true,
+	"broadcast": true, "subscribe": true, "hook_event": true,
+}
+
+// Health of the update loop
+var (
+	lastPollAt atomic.Int64 // Unix time of the last getUpdates attempt
+	lastPollOK atomic.Bool  // Whether it succeeded
+)
+
+const (
+	healthPollWindow     = 2 * time.Minute  // The update loop must have polled this recently
+	sessionMetricsMaxAge = 30 * time.Second // Session states are checked at most this often
+)
+
+// markPoll records the outcome of a getUpdates call
+func markPoll(ok bool) {
+	lastPollAt.Store(time.Now().Unix())
+	lastPollOK.Store(ok)
+}
+
+// checkLive reports whether the update loop is still running
+func checkLive(now time.Time) error {
+	last := time.Unix(lastPollAt.Load(), 0)
+	if lastPollAt.Load() == 0 {
+		last = serverStartTime
+	}
+	if now.Sub(last) > healthPollWindow {
+		return fmt.Errorf("update loop stalled: last poll %s ago", formatDuration(now.Sub(last)))
+	}
+	return nil
+}
+
+// checkReady reports whether the listener can serve: the update loop is live,
+// the last poll reached Telegram and the API socket is listening
+func checkReady(now time.Time) error {
+	if err := checkLive(now); err != nil {
+		return err
+	}
+	if lastPollAt.Load() == 0 {
+		return fmt.Errorf("waiting for the first Telegram poll")
+	}
+	if !lastPollOK.Load() {
+		return fmt.Errorf("last Telegram poll failed")
+	}
+	if socketListener == nil {
+		return fmt.Errorf("API socket not listening")
+	}
+	return nil
+}
+
+var sessionMetricsCache struct {
+	sync.Mutex
+	at      time.Time
+	samples []metrics.Sample
+}
+
+// sessionStateSamples counts sessions by state and host. Checking states
+// takes tmux and SSH calls, so results are reused for sessionMetricsMaxAge.
+func sessionStateSamples() []metrics.Sample {
+	c := &sessionMetricsCache
+	c.Lock()
+	defer c.Unlock()
+	if time.Since(c.at) < sessionMetricsMaxAge {
+		return c.samples
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return c.samples
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	counts := map[[2]string]int{}
+	for name, info := range cfg.Sessions {
+		if info == nil || info.Deleted {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, info *SessionInfo) {
+			defer wg.Done()
+			st := collectSessionStatus(cfg, name, info)
+			mu.Lock()
+			counts[[2]string{st.State, st.Host}]++
+			mu.Unlock()
+		}(name, info)
+	}
+	wg.Wait()
+
+	samples := []metrics.Sample{}
+	for k, n := range counts {
+		samples = append(samples, metrics.Sample{Labels: []string{k[0], k[1]}, Value: float64(n)})
+	}
+	c.at, c.samples = time.Now(), samples
+	return samples
+}
+
+// startMetricsServer serves metrics and health checks on addr
+func startMetricsServer(addr string) error {
+	metricsRegistry.GaugeFunc("ccc_sessions", "Sessions by state and host.", []string{"state", "host"}, sessionStateSamples)
+	metricsRegistry.GaugeFunc("ccc_outbox_depth", "Telegram calls waiting in the outbound queue.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(outbox.Depth())}}
+	})
+	metricsRegistry.GaugeFunc("ccc_uptime_seconds", "Seconds since the listener started.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: time.Since(serverStartTime).Seconds()}}
+	})
+	metricsRegistry.GaugeFunc("ccc_build_info", "Version of the running listener.", []string{"version"}, func() []metrics.Sample {
+		return []metrics.Sample{{Labels: []string{version}, Value: 1}}
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsRegistry.Handler())
+	mux.HandleFunc("/healthz", healthHandler(checkLive))
+	mux.HandleFunc("/readyz", healthHandler(checkReady))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Metrics: http://%s/metrics\n", listener.Addr())
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// healthHandler answers 200 "ok", or 503 with the reason check failed
+func healthHandler(check func(time.Time) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(time.Now()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// reportHook tells a running listener that a hook fired, for metrics. It
+// gives up quickly so hooks are never slowed down.
+func reportHook(hook string) {
+	conn, err := net.DialTimeout("unix", socketPath(), 100*time.Millisecond)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	json.NewEncoder(conn).Encode(APIRequest{Cmd: "hook_event", Text: hook})
+	bufio.NewReader(conn).ReadBytes('\n')
+}
+
 // handleSocketConnection handles a single socket connection
 func handleSocketConnection(conn net.Conn, cfg *Config) {
 	defer conn.Close()
@@ -477,36 +688,88 @@ func handleSocketConnection(conn net.Conn, cfg *Config) {
 			cfg = freshCfg
 		}
 
+		if req.ID == "" {
+			req.ID = logging.NewID()
+		}
+		logFor("api").Debug("request", "cmd", req.Cmd, logging.SessionKey, req.Session, logging.IDKey, req.ID, "from", req.From)
+
+		if apiCommandNames[req.Cmd] {
+			apiCommandsTotal.Inc(req.Cmd)
+		} else {
+			apiCommandsTotal.Inc("unknown")
+		}
+
+		// Commands that act on sessions are audited with the response they got
+		enc := encoder
+		var reply bytes.Buffer
+		if apiAuditedCommands[req.Cmd] {
+			enc = json.NewEncoder(io.MultiWriter(conn, &reply))
+		}
+
 		switch req.Cmd {
 		case "ping":
-			handlePingCmd(encoder, cfg)
+			handlePingCmd(enc, cfg)
 		case "sessions":
-			handleSessionsCmd(encoder, cfg)
+			handleSessionsCmd(enc, cfg, req)
 		case "ask":
-			handleAskCmd(encoder, cfg, req)
+			handleAskCmd(enc, cfg, req)
 		case "send":
-			handleSendCmd(encoder, cfg, req)
+			handleSendCmd(enc, cfg, req)
 		case "history":
-			handleHistoryCmd(encoder, cfg, req)
+			handleHistoryCmd(enc, cfg, req)
 		case "activity":
-			handleActivityCmd(encoder, cfg)
+			handleActivityCmd(enc, cfg, req)
 		case "screenshot":
-			handleScreenshotCmd(encoder, cfg, req)
+			handleScreenshotCmd(enc, cfg, req)
 		case "questions":
-			handleQuestionsCmd(encoder, cfg, req)
+			handleQuestionsCmd(enc, cfg, req)
 		case "answer":
-			handleAnswerCmd(encoder, cfg, req)
+			handleAnswerCmd(enc, cfg, req)
 		case "continue":
-			handleContinueCmd(encoder, cfg, req)
+			handleContinueCmd(enc, cfg, req)
+		case "usage":
+			handleUsageCmd(enc, cfg, req)
+		case "broadcast":
+			handleBroadcastCmd(enc, cfg, req)
 		case "subscribe":
-			handleSubscribeCmd(conn, encoder, cfg, req)
+			handleSubscribeCmd(conn, enc, cfg, req)
 			return // Subscribe keeps connection open until done
+		case "hook_event":
+			if req.Text != "" {
+				hookInvocationsTotal.Inc(req.Text)
+			}
+			enc.Encode(APIResponse{OK: true})
 		default:
-			encoder.Encode(APIResponse{OK: false, Error: "unknown command"})
+			enc.Encode(APIResponse{OK: false, Error: "unknown command"})
+		}
+		if apiAuditedCommands[req.Cmd] {
+			auditAPIRequest(cfg, req, reply.Bytes())
 		}
 	}
 }
 
+// apiAuditedCommands are the API commands recorded in the audit log
+var apiAuditedCommands = map[string]bool{"send": true, "ask": true, "answer": true, "continue": true, "broadcast": true}
+
+// auditAPIRequest records an API command and the response it got
+func auditAPIRequest(cfg *Config, req APIRequest, reply []byte) {
+	target, args := req.Session, req.Text
+	switch req.Cmd {
+	case "broadcast":
+		target = req.Selector
+	case "answer":
+		args = fmt.Sprintf("question %d, option %d", req.QuestionIndex, req.OptionIndex)
+	}
+	var resp APIResponse
+	result := "ok"
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		result = "no response"
+	} else if !resp.OK {
+		result = "error: " + resp.Error
+	}
+	recordAudit(cfg, apiActor(req.From), req.Cmd, target, args, result)
+}
+
 // handlePingCmd handles the "ping" command
 func handlePingCmd(encoder *json.Encoder, cfg *Config) {
 	// Count configured (non-deleted) sessions — no tmux/SSH calls for fast response
@@ -517,16 +780,38 @@ func handlePingCmd(encoder *json.Encoder, cfg *Config) {
 		}
 	}
 
+	depth := outbox.Depth()
 	encoder.Encode(APIResponse{
 		OK:             true,
 		Version:        version,
 		UptimeSeconds:  int64(time.Since(serverStartTime).Seconds()),
 		SessionsActive: total,
+		QueueDepth:     &depth,
 	})
 }
 
-// handleSessionsCmd handles the "sessions" command
-func handleSessionsCmd(encoder *json.Encoder, cfg *Config) {
+// handleUsageCmd handles the "usage" command
+func handleUsageCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session != "" && cfg.Sessions[req.Session] == nil {
+		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+		return
+	}
+	period := req.Period
+	if period == "" {
+		period = "today"
+	}
+	from, to, err := usage.PeriodRange(period, time.Now())
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: err.Error()})
+		return
+	}
+	report := loadUsageStore().Report(req.Session, from, to)
+	encoder.Encode(APIResponse{OK: true, Usage: &report})
+}
+
+// handleSessionsCmd handles the "sessions" command; an optional selector
+// (names, globs, host:<h>, tag:<t>) limits the sessions returned
+func handleSessionsCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
 	var sessions []APISessionInfo
 
 	for name, info := range cfg.Sessions {
@@ -536,6 +821,9 @@ func handleSessionsCmd(encoder *json.Encoder, cfg *Config) {
 		if info.Deleted {
 			continue
 		}
+		if req.Selector != "" && !matchesSelector(name, info, req.Selector) {
+			continue
+		}
 
 		status := "idle"
 		tmuxName := tmuxSessionName(name)
@@ -550,7 +838,7 @@ func handleSessionsCmd(encoder *json.Encoder, cfg *Config) {
 			}
 		} else {
 			// Local session
-			if tmuxSessionExists(tmuxName) {
+			if backend().Exists(tmuxName) {
 				if checkClaudeState(tmuxName, "") == "busy" {
 					status = "active"
 				}
@@ -562,15 +850,9 @@ func handleSessionsCmd(encoder *json.Encoder, cfg *Config) {
 			host = info.Host
 		}
 
-		// Get last activity time from latest history file
 		var lastActivity int64
-		histDir := getHistoryDir(info.TopicID)
-		if histFiles, err := filepath.Glob(filepath.Join(histDir, "*.jsonl")); err == nil && len(histFiles) > 0 {
-			// Files are date-sorted by name; last one is newest
-			latestFile := histFiles[len(histFiles)-1]
-			if fi, err := os.Stat(latestFile); err == nil {
-				lastActivity = fi.ModTime().Unix()
-			}
+		if t := sessionLastActivity(info); !t.IsZero() {
+			lastActivity = t.Unix()
 		}
 
 		sessions = append(sessions, APISessionInfo{
@@ -579,6 +861,8 @@ func handleSessionsCmd(encoder *json.Encoder, cfg *Config) {
 			Status:       status,
 			Cwd:          info.Path,
 			LastActivity: lastActivity,
+			Tags:         info.Tags,
+			Description:  info.Description,
 		})
 	}
 
@@ -624,9 +908,9 @@ func ensureSessionRunning(cfg *Config, sessionName string, info *SessionInfo) st
 		}
 	} else {
 		// Local session
-		if !tmuxSessionExists(tmuxName) {
+		if !backend().Exists(tmuxName) {
 			// Session doesn't exist, create it with continue flag
-			if err := createTmuxSession(tmuxName, projectPath, true); err != nil {
+			if err := backend().Create(tmuxName, projectPath, true); err != nil {
 				// Ignore "duplicate session" error
 				if !strings.Contains(err.Error(), "duplicate session") {
 					return fmt.Sprintf("failed to start session: %v", err)
@@ -645,6 +929,13 @@ func ensureSessionRunning(cfg *Config, sessionName string, info *SessionInfo) st
 		}
 	}
 
+	if info.Record {
+		startRecording(tmuxName, getHostAddress(cfg, info.Host))
+	}
+	if info.Sleeping {
+		info.Sleeping = false
+		setSessionSleeping(sessionName, false)
+	}
 	return ""
 }
 
@@ -655,63 +946,30 @@ func handleAskCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
 		return
 	}
 
-	info, exists := cfg.Sessions[req.Session]
-	if !exists || info.Deleted {
-		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
-		return
-	}
-
-	// Ensure session is running (auto-start if needed)
-	if errMsg := ensureSessionRunning(cfg, req.Session, info); errMsg != "" {
-		encoder.Encode(APIResponse{OK: false, Error: errMsg})
+	response, duration, err := askSession(cfg, req.Session, req.Text, req.From, req.ID)
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: err.Error()})
 		return
 	}
-
-	// Extract correct tmux session name
-	_, projectName := parseSessionTarget(req.Session)
-	tmuxName := tmuxSessionName(extractProjectName(projectName))
-	startTime := time.Now()
-
-	// Format message with agent identifier
-	agentLabel := req.From
-	if agentLabel == "" {
-		agentLabel = "api"
-	}
-
-	// Send to Telegram topic
-	if info.TopicID > 0 {
-		telegramMsg := fmt.Sprintf("🤖 [%s] %s", agentLabel, req.Text)
-		sendMessage(cfg, cfg.GroupID, info.TopicID, telegramMsg)
-	}
-
-	// Store in history
-	msgID := nextMessageID()
-	appendHistory(info.TopicID, HistoryMessage{
-		ID:        msgID,
-		Timestamp: time.Now().Unix(),
-		From:      "api",
-		Text:      req.Text,
-		Agent:     agentLabel,
+	askDuration.Observe(duration.Seconds())
+	encoder.Encode(APIResponse{
+		OK:       true,
+		Response: response,
+		Duration: duration.Milliseconds(),
 	})
+}
 
-	// Mark as sent to suppress prompt hook echo (same mechanism as Telegram dedup)
-	if info.TopicID > 0 {
-		markTelegramSent(info.TopicID)
-	}
-
-	// Send to tmux
-	var sendErr error
-	if info.Host != "" {
-		address := getHostAddress(cfg, info.Host)
-		sendErr = sshTmuxSendKeys(address, tmuxName, req.Text)
-	} else {
-		sendErr = sendToTmux(tmuxName, req.Text)
+// askSession sends text to a session, waits for Claude to finish the turn
+// and returns the response stored by the Stop hook
+func askSession(cfg *Config, sessionName string, text string, from string, cid string) (string, time.Duration, error) {
+	startTime := time.Now()
+	if _, err := promptSession(cfg, sessionName, text, from, cid); err != nil {
+		return "", 0, err
 	}
 
-	if sendErr != nil {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to send: %v", sendErr)})
-		return
-	}
+	info := cfg.Sessions[sessionName]
+	_, projectName := parseSessionTarget(sessionName)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
 
 	// Wait for Claude to finish (poll state)
 	sshAddr := ""
@@ -735,8 +993,7 @@ func handleAskCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
 	for {
 		select {
 		case <-timeout:
-			encoder.Encode(APIResponse{OK: false, Error: "timeout waiting for response"})
-			return
+			return "", time.Since(startTime), fmt.Errorf("timeout waiting for response")
 		case <-ticker.C:
 			state := checkClaudeState(tmuxName, sshAddr)
 			if state == "idle" {
@@ -744,15 +1001,9 @@ func handleAskCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
 				if idleCount >= 2 {
 					// Claude is idle. The Stop hook should have stored the response
 					// in history already. Poll history to retrieve it.
-					duration := time.Since(startTime).Milliseconds()
+					duration := time.Since(startTime)
 					response := waitForHistoryResponse(info.TopicID, sentAt, 10*time.Second)
-
-					encoder.Encode(APIResponse{
-						OK:       true,
-						Response: response,
-						Duration: duration,
-					})
-					return
+					return response, duration, nil
 				}
 			} else {
 				idleCount = 0
@@ -779,4709 +1030,10358 @@ func waitForHistoryResponse(topicID int64, sentAt time.Time, maxWait time.Durati
 	return ""
 }
 
-// captureResponseAsync polls a remote session in the background to capture
-// Claude's response and store it in history. This is a fallback for cases
-// where client-mode forwarding isn't active — if the Stop hook already
-// forwarded the response (Fix 1), this is a no-op due to dedup.
-func captureResponseAsync(cfg *Config, sessionName string, info *SessionInfo) {
-	if info.Host == "" || info.TopicID == 0 {
-		return
-	}
+// Session tags and listing
 
-	// Per-session guard: only one capture goroutine per session
-	if _, loaded := activeCaptures.LoadOrStore(sessionName, true); loaded {
-		return
+// normalizeTags lower-cases tags, strips a leading '#' and drops empty and
+// duplicate tags; the result is sorted
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "#"))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
 	}
+	sort.Strings(out)
+	return out
+}
 
-	go func() {
-		defer activeCaptures.Delete(sessionName)
-
-		_, projectName := parseSessionTarget(sessionName)
-		tmuxName := tmuxSessionName(extractProjectName(projectName))
-		sshAddr := getHostAddress(cfg, info.Host)
-		sentAt := time.Now()
+// removeTags returns tags without the ones in remove
+func removeTags(tags []string, remove []string) []string {
+	drop := make(map[string]bool)
+	for _, t := range normalizeTags(remove) {
+		drop[t] = true
+	}
+	var out []string
+	for _, t := range tags {
+		if !drop[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
 
-		// Wait for Claude to start processing
-		time.Sleep(2 * time.Second)
+// formatTags renders tags as "#a #b"
+func formatTags(tags []string) string {
+	parts := make([]string, len(tags))
+	for i, t := range tags {
+		parts[i] = "#" + t
+	}
+	return strings.Join(parts, " ")
+}
 
-		// Poll until Claude becomes idle
-		timeout := time.After(5 * time.Minute)
-		ticker := time.NewTicker(3 * time.Second)
-		defer ticker.Stop()
+// sessionCommandTarget resolves the session a /tag, /untag, /describe,
+// /rename, /archive or /purge command is about: the topic's session, or the
+// first argument elsewhere. Inside a topic the first argument still names
+// the session when it is one and more arguments follow, so /tag <session>
+// <tag> works everywhere. Deleted sessions are only found with allowDeleted.
+func sessionCommandTarget(cfg *Config, threadID int64, args []string, allowDeleted bool) (string, []string, error) {
+	found := func(name string) bool {
+		info := cfg.Sessions[name]
+		return info != nil && (allowDeleted || !info.Deleted)
+	}
+	if len(args) > 1 && found(args[0]) {
+		return args[0], args[1:], nil
+	}
+	if threadID > 0 {
+		if name := getSessionByTopic(cfg, threadID); name != "" {
+			if !found(name) {
+				return "", nil, fmt.Errorf("session '%s' was deleted", name)
+			}
+			return name, args, nil
+		}
+	}
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("session name required outside a session topic")
+	}
+	if !found(args[0]) {
+		return "", nil, fmt.Errorf("session '%s' not found", args[0])
+	}
+	return args[0], args[1:], nil
+}
 
-		idleCount := 0
-		for {
-			select {
-			case <-timeout:
-				fmt.Printf("[capture] timeout waiting for idle session=%s\n", sessionName)
-				return
-			case <-ticker.C:
-				state := checkClaudeState(tmuxName, sshAddr)
-				if state == "idle" {
-					idleCount++
-					if idleCount >= 2 {
-						// Claude is idle — check if response already in history (from Stop hook + Fix 1)
-						response := waitForHistoryResponse(info.TopicID, sentAt, 5*time.Second)
-						if response != "" {
-							fmt.Printf("[capture] response already in history session=%s\n", sessionName)
-							return
-						}
+// sessionListOptions controls /list and ccc list
+type sessionListOptions struct {
+	Filters []string // All must match: tag:<t>, host:<h>, globs, or text in name or description
+	Sort    string   // name (default), activity, status
+	Group   string   // host (default), tag, none
+}
 
-						// No response in history — capture from remote transcript
-						response = getRemoteLastResponse(sshAddr, info.Path)
-						if response != "" {
-							appendHistoryDedup(info.TopicID, "claude", response)
-							fmt.Printf("[capture] stored remote response session=%s len=%d\n", sessionName, len(response))
-						} else {
-							fmt.Printf("[capture] no response captured session=%s\n", sessionName)
-						}
-						return
-					}
-				} else {
-					idleCount = 0
-				}
+// parseListArgs parses /list arguments such as "tag:backend host:gpu sort:activity group:tag"
+func parseListArgs(args []string) (sessionListOptions, error) {
+	opts := sessionListOptions{Sort: "name", Group: "host"}
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "sort:"):
+			opts.Sort = strings.TrimPrefix(a, "sort:")
+			if opts.Sort != "name" && opts.Sort != "activity" && opts.Sort != "status" {
+				return opts, fmt.Errorf("unknown sort %q (name, activity, status)", opts.Sort)
 			}
+		case strings.HasPrefix(a, "group:"):
+			opts.Group = strings.TrimPrefix(a, "group:")
+			if opts.Group != "host" && opts.Group != "tag" && opts.Group != "none" {
+				return opts, fmt.Errorf("unknown group %q (host, tag, none)", opts.Group)
+			}
+		default:
+			opts.Filters = append(opts.Filters, a)
 		}
-	}()
+	}
+	return opts, nil
 }
 
-// getRemoteLastResponse reads the last Claude response from a remote machine's
-// transcript file via SSH. Used as a fallback when client-mode forwarding is not active.
-func getRemoteLastResponse(sshAddr string, projectPath string) string {
-	if sshAddr == "" || projectPath == "" {
-		return ""
+// listFilterMatches reports whether a session passes one /list filter.
+// Plain words match part of the name or description.
+func listFilterMatches(name string, info *SessionInfo, filter string) bool {
+	if strings.HasPrefix(filter, "tag:") || strings.HasPrefix(filter, "host:") || strings.ContainsAny(filter, "*?[") {
+		ok, _ := sessionMatches(name, info, filter)
+		return ok
 	}
+	f := strings.ToLower(filter)
+	return strings.Contains(strings.ToLower(name), f) || strings.Contains(strings.ToLower(info.Description), f)
+}
 
-	// Claude encodes project path by replacing / with -
-	encodedPath := strings.ReplaceAll(projectPath, "/", "-")
-
-	// Find the most recent transcript file for this project
-	findCmd := fmt.Sprintf(
-		"ls -t ~/.claude/projects/%s/*/transcript.jsonl 2>/dev/null | head -1",
-		encodedPath,
-	)
-	result, err := runSSH(sshAddr, findCmd, 10*time.Second)
-	if err != nil || strings.TrimSpace(result) == "" {
-		return ""
-	}
-	transcriptPath := strings.TrimSpace(result)
+// sessionListEntry is a session shown by /list
+type sessionListEntry struct {
+	Name     string
+	Info     *SessionInfo
+	Running  bool
+	Activity time.Time
+}
 
-	// Read the last 200KB of the transcript (enough for the last turn)
-	readCmd := fmt.Sprintf("tail -c 204800 %s", shellQuote(transcriptPath))
-	content, err := runSSH(sshAddr, readCmd, 15*time.Second)
-	if err != nil || content == "" {
-		return ""
+// sessionLastActivity returns the time of the session's latest history file
+func sessionLastActivity(info *SessionInfo) time.Time {
+	histFiles, err := filepath.Glob(filepath.Join(getHistoryDir(info.TopicID), "*.jsonl"))
+	if err != nil || len(histFiles) == 0 {
+		return time.Time{}
 	}
-
-	// Write to temp file and parse with existing getLastAssistantMessage
-	tmpFile, err := os.CreateTemp("", "ccc-transcript-*.jsonl")
+	// Files are date-sorted by name; last one is newest
+	fi, err := os.Stat(histFiles[len(histFiles)-1])
 	if err != nil {
-		return ""
+		return time.Time{}
 	}
-	defer os.Remove(tmpFile.Name())
-	tmpFile.WriteString(content)
-	tmpFile.Close()
-
-	return getLastAssistantMessage(tmpFile.Name())
+	return fi.ModTime()
 }
 
-// handleSendCmd handles the "send" command (non-blocking)
-func handleSendCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" || req.Text == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session and text required"})
-		return
+// collectSessionList returns the sessions passing opts.Filters along with
+// whether they are running (checked concurrently, remote ones over SSH)
+func collectSessionList(cfg *Config, opts sessionListOptions) []sessionListEntry {
+	var entries []sessionListEntry
+	for name, info := range cfg.Sessions {
+		if info == nil || info.Deleted {
+			continue
+		}
+		matched := true
+		for _, f := range opts.Filters {
+			if !listFilterMatches(name, info, f) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			entries = append(entries, sessionListEntry{Name: name, Info: info})
+		}
 	}
 
-	info, exists := cfg.Sessions[req.Session]
-	if !exists || info.Deleted {
-		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
-		return
+	var wg sync.WaitGroup
+	for i := range entries {
+		wg.Add(1)
+		go func(e *sessionListEntry) {
+			defer wg.Done()
+			_, projectName := parseSessionTarget(e.Name)
+			tmuxName := tmuxSessionName(extractProjectName(projectName))
+			if e.Info.Host != "" {
+				address := getHostAddress(cfg, e.Info.Host)
+				e.Running = address != "" && sshTmuxHasSession(address, tmuxName)
+			} else {
+				e.Running = backend().Exists(tmuxName)
+			}
+			e.Activity = sessionLastActivity(e.Info)
+		}(&entries[i])
 	}
+	wg.Wait()
+	return entries
+}
 
-	// Ensure session is running (auto-start if needed)
-	if errMsg := ensureSessionRunning(cfg, req.Session, info); errMsg != "" {
-		encoder.Encode(APIResponse{OK: false, Error: errMsg})
-		return
+// formatSessionList sorts, groups and renders sessions for /list
+func formatSessionList(entries []sessionListEntry, opts sessionListOptions) string {
+	if len(entries) == 0 {
+		if len(opts.Filters) > 0 {
+			return "No sessions match " + strings.Join(opts.Filters, " ")
+		}
+		return "No sessions configured"
 	}
 
-	// Extract correct tmux session name
-	_, projectName := parseSessionTarget(req.Session)
-	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case opts.Sort == "activity" && !a.Activity.Equal(b.Activity):
+			return a.Activity.After(b.Activity)
+		case opts.Sort == "status" && a.Running != b.Running:
+			return a.Running
+		}
+		return a.Name < b.Name
+	})
 
-	// Format message with agent identifier
-	agentLabel := req.From
-	if agentLabel == "" {
-		agentLabel = "api"
+	line := func(e sessionListEntry) string {
+		status := "⚪"
+		if e.Running {
+			status = "🟢"
+		} else if e.Info.Sleeping {
+			status = "💤"
+		}
+		s := status + " " + e.Name
+		if e.Info.Description != "" {
+			s += " — " + e.Info.Description
+		}
+		if len(e.Info.Tags) > 0 {
+			s += "  " + formatTags(e.Info.Tags)
+		}
+		return s
 	}
 
-	// Send to Telegram topic
-	if info.TopicID > 0 {
-		telegramMsg := fmt.Sprintf("🤖 [%s] %s", agentLabel, req.Text)
-		sendMessage(cfg, cfg.GroupID, info.TopicID, telegramMsg)
+	// Group keys in order of first appearance, so groups follow the sort
+	var keys []string
+	groups := make(map[string][]string)
+	add := func(key string, e sessionListEntry) {
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], line(e))
 	}
-
-	// Store in history
-	msgID := nextMessageID()
-	appendHistory(info.TopicID, HistoryMessage{
-		ID:        msgID,
-		Timestamp: time.Now().Unix(),
-		From:      "api",
-		Text:      req.Text,
-		Agent:     agentLabel,
-	})
-
-	// Mark as sent to suppress prompt hook echo (same mechanism as Telegram dedup)
-	if info.TopicID > 0 {
-		markTelegramSent(info.TopicID)
+	for _, e := range entries {
+		switch opts.Group {
+		case "tag":
+			if len(e.Info.Tags) == 0 {
+				add("🏷 untagged", e)
+			}
+			for _, t := range e.Info.Tags {
+				add("🏷 "+t, e)
+			}
+		case "host":
+			host := e.Info.Host
+			if host == "" {
+				host = "local"
+			}
+			add("🖥 "+host, e)
+		default:
+			add("", e)
+		}
+	}
+	if opts.Group != "none" {
+		sort.Strings(keys)
+	}
+	// A single host needs no heading
+	if opts.Group == "host" && len(keys) == 1 {
+		groups[""], keys = groups[keys[0]], []string{""}
 	}
 
-	// Send to tmux
-	var sendErr error
-	if info.Host != "" {
-		address := getHostAddress(cfg, info.Host)
-		sendErr = sshTmuxSendKeys(address, tmuxName, req.Text)
+	var b strings.Builder
+	if len(opts.Filters) > 0 {
+		fmt.Fprintf(&b, "Sessions matching %s (%d):\n", strings.Join(opts.Filters, " "), len(entries))
 	} else {
-		sendErr = sendToTmux(tmuxName, req.Text)
+		fmt.Fprintf(&b, "Sessions (%d):\n", len(entries))
 	}
-
-	if sendErr != nil {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to send: %v", sendErr)})
-		return
+	for _, key := range keys {
+		if key != "" {
+			b.WriteString("\n" + key + "\n")
+		}
+		b.WriteString(strings.Join(groups[key], "\n") + "\n")
 	}
+	return strings.TrimRight(b.String(), "\n")
+}
 
-	encoder.Encode(APIResponse{OK: true, MessageID: msgID})
+// Broadcast
 
-	// Background capture for remote sessions (fallback if client-mode forwarding is inactive)
-	captureResponseAsync(cfg, req.Session, info)
-}
+// defaultBroadcastConcurrency is how many sessions a broadcast prompts at once
+const defaultBroadcastConcurrency = 4
 
+// BroadcastResult is the outcome of a broadcast prompt in one session
+type BroadcastResult struct {
+	Session  string `json:"session"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+	Response string `json:"response,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
 
-// handleContinueCmd handles the "continue" command - restarts Claude in a session with -c flag
-func handleContinueCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session required"})
-		return
+// selectSessions resolves a selector to sorted session names. A selector is
+// a comma-separated list of session names, globs (api-*), host:<name>
+// (host:local for this machine), tag:<name> or "all".
+func selectSessions(cfg *Config, selector string) ([]string, error) {
+	matched := make(map[string]bool)
+	for _, item := range strings.Split(selector, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		found := false
+		for name, info := range cfg.Sessions {
+			if info == nil || info.Deleted {
+				continue
+			}
+			ok, err := sessionMatches(name, info, item)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched[name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no session matches %q", item)
+		}
 	}
-
-	info, exists := cfg.Sessions[req.Session]
-	if !exists || info.Deleted {
-		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
-		return
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("empty selector")
 	}
 
-	_, projectName := parseSessionTarget(req.Session)
-	tmuxName := tmuxSessionName(extractProjectName(projectName))
-	workDir := info.Path
-	if workDir == "" {
-		workDir = resolveProjectPath(cfg, projectName)
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names, nil
+}
 
-	if info.Host != "" {
-		// Remote session
-		address := getHostAddress(cfg, info.Host)
-		if address == "" {
-			encoder.Encode(APIResponse{OK: false, Error: "host not configured"})
-			return
-		}
-
-		// Kill existing tmux session if running
-		if sshTmuxHasSession(address, tmuxName) {
-			sshTmuxKillSession(address, tmuxName)
-			time.Sleep(300 * time.Millisecond)
+// sessionMatches reports whether a session matches one selector item
+func sessionMatches(name string, info *SessionInfo, item string) (bool, error) {
+	if name == item || item == "all" {
+		return true, nil
+	}
+	if host, ok := strings.CutPrefix(item, "host:"); ok {
+		if host == "local" {
+			return info.Host == "", nil
 		}
-
-		// Create directory if needed
-		if workDir != "" {
-			sshMkdir(address, workDir)
+		return info.Host == host, nil
+	}
+	if tag, ok := strings.CutPrefix(item, "tag:"); ok {
+		for _, t := range info.Tags {
+			if strings.EqualFold(t, tag) {
+				return true, nil
+			}
 		}
-
-		// Create new tmux session with -c (continue) flag
-		if err := sshTmuxNewSession(address, tmuxName, workDir, true); err != nil {
-			encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to start: %v", err)})
-			return
+		return false, nil
+	}
+	if strings.ContainsAny(item, "*?[") {
+		ok, err := filepath.Match(item, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q", item)
 		}
+		return ok, nil
+	}
+	return false, nil
+}
 
-		// Wait for Claude to initialize
-		time.Sleep(5 * time.Second)
-
-		if !isClaudeRunning(tmuxName, address) {
-			encoder.Encode(APIResponse{OK: false, Error: "session started but Claude failed to initialize"})
-			return
-		}
-	} else {
-		// Local session
-		// Kill existing tmux session if running
-		if tmuxSessionExists(tmuxName) {
-			killTmuxSession(tmuxName)
-			time.Sleep(300 * time.Millisecond)
-		}
+// runBroadcast asks every session the same prompt, at most concurrency at a
+// time, and posts a summary of the results to the private chat
+func runBroadcast(cfg *Config, sessions []string, text string, from string, concurrency int, cid string) []BroadcastResult {
+	if concurrency <= 0 {
+		concurrency = cfg.BroadcastConcurrency
+	}
+	if concurrency <= 0 {
+		concurrency = defaultBroadcastConcurrency
+	}
 
-		// Ensure work directory exists
-		if workDir != "" {
-			os.MkdirAll(workDir, 0755)
-		}
+	// All prompts of a broadcast share one correlation ID
+	if cid == "" {
+		cid = logging.NewID()
+	}
+	start := time.Now()
+	results := make([]BroadcastResult, len(sessions))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range sessions {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
 
-		// Create new tmux session with -c (continue) flag
-		if err := createTmuxSession(tmuxName, workDir, true); err != nil {
-			encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to start: %v", err)})
-			return
-		}
+			response, duration, err := askSession(cfg, name, text, from, cid)
+			results[i] = BroadcastResult{Session: name, OK: err == nil, Response: response, Duration: duration.Milliseconds()}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, name)
+	}
+	wg.Wait()
 
-		// Wait for Claude to initialize
-		time.Sleep(5 * time.Second)
+	logFor("broadcast").Info("broadcast done", logging.IDKey, cid, "sessions", len(sessions), "duration", time.Since(start).Round(time.Second).String())
+	if cfg.ChatID != 0 {
+		sendMessage(cfg, cfg.ChatID, 0, formatBroadcastSummary(text, results, time.Since(start)))
+	}
+	return results
+}
 
-		if !isClaudeRunning(tmuxName, "") {
-			encoder.Encode(APIResponse{OK: false, Error: "session started but Claude failed to initialize"})
-			return
+// formatBroadcastSummary renders broadcast results for Telegram
+func formatBroadcastSummary(text string, results []BroadcastResult, elapsed time.Duration) string {
+	ok := 0
+	for _, r := range results {
+		if r.OK {
+			ok++
 		}
 	}
 
-	// Notify Telegram
-	if info.TopicID > 0 {
-		agentLabel := req.From
-		if agentLabel == "" {
-			agentLabel = "api"
+	var b strings.Builder
+	fmt.Fprintf(&b, "📣 Broadcast: %d/%d succeeded in %s\n", ok, len(results), elapsed.Round(time.Second))
+	fmt.Fprintf(&b, "› %s\n", firstLine(text, 100))
+	for _, r := range results {
+		d := (time.Duration(r.Duration) * time.Millisecond).Round(time.Second)
+		if !r.OK {
+			fmt.Fprintf(&b, "\n❌ %s — %s: %s", r.Session, d, r.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "\n✅ %s — %s", r.Session, d)
+		if line := firstLine(r.Response, 120); line != "" {
+			fmt.Fprintf(&b, "\n   %s", line)
 		}
-		sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("🔄 [%s] Session continued", agentLabel))
 	}
-
-	encoder.Encode(APIResponse{OK: true})
+	return b.String()
 }
 
-// handleHistoryCmd handles the "history" command
-func handleHistoryCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+// handleBroadcastCmd handles the "broadcast" command
+func handleBroadcastCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Selector == "" || req.Text == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "selector and text required"})
 		return
 	}
-
-	info, exists := cfg.Sessions[req.Session]
-	if !exists || info.Deleted {
-		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+	sessions, err := selectSessions(cfg, req.Selector)
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: err.Error()})
 		return
 	}
+	from := req.From
+	if from == "" {
+		from = "broadcast"
+	}
+	encoder.Encode(APIResponse{OK: true, Results: runBroadcast(cfg, sessions, req.Text, from, req.Concurrency, req.ID)})
+}
 
-	messages, err := readHistory(info.TopicID, req.After, req.Limit, req.FromFilter)
+// Pipelines
+
+func pipelineStatePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "pipelines.json")
+}
+
+// withPipelineState runs fn on the pipeline chain state under an exclusive
+// lock and saves it (hooks of several sessions may forward at once)
+func withPipelineState(fn func(s *pipeline.State)) error {
+	path := pipelineStatePath()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(strings.TrimSuffix(path, ".json")+".lock", os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to read history: %v", err)})
-		return
+		return err
 	}
+	defer f.Close()
+	syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 
-	encoder.Encode(APIResponse{OK: true, Messages: messages})
+	s, err := pipeline.Load(path)
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return s.Save(path)
 }
 
-// handleActivityCmd returns last message summary for all sessions
-func handleActivityCmd(encoder *json.Encoder, cfg *Config) {
-	var activity []ActivityInfo
-
-	for name, info := range cfg.Sessions {
-		if info.Deleted {
+// matchesSelector reports whether a session matches any item of a selector
+func matchesSelector(name string, info *SessionInfo, selector string) bool {
+	for _, item := range strings.Split(selector, ",") {
+		if item = strings.TrimSpace(item); item == "" {
 			continue
 		}
-		ai := ActivityInfo{Name: name}
-
-		if info.TopicID > 0 {
-			if msg := readLastHistoryMessage(info.TopicID); msg != nil {
-				ai.LastMessageID = msg.ID
-				ai.LastMessageTs = msg.Timestamp
-				ai.LastFrom = msg.From
-				text := msg.Text
-				if text == "" && msg.Transcription != "" {
-					text = msg.Transcription
-				}
-				if text == "" && msg.Caption != "" {
-					text = msg.Caption
-				}
-				if len(text) > 100 {
-					text = text[:100] + "..."
-				}
-				ai.LastText = text
-			}
+		if ok, _ := sessionMatches(name, info, item); ok {
+			return true
 		}
-
-		activity = append(activity, ai)
 	}
-
-	encoder.Encode(APIResponse{OK: true, Activity: activity})
+	return false
 }
 
-// readLastHistoryMessage reads the last line from the newest history JSONL file
-func readLastHistoryMessage(topicID int64) *HistoryMessage {
-	dir := getHistoryDir(topicID)
-	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
-	if err != nil || len(files) == 0 {
-		return nil
+// runPipelines forwards a finished turn of sessionName to the targets of the
+// enabled pipelines it matches. Called from the Stop hook paths, outside of
+// the hook itself (see spawnPipelines).
+func runPipelines(cfg *Config, sessionName string, response string) {
+	info := cfg.Sessions[sessionName]
+	if len(cfg.Pipelines) == 0 || info == nil || strings.TrimSpace(response) == "" {
+		return
 	}
-	// Files are named YYYY-MM-DD-HH.jsonl, lexicographic sort = chronological
-	sort.Strings(files)
 
-	// Read last non-empty line from newest file, fall back to older files
-	for i := len(files) - 1; i >= 0; i-- {
-		if msg := readLastLine(files[i]); msg != nil {
-			return msg
+	type forward struct {
+		name, to, prompt string
+	}
+	var forwards []forward
+	for _, p := range cfg.Pipelines {
+		if p.Disabled || p.To == sessionName || !matchesSelector(sessionName, info, p.From) {
+			continue
+		}
+		groups, ok, err := pipeline.Match(p.Match, response)
+		if err != nil {
+			logFor("pipeline").Warn("bad match pattern", "pipeline", p.Name, "error", err)
+			continue
+		}
+		if ok {
+			forwards = append(forwards, forward{p.Name, p.To, pipeline.Render(p.Template, sessionName, response, groups)})
 		}
 	}
-	return nil
-}
-
-// readLastLine reads the last non-empty JSONL line from a file using tail seek
-func readLastLine(path string) *HistoryMessage {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil
+	if len(forwards) == 0 {
+		return
 	}
-	defer f.Close()
 
-	stat, err := f.Stat()
-	if err != nil || stat.Size() == 0 {
-		return nil
+	// Loop protection: a chain of forwards may only take PipelineMaxHops steps
+	// before a person has to prompt one of its sessions again
+	var chain []string
+	allowed := false
+	now := time.Now()
+	withPipelineState(func(s *pipeline.State) {
+		chain, allowed = pipeline.Next(s.Origin(sessionName, now), sessionName, cfg.PipelineMaxHops)
+		if allowed {
+			for _, f := range forwards {
+				s.Forwarded(f.to, chain, now)
+			}
+		}
+	})
+	if !allowed {
+		logFor("pipeline").Warn("chain limit reached", logging.SessionKey, sessionName, "chain", strings.Join(chain, " → "))
+		sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("🔗 Pipeline stopped after %d forwards in a row (%s). Send a message to continue.", len(chain)-1, strings.Join(chain, " → ")))
+		return
 	}
 
-	// Read up to last 8KB to find the last line
-	bufSize := int64(8192)
-	if stat.Size() < bufSize {
-		bufSize = stat.Size()
+	// Forwarded prompts keep the correlation ID of the answer that triggered them
+	cid := topicCorrelation(info.TopicID)
+	for _, f := range forwards {
+		logFor("pipeline").Info("forwarding", logging.SessionKey, sessionName, logging.IDKey, cid, "pipeline", f.name, "to", f.to)
+		if _, err := promptSession(cfg, f.to, f.prompt, "pipeline:"+f.name, cid); err != nil {
+			sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("🔗 Pipeline %s could not forward to %s: %v", f.name, f.to, err))
+			continue
+		}
+		sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("🔗 Forwarded to %s (%s)", f.to, f.name))
 	}
-	buf := make([]byte, bufSize)
-	f.ReadAt(buf, stat.Size()-bufSize)
+}
 
-	// Find last newline-terminated JSON line
-	lines := bytes.Split(buf, []byte("\n"))
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := bytes.TrimSpace(lines[i])
-		if len(line) == 0 {
-			continue
+// spawnPipelines runs runPipelines in a detached "ccc pipeline-run" so the
+// Stop hook doesn't wait for the targets to start and take their prompts
+func spawnPipelines(cfg *Config, sessionName string, response string) {
+	if len(cfg.Pipelines) == 0 || strings.TrimSpace(response) == "" {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		logFor("pipeline").Warn("forwards skipped", logging.SessionKey, sessionName, "error", err)
+		return
+	}
+	f, err := os.CreateTemp("", "ccc-pipeline-*.txt")
+	if err != nil {
+		logFor("pipeline").Warn("forwards skipped", logging.SessionKey, sessionName, "error", err)
+		return
+	}
+	_, err = f.WriteString(response)
+	f.Close()
+	if err == nil {
+		cmd := exec.Command(exe, "pipeline-run", sessionName, f.Name())
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err = cmd.Start(); err == nil {
+			cmd.Process.Release()
+			return
 		}
-		var msg HistoryMessage
-		if json.Unmarshal(line, &msg) == nil && msg.ID > 0 {
-			return &msg
+	}
+	os.Remove(f.Name())
+	logFor("pipeline").Warn("forwards skipped", logging.SessionKey, sessionName, "error", err)
+}
+
+// resetPipelineChain forgets how a session's turn was started, so any prompt
+// that isn't a pipeline forward (Telegram, voice, photos, the API, the
+// terminal) starts a new chain
+func resetPipelineChain(cfg *Config, sessionName string) {
+	if len(cfg.Pipelines) == 0 {
+		return
+	}
+	withPipelineState(func(s *pipeline.State) { s.Reset(sessionName) })
+}
+
+// formatPipelines renders the configured pipelines for /pipelines
+func formatPipelines(pipelines []Pipeline) string {
+	if len(pipelines) == 0 {
+		return "🔗 No pipelines configured. Add them to \"pipelines\" in ~/.ccc.json."
+	}
+	var b strings.Builder
+	b.WriteString("🔗 Pipelines\n")
+	for _, p := range pipelines {
+		state := "✅"
+		if p.Disabled {
+			state = "⏸"
+		}
+		fmt.Fprintf(&b, "\n%s %s: %s → %s", state, p.Name, p.From, p.To)
+		if p.Match != "" {
+			fmt.Fprintf(&b, " (match: %s)", p.Match)
 		}
 	}
-	return nil
+	return b.String()
 }
 
-// handleScreenshotCmd handles the "screenshot" command — returns raw tmux capture-pane
-func handleScreenshotCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+// captureResponseAsync polls a remote session in the background to capture
+// Claude's response and store it in history. This is a fallback for cases
+// where client-mode forwarding isn't active — if the Stop hook already
+// forwarded the response (Fix 1), this is a no-op due to dedup.
+func captureResponseAsync(cfg *Config, sessionName string, info *SessionInfo) {
+	if info.Host == "" || info.TopicID == 0 {
 		return
 	}
 
-	info, exists := cfg.Sessions[req.Session]
-	if !exists || info.Deleted {
-		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+	// Per-session guard: only one capture goroutine per session
+	if _, loaded := activeCaptures.LoadOrStore(sessionName, true); loaded {
 		return
 	}
 
-	_, projectName := parseSessionTarget(req.Session)
-	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	go func() {
+		defer activeCaptures.Delete(sessionName)
 
-	var sshAddress string
-	if info.Host != "" {
-		sshAddress = getHostAddress(cfg, info.Host)
-		if sshAddress == "" {
-			encoder.Encode(APIResponse{OK: false, Error: "host not configured: " + info.Host})
-			return
+		_, projectName := parseSessionTarget(sessionName)
+		tmuxName := tmuxSessionName(extractProjectName(projectName))
+		sshAddr := getHostAddress(cfg, info.Host)
+		sentAt := time.Now()
+
+		// Wait for Claude to start processing
+		time.Sleep(2 * time.Second)
+
+		// Poll until Claude becomes idle
+		timeout := time.After(5 * time.Minute)
+		ticker := time.NewTicker(3 * time.Second)
+		defer ticker.Stop()
+
+		idleCount := 0
+		for {
+			select {
+			case <-timeout:
+				logFor("capture").Warn("timeout waiting for idle", logging.SessionKey, sessionName)
+				return
+			case <-ticker.C:
+				state := checkClaudeState(tmuxName, sshAddr)
+				if state == "idle" {
+					idleCount++
+					if idleCount >= 2 {
+						// Claude is idle — check if response already in history (from Stop hook + Fix 1)
+						response := waitForHistoryResponse(info.TopicID, sentAt, 5*time.Second)
+						if response != "" {
+							logFor("capture").Debug("response already in history", logging.SessionKey, sessionName)
+							return
+						}
+
+						// No response in history — capture from remote transcript
+						response = getRemoteLastResponse(sshAddr, info.Path)
+						if response != "" {
+							appendHistoryDedup(info.TopicID, "claude", response)
+							logFor("capture").Info("stored remote response", logging.SessionKey, sessionName, "chars", len(response))
+						} else {
+							logFor("capture").Warn("no response captured", logging.SessionKey, sessionName)
+						}
+						return
+					}
+				} else {
+					idleCount = 0
+				}
+			}
 		}
+	}()
+}
+
+// getRemoteLastResponse reads the last Claude response from a remote machine's
+// transcript file via SSH. Used as a fallback when client-mode forwarding is not active.
+func getRemoteLastResponse(sshAddr string, projectPath string) string {
+	if sshAddr == "" || projectPath == "" {
+		return ""
 	}
 
-	lines := req.Limit
-	if lines <= 0 {
-		lines = 50
+	// Claude encodes project path by replacing / with -
+	encodedPath := strings.ReplaceAll(projectPath, "/", "-")
+
+	// Find the most recent transcript file for this project
+	findCmd := fmt.Sprintf(
+		"ls -t ~/.claude/projects/%s/*/transcript.jsonl 2>/dev/null | head -1",
+		encodedPath,
+	)
+	result, err := runSSH(sshAddr, findCmd, 10*time.Second)
+	if err != nil || strings.TrimSpace(result) == "" {
+		return ""
 	}
+	transcriptPath := strings.TrimSpace(result)
 
-	content, err := captureTmuxPane(tmuxName, sshAddress, lines)
+	// Read the last 200KB of the transcript (enough for the last turn)
+	readCmd := fmt.Sprintf("tail -c 204800 %s", shellQuote(transcriptPath))
+	content, err := runSSH(sshAddr, readCmd, 15*time.Second)
+	if err != nil || content == "" {
+		return ""
+	}
+
+	// Write to temp file and parse with existing getLastAssistantMessage
+	tmpFile, err := os.CreateTemp("", "ccc-transcript-*.jsonl")
 	if err != nil {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("capture failed: %v", err)})
-		return
+		return ""
 	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString(content)
+	tmpFile.Close()
 
-	encoder.Encode(APIResponse{OK: true, Response: content})
+	return getLastAssistantMessage(tmpFile.Name())
 }
 
-// handleQuestionsCmd returns pending AskUserQuestion questions for a session.
-func handleQuestionsCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+// handleSendCmd handles the "send" command (non-blocking)
+func handleSendCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" || req.Text == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session and text required"})
 		return
 	}
 
-	// Clean expired questions (older than 5 minutes)
-	cleanExpiredQuestions()
-
-	val, exists := pendingQuestions.Load(req.Session)
-	if !exists {
-		encoder.Encode(APIResponse{OK: true}) // No pending questions
+	msgID, err := promptSession(cfg, req.Session, req.Text, req.From, req.ID)
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: err.Error()})
 		return
 	}
 
-	qs := val.(*PendingQuestionSet)
-	encoder.Encode(APIResponse{OK: true, Questions: qs})
+	encoder.Encode(APIResponse{OK: true, MessageID: msgID})
+
+	// Background capture for remote sessions (fallback if client-mode forwarding is inactive)
+	captureResponseAsync(cfg, req.Session, cfg.Sessions[req.Session])
 }
 
-// handleAnswerCmd answers a pending AskUserQuestion by sending keys to tmux.
-func handleAnswerCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
-	if req.Session == "" {
-		encoder.Encode(APIResponse{OK: false, Error: "session required"})
-		return
+// promptSession sends text from an agent to a session without waiting for
+// the answer: it starts the session if needed, shows the text in the topic,
+// stores it in history and types it into Claude. Returns the history ID.
+func promptSession(cfg *Config, sessionName string, text string, from string, cid string) (int64, error) {
+	info, exists := cfg.Sessions[sessionName]
+	if !exists || info.Deleted {
+		return 0, fmt.Errorf("session not found")
 	}
 
-	val, exists := pendingQuestions.Load(req.Session)
-	if !exists {
-		encoder.Encode(APIResponse{OK: false, Error: "no pending questions for this session"})
-		return
+	if spent, paused := budgetStatus(cfg); paused {
+		return 0, fmt.Errorf("daily budget reached ($%.2f of $%.2f)", spent, cfg.DailyBudget)
 	}
 
-	qs := val.(*PendingQuestionSet)
-	if req.QuestionIndex < 0 || req.QuestionIndex >= len(qs.Questions) {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("question_index out of range (0-%d)", len(qs.Questions)-1)})
-		return
+	// Ensure session is running (auto-start if needed)
+	if errMsg := ensureSessionRunning(cfg, sessionName, info); errMsg != "" {
+		return 0, fmt.Errorf("%s", errMsg)
 	}
 
-	q := &qs.Questions[req.QuestionIndex]
-	if q.Answered {
-		encoder.Encode(APIResponse{OK: false, Error: "question already answered"})
-		return
+	// Format message with agent identifier
+	agentLabel := from
+	if agentLabel == "" {
+		agentLabel = "api"
 	}
 
-	if req.OptionIndex < 0 || req.OptionIndex >= len(q.Options) {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("option_index out of range (0-%d)", len(q.Options)-1)})
+	// Send to Telegram topic
+	if info.TopicID > 0 {
+		telegramMsg := fmt.Sprintf("🤖 [%s] %s", agentLabel, text)
+		sendMessage(cfg, cfg.GroupID, info.TopicID, telegramMsg)
+	}
+
+	// Store in history
+	msgID := nextMessageID()
+	appendHistory(info.TopicID, HistoryMessage{
+		ID:        msgID,
+		Timestamp: time.Now().Unix(),
+		From:      "api",
+		Text:      text,
+		Agent:     agentLabel,
+	})
+
+	// Mark as sent to suppress prompt hook echo (same mechanism as Telegram dedup)
+	if info.TopicID > 0 {
+		markTelegramSent(info.TopicID)
+	}
+
+	// Send to tmux
+	if sendErr := typePrompt(cfg, sessionName, info, text, "api:"+agentLabel, cid); sendErr != nil {
+		return 0, fmt.Errorf("failed to send: %v", sendErr)
+	}
+	return msgID, nil
+}
+
+// handleContinueCmd handles the "continue" command - restarts Claude in a session with -c flag
+func handleContinueCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session required"})
 		return
 	}
 
-	// Resolve session info for tmux
-	info, sessionExists := cfg.Sessions[req.Session]
-	if !sessionExists || info.Deleted {
+	info, exists := cfg.Sessions[req.Session]
+	if !exists || info.Deleted {
 		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
 		return
 	}
 
 	_, projectName := parseSessionTarget(req.Session)
 	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	workDir := info.Path
+	if workDir == "" {
+		workDir = resolveProjectPath(cfg, projectName)
+	}
 
-	// Send tmux keys: Down × optionIndex, then Enter
-	var sendErr error
 	if info.Host != "" {
+		// Remote session
 		address := getHostAddress(cfg, info.Host)
-		for i := 0; i < req.OptionIndex; i++ {
-			cmd := fmt.Sprintf("tmux send-keys -t %s Down", shellQuote(tmuxName))
-			runSSH(address, cmd, 5*time.Second)
-			time.Sleep(50 * time.Millisecond)
+		if address == "" {
+			encoder.Encode(APIResponse{OK: false, Error: "host not configured"})
+			return
 		}
-		cmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(tmuxName))
-		_, sendErr = runSSH(address, cmd, 5*time.Second)
-	} else {
-		for i := 0; i < req.OptionIndex; i++ {
-			tmuxCmd("send-keys", "-t", tmuxName, "Down").Run()
-			time.Sleep(50 * time.Millisecond)
+
+		// Kill existing tmux session if running
+		if sshTmuxHasSession(address, tmuxName) {
+			sshTmuxKillSession(address, tmuxName)
+			time.Sleep(300 * time.Millisecond)
 		}
-		sendErr = tmuxCmd("send-keys", "-t", tmuxName, "Enter").Run()
-	}
 
-	if sendErr != nil {
-		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to send keys: %v", sendErr)})
-		return
-	}
+		// Create directory if needed
+		if workDir != "" {
+			sshMkdir(address, workDir)
+		}
 
-	// Mark as answered
-	q.Answered = true
-	q.AnswerIndex = req.OptionIndex
-
-	// Auto-submit if this was the last question
-	allAnswered := true
-	for _, qq := range qs.Questions {
-		if !qq.Answered {
-			allAnswered = false
-			break
-		}
-	}
-	if allAnswered {
-		time.Sleep(300 * time.Millisecond)
-		if info.Host != "" {
-			address := getHostAddress(cfg, info.Host)
-			cmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(tmuxName))
-			runSSH(address, cmd, 5*time.Second)
-		} else {
-			tmuxCmd("send-keys", "-t", tmuxName, "Enter").Run()
+		// Create new tmux session with -c (continue) flag
+		if err := sshTmuxNewSession(address, tmuxName, workDir, true); err != nil {
+			encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to start: %v", err)})
+			return
 		}
-		// Remove from pending
-		pendingQuestions.Delete(req.Session)
-		fmt.Printf("[answer] Auto-submitted all answers for %s\n", req.Session)
-	}
-
-	// Store answer in history
-	optLabel := q.Options[req.OptionIndex].Label
-	appendHistoryDedup(qs.TopicID, "human", fmt.Sprintf("Selected: %s", optLabel))
 
-	encoder.Encode(APIResponse{OK: true, Response: fmt.Sprintf("answered question %d with option %d (%s)", req.QuestionIndex, req.OptionIndex, optLabel)})
-}
+		// Wait for Claude to initialize
+		time.Sleep(5 * time.Second)
 
-// cleanExpiredQuestions removes pending questions older than 5 minutes.
-func cleanExpiredQuestions() {
-	cutoff := time.Now().Unix() - 300
-	pendingQuestions.Range(func(key, value interface{}) bool {
-		qs := value.(*PendingQuestionSet)
-		if qs.Timestamp < cutoff {
-			pendingQuestions.Delete(key)
+		if !isClaudeRunning(tmuxName, address) {
+			encoder.Encode(APIResponse{OK: false, Error: "session started but Claude failed to initialize"})
+			return
 		}
-		return true
-	})
-}
-
-// handleSubscribeCmd handles the "subscribe" command
-func handleSubscribeCmd(conn net.Conn, encoder *json.Encoder, cfg *Config, req APIRequest) {
-	// For now, implement basic subscription that sends events for specified sessions
-	sessions := req.Sessions
-	if len(sessions) == 0 {
-		// Subscribe to all sessions
-		for name, info := range cfg.Sessions {
-			if !info.Deleted {
-				sessions = append(sessions, name)
-			}
+	} else {
+		// Local session
+		// Kill existing tmux session if running
+		if backend().Exists(tmuxName) {
+			killLocalSession(tmuxName)
+			time.Sleep(300 * time.Millisecond)
 		}
-	}
-
-	// Send subscribed confirmation
-	encoder.Encode(APIEvent{Event: "subscribed", Session: strings.Join(sessions, ",")})
-
-	// Keep connection open and poll for state changes
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
 
-	lastStatus := make(map[string]string)
+		// Ensure work directory exists
+		if workDir != "" {
+			os.MkdirAll(workDir, 0755)
+		}
 
-	for {
-		select {
-		case <-ticker.C:
-			for _, sessionName := range sessions {
-				info, exists := cfg.Sessions[sessionName]
-				if !exists || info.Deleted {
-					continue
-				}
+		// Create new tmux session with -c (continue) flag
+		if err := backend().Create(tmuxName, workDir, true); err != nil {
+			encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to start: %v", err)})
+			return
+		}
 
-				tmuxName := tmuxSessionName(sessionName)
-				sshAddr := ""
-				if info.Host != "" {
-					sshAddr = getHostAddress(cfg, info.Host)
-				}
+		// Wait for Claude to initialize
+		time.Sleep(5 * time.Second)
 
-				var status string
-				if info.Host != "" && sshAddr != "" {
-					if sshTmuxHasSession(sshAddr, tmuxName) {
-						if checkClaudeState(tmuxName, sshAddr) == "busy" {
-							status = "active"
-						} else {
-							status = "idle"
-						}
-					} else {
-						status = "stopped"
-					}
-				} else if tmuxSessionExists(tmuxName) {
-					if checkClaudeState(tmuxName, "") == "busy" {
-						status = "active"
-					} else {
-						status = "idle"
-					}
-				} else {
-					status = "stopped"
-				}
+		if !isClaudeRunning(tmuxName, "") {
+			encoder.Encode(APIResponse{OK: false, Error: "session started but Claude failed to initialize"})
+			return
+		}
+	}
 
-				if lastStatus[sessionName] != status {
-					lastStatus[sessionName] = status
-					if err := encoder.Encode(APIEvent{Event: "status", Session: sessionName, Status: status}); err != nil {
-						return // Connection closed
-					}
-				}
-			}
+	// Notify Telegram
+	if info.TopicID > 0 {
+		agentLabel := req.From
+		if agentLabel == "" {
+			agentLabel = "api"
 		}
+		sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("🔄 [%s] Session continued", agentLabel))
 	}
-}
 
-// captureTmuxPane captures the last N lines from a tmux pane
-func captureTmuxPane(tmuxName string, sshAddress string, lines int) (string, error) {
-	linesArg := fmt.Sprintf("-%d", lines)
+	encoder.Encode(APIResponse{OK: true})
+}
 
-	if sshAddress != "" {
-		cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S %s", shellQuote(tmuxName), linesArg)
-		result, err := runSSH(sshAddress, cmd, 10*time.Second)
-		if err != nil {
-			return "", fmt.Errorf("failed to capture pane: %w", err)
-		}
-		return strings.TrimRight(result, "\n"), nil
+// handleHistoryCmd handles the "history" command
+func handleHistoryCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+		return
 	}
 
-	cmd := tmuxCmd("capture-pane", "-t", tmuxName, "-p", "-S", linesArg)
-	result, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to capture pane: %w", err)
+	info, exists := cfg.Sessions[req.Session]
+	if !exists || info.Deleted {
+		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+		return
 	}
-	return strings.TrimRight(string(result), "\n"), nil
-}
 
-// truncateRepeatingChars compresses runs of repeated characters (>10) to char(count) format
-func truncateRepeatingChars(s string) string {
-	if len(s) == 0 {
-		return s
+	messages, err := readHistory(info.TopicID, req.After, req.Limit, req.FromFilter)
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to read history: %v", err)})
+		return
 	}
 
-	var result strings.Builder
-	runes := []rune(s)
-	i := 0
+	encoder.Encode(APIResponse{OK: true, Messages: messages})
+}
 
-	for i < len(runes) {
-		char := runes[i]
-		count := 1
+// handleActivityCmd returns last message summary for all sessions, or for
+// those matching req.Selector
+func handleActivityCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	var activity []ActivityInfo
 
-		// Count consecutive occurrences
-		for i+count < len(runes) && runes[i+count] == char {
-			count++
+	for name, info := range cfg.Sessions {
+		if info.Deleted {
+			continue
+		}
+		if req.Selector != "" && !matchesSelector(name, info, req.Selector) {
+			continue
 		}
+		ai := ActivityInfo{Name: name, Tags: info.Tags}
 
-		if count > 10 {
-			// Truncate: keep 10 chars, show total count in brackets
-			for j := 0; j < 10; j++ {
-				result.WriteRune(char)
-			}
-			result.WriteString(fmt.Sprintf("(%d)", count))
-		} else {
-			// Keep as is
-			for j := 0; j < count; j++ {
-				result.WriteRune(char)
+		if info.TopicID > 0 {
+			if msg := readLastHistoryMessage(info.TopicID); msg != nil {
+				ai.LastMessageID = msg.ID
+				ai.LastMessageTs = msg.Timestamp
+				ai.LastFrom = msg.From
+				text := msg.Text
+				if text == "" && msg.Transcription != "" {
+					text = msg.Transcription
+				}
+				if text == "" && msg.Caption != "" {
+					text = msg.Caption
+				}
+				if len(text) > 100 {
+					text = text[:100] + "..."
+				}
+				ai.LastText = text
 			}
 		}
 
-		i += count
+		activity = append(activity, ai)
 	}
 
-	return result.String()
+	encoder.Encode(APIResponse{OK: true, Activity: activity})
 }
 
-// truncateRepeatingCharsInLines applies truncateRepeatingChars to each line
-func truncateRepeatingCharsInLines(s string) string {
-	lines := strings.Split(s, "\n")
-	for i, line := range lines {
-		lines[i] = truncateRepeatingChars(line)
+// readLastHistoryMessage reads the last line from the newest history JSONL file
+func readLastHistoryMessage(topicID int64) *HistoryMessage {
+	dir := getHistoryDir(topicID)
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(files) == 0 {
+		return nil
 	}
-	return strings.Join(lines, "\n")
-}
-
-// Config function wrappers - delegate to config package
-func getConfigPath() string                           { return config.Path() }
-func loadOrCreateConfig() (*Config, error)            { return config.LoadOrCreate() }
-func loadConfig() (*Config, error)                    { return config.Load() }
-func saveConfig(cfg *Config) error                    { return config.Save(cfg) }
-func getProjectsDir(cfg *Config) string               { return config.GetProjectsDir(cfg) }
-func resolveProjectPath(cfg *Config, name string) string { return config.ResolveProjectPath(cfg, name) }
-
-// Telegram API helpers
-
-const maxResponseSize = 10 * 1024 * 1024 // 10MB limit for HTTP response bodies
+	// Files are named YYYY-MM-DD-HH.jsonl, lexicographic sort = chronological
+	sort.Strings(files)
 
-// redactTokenError replaces the bot token in error messages with "***"
-func redactTokenError(err error, token string) error {
-	if err == nil || token == "" {
-		return err
+	// Read last non-empty line from newest file, fall back to older files
+	for i := len(files) - 1; i >= 0; i-- {
+		if msg := readLastLine(files[i]); msg != nil {
+			return msg
+		}
 	}
-	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), token, "***"))
+	return nil
 }
 
-// telegramGet performs an HTTP GET and redacts the bot token from any errors
-func telegramGet(token string, url string) (*http.Response, error) {
-	resp, err := http.Get(url)
+// readLastLine reads the last non-empty JSONL line from a file using tail seek
+func readLastLine(path string) *HistoryMessage {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, redactTokenError(err, token)
+		return nil
 	}
-	return resp, nil
-}
+	defer f.Close()
 
-// telegramClientGet performs an HTTP GET with a custom client and redacts the bot token
-func telegramClientGet(client *http.Client, token string, url string) (*http.Response, error) {
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, redactTokenError(err, token)
+	stat, err := f.Stat()
+	if err != nil || stat.Size() == 0 {
+		return nil
 	}
-	return resp, nil
-}
 
-func telegramAPI(config *Config, method string, params url.Values) (*TelegramResponse, error) {
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/%s", config.BotToken, method)
-	resp, err := http.PostForm(apiURL, params)
-	if err != nil {
-		return nil, redactTokenError(err, config.BotToken)
+	// Read up to last 8KB to find the last line
+	bufSize := int64(8192)
+	if stat.Size() < bufSize {
+		bufSize = stat.Size()
 	}
-	defer resp.Body.Close()
+	buf := make([]byte, bufSize)
+	f.ReadAt(buf, stat.Size()-bufSize)
 
-	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-	var result TelegramResponse
-	json.Unmarshal(body, &result)
-	return &result, nil
+	// Find last newline-terminated JSON line
+	lines := bytes.Split(buf, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := bytes.TrimSpace(lines[i])
+		if len(line) == 0 {
+			continue
+		}
+		var msg HistoryMessage
+		if json.Unmarshal(line, &msg) == nil && msg.ID > 0 {
+			return &msg
+		}
+	}
+	return nil
 }
 
-func sendMessage(config *Config, chatID int64, threadID int64, text string) error {
-	const maxLen = 4000
+// handleScreenshotCmd handles the "screenshot" command — returns raw tmux capture-pane
+func handleScreenshotCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+		return
+	}
 
-	// Split long messages
-	messages := splitMessage(text, maxLen)
+	info, exists := cfg.Sessions[req.Session]
+	if !exists || info.Deleted {
+		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+		return
+	}
 
-	for _, msg := range messages {
-		params := url.Values{
-			"chat_id": {fmt.Sprintf("%d", chatID)},
-			"text":    {msg},
-		}
-		if threadID > 0 {
-			params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
+	_, projectName := parseSessionTarget(req.Session)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+	var sshAddress string
+	if info.Host != "" {
+		sshAddress = getHostAddress(cfg, info.Host)
+		if sshAddress == "" {
+			encoder.Encode(APIResponse{OK: false, Error: "host not configured: " + info.Host})
+			return
 		}
+	}
 
-		result, err := telegramAPI(config, "sendMessage", params)
+	if req.Format == "image" {
+		data, _, err := screenshotPNG(tmuxName, sshAddress, req.Limit, nil)
 		if err != nil {
-			return err
-		}
-		if !result.OK {
-			return fmt.Errorf("telegram error: %s", result.Description)
+			encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("screenshot failed: %v", err)})
+			return
 		}
+		encoder.Encode(APIResponse{OK: true, Image: base64.StdEncoding.EncodeToString(data)})
+		return
+	}
 
-		// Small delay between messages to maintain order
-		if len(messages) > 1 {
-			time.Sleep(100 * time.Millisecond)
-		}
+	lines := req.Limit
+	if lines <= 0 {
+		lines = 50
 	}
-	return nil
-}
 
-// InlineKeyboardButton represents a Telegram inline keyboard button
-type InlineKeyboardButton struct {
-	Text         string `json:"text"`
-	CallbackData string `json:"callback_data"`
+	content, err := captureTmuxPane(tmuxName, sshAddress, lines)
+	if err != nil {
+		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("capture failed: %v", err)})
+		return
+	}
+
+	encoder.Encode(APIResponse{OK: true, Response: content})
 }
 
-func sendMessageWithKeyboard(config *Config, chatID int64, threadID int64, text string, buttons [][]InlineKeyboardButton) error {
-	keyboard := map[string]interface{}{
-		"inline_keyboard": buttons,
+// handleQuestionsCmd returns pending AskUserQuestion questions for a session.
+func handleQuestionsCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+		return
 	}
-	keyboardJSON, _ := json.Marshal(keyboard)
 
-	params := url.Values{
-		"chat_id":      {fmt.Sprintf("%d", chatID)},
-		"text":         {text},
-		"reply_markup": {string(keyboardJSON)},
-	}
-	if threadID > 0 {
-		params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
-	}
+	// Clean expired questions (older than 5 minutes)
+	cleanExpiredQuestions()
 
-	result, err := telegramAPI(config, "sendMessage", params)
-	if err != nil {
-		return err
-	}
-	if !result.OK {
-		return fmt.Errorf("telegram error: %s", result.Description)
+	val, exists := pendingQuestions.Load(req.Session)
+	if !exists {
+		encoder.Encode(APIResponse{OK: true}) // No pending questions
+		return
 	}
-	return nil
+
+	qs := val.(*PendingQuestionSet)
+	encoder.Encode(APIResponse{OK: true, Questions: qs})
 }
 
-func answerCallbackQuery(config *Config, callbackID string) {
-	params := url.Values{
-		"callback_query_id": {callbackID},
+// handleAnswerCmd answers a pending AskUserQuestion by sending keys to tmux.
+func handleAnswerCmd(encoder *json.Encoder, cfg *Config, req APIRequest) {
+	if req.Session == "" {
+		encoder.Encode(APIResponse{OK: false, Error: "session required"})
+		return
 	}
-	telegramAPI(config, "answerCallbackQuery", params)
-}
 
-func editMessageRemoveKeyboard(config *Config, chatID int64, messageID int, newText string) {
-	params := url.Values{
-		"chat_id":    {fmt.Sprintf("%d", chatID)},
-		"message_id": {fmt.Sprintf("%d", messageID)},
-		"text":       {newText},
+	val, exists := pendingQuestions.Load(req.Session)
+	if !exists {
+		encoder.Encode(APIResponse{OK: false, Error: "no pending questions for this session"})
+		return
 	}
-	telegramAPI(config, "editMessageText", params)
-}
 
-func sendTypingAction(config *Config, chatID int64, threadID int64) {
-	params := url.Values{
-		"chat_id": {fmt.Sprintf("%d", chatID)},
-		"action":  {"typing"},
+	qs := val.(*PendingQuestionSet)
+	if req.QuestionIndex < 0 || req.QuestionIndex >= len(qs.Questions) {
+		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("question_index out of range (0-%d)", len(qs.Questions)-1)})
+		return
 	}
-	if threadID > 0 {
-		params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
+
+	q := &qs.Questions[req.QuestionIndex]
+	if q.Answered {
+		encoder.Encode(APIResponse{OK: false, Error: "question already answered"})
+		return
 	}
-	telegramAPI(config, "sendChatAction", params)
-}
 
-// Continuous typing indicator management
-var (
-	typingCancelers = make(map[string]context.CancelFunc)
-	typingMu        sync.Mutex
-)
+	if req.OptionIndex < 0 || req.OptionIndex >= len(q.Options) {
+		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("option_index out of range (0-%d)", len(q.Options)-1)})
+		return
+	}
 
-// checkClaudeState checks if Claude is busy or idle in a tmux session
-// Returns: "busy", "idle", or "unknown"
-// sshAddress is empty for local sessions, or SSH address for remote sessions
-func checkClaudeState(tmuxName string, sshAddress string) string {
-	var content string
-	var err error
+	// Resolve session info for tmux
+	info, sessionExists := cfg.Sessions[req.Session]
+	if !sessionExists || info.Deleted {
+		encoder.Encode(APIResponse{OK: false, Error: "session not found"})
+		return
+	}
 
-	if sshAddress != "" {
-		// Remote session - use SSH
-		cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S -15", shellQuote(tmuxName))
-		content, err = runSSH(sshAddress, cmd, 5*time.Second)
+	_, projectName := parseSessionTarget(req.Session)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+	// Send tmux keys: Down × optionIndex, then Enter
+	var sendErr error
+	if info.Host != "" {
+		address := getHostAddress(cfg, info.Host)
+		for i := 0; i < req.OptionIndex; i++ {
+			cmd := fmt.Sprintf("tmux send-keys -t %s Down", shellQuote(tmuxName))
+			runSSH(address, cmd, 5*time.Second)
+			time.Sleep(50 * time.Millisecond)
+		}
+		cmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(tmuxName))
+		_, sendErr = runSSH(address, cmd, 5*time.Second)
 	} else {
-		// Local session
-		cmd := tmuxCmd("capture-pane", "-t", tmuxName, "-p", "-S", "-15")
-		var output []byte
-		output, err = cmd.Output()
-		content = string(output)
+		for i := 0; i < req.OptionIndex; i++ {
+			backend().SendKey(tmuxName, "Down")
+			time.Sleep(50 * time.Millisecond)
+		}
+		sendErr = backend().SendKey(tmuxName, "Enter")
 	}
 
-	if err != nil {
-		return "unknown"
+	if sendErr != nil {
+		encoder.Encode(APIResponse{OK: false, Error: fmt.Sprintf("failed to send keys: %v", sendErr)})
+		return
 	}
 
-	// Parse lines and find the prompt position
-	lines := strings.Split(content, "\n")
+	// Mark as answered
+	q.Answered = true
+	q.AnswerIndex = req.OptionIndex
 
-	// Find the last occurrence of the input prompt ❯
-	promptLineIdx := -1
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := strings.TrimSpace(lines[i])
-		if line == "❯" || line == "> " {
-			promptLineIdx = i
+	// Auto-submit if this was the last question
+	allAnswered := true
+	for _, qq := range qs.Questions {
+		if !qq.Answered {
+			allAnswered = false
 			break
 		}
 	}
-
-	// If no prompt found, state is unknown
-	if promptLineIdx == -1 {
-		return "unknown"
+	if allAnswered {
+		time.Sleep(300 * time.Millisecond)
+		if info.Host != "" {
+			address := getHostAddress(cfg, info.Host)
+			cmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(tmuxName))
+			runSSH(address, cmd, 5*time.Second)
+		} else {
+			backend().SendKey(tmuxName, "Enter")
+		}
+		// Remove from pending
+		pendingQuestions.Delete(req.Session)
+		clearQuestionsPending(info.TopicID)
+		logFor("api").Info("auto-submitted all answers", logging.SessionKey, req.Session)
 	}
 
-	// Check lines AFTER the prompt for activity indicators
-	// If "ctrl+c to interrupt" or spinners appear AFTER the prompt, Claude is busy
-	for i := promptLineIdx + 1; i < len(lines); i++ {
-		line := lines[i]
-		// Skip separator lines and status bar
-		if strings.HasPrefix(strings.TrimSpace(line), "─") || strings.Contains(line, "bypass permissions") {
-			continue
-		}
-		// Activity indicators after prompt mean busy
-		if strings.Contains(line, "ctrl+c to interrupt") {
-			return "busy"
-		}
-		if strings.Contains(line, "✽") || strings.Contains(line, "✻") {
-			return "busy"
+	// Store answer in history
+	optLabel := q.Options[req.OptionIndex].Label
+	appendHistoryDedup(qs.TopicID, "human", fmt.Sprintf("Selected: %s", optLabel))
+
+	encoder.Encode(APIResponse{OK: true, Response: fmt.Sprintf("answered question %d with option %d (%s)", req.QuestionIndex, req.OptionIndex, optLabel)})
+}
+
+// cleanExpiredQuestions removes pending questions older than 5 minutes.
+func cleanExpiredQuestions() {
+	cutoff := time.Now().Unix() - 300
+	pendingQuestions.Range(func(key, value interface{}) bool {
+		qs := value.(*PendingQuestionSet)
+		if qs.Timestamp < cutoff {
+			pendingQuestions.Delete(key)
 		}
-		if strings.Contains(line, "Running…") || strings.Contains(line, "Thinking…") {
-			return "busy"
+		return true
+	})
+}
+
+// handleSubscribeCmd handles the "subscribe" command
+func handleSubscribeCmd(conn net.Conn, encoder *json.Encoder, cfg *Config, req APIRequest) {
+	// For now, implement basic subscription that sends events for specified sessions
+	sessions := req.Sessions
+	if len(sessions) == 0 {
+		// Subscribe to all sessions
+		for name, info := range cfg.Sessions {
+			if !info.Deleted {
+				sessions = append(sessions, name)
+			}
 		}
 	}
 
-	// Check lines BEFORE the prompt - if recent activity indicator, might still be transitioning
-	// Look only at the 3 lines before prompt
-	startCheck := promptLineIdx - 3
-	if startCheck < 0 {
-		startCheck = 0
+	// Send subscribed confirmation
+	encoder.Encode(APIEvent{Event: "subscribed", Session: strings.Join(sessions, ",")})
+
+	// Keep connection open and poll for state changes
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	lastStatus := make(map[string]string)
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, sessionName := range sessions {
+				info, exists := cfg.Sessions[sessionName]
+				if !exists || info.Deleted {
+					continue
+				}
+
+				tmuxName := tmuxSessionName(sessionName)
+				sshAddr := ""
+				if info.Host != "" {
+					sshAddr = getHostAddress(cfg, info.Host)
+				}
+
+				var status string
+				if info.Host != "" && sshAddr != "" {
+					if sshTmuxHasSession(sshAddr, tmuxName) {
+						if checkClaudeState(tmuxName, sshAddr) == "busy" {
+							status = "active"
+						} else {
+							status = "idle"
+						}
+					} else {
+						status = "stopped"
+					}
+				} else if backend().Exists(tmuxName) {
+					if checkClaudeState(tmuxName, "") == "busy" {
+						status = "active"
+					} else {
+						status = "idle"
+					}
+				} else {
+					status = "stopped"
+				}
+
+				if lastStatus[sessionName] != status {
+					lastStatus[sessionName] = status
+					if err := encoder.Encode(APIEvent{Event: "status", Session: sessionName, Status: status}); err != nil {
+						return // Connection closed
+					}
+				}
+			}
+		}
 	}
-	for i := startCheck; i < promptLineIdx; i++ {
-		line := lines[i]
-		// If there's an active spinner line right before prompt, still processing
-		if strings.Contains(line, "ctrl+c to interrupt") {
-			// This is historical - Claude finished. Check if prompt is truly last
-			break
+}
+
+// captureTmuxPane captures the last N lines from a tmux pane
+func captureTmuxPane(tmuxName string, sshAddress string, lines int) (string, error) {
+	linesArg := fmt.Sprintf("-%d", lines)
+
+	if sshAddress != "" {
+		cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S %s", shellQuote(tmuxName), linesArg)
+		result, err := runSSH(sshAddress, cmd, 10*time.Second)
+		if err != nil {
+			return "", fmt.Errorf("failed to capture pane: %w", err)
 		}
+		return strings.TrimRight(result, "\n"), nil
 	}
 
-	// Prompt found and no activity after it - Claude is idle
-	return "idle"
+	result, err := backend().Capture(tmuxName, lines)
+	if err != nil {
+		return "", fmt.Errorf("failed to capture pane: %w", err)
+	}
+	return result, nil
 }
 
-// isClaudeRunning checks if Claude Code is running in a tmux session
-// Returns true if Claude UI elements are detected, false if it looks like plain bash
-func isClaudeRunning(tmuxName string, sshAddress string) bool {
+// screenshotPNG renders the pane, with its colors, as a PNG image. lines is
+// the number of scrollback lines above the visible screen to include.
+//
+// With a redactor, secrets are masked before rendering; the number masked is
+// returned.
+func screenshotPNG(tmuxName string, sshAddress string, lines int, r *redact.Redactor) ([]byte, int, error) {
 	var content string
 	var err error
-
 	if sshAddress != "" {
-		// Remote session - use SSH
-		cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S -30", shellQuote(tmuxName))
-		content, err = runSSH(sshAddress, cmd, 5*time.Second)
+		cmd := fmt.Sprintf("tmux capture-pane -e -t %s -p -S -%d", shellQuote(tmuxName), lines)
+		content, err = runSSH(sshAddress, cmd, 10*time.Second)
 	} else {
-		// Local session
-		cmd := tmuxCmd("capture-pane", "-t", tmuxName, "-p", "-S", "-30")
-		var output []byte
-		output, err = cmd.Output()
-		content = string(output)
+		content, err = backend().CaptureANSI(tmuxName, lines)
 	}
-
 	if err != nil {
-		return false
+		return nil, 0, fmt.Errorf("failed to capture pane: %w", err)
 	}
-
-	// Claude Code UI has distinctive elements:
-	// - Input prompt: ❯
-	// - Status bar: "bypass permissions" or "shift+tab to cycle"
-	// - Activity indicators: ●, ✽, ✻
-	// - Tool output markers: ⎿
-	// If none of these are present, Claude is probably not running
-
-	claudeIndicators := []string{
-		"❯",                    // Input prompt
-		"bypass permissions",   // Status bar
-		"shift+tab to cycle",   // Status bar variant
-		"ctrl+c to interrupt",  // Activity indicator
-		"●",                    // Tool marker
-		"✽",                    // Spinner
-		"✻",                    // Spinner variant
-		"⎿",                    // Tool output
+	masked := 0
+	if r != nil {
+		content, masked = r.Redact(content)
 	}
+	data, err := termimg.PNG(content, termimg.Options{})
+	return data, masked, err
+}
 
-	for _, indicator := range claudeIndicators {
-		if strings.Contains(content, indicator) {
-			return true
-		}
+// truncateRepeatingChars compresses runs of repeated characters (>10) to char(count) format
+func truncateRepeatingChars(s string) string {
+	if len(s) == 0 {
+		return s
 	}
 
-	return false
-}
+	var result strings.Builder
+	runes := []rune(s)
+	i := 0
 
-// restartClaudeInSession restarts Claude Code in an existing tmux session where it crashed
-// Returns true if restart was successful
-func restartClaudeInSession(tmuxName string, sshAddress string) bool {
-	restartCmd := cccPath + " run -c"
+	for i < len(runes) {
+		char := runes[i]
+		count := 1
 
-	if sshAddress != "" {
-		// Remote session - send command via SSH
-		cmd := fmt.Sprintf("tmux send-keys -t %s %s C-m", shellQuote(tmuxName), shellQuote(restartCmd))
-		_, err := runSSH(sshAddress, cmd, 10*time.Second)
-		if err != nil {
-			return false
+		// Count consecutive occurrences
+		for i+count < len(runes) && runes[i+count] == char {
+			count++
 		}
-	} else {
-		// Local session - send command directly
-		cmd := tmuxCmd("send-keys", "-t", tmuxName, restartCmd, "C-m")
-		if err := cmd.Run(); err != nil {
-			return false
+
+		if count > 10 {
+			// Truncate: keep 10 chars, show total count in brackets
+			for j := 0; j < 10; j++ {
+				result.WriteRune(char)
+			}
+			result.WriteString(fmt.Sprintf("(%d)", count))
+		} else {
+			// Keep as is
+			for j := 0; j < count; j++ {
+				result.WriteRune(char)
+			}
 		}
-	}
 
-	// Wait for Claude to start
-	time.Sleep(3 * time.Second)
+		i += count
+	}
 
-	// Verify Claude is now running
-	return isClaudeRunning(tmuxName, sshAddress)
+	return result.String()
 }
 
-// startContinuousTyping starts sending typing indicator every 4 seconds
-// until stopContinuousTyping is called or Claude becomes idle
-func startContinuousTyping(cfg *Config, chatID, threadID int64, sessionName string) {
-	fmt.Fprintf(os.Stderr, "[typing] START session=%s\n", sessionName)
-	typingMu.Lock()
-	// Cancel existing typing for this session
-	if cancel, ok := typingCancelers[sessionName]; ok {
-		cancel()
+// truncateRepeatingCharsInLines applies truncateRepeatingChars to each line
+func truncateRepeatingCharsInLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = truncateRepeatingChars(line)
 	}
+	return strings.Join(lines, "\n")
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute) // Max 10 min
-	typingCancelers[sessionName] = cancel
-	typingMu.Unlock()
+// Config function wrappers - delegate to config package
+func getConfigPath() string                           { return config.Path() }
+func loadOrCreateConfig() (*Config, error)            { return config.LoadOrCreate() }
+func loadConfig() (*Config, error)                    { return config.Load() }
+func saveConfig(cfg *Config) error                    { return config.Save(cfg) }
+func getProjectsDir(cfg *Config) string               { return config.GetProjectsDir(cfg) }
+func resolveProjectPath(cfg *Config, name string) string { return config.ResolveProjectPath(cfg, name) }
 
-	// Determine tmux session name and SSH address (if remote)
-	var tmuxName string
-	var sshAddress string
+// updateConfig applies fn to a freshly loaded config and saves it under the
+// config lock (see config.Update)
+func updateConfig(fn func(*Config) error) (*Config, error) {
+	return config.Update(fn)
+}
 
-	if idx := strings.Index(sessionName, ":"); idx != -1 {
-		// Remote session (host:name format)
-		hostName := sessionName[:idx]
-		projectName := sessionName[idx+1:]
-		tmuxName = tmuxSessionName(projectName)
-		sshAddress = getHostAddress(cfg, hostName)
-	} else {
-		// Local session
-		tmuxName = tmuxSessionName(sessionName)
-		sshAddress = ""
+// updateSession applies fn to session name in cfg and saves the change
+// through updateConfig, so the rest of cfg, which may be stale, isn't
+// written back over changes made meanwhile
+func updateSession(cfg *Config, name string, fn func(*SessionInfo)) error {
+	if info := cfg.Sessions[name]; info != nil {
+		fn(info)
 	}
+	_, err := updateConfig(func(fresh *Config) error {
+		if info := fresh.Sessions[name]; info != nil {
+			fn(info)
+		}
+		return nil
+	})
+	return err
+}
 
-	go func() {
-		typingTicker := time.NewTicker(4 * time.Second)
-		stateTicker := time.NewTicker(2 * time.Second)
-		defer typingTicker.Stop()
-		defer stateTicker.Stop()
+// addSession saves a new session through updateConfig and adds it to cfg
+func addSession(cfg *Config, name string, info *SessionInfo) error {
+	if cfg.Sessions == nil {
+		cfg.Sessions = make(map[string]*SessionInfo)
+	}
+	cfg.Sessions[name] = info
+	_, err := updateConfig(func(fresh *Config) error {
+		if fresh.Sessions == nil {
+			fresh.Sessions = make(map[string]*SessionInfo)
+		}
+		copied := *info
+		fresh.Sessions[name] = &copied
+		return nil
+	})
+	return err
+}
 
-		// Send initial typing
-		sendTypingAction(cfg, chatID, threadID)
+// Telegram API helpers
 
-		// Track consecutive idle checks to avoid false positives
-		idleCount := 0
+const maxResponseSize = 10 * 1024 * 1024 // 10MB limit for HTTP response bodies
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-stateTicker.C:
-				// Check Claude state
-				state := checkClaudeState(tmuxName, sshAddress)
-				if state == "idle" {
-					idleCount++
-					// Require 2 consecutive idle checks to confirm
-					if idleCount >= 2 {
-						fmt.Fprintf(os.Stderr, "[typing] %s: Claude idle, stopping typing indicator\n", sessionName)
-						stopContinuousTyping(sessionName)
-						return
-					}
-				} else if state == "busy" {
-					idleCount = 0
-				}
-				// On "unknown" state, don't reset counter (might be transient)
-			case <-typingTicker.C:
-				sendTypingAction(cfg, chatID, threadID)
-			}
-		}
-	}()
+// requestResult labels a Telegram call for metrics
+func requestResult(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "error"
 }
 
-// stopContinuousTyping stops the typing indicator for a session
-func stopContinuousTyping(sessionName string) {
-	typingMu.Lock()
-	defer typingMu.Unlock()
-	if cancel, ok := typingCancelers[sessionName]; ok {
-		cancel()
-		delete(typingCancelers, sessionName)
+// redactTokenError replaces the bot token in error messages with "***"
+func redactTokenError(err error, token string) error {
+	if err == nil || token == "" {
+		return err
 	}
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), token, "***"))
 }
 
-func splitMessage(text string, maxLen int) []string {
-	if len(text) <= maxLen {
-		return []string{text}
-	}
+// Secret redaction: text and files sent to Telegram are masked by
+// internal/redact at the last step (telegramPost, telegramUpload), so every
+// message, edit, caption and attachment is covered. A notice at the end of
+// the message says how many secrets were masked.
 
-	var messages []string
-	remaining := text
+var redactorCache struct {
+	sync.Mutex
+	key string
+	r   *redact.Redactor
+}
 
-	for len(remaining) > 0 {
-		if len(remaining) <= maxLen {
-			messages = append(messages, remaining)
-			break
+// redactorFor returns the redactor for cfg's settings, or nil when
+// redaction is disabled. Invalid patterns are logged and skipped.
+func redactorFor(cfg *Config) *redact.Redactor {
+	opts := redact.Options{}
+	if rc := cfg.Redaction; rc != nil {
+		if rc.Disabled {
+			return nil
 		}
+		opts = redact.Options{Patterns: rc.Patterns, Allow: rc.Allow, Entropy: rc.Entropy, MinLength: rc.MinLength}
+	}
 
-		// Find a good split point (newline or space)
-		splitAt := maxLen
-
-		// Try to split at a newline first
-		if idx := strings.LastIndex(remaining[:maxLen], "\n"); idx > maxLen/2 {
-			splitAt = idx + 1
-		} else if idx := strings.LastIndex(remaining[:maxLen], " "); idx > maxLen/2 {
-			// Fall back to space
-			splitAt = idx + 1
+	c := &redactorCache
+	c.Lock()
+	defer c.Unlock()
+	key := fmt.Sprintf("%#v", opts)
+	if c.r == nil || c.key != key {
+		r, err := redact.New(opts)
+		if err != nil {
+			logFor("redact").Warn("invalid redaction pattern", "error", err)
 		}
-
-		messages = append(messages, strings.TrimRight(remaining[:splitAt], " \n"))
-		remaining = remaining[splitAt:]
+		c.r, c.key = r, key
 	}
-
-	return messages
+	return c.r
 }
 
-// Download file from Telegram
-func downloadTelegramFile(config *Config, fileID string, destPath string) error {
-	// Get file path from Telegram
-	resp, err := telegramGet(config.BotToken, fmt.Sprintf("https://api.telegram.org/bot%s/getFile?file_id=%s", config.BotToken, fileID))
-	if err != nil {
-		return err
+// checkRedaction compiles the configured redaction patterns
+func checkRedaction(cfg *Config) error {
+	rc := cfg.Redaction
+	if rc == nil {
+		return nil
 	}
-	defer resp.Body.Close()
+	_, err := redact.New(redact.Options{Patterns: rc.Patterns, Allow: rc.Allow})
+	return err
+}
 
-	var result struct {
-		OK     bool `json:"ok"`
-		Result struct {
-			FilePath string `json:"file_path"`
-		} `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return err
+// redactText masks secrets in outbound text and appends the redaction notice
+func redactText(cfg *Config, text string) string {
+	r := redactorFor(cfg)
+	if r == nil || text == "" {
+		return text
 	}
-	if !result.OK {
-		return fmt.Errorf("failed to get file path")
+	text, n := r.Redact(text)
+	if n > 0 {
+		logFor("redact").Info("masked secrets in message", "count", n)
+		text += "\n\n" + redact.Notice(n)
 	}
+	return text
+}
 
-	// Download the file
-	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", config.BotToken, result.Result.FilePath)
-	fileResp, err := telegramGet(config.BotToken, fileURL)
+// telegramGet performs an HTTP GET and redacts the bot token from any errors
+func telegramGet(token string, url string) (*http.Response, error) {
+	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return nil, redactTokenError(err, token)
 	}
-	defer fileResp.Body.Close()
+	return resp, nil
+}
 
-	out, err := os.Create(destPath)
+// telegramClientGet performs an HTTP GET with a custom client and redacts the bot token
+func telegramClientGet(client *http.Client, token string, url string) (*http.Response, error) {
+	resp, err := client.Get(url)
 	if err != nil {
-		return err
+		return nil, redactTokenError(err, token)
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, fileResp.Body)
-	return err
+	return resp, nil
 }
 
-// Transcribe audio file using configured command or fallback to whisper
-func transcribeAudio(config *Config, audioPath string) (string, error) {
-	// Use configured transcription command if set
-	if config.TranscriptionCmd != "" {
-		cmdPath := expandPath(config.TranscriptionCmd)
-		cmd := exec.Command(cmdPath, audioPath)
-		output, err := cmd.Output()
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				return "", fmt.Errorf("%s: %s", err, string(exitErr.Stderr))
-			}
-			return "", err
-		}
-		return strings.TrimSpace(string(output)), nil
-	}
+// outbox rate-limits and orders all outbound Telegram calls of this process.
+// Hooks run as separate processes with their own outbox; together with the
+// listener they can exceed Telegram's limits and then rely on 429 retries.
+var outbox = dispatch.New(dispatch.Options{})
 
-	// Fallback: try to find whisper in PATH or known locations
-	whisperPath := "whisper"
-	if _, err := exec.LookPath("whisper"); err != nil {
-		// Try common locations
-		for _, p := range []string{"/opt/homebrew/bin/whisper", "/usr/local/bin/whisper"} {
-			if _, err := os.Stat(p); err == nil {
-				whisperPath = p
-				break
-			}
-		}
+// unthrottledMethods bypass the outbox (no chat target, or not counted by
+// Telegram's per-chat message limits)
+var unthrottledMethods = map[string]bool{
+	"answerCallbackQuery": true,
+	"sendChatAction":      true,
+}
+
+// telegramAPI calls a Bot API method. Calls that target a chat go through the
+// outbox: they wait for rate limits, are retried on 429/network errors and
+// stay in order per topic.
+func telegramAPI(config *Config, method string, params url.Values) (*TelegramResponse, error) {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	if chatID == 0 || unthrottledMethods[method] {
+		return telegramPost(config, method, params)
 	}
+	threadID, _ := strconv.ParseInt(params.Get("message_thread_id"), 10, 64)
 
-	cmd := exec.Command(whisperPath, audioPath, "--model", "small", "--output_format", "txt", "--output_dir", filepath.Dir(audioPath))
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("whisper failed: %w (set transcription_cmd in config for custom transcription)", err)
+	var result *TelegramResponse
+	err := outbox.Do(chatID, threadID, func() dispatch.Outcome {
+		r, err := telegramPost(config, method, params)
+		result = r
+		return telegramOutcome(r, err)
+	})
+	if result != nil {
+		return result, nil
 	}
+	return nil, err
+}
 
-	// Read the transcription
-	txtPath := strings.TrimSuffix(audioPath, filepath.Ext(audioPath)) + ".txt"
-	content, err := os.ReadFile(txtPath)
+// telegramOutcome classifies a Bot API response for the outbox
+func telegramOutcome(r *TelegramResponse, err error) dispatch.Outcome {
 	if err != nil {
-		return "", err
+		return dispatch.Outcome{Err: err, Temporary: true}
 	}
-
-	// Cleanup
-	os.Remove(txtPath)
-
-	return strings.TrimSpace(string(content)), nil
+	if !r.OK && r.Parameters != nil && r.Parameters.RetryAfter > 0 {
+		return dispatch.Outcome{RetryAfter: time.Duration(r.Parameters.RetryAfter) * time.Second}
+	}
+	if !r.OK && r.ErrorCode >= 500 {
+		return dispatch.Outcome{Err: fmt.Errorf("telegram error: %s", r.Description), Temporary: true}
+	}
+	return dispatch.Outcome{}
 }
 
-// expandPath expands ~ to home directory
-func expandPath(path string) string { return config.ExpandPath(path) }
-
-// SSH utilities for remote host operations
-
-const (
-	sshConnectTimeout = 5  // seconds
-	sshCommandTimeout = 10 // seconds
-)
-
-// runSSH executes a command on a remote host via SSH
-func runSSH(address string, command string, timeout time.Duration) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	// Wrap command in interactive login shell for full environment (nvm, etc.)
-	wrappedCmd := fmt.Sprintf("bash -i -l -c %s", shellQuote(command))
-
-	cmd := exec.CommandContext(ctx, "ssh",
-		"-o", "BatchMode=yes",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout),
-		address,
-		wrappedCmd,
-	)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
-		return "", fmt.Errorf("timeout after %v", timeout)
+func telegramPost(config *Config, method string, params url.Values) (*TelegramResponse, error) {
+	for _, field := range []string{"text", "caption"} {
+		if params.Has(field) {
+			params.Set(field, redactText(config, params.Get(field)))
+		}
 	}
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/%s", config.BotToken, method)
+	resp, err := http.PostForm(apiURL, params)
 	if err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return "", fmt.Errorf("%s: %s", err, errMsg)
-		}
-		return "", err
+		telegramRequestsTotal.Inc(method, "error")
+		err = redactTokenError(err, config.BotToken)
+		logFor("telegram").Warn("request failed", "method", method, "error", err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	return strings.TrimSpace(stdout.String()), nil
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	var result TelegramResponse
+	json.Unmarshal(body, &result)
+	telegramRequestsTotal.Inc(method, requestResult(result.OK))
+	if !result.OK {
+		logFor("telegram").Warn("request failed", "method", method, "error", result.Description)
+	}
+	return &result, nil
 }
 
-// scpToHost copies a file to a remote host via scp
-func scpToHost(address string, localPath string, remotePath string, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func sendMessage(config *Config, chatID int64, threadID int64, text string) error {
+	const maxLen = 4000
 
-	cmd := exec.CommandContext(ctx, "scp",
-		"-o", "BatchMode=yes",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout),
-		localPath,
-		address+":"+remotePath,
-	)
+	// Split long messages
+	messages := splitMessage(text, maxLen)
 
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	for _, msg := range messages {
+		params := url.Values{
+			"chat_id": {fmt.Sprintf("%d", chatID)},
+			"text":    {msg},
+		}
+		if threadID > 0 {
+			params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
+		}
 
-	err := cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
-		return fmt.Errorf("timeout after %v", timeout)
-	}
-	if err != nil {
-		errMsg := strings.TrimSpace(stderr.String())
-		if errMsg != "" {
-			return fmt.Errorf("%s: %s", err, errMsg)
+		result, err := telegramAPI(config, "sendMessage", params)
+		if err != nil {
+			return err
+		}
+		if !result.OK {
+			return fmt.Errorf("telegram error: %s", result.Description)
 		}
-		return err
-	}
 
+		// Small delay between messages to maintain order
+		if len(messages) > 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
 	return nil
 }
 
-// shellQuote quotes a string for safe shell usage
-func shellQuote(s string) string {
-	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
+// InlineKeyboardButton represents a Telegram inline keyboard button
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
 }
 
-// sshCheckConnection verifies SSH connection to a host
-func sshCheckConnection(address string) error {
-	_, err := runSSH(address, "echo ok", time.Duration(sshConnectTimeout+2)*time.Second)
+func sendMessageWithKeyboard(config *Config, chatID int64, threadID int64, text string, buttons [][]InlineKeyboardButton) error {
+	_, err := sendMessageWithMarkup(config, chatID, threadID, text, map[string]interface{}{
+		"inline_keyboard": buttons,
+	})
 	return err
 }
 
-// sshCheckCommand checks if a command exists on remote host, returns path
-func sshCheckCommand(address string, cmdName string) (string, error) {
-	return runSSH(address, "which "+cmdName, time.Duration(sshCommandTimeout)*time.Second)
-}
+// sendMessageWithMarkup sends a message with a reply markup (inline keyboard,
+// force reply…) and returns its message ID
+func sendMessageWithMarkup(config *Config, chatID int64, threadID int64, text string, markup interface{}) (int, error) {
+	markupJSON, _ := json.Marshal(markup)
 
-// sshResolvePath resolves a path on remote host (expands ~, gets absolute path)
-func sshResolvePath(address string, path string) (string, error) {
-	// Use eval to expand ~ and readlink to get absolute path
-	cmd := fmt.Sprintf("eval echo %s | xargs readlink -f 2>/dev/null || eval echo %s", path, path)
-	return runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
+	params := url.Values{
+		"chat_id":      {fmt.Sprintf("%d", chatID)},
+		"text":         {text},
+		"reply_markup": {string(markupJSON)},
+	}
+	if threadID > 0 {
+		params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
+	}
+
+	result, err := telegramAPI(config, "sendMessage", params)
+	if err != nil {
+		return 0, err
+	}
+	if !result.OK {
+		return 0, fmt.Errorf("telegram error: %s", result.Description)
+	}
+	var sent TelegramMessage
+	if err := json.Unmarshal(result.Result, &sent); err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
 }
 
-// sshMkdir creates a directory on remote host
-func sshMkdir(address string, path string) error {
-	// Use eval to expand ~ in path
-	cmd := fmt.Sprintf("mkdir -p \"$(eval echo %s)\"", shellQuote(path))
-	_, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
-	return err
-}
-
-// sshDirExists checks if a directory exists on remote host
-func sshDirExists(address string, path string) bool {
-	// Use eval to expand ~ in path
-	cmd := fmt.Sprintf("test -d \"$(eval echo %s)\"", shellQuote(path))
-	_, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
-	return err == nil
+func answerCallbackQuery(config *Config, callbackID string) {
+	params := url.Values{
+		"callback_query_id": {callbackID},
+	}
+	telegramAPI(config, "answerCallbackQuery", params)
 }
 
-// sshTmuxHasSession checks if a tmux session exists on remote host
-func sshTmuxHasSession(address string, sessionName string) bool {
-	_, err := runSSH(address, "tmux has-session -t "+shellQuote(sessionName), time.Duration(sshCommandTimeout)*time.Second)
-	return err == nil
+func editMessageRemoveKeyboard(config *Config, chatID int64, messageID int, newText string) {
+	params := url.Values{
+		"chat_id":    {fmt.Sprintf("%d", chatID)},
+		"message_id": {fmt.Sprintf("%d", messageID)},
+		"text":       {newText},
+	}
+	telegramAPI(config, "editMessageText", params)
 }
 
-// sshTmuxNewSession creates a new tmux session on remote host
-func sshTmuxNewSession(address string, name string, workDir string, continueSession bool) error {
-	// Create session
-	cmd := fmt.Sprintf("tmux new-session -d -s %s -c %s", shellQuote(name), shellQuote(workDir))
-	if _, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
-		return fmt.Errorf("failed to create tmux session: %w", err)
+// sendMessageGetID sends a single message and returns its message ID
+// (used for messages that are later edited in place)
+func sendMessageGetID(config *Config, chatID int64, threadID int64, text string) (int, error) {
+	params := url.Values{
+		"chat_id": {fmt.Sprintf("%d", chatID)},
+		"text":    {text},
+	}
+	if threadID > 0 {
+		params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
 	}
 
-	// Enable mouse mode
-	runSSH(address, fmt.Sprintf("tmux set-option -t %s mouse on", shellQuote(name)), time.Duration(sshCommandTimeout)*time.Second)
-
-	// Start claude
-	claudeCmd := "claude --dangerously-skip-permissions"
-	if continueSession {
-		claudeCmd += " -c"
+	result, err := telegramAPI(config, "sendMessage", params)
+	if err != nil {
+		return 0, err
 	}
-	sendCmd := fmt.Sprintf("tmux send-keys -t %s %s C-m", shellQuote(name), shellQuote(claudeCmd))
-	if _, err := runSSH(address, sendCmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
-		return err
+	if !result.OK {
+		return 0, fmt.Errorf("telegram error: %s", result.Description)
 	}
-
-	// Confirm bypass permissions prompt (first run only)
-	// Default is "No, exit" so we need Down arrow to select "Yes", then Enter
-	// Down is safer than Up - if no prompt exists, Down does nothing
-	time.Sleep(2 * time.Second)
-	// Send Down arrow first
-	downCmd := fmt.Sprintf("tmux send-keys -t %s Down", shellQuote(name))
-	runSSH(address, downCmd, time.Duration(sshCommandTimeout)*time.Second)
-	time.Sleep(100 * time.Millisecond)
-	// Then send Enter
-	enterCmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(name))
-	runSSH(address, enterCmd, time.Duration(sshCommandTimeout)*time.Second)
-
-	return nil
-}
-
-// sshTmuxSendKeys sends text to a tmux session on remote host using Base64
-func sshTmuxSendKeys(address string, sessionName string, text string) error {
-	// Encode text as Base64 to avoid escaping issues
-	encoded := base64.StdEncoding.EncodeToString([]byte(text))
-
-	// Decode on remote and send to tmux
-	cmd := fmt.Sprintf(
-		"echo %s | base64 -d | xargs -0 tmux send-keys -t %s -l",
-		encoded, shellQuote(sessionName),
-	)
-	if _, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
-		return err
+	var sent TelegramMessage
+	if err := json.Unmarshal(result.Result, &sent); err != nil {
+		return 0, err
 	}
-
-	// Always wait 2 seconds before Enter to ensure text is fully processed
-	// Without this delay, Enter may be interpreted as newline instead of submit
-	time.Sleep(2 * time.Second)
-
-	// Send Enter twice (Claude needs double Enter)
-	enterCmd := fmt.Sprintf(
-		"tmux send-keys -t %s C-m && sleep 0.05 && tmux send-keys -t %s C-m",
-		shellQuote(sessionName), shellQuote(sessionName),
-	)
-	_, err := runSSH(address, enterCmd, time.Duration(sshCommandTimeout)*time.Second)
-	return err
+	return sent.MessageID, nil
 }
 
-// sshTmuxKillSession kills a tmux session on remote host
-func sshTmuxKillSession(address string, sessionName string) error {
-	_, err := runSSH(address, "tmux kill-session -t "+shellQuote(sessionName), time.Duration(sshCommandTimeout)*time.Second)
-	return err
+// editMessageText replaces the text of an existing message.
+// Editing with identical text is not treated as an error.
+func editMessageText(config *Config, chatID int64, messageID int, text string) error {
+	return editMessage(config, chatID, messageID, text, "")
 }
 
-// sshRunCommand executes an arbitrary command on remote host (for /rc)
-func sshRunCommand(address string, command string, timeout time.Duration) (string, error) {
-	return runSSH(address, command, timeout)
+// editMessageHTML replaces a message with Telegram HTML, falling back to
+// plain text if Telegram can't parse the markup
+func editMessageHTML(config *Config, chatID int64, messageID int, text string) error {
+	err := editMessage(config, chatID, messageID, text, "HTML")
+	if err != nil && strings.Contains(err.Error(), "can't parse entities") {
+		return editMessage(config, chatID, messageID, markdown.StripTags(text), "")
+	}
+	return err
 }
 
-// Session name parsing utilities
-
-// parseSessionTarget parses "host:name" or "name" format
-// Returns (host, name) where host is empty for local sessions
-func parseSessionTarget(input string) (host string, name string) {
-	// Check for host:name format
-	// But be careful: ~/path and /path are not host prefixes
-	if strings.HasPrefix(input, "~/") || strings.HasPrefix(input, "/") {
-		return "", input
+func editMessage(config *Config, chatID int64, messageID int, text string, parseMode string) error {
+	params := url.Values{
+		"chat_id":    {fmt.Sprintf("%d", chatID)},
+		"message_id": {fmt.Sprintf("%d", messageID)},
+		"text":       {text},
 	}
-
-	idx := strings.Index(input, ":")
-	if idx > 0 {
-		host = input[:idx]
-		name = input[idx+1:]
-		return host, name
+	if parseMode != "" {
+		params.Set("parse_mode", parseMode)
 	}
-
-	return "", input
-}
-
-// fullSessionName creates full session name from host and name
-func fullSessionName(host string, name string) string {
-	if host == "" {
-		return name
+	result, err := telegramAPI(config, "editMessageText", params)
+	if err != nil {
+		return err
 	}
-	return host + ":" + name
+	if !result.OK && !strings.Contains(result.Description, "message is not modified") {
+		return fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return nil
 }
 
-// getHostAddress returns SSH address for a host, or empty if local/not found
-func getHostAddress(cfg *Config, hostName string) string { return config.GetHostAddress(cfg, hostName) }
-
-// getHostProjectsDir returns projects dir for a host
-func getHostProjectsDir(cfg *Config, hostName string) string { return config.GetHostProjectsDir(cfg, hostName) }
-
-// resolveSessionPath resolves project path for a session
-// For local: uses config.ProjectsDir
-// For remote: uses host's projects_dir and resolves via SSH
-func resolveSessionPath(config *Config, hostName string, nameOrPath string) (string, error) {
-	// Check if it's already an absolute or home-relative path
-	if strings.HasPrefix(nameOrPath, "/") || strings.HasPrefix(nameOrPath, "~/") {
-		if hostName == "" {
-			// Local: expand ~ and return
-			return expandPath(nameOrPath), nil
+// sendHTMLMessage sends Telegram HTML, splitting it without breaking tags or
+// entities. Chunks Telegram refuses to parse are resent as plain text.
+func sendHTMLMessage(config *Config, chatID int64, threadID int64, text string) error {
+	chunks := markdown.Split(text, 4000)
+	for i, chunk := range chunks {
+		params := url.Values{
+			"chat_id":    {fmt.Sprintf("%d", chatID)},
+			"text":       {chunk},
+			"parse_mode": {"HTML"},
 		}
-		// Remote: resolve via SSH
-		address := getHostAddress(config, hostName)
-		if address == "" {
-			return "", fmt.Errorf("host '%s' not found", hostName)
+		if threadID > 0 {
+			params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
 		}
-		return sshResolvePath(address, nameOrPath)
-	}
 
-	// Relative name - use projects_dir
-	projectsDir := getHostProjectsDir(config, hostName)
-	fullPath := filepath.Join(projectsDir, nameOrPath)
+		result, err := telegramAPI(config, "sendMessage", params)
+		if err != nil {
+			return err
+		}
+		if !result.OK {
+			if !strings.Contains(result.Description, "can't parse entities") {
+				return fmt.Errorf("telegram error: %s", result.Description)
+			}
+			if err := sendMessage(config, chatID, threadID, markdown.StripTags(chunk)); err != nil {
+				return err
+			}
+		}
 
-	if hostName == "" {
-		// Local
-		return expandPath(fullPath), nil
+		// Small delay between messages to maintain order
+		if i < len(chunks)-1 {
+			time.Sleep(100 * time.Millisecond)
+		}
 	}
+	return nil
+}
 
-	// Remote: resolve via SSH
-	address := getHostAddress(config, hostName)
-	if address == "" {
-		return "", fmt.Errorf("host '%s' not found", hostName)
+// formatClaudeMessage converts Claude's Markdown into Telegram HTML with an
+// optional plain-text header (e.g. "✅ session"). When code_file_lines is set,
+// long code blocks are cut out and returned separately to be sent as files.
+// Secrets are masked in the Markdown, where patterns see the text as written
+// rather than split by tags.
+func formatClaudeMessage(config *Config, header string, body string) (string, []markdown.CodeBlock) {
+	body = redactText(config, body)
+	body, blocks := markdown.ExtractCodeBlocks(body, config.CodeFileLines)
+	text := markdown.ToHTML(body)
+	if header != "" {
+		text = html.EscapeString(header) + "\n\n" + text
 	}
-	return sshResolvePath(address, fullPath)
+	return text, blocks
 }
 
-// extractProjectName extracts project name from path
-func extractProjectName(path string) string {
-	return filepath.Base(path)
+// sendClaudeMessage formats and sends Claude output to a chat or topic
+func sendClaudeMessage(config *Config, chatID int64, threadID int64, header string, body string) error {
+	text, blocks := formatClaudeMessage(config, header, body)
+	if err := sendHTMLMessage(config, chatID, threadID, text); err != nil {
+		return err
+	}
+	return sendCodeBlocks(config, chatID, threadID, blocks)
 }
 
-// tmuxSessionName returns a safe tmux session name for a project
-// Replaces dots with underscores because tmux 3.5+ interprets dots as window/pane separators
-func tmuxSessionName(name string) string {
-	safeName := strings.ReplaceAll(name, ".", "_")
-	return "claude-" + safeName
+// sendCodeBlocks attaches extracted code blocks as documents
+func sendCodeBlocks(config *Config, chatID int64, threadID int64, blocks []markdown.CodeBlock) error {
+	for i, block := range blocks {
+		name := markdown.FileName(block, i+1)
+		if err := sendDocument(config, chatID, threadID, name, []byte(block.Code), ""); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func createForumTopic(config *Config, name string) (int64, error) {
-	if config.GroupID == 0 {
-		return 0, fmt.Errorf("no group configured. Add bot to a group with topics enabled and run: ccc setgroup")
+// sendDocument uploads data as a file attachment
+func sendDocument(config *Config, chatID int64, threadID int64, filename string, data []byte, caption string) error {
+	fields := map[string]string{"chat_id": fmt.Sprintf("%d", chatID)}
+	if threadID > 0 {
+		fields["message_thread_id"] = fmt.Sprintf("%d", threadID)
 	}
-
-	params := url.Values{
-		"chat_id": {fmt.Sprintf("%d", config.GroupID)},
-		"name":    {name},
+	if caption != "" {
+		fields["caption"] = caption
 	}
 
-	result, err := telegramAPI(config, "createForumTopic", params)
-	if err != nil {
-		return 0, err
+	var result *TelegramResponse
+	err := outbox.Do(chatID, threadID, func() dispatch.Outcome {
+		r, err := telegramUpload(config, "sendDocument", fields, "document", filename, data)
+		result = r
+		return telegramOutcome(r, err)
+	})
+	if result == nil {
+		return err
 	}
 	if !result.OK {
-		return 0, fmt.Errorf("failed to create topic: %s", result.Description)
-	}
-
-	var topic TopicResult
-	if err := json.Unmarshal(result.Result, &topic); err != nil {
-		return 0, fmt.Errorf("failed to parse topic result: %w", err)
+		return fmt.Errorf("telegram error: %s", result.Description)
 	}
-
-	return topic.MessageThreadID, nil
+	return nil
 }
 
-// editForumTopic renames a topic and verifies it exists
-func editForumTopic(config *Config, topicID int64, name string) error {
-	if config.GroupID == 0 {
-		return fmt.Errorf("no group configured")
-	}
-
-	params := url.Values{
-		"chat_id":           {fmt.Sprintf("%d", config.GroupID)},
-		"message_thread_id": {fmt.Sprintf("%d", topicID)},
-		"name":              {name},
+// sendVoice uploads OGG/Opus audio as a voice message
+func sendVoice(config *Config, chatID int64, threadID int64, data []byte) error {
+	fields := map[string]string{"chat_id": fmt.Sprintf("%d", chatID)}
+	if threadID > 0 {
+		fields["message_thread_id"] = fmt.Sprintf("%d", threadID)
 	}
 
-	result, err := telegramAPI(config, "editForumTopic", params)
-	if err != nil {
+	var result *TelegramResponse
+	err := outbox.Do(chatID, threadID, func() dispatch.Outcome {
+		r, err := telegramUpload(config, "sendVoice", fields, "voice", "reply.ogg", data)
+		result = r
+		return telegramOutcome(r, err)
+	})
+	if result == nil {
 		return err
 	}
 	if !result.OK {
-		return fmt.Errorf("failed to edit topic: %s", result.Description)
+		return fmt.Errorf("telegram error: %s", result.Description)
 	}
-
 	return nil
 }
 
-// deleteForumTopic deletes a topic
-func deleteForumTopic(config *Config, topicID int64) error {
-	if config.GroupID == 0 {
-		return fmt.Errorf("no group configured")
+// sendPhoto uploads a PNG image as a photo, falling back to a file when
+// Telegram rejects it (e.g. for extreme dimensions)
+func sendPhoto(config *Config, chatID int64, threadID int64, filename string, data []byte, caption string) error {
+	fields := map[string]string{"chat_id": fmt.Sprintf("%d", chatID)}
+	if threadID > 0 {
+		fields["message_thread_id"] = fmt.Sprintf("%d", threadID)
 	}
-
-	params := url.Values{
-		"chat_id":           {fmt.Sprintf("%d", config.GroupID)},
-		"message_thread_id": {fmt.Sprintf("%d", topicID)},
+	if caption != "" {
+		fields["caption"] = caption
 	}
 
-	result, err := telegramAPI(config, "deleteForumTopic", params)
-	if err != nil {
+	var result *TelegramResponse
+	err := outbox.Do(chatID, threadID, func() dispatch.Outcome {
+		r, err := telegramUpload(config, "sendPhoto", fields, "photo", filename, data)
+		result = r
+		return telegramOutcome(r, err)
+	})
+	if result == nil {
 		return err
 	}
 	if !result.OK {
-		return fmt.Errorf("failed to delete topic: %s", result.Description)
+		if strings.Contains(result.Description, "PHOTO") {
+			return sendDocument(config, chatID, threadID, filename, data, caption)
+		}
+		return fmt.Errorf("telegram error: %s", result.Description)
 	}
-
 	return nil
 }
 
-// getOrCreateTopic finds existing topic or creates new one
-// Also syncs topic name and updates path if changed
-func getOrCreateTopic(config *Config, fullName string, path string, host string) (int64, error) {
-	// Check if session exists in config (including deleted)
-	if info, exists := config.Sessions[fullName]; exists {
-		// Try to rename topic to verify it exists and sync name
-		err := editForumTopic(config, info.TopicID, fullName)
-		if err != nil {
-			errStr := err.Error()
-			// Check if error indicates topic doesn't exist vs just "not modified"
-			if strings.Contains(errStr, "not found") || strings.Contains(errStr, "TOPIC_CLOSED") ||
-				strings.Contains(errStr, "TOPIC_DELETED") || strings.Contains(errStr, "invalid") {
-				// Topic was deleted by user, create new one
-				fmt.Fprintf(os.Stderr, "Topic %d gone, creating new: %v\n", info.TopicID, err)
-				topicID, err := createForumTopic(config, fullName)
-				if err != nil {
-					return 0, err
-				}
-				info.TopicID = topicID
-			}
-			// Otherwise (e.g., "not modified"), topic exists - just continue
+// telegramUpload calls a Bot API method with a multipart file upload
+func telegramUpload(config *Config, method string, fields map[string]string, fileField string, filename string, data []byte) (*TelegramResponse, error) {
+	if r := redactorFor(config); r != nil {
+		// Copy fields: the outbox may call again with the same map
+		caption := redactText(config, fields["caption"])
+		var n int
+		if data, n = r.RedactBytes(data); n > 0 {
+			logFor("redact").Info("masked secrets in file", "file", filename, "count", n)
+			caption = strings.TrimSpace(caption + "\n\n" + redact.Notice(n))
 		}
-		// Update path and undelete
-		info.Path = path
-		info.Deleted = false
-		saveConfig(config)
-		return info.TopicID, nil
+		withCaption := map[string]string{}
+		for k, v := range fields {
+			withCaption[k] = v
+		}
+		if caption != "" {
+			withCaption["caption"] = caption
+		}
+		fields = withCaption
 	}
 
-	// Create new topic
-	topicID, err := createForumTopic(config, fullName)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	part, err := w.CreateFormFile(fileField, filename)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	part.Write(data)
+	w.Close()
 
-	// Save to config
-	config.Sessions[fullName] = &SessionInfo{
-		TopicID: topicID,
-		Path:    path,
-		Host:    host,
-		Deleted: false,
+	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/%s", config.BotToken, method)
+	resp, err := http.Post(apiURL, w.FormDataContentType(), &body)
+	if err != nil {
+		telegramRequestsTotal.Inc(method, "error")
+		return nil, redactTokenError(err, config.BotToken)
 	}
-	saveConfig(config)
+	defer resp.Body.Close()
 
-	return topicID, nil
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	var result TelegramResponse
+	json.Unmarshal(respBody, &result)
+	telegramRequestsTotal.Inc(method, requestResult(result.OK))
+	if !result.OK {
+		logFor("telegram").Warn("upload failed", "method", method, "error", result.Description)
+	}
+	return &result, nil
 }
 
-// Tmux session management
+// pinChatMessage pins a message without notifying members
+func pinChatMessage(config *Config, chatID int64, messageID int) error {
+	params := url.Values{
+		"chat_id":              {fmt.Sprintf("%d", chatID)},
+		"message_id":           {fmt.Sprintf("%d", messageID)},
+		"disable_notification": {"true"},
+	}
+	result, err := telegramAPI(config, "pinChatMessage", params)
+	if err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram error: %s", result.Description)
+	}
+	return nil
+}
+
+func sendTypingAction(config *Config, chatID int64, threadID int64) {
+	params := url.Values{
+		"chat_id": {fmt.Sprintf("%d", chatID)},
+		"action":  {"typing"},
+	}
+	if threadID > 0 {
+		params.Set("message_thread_id", fmt.Sprintf("%d", threadID))
+	}
+	telegramAPI(config, "sendChatAction", params)
+}
 
+// Continuous typing indicator management
 var (
-	tmuxSocket string
-	tmuxPath   string
-	cccPath    string
-	claudePath string
+	typingCancelers = make(map[string]context.CancelFunc)
+	typingMu        sync.Mutex
 )
 
-func init() {
-	// Find tmux socket path using current UID
-	// macOS uses /private/tmp, Linux uses /tmp
-	uid := os.Getuid()
-	macOSSocket := fmt.Sprintf("/private/tmp/tmux-%d/default", uid)
-	linuxSocket := fmt.Sprintf("/tmp/tmux-%d/default", uid)
+// checkClaudeState checks if Claude is busy or idle in a tmux session
+// Returns: "busy", "idle", or "unknown"
+// sshAddress is empty for local sessions, or SSH address for remote sessions
+func checkClaudeState(tmuxName string, sshAddress string) string {
+	var content string
+	var err error
 
-	// Check which socket exists, prefer Linux path first (more common in headless)
-	if _, err := os.Stat(linuxSocket); err == nil {
-		tmuxSocket = linuxSocket
-	} else if _, err := os.Stat(macOSSocket); err == nil {
-		tmuxSocket = macOSSocket
+	if sshAddress != "" {
+		// Remote session - use SSH
+		cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S -15", shellQuote(tmuxName))
+		content, err = runSSH(sshAddress, cmd, 5*time.Second)
 	} else {
-		// Default based on OS
-		if _, err := os.Stat("/private"); err == nil {
-			tmuxSocket = macOSSocket
-		} else {
-			tmuxSocket = linuxSocket
-		}
+		// Local session
+		content, err = backend().Capture(tmuxName, 15)
 	}
 
-	// Find tmux binary
-	if path, err := exec.LookPath("tmux"); err == nil {
-		tmuxPath = path
-	} else {
-		// Fallback paths for common installations
-		for _, p := range []string{"/opt/homebrew/bin/tmux", "/usr/local/bin/tmux", "/usr/bin/tmux"} {
-			if _, err := os.Stat(p); err == nil {
-				tmuxPath = p
-				break
-			}
+	if err != nil {
+		return "unknown"
+	}
+
+	// Parse lines and find the prompt position
+	lines := strings.Split(content, "\n")
+
+	// Find the last occurrence of the input prompt ❯
+	promptLineIdx := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if line == "❯" || line == "> " {
+			promptLineIdx = i
+			break
 		}
 	}
 
-	// Find ccc binary (self)
-	if exe, err := os.Executable(); err == nil {
-		cccPath = exe
+	// If no prompt found, state is unknown
+	if promptLineIdx == -1 {
+		return "unknown"
 	}
 
-	// Find claude binary - first try PATH, then fallback paths
-	if path, err := exec.LookPath("claude"); err == nil {
-		claudePath = path
-	} else {
-		home, _ := os.UserHomeDir()
-		claudePaths := []string{
-			filepath.Join(home, ".claude", "local", "claude"),
-			"/usr/local/bin/claude",
+	// Check lines AFTER the prompt for activity indicators
+	// If "ctrl+c to interrupt" or spinners appear AFTER the prompt, Claude is busy
+	for i := promptLineIdx + 1; i < len(lines); i++ {
+		line := lines[i]
+		// Skip separator lines and status bar
+		if strings.HasPrefix(strings.TrimSpace(line), "─") || strings.Contains(line, "bypass permissions") {
+			continue
 		}
-		for _, p := range claudePaths {
-			if _, err := os.Stat(p); err == nil {
-				claudePath = p
-				break
-			}
+		// Activity indicators after prompt mean busy
+		if strings.Contains(line, "ctrl+c to interrupt") {
+			return "busy"
+		}
+		if strings.Contains(line, "✽") || strings.Contains(line, "✻") {
+			return "busy"
+		}
+		if strings.Contains(line, "Running…") || strings.Contains(line, "Thinking…") {
+			return "busy"
 		}
 	}
-}
-
-// markTelegramSent creates a marker file indicating a message was just sent
-// from Telegram to this topic's tmux session. Used by hook-prompt to avoid
-// echoing Telegram-originated messages back to Telegram.
-func markTelegramSent(topicID int64) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return
-	}
-	dir := filepath.Join(home, ".ccc", "telegram-sent")
-	os.MkdirAll(dir, 0755)
-	marker := filepath.Join(dir, fmt.Sprintf("%d", topicID))
-	os.WriteFile(marker, nil, 0644)
-}
 
-// wasTelegramSent checks if a message was sent from Telegram to this topic
-// within the cooldown period (10 seconds).
-func wasTelegramSent(topicID int64) bool {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return false
+	// Check lines BEFORE the prompt - if recent activity indicator, might still be transitioning
+	// Look only at the 3 lines before prompt
+	startCheck := promptLineIdx - 3
+	if startCheck < 0 {
+		startCheck = 0
 	}
-	marker := filepath.Join(home, ".ccc", "telegram-sent", fmt.Sprintf("%d", topicID))
-	info, err := os.Stat(marker)
-	if err != nil {
-		return false
+	for i := startCheck; i < promptLineIdx; i++ {
+		line := lines[i]
+		// If there's an active spinner line right before prompt, still processing
+		if strings.Contains(line, "ctrl+c to interrupt") {
+			// This is historical - Claude finished. Check if prompt is truly last
+			break
+		}
 	}
-	return time.Since(info.ModTime()) < 10*time.Second
-}
 
-// tmuxVerbose returns true if CCC_TMUX_VERBOSE env is set
-func tmuxVerbose() bool {
-	return os.Getenv("CCC_TMUX_VERBOSE") != ""
+	// Prompt found and no activity after it - Claude is idle
+	return "idle"
 }
 
-// tmuxBaseArgs returns base tmux arguments including socket and optional verbose flag
-func tmuxBaseArgs() []string {
-	args := []string{"-S", tmuxSocket}
-	if tmuxVerbose() {
-		args = append([]string{"-v"}, args...)
+// isClaudeRunning checks if Claude Code is running in a session
+// Returns true if Claude UI elements are detected, false if it looks like plain bash
+func isClaudeRunning(tmuxName string, sshAddress string) bool {
+	if sshAddress == "" {
+		return backend().ClaudeRunning(tmuxName)
 	}
-	return args
-}
 
-// tmuxLogDir returns the directory for tmux verbose logs (~/.ccc/tmux-logs/)
-// and ensures it exists.
-func tmuxLogDir() string {
-	home, err := os.UserHomeDir()
+	cmd := fmt.Sprintf("tmux capture-pane -t %s -p -S -30", shellQuote(tmuxName))
+	content, err := runSSH(sshAddress, cmd, 5*time.Second)
 	if err != nil {
-		return ""
+		return false
 	}
-	dir := filepath.Join(home, ".ccc", "tmux-logs")
-	os.MkdirAll(dir, 0755)
-	return dir
+	return looksLikeClaude(content)
 }
 
-// tmuxCmd creates an exec.Cmd for tmux with proper base args
-func tmuxCmd(cmdArgs ...string) *exec.Cmd {
-	args := append(tmuxBaseArgs(), cmdArgs...)
-	cmd := exec.Command(tmuxPath, args...)
-	if tmuxVerbose() {
-		if dir := tmuxLogDir(); dir != "" {
-			cmd.Dir = dir
+// restartClaudeInSession restarts Claude Code in an existing tmux session where it crashed
+// Returns true if restart was successful
+func restartClaudeInSession(tmuxName string, sshAddress string) bool {
+	restartCmd := cccPath + " run -c"
+
+	if sshAddress != "" {
+		// Remote session - send command via SSH
+		cmd := fmt.Sprintf("tmux send-keys -t %s %s C-m", shellQuote(tmuxName), shellQuote(restartCmd))
+		_, err := runSSH(sshAddress, cmd, 10*time.Second)
+		if err != nil {
+			return false
+		}
+	} else {
+		// Local session
+		if err := backend().Restart(tmuxName); err != nil {
+			return false
 		}
 	}
-	return cmd
+
+	// Wait for Claude to start
+	time.Sleep(3 * time.Second)
+
+	// Verify Claude is now running
+	return isClaudeRunning(tmuxName, sshAddress)
 }
 
-// ensureTmuxServer ensures tmux server is running by checking if socket exists
-// If not, starts a new tmux server. This handles the case after system reboot
-// when tmux hasn't been started yet and socket doesn't exist.
-func ensureTmuxServer() error {
-	// Check if socket directory exists
-	socketDir := filepath.Dir(tmuxSocket)
-	if _, err := os.Stat(socketDir); os.IsNotExist(err) {
-		// Socket directory doesn't exist - create it with proper permissions (700)
-		if err := os.MkdirAll(socketDir, 0700); err != nil {
-			return fmt.Errorf("failed to create tmux socket directory: %w", err)
-		}
-
-		// Start tmux server by creating and immediately killing a temporary session
-		cmd := tmuxCmd("new-session", "-d", "-s", "ccc-init")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to start tmux server: %w", err)
-		}
-		// Kill the temporary session
-		tmuxCmd("kill-session", "-t", "ccc-init").Run()
-
-		if tmuxVerbose() {
-			fmt.Fprintf(os.Stderr, "tmux verbose logging enabled, logs in current directory\n")
-		}
+// startContinuousTyping starts sending typing indicator every 4 seconds
+// until stopContinuousTyping is called or Claude becomes idle
+func startContinuousTyping(cfg *Config, chatID, threadID int64, sessionName string) {
+	logFor("typing").Debug("start", logging.SessionKey, sessionName)
+	typingMu.Lock()
+	// Cancel existing typing for this session
+	if cancel, ok := typingCancelers[sessionName]; ok {
+		cancel()
 	}
-	return nil
-}
 
-func tmuxSessionExists(name string) bool {
-	// Ensure tmux server is running first
-	if err := ensureTmuxServer(); err != nil {
-		return false
-	}
-	cmd := tmuxCmd("has-session", "-t", name)
-	return cmd.Run() == nil
-}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute) // Max 10 min
+	typingCancelers[sessionName] = cancel
+	typingMu.Unlock()
 
-func createTmuxSession(name string, workDir string, continueSession bool) error {
-	// Ensure tmux server is running (handles post-reboot case)
-	if err := ensureTmuxServer(); err != nil {
-		return err
-	}
+	// Determine tmux session name and SSH address (if remote)
+	var tmuxName string
+	var sshAddress string
 
-	// Build the command to run inside tmux
-	cccCmd := cccPath + " run"
-	if continueSession {
-		cccCmd += " -c"
+	if idx := strings.Index(sessionName, ":"); idx != -1 {
+		// Remote session (host:name format)
+		hostName := sessionName[:idx]
+		projectName := sessionName[idx+1:]
+		tmuxName = tmuxSessionName(projectName)
+		sshAddress = getHostAddress(cfg, hostName)
+	} else {
+		// Local session
+		tmuxName = tmuxSessionName(sessionName)
+		sshAddress = ""
 	}
 
-	// Create tmux session with a login shell (don't run command directly - it kills session on exit)
-	cmd := tmuxCmd("new-session", "-d", "-s", name, "-c", workDir)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+	go func() {
+		typingTicker := time.NewTicker(4 * time.Second)
+		stateTicker := time.NewTicker(2 * time.Second)
+		defer typingTicker.Stop()
+		defer stateTicker.Stop()
 
-	// Enable mouse mode for this session (allows scrolling)
-	tmuxCmd("set-option", "-t", name, "mouse", "on").Run()
+		// Send initial typing
+		sendTypingAction(cfg, chatID, threadID)
 
-	// Send the command to the session via send-keys (preserves TTY properly)
-	time.Sleep(200 * time.Millisecond)
-	tmuxCmd( "send-keys", "-t", name, cccCmd, "C-m").Run()
+		// Track consecutive idle checks to avoid false positives
+		idleCount := 0
 
-	return nil
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-stateTicker.C:
+				// Check Claude state
+				state := checkClaudeState(tmuxName, sshAddress)
+				if state == "idle" {
+					idleCount++
+					// Require 2 consecutive idle checks to confirm
+					if idleCount >= 2 {
+						logFor("typing").Debug("Claude idle, stopping typing indicator", logging.SessionKey, sessionName)
+						stopContinuousTyping(sessionName)
+						return
+					}
+				} else if state == "busy" {
+					idleCount = 0
+				}
+				// On "unknown" state, don't reset counter (might be transient)
+			case <-typingTicker.C:
+				sendTypingAction(cfg, chatID, threadID)
+			}
+		}
+	}()
 }
 
-// runClaudeRaw runs claude directly (used inside tmux sessions)
-func runClaudeRaw(continueSession bool) error {
-	if claudePath == "" {
-		return fmt.Errorf("claude binary not found")
+// stopContinuousTyping stops the typing indicator for a session
+func stopContinuousTyping(sessionName string) {
+	typingMu.Lock()
+	defer typingMu.Unlock()
+	if cancel, ok := typingCancelers[sessionName]; ok {
+		cancel()
+		delete(typingCancelers, sessionName)
 	}
+}
 
-	args := []string{"--dangerously-skip-permissions"}
-	if continueSession {
-		args = append(args, "-c")
-	}
+// Live progress: one "working…" message per turn, edited in place as the
+// transcript grows and replaced with the final answer by the Stop hook.
+// The bot process owns the edit loop; hooks run in separate processes, so the
+// message ID is shared through a state file in ~/.ccc/progress.
 
-	cmd := exec.Command(claudePath, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+var (
+	progressCancelers = make(map[string]context.CancelFunc)
+	progressMu        sync.Mutex
+)
 
-	return cmd.Run()
+const (
+	progressMaxSteps  = 8                // Steps shown in the progress message
+	progressTailBytes = 256 * 1024       // Transcript tail read per update
+	progressMaxTurn   = 30 * time.Minute // Stop editing after this long
+)
+
+// progressState is persisted while a turn's progress message is live
+type progressState struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int   `json:"message_id"`
+	Started   int64 `json:"started"`
 }
 
-// startSession creates/attaches to a tmux session with Telegram topic
-func startSession(continueSession bool) error {
-	// Get current directory name as session name
-	cwd, err := os.Getwd()
+func progressStatePath(topicID int64) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "progress", fmt.Sprintf("%d.json", topicID))
+}
+
+// lockProgress takes an exclusive lock on a topic's progress state so the
+// edit loop and the Stop hook never edit the same message concurrently
+func lockProgress(topicID int64) func() {
+	path := progressStatePath(topicID)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(strings.TrimSuffix(path, ".json")+".lock", os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		return err
+		return func() {}
 	}
-	name := filepath.Base(cwd)
-	tmuxName := tmuxSessionName(name)
+	syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+}
 
-	// Load config to check/create topic
-	config, err := loadConfig()
+func readProgressState(topicID int64) *progressState {
+	data, err := os.ReadFile(progressStatePath(topicID))
 	if err != nil {
-		// No config, just run claude directly
-		return runClaudeRaw(continueSession)
+		return nil
 	}
-
-	// Create topic if it doesn't exist and we have a group configured
-	if config.GroupID != 0 {
-		if _, exists := config.Sessions[name]; !exists {
-			topicID, err := createForumTopic(config, name)
-			if err == nil {
-				config.Sessions[name] = &SessionInfo{
-					TopicID: topicID,
-					Path:    cwd,
-				}
-				saveConfig(config)
-				fmt.Printf("📱 Created Telegram topic: %s\n", name)
-			}
-		}
+	var st progressState
+	if json.Unmarshal(data, &st) != nil || st.MessageID == 0 {
+		return nil
 	}
+	return &st
+}
 
-	// Check if tmux session exists
-	if tmuxSessionExists(tmuxName) {
-		// Check if we're already inside tmux
-		if os.Getenv("TMUX") != "" {
-			// Inside tmux: switch to the session
-			cmd := tmuxCmd( "switch-client", "-t", tmuxName)
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			return cmd.Run()
-		}
-		// Outside tmux: attach to existing session
-		cmd := tmuxCmd( "attach-session", "-t", tmuxName)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
-	}
+// progressActive reports whether a live progress message exists for the topic
+func progressActive(topicID int64) bool {
+	return readProgressState(topicID) != nil
+}
 
-	// Create new tmux session and attach
-	if err := createTmuxSession(tmuxName, cwd, continueSession); err != nil {
-		return err
+// liveProgressEnabled returns the effective live progress setting for a session
+func liveProgressEnabled(cfg *Config, info *SessionInfo) bool {
+	if info != nil && info.LiveProgress != nil {
+		return *info.LiveProgress
 	}
+	return cfg.LiveProgress
+}
 
-	// Check if we're already inside tmux
-	if os.Getenv("TMUX") != "" {
-		cmd := tmuxCmd( "switch-client", "-t", tmuxName)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
+// liveProgressInterval returns the minimum delay between edits.
+// Telegram allows roughly 20 edits per minute in groups, so keep it >= 3s.
+func liveProgressInterval(cfg *Config) time.Duration {
+	secs := cfg.LiveProgressInterval
+	if secs <= 0 {
+		secs = 5
 	}
-	cmd := tmuxCmd( "attach-session", "-t", tmuxName)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if secs < 3 {
+		secs = 3
+	}
+	return time.Duration(secs) * time.Second
 }
 
-func sendToTmux(session string, text string) error {
-	// Always use 2 second delay before Enter to ensure text is fully processed
-	// Without this delay, Enter may be interpreted as newline instead of submit
-	return sendToTmuxWithDelay(session, text, 2*time.Second)
-}
+// startLiveProgress posts the "working…" message for a new turn and keeps
+// editing it until the Stop hook replaces it, Claude goes idle, or the turn
+// exceeds progressMaxTurn
+func startLiveProgress(cfg *Config, chatID, threadID int64, sessionName string) {
+	info := cfg.Sessions[sessionName]
+	if info == nil || threadID == 0 || !liveProgressEnabled(cfg, info) {
+		return
+	}
 
-func sendToTmuxWithDelay(session string, text string, delay time.Duration) error {
-	// Send text literally
-	cmd := tmuxCmd( "send-keys", "-t", session, "-l", text)
-	if err := cmd.Run(); err != nil {
-		return err
+	progressMu.Lock()
+	if cancel, ok := progressCancelers[sessionName]; ok {
+		cancel()
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), progressMaxTurn)
+	progressCancelers[sessionName] = cancel
+	progressMu.Unlock()
 
-	// Wait for content to load (e.g., images, long pasted text)
-	time.Sleep(delay)
+	_, projectName := parseSessionTarget(sessionName)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	sshAddress := getHostAddress(cfg, info.Host)
+	interval := liveProgressInterval(cfg)
 
-	// Send Enter twice (Claude Code needs double Enter)
-	cmd = tmuxCmd( "send-keys", "-t", session, "C-m")
-	if err := cmd.Run(); err != nil {
-		return err
+	go func() {
+		defer cancel()
+
+		started := time.Now()
+		text := renderProgress(sessionName, 0, nil)
+		msgID, err := sendMessageGetID(cfg, chatID, threadID, text)
+		if err != nil {
+			logFor("progress").Warn("send failed", logging.SessionKey, sessionName, "error", err)
+			return
+		}
+
+		unlock := lockProgress(threadID)
+		if old := readProgressState(threadID); old != nil {
+			// Previous turn never got a Stop hook
+			editMessageText(cfg, old.ChatID, old.MessageID, fmt.Sprintf("⏹ %s — interrupted by a new message", sessionName))
+		}
+		data, _ := json.Marshal(progressState{ChatID: chatID, MessageID: msgID, Started: started.Unix()})
+		os.WriteFile(progressStatePath(threadID), data, 0600)
+		unlock()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		idleSince := time.Time{}
+		lastText := text
+
+		for {
+			select {
+			case <-ctx.Done():
+				if ctx.Err() != context.DeadlineExceeded {
+					return // superseded by a newer turn
+				}
+				finishLiveProgress(cfg, threadID, msgID, fmt.Sprintf("⏳ %s — still working after %s, live updates stopped", sessionName, formatDuration(time.Since(started))))
+				return
+			case <-ticker.C:
+			}
+
+			// Stop hook already replaced the message (or a newer turn took over)
+			if st := readProgressState(threadID); st == nil || st.MessageID != msgID {
+				return
+			}
+
+			// No Stop hook after Claude went idle (interrupted turn): close it out
+			if checkClaudeState(tmuxName, sshAddress) == "idle" {
+				if idleSince.IsZero() {
+					idleSince = time.Now()
+				} else if time.Since(idleSince) > 30*time.Second {
+					finishLiveProgress(cfg, threadID, msgID, fmt.Sprintf("⏹ %s — stopped after %s", sessionName, formatDuration(idleSince.Sub(started))))
+					return
+				}
+			} else {
+				idleSince = time.Time{}
+			}
+
+			steps := summarizeProgress(readTranscriptTail(info, sshAddress), started)
+			text = renderProgress(sessionName, time.Since(started), steps)
+			if text == lastText {
+				continue
+			}
+
+			unlock := lockProgress(threadID)
+			if st := readProgressState(threadID); st != nil && st.MessageID == msgID {
+				if err := editMessageText(cfg, chatID, msgID, text); err != nil {
+					logFor("progress").Warn("edit failed", logging.SessionKey, sessionName, "error", err)
+				} else {
+					lastText = text
+				}
+			}
+			unlock()
+		}
+	}()
+}
+
+// stopLiveProgress cancels the edit loop for a session
+func stopLiveProgress(sessionName string) {
+	progressMu.Lock()
+	defer progressMu.Unlock()
+	if cancel, ok := progressCancelers[sessionName]; ok {
+		cancel()
+		delete(progressCancelers, sessionName)
 	}
-	time.Sleep(50 * time.Millisecond)
-	cmd = tmuxCmd( "send-keys", "-t", session, "C-m")
-	return cmd.Run()
 }
 
-func killTmuxSession(name string) error {
-	cmd := tmuxCmd( "kill-session", "-t", name)
-	return cmd.Run()
+// finishLiveProgress edits the progress message one last time and drops the
+// state, unless the Stop hook got there first
+func finishLiveProgress(cfg *Config, topicID int64, msgID int, text string) {
+	unlock := lockProgress(topicID)
+	defer unlock()
+	st := readProgressState(topicID)
+	if st == nil || st.MessageID != msgID {
+		return
+	}
+	os.Remove(progressStatePath(topicID))
+	editMessageText(cfg, st.ChatID, msgID, text)
 }
 
-func listTmuxSessions() ([]string, error) {
-	cmd := tmuxCmd( "list-sessions", "-F", "#{session_name}")
-	out, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// deliverFinalMessage sends a Stop message to a topic. If a live progress
+// message is active, it is edited into the first chunk of the answer and any
+// remaining chunks are sent as new messages.
+func deliverFinalMessage(config *Config, chatID int64, topicID int64, header string, body string) error {
+	unlock := lockProgress(topicID)
+	st := readProgressState(topicID)
+	if st != nil {
+		os.Remove(progressStatePath(topicID))
 	}
+	unlock()
 
-	var sessions []string
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		name := scanner.Text()
-		if strings.HasPrefix(name, "claude-") {
-			sessions = append(sessions, strings.TrimPrefix(name, "claude-"))
-		}
+	if st == nil {
+		return sendClaudeMessage(config, chatID, topicID, header, body)
 	}
-	return sessions, nil
-}
 
-// TmuxSessionInfo holds information about a tmux session
-type TmuxSessionInfo struct {
-	Created  time.Time
-	Activity time.Time
-	Path     string
+	text, blocks := formatClaudeMessage(config, header, body)
+	chunks := markdown.Split(text, 4000)
+	if err := editMessageHTML(config, st.ChatID, st.MessageID, chunks[0]); err != nil {
+		return sendClaudeMessage(config, chatID, topicID, header, body)
+	}
+	for _, chunk := range chunks[1:] {
+		if err := sendHTMLMessage(config, chatID, topicID, chunk); err != nil {
+			return err
+		}
+	}
+	return sendCodeBlocks(config, chatID, topicID, blocks)
 }
 
-// getTmuxSessionInfo returns detailed info about a tmux session
-func getTmuxSessionInfo(name string) (*TmuxSessionInfo, error) {
-	cmd := tmuxCmd( "list-sessions", "-F",
-		"#{session_name}\t#{session_created}\t#{session_activity}\t#{pane_current_path}",
-		"-f", fmt.Sprintf("#{==:#{session_name},%s}", name))
-	out, err := cmd.Output()
+// readTranscriptTail returns the tail of the most recent transcript for a
+// session's project, reading it over SSH for remote sessions
+func readTranscriptTail(info *SessionInfo, sshAddress string) []byte {
+	encoded := encodeProjectPath(info.Path)
+
+	if info.Host != "" {
+		if sshAddress == "" {
+			return nil
+		}
+		cmd := fmt.Sprintf("f=$(ls -t ~/.claude/projects/%s/*.jsonl 2>/dev/null | head -1); [ -n \"$f\" ] && tail -c %d \"$f\"",
+			shellQuote(encoded), progressTailBytes)
+		out, err := runSSH(sshAddress, cmd, 10*time.Second)
+		if err != nil {
+			return nil
+		}
+		return []byte(out)
+	}
+
+	home, _ := os.UserHomeDir()
+	matches, _ := filepath.Glob(filepath.Join(home, ".claude", "projects", encoded, "*.jsonl"))
+	var latest string
+	var latestMod time.Time
+	for _, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.ModTime().After(latestMod) {
+			latest, latestMod = m, fi.ModTime()
+		}
+	}
+	if latest == "" {
+		return nil
+	}
+
+	f, err := os.Open(latest)
 	if err != nil {
-		return nil, err
+		return nil
 	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > progressTailBytes {
+		f.Seek(fi.Size()-progressTailBytes, io.SeekStart)
+	}
+	data, _ := io.ReadAll(f)
+	return data
+}
 
-	line := strings.TrimSpace(string(out))
-	if line == "" {
-		return nil, fmt.Errorf("session not found")
+// summarizeProgress turns transcript entries written after since into short
+// progress lines: tool calls with their main argument, a one-line preview of
+// each tool result, and assistant text. Partial lines (from reading a tail)
+// are skipped.
+func summarizeProgress(data []byte, since time.Time) []string {
+	type block struct {
+		Type      string          `json:"type"`
+		Text      string          `json:"text"`
+		Name      string          `json:"name"`
+		ID        string          `json:"id"`
+		ToolUseID string          `json:"tool_use_id"`
+		Input     json.RawMessage `json:"input"`
+		Content   json.RawMessage `json:"content"`
+		IsError   bool            `json:"is_error"`
+	}
+	type line struct {
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Message   struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
 	}
 
-	parts := strings.Split(line, "\t")
-	if len(parts) < 4 {
-		return nil, fmt.Errorf("invalid tmux output")
+	var steps []string
+	toolStep := make(map[string]int) // tool_use_id -> index in steps
+	seen := make(map[string]bool)    // tool_use_ids and texts already listed (streaming duplicates)
+
+	for _, raw := range bytes.Split(data, []byte("\n")) {
+		var l line
+		if len(raw) == 0 || json.Unmarshal(raw, &l) != nil {
+			continue
+		}
+		if l.Timestamp.Before(since) {
+			continue
+		}
+		var blocks []block
+		if json.Unmarshal(l.Message.Content, &blocks) != nil {
+			continue
+		}
+		for _, b := range blocks {
+			switch {
+			case l.Type == "assistant" && b.Type == "tool_use":
+				if seen[b.ID] {
+					continue
+				}
+				seen[b.ID] = true
+				step := "🔧 " + b.Name
+				if arg := toolInputSummary(b.Input); arg != "" {
+					step += ": " + arg
+				}
+				toolStep[b.ID] = len(steps)
+				steps = append(steps, step)
+			case l.Type == "assistant" && b.Type == "text":
+				text := firstLine(b.Text, 100)
+				if text == "" || seen["text:"+text] {
+					continue
+				}
+				seen["text:"+text] = true
+				steps = append(steps, "💭 "+text)
+			case l.Type == "user" && b.Type == "tool_result":
+				idx, ok := toolStep[b.ToolUseID]
+				if !ok {
+					continue
+				}
+				preview := firstLine(toolResultText(b.Content), 80)
+				if b.IsError {
+					steps[idx] += "\n   ⚠️ " + preview
+				} else if preview != "" {
+					steps[idx] += "\n   ↳ " + preview
+				}
+			}
+		}
 	}
+	return steps
+}
 
-	created, _ := strconv.ParseInt(parts[1], 10, 64)
-	activity, _ := strconv.ParseInt(parts[2], 10, 64)
+// toolInputSummary picks the most descriptive field of a tool call's input
+func toolInputSummary(input json.RawMessage) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(input, &fields) != nil {
+		return ""
+	}
+	for _, key := range []string{"command", "file_path", "path", "pattern", "url", "query", "description", "prompt"} {
+		if v, ok := fields[key].(string); ok && v != "" {
+			if key == "file_path" || key == "path" {
+				v = filepath.Base(v)
+			}
+			return firstLine(v, 60)
+		}
+	}
+	return ""
+}
 
-	return &TmuxSessionInfo{
-		Created:  time.Unix(created, 0),
-		Activity: time.Unix(activity, 0),
-		Path:     parts[3],
-	}, nil
+// toolResultText extracts text from a tool_result content (string or blocks)
+func toolResultText(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(content, &blocks) == nil {
+		for _, b := range blocks {
+			if b.Type == "text" && strings.TrimSpace(b.Text) != "" {
+				return b.Text
+			}
+		}
+	}
+	return ""
 }
 
-// sshGetTmuxSessionInfo returns detailed info about a remote tmux session
-func sshGetTmuxSessionInfo(address string, name string) (*TmuxSessionInfo, error) {
-	cmd := fmt.Sprintf("tmux list-sessions -F '#{session_name}\t#{session_created}\t#{session_activity}\t#{pane_current_path}' -f '#{==:#{session_name},%s}'", name)
-	out, err := runSSH(address, cmd, 10*time.Second)
-	if err != nil {
-		return nil, err
+// firstLine returns the first non-empty line of s, truncated to maxLen runes
+func firstLine(s string, maxLen int) string {
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if r := []rune(l); len(r) > maxLen {
+			return string(r[:maxLen]) + "…"
+		}
+		return l
 	}
+	return ""
+}
 
-	line := strings.TrimSpace(out)
-	if line == "" {
-		return nil, fmt.Errorf("session not found")
+// renderProgress builds the progress message text
+func renderProgress(sessionName string, elapsed time.Duration, steps []string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("⏳ %s — working… (%s)", sessionName, formatDuration(elapsed)))
+	if len(steps) > progressMaxSteps {
+		b.WriteString(fmt.Sprintf("\n\n… %d earlier steps", len(steps)-progressMaxSteps))
+		steps = steps[len(steps)-progressMaxSteps:]
+	} else if len(steps) > 0 {
+		b.WriteString("\n")
 	}
+	for _, s := range steps {
+		b.WriteString("\n" + s)
+	}
+	return b.String()
+}
 
-	parts := strings.Split(line, "\t")
-	if len(parts) < 4 {
-		return nil, fmt.Errorf("invalid tmux output")
+func splitMessage(text string, maxLen int) []string {
+	if len(text) <= maxLen {
+		return []string{text}
 	}
 
-	created, _ := strconv.ParseInt(parts[1], 10, 64)
-	activity, _ := strconv.ParseInt(parts[2], 10, 64)
+	var messages []string
+	remaining := text
 
-	return &TmuxSessionInfo{
-		Created:  time.Unix(created, 0),
-		Activity: time.Unix(activity, 0),
-		Path:     parts[3],
-	}, nil
+	for len(remaining) > 0 {
+		if len(remaining) <= maxLen {
+			messages = append(messages, remaining)
+			break
+		}
+
+		// Find a good split point (newline or space)
+		splitAt := maxLen
+
+		// Try to split at a newline first
+		if idx := strings.LastIndex(remaining[:maxLen], "\n"); idx > maxLen/2 {
+			splitAt = idx + 1
+		} else if idx := strings.LastIndex(remaining[:maxLen], " "); idx > maxLen/2 {
+			// Fall back to space
+			splitAt = idx + 1
+		}
+
+		messages = append(messages, strings.TrimRight(remaining[:splitAt], " \n"))
+		remaining = remaining[splitAt:]
+	}
+
+	return messages
 }
 
-// formatDuration formats a duration in human-readable format
-func formatDuration(d time.Duration) string {
-	if d < time.Minute {
-		return fmt.Sprintf("%ds", int(d.Seconds()))
+// Download file from Telegram
+func downloadTelegramFile(config *Config, fileID string, destPath string) error {
+	// Get file path from Telegram
+	resp, err := telegramGet(config.BotToken, fmt.Sprintf("https://api.telegram.org/bot%s/getFile?file_id=%s", config.BotToken, fileID))
+	if err != nil {
+		return err
 	}
-	if d < time.Hour {
-		return fmt.Sprintf("%dm", int(d.Minutes()))
+	defer resp.Body.Close()
+
+	var result struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			FilePath string `json:"file_path"`
+		} `json:"result"`
 	}
-	if d < 24*time.Hour {
-		hours := int(d.Hours())
-		mins := int(d.Minutes()) % 60
-		if mins > 0 {
-			return fmt.Sprintf("%dh %dm", hours, mins)
-		}
-		return fmt.Sprintf("%dh", hours)
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
 	}
-	days := int(d.Hours()) / 24
-	hours := int(d.Hours()) % 24
-	if hours > 0 {
-		return fmt.Sprintf("%dd %dh", days, hours)
+	if !result.OK {
+		return fmt.Errorf("failed to get file path")
 	}
-	return fmt.Sprintf("%dd", days)
-}
-
-// Session management
 
-func createSession(config *Config, name string) error {
-	// Check if session already exists
-	if _, exists := config.Sessions[name]; exists {
-		return fmt.Errorf("session '%s' already exists", name)
+	// Download the file
+	fileURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", config.BotToken, result.Result.FilePath)
+	fileResp, err := telegramGet(config.BotToken, fileURL)
+	if err != nil {
+		return err
 	}
+	defer fileResp.Body.Close()
 
-	// Create Telegram topic
-	topicID, err := createForumTopic(config, name)
+	out, err := os.Create(destPath)
 	if err != nil {
-		return fmt.Errorf("failed to create topic: %w", err)
+		return err
 	}
+	defer out.Close()
 
-	// Create tmux session
-	workDir := resolveProjectPath(config, name)
-	if _, err := os.Stat(workDir); os.IsNotExist(err) {
-		// Create project directory
-		os.MkdirAll(workDir, 0755)
+	_, err = io.Copy(out, fileResp.Body)
+	return err
+}
+
+const defaultTranscriptionMaxSeconds = 600
+
+// transcriptionProvider returns the provider configured for voice messages.
+// Without a "transcription" block, transcription_cmd is used if set and the
+// Whisper CLI otherwise.
+func transcriptionProvider(cfg *Config) (transcribe.Provider, error) {
+	tc := cfg.Transcription
+	if tc == nil {
+		tc = &config.TranscriptionConfig{}
+	}
+	provider := tc.Provider
+	if provider == "" {
+		provider = "whisper"
+		if cfg.TranscriptionCmd != "" {
+			provider = "command"
+		}
 	}
 
-	if err := createTmuxSession(tmuxSessionName(name), workDir, false); err != nil {
-		return fmt.Errorf("failed to create tmux session: %w", err)
+	switch provider {
+	case "command":
+		if cfg.TranscriptionCmd == "" {
+			return nil, fmt.Errorf("provider \"command\" needs transcription_cmd")
+		}
+		return transcribe.Command{Path: expandPath(cfg.TranscriptionCmd)}, nil
+	case "whisper":
+		path := expandPath(tc.WhisperBin)
+		if path == "" {
+			path = findWhisper()
+		}
+		return transcribe.Whisper{Path: path, Model: tc.WhisperModel}, nil
+	case "whisper-cpp":
+		return transcribe.WhisperCpp{Binary: expandPath(tc.WhisperBin), Model: expandPath(tc.WhisperModel), Threads: tc.Threads}, nil
+	case "openai":
+		key := tc.APIKey
+		if key == "" {
+			key = os.Getenv("OPENAI_API_KEY")
+		}
+		return transcribe.OpenAI{BaseURL: tc.BaseURL, APIKey: key, Model: tc.Model}, nil
 	}
+	return nil, fmt.Errorf("unknown transcription provider %q (use command, whisper, whisper-cpp or openai)", provider)
+}
 
-	// Save mapping with full path
-	config.Sessions[name] = &SessionInfo{
-		TopicID: topicID,
-		Path:    workDir,
+// findWhisper looks for the Whisper CLI in PATH or known locations
+func findWhisper() string {
+	if path, err := exec.LookPath("whisper"); err == nil {
+		return path
 	}
-	if err := saveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+	for _, p := range []string{"/opt/homebrew/bin/whisper", "/usr/local/bin/whisper"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
 	}
-
-	return nil
+	return "whisper"
 }
 
-func killSession(config *Config, name string) error {
-	sessionInfo, exists := config.Sessions[name]
-	if !exists {
-		return fmt.Errorf("session '%s' not found", name)
+// transcriptionOptions combines the global hints with a session's vocabulary
+func transcriptionOptions(cfg *Config, info *SessionInfo) transcribe.Options {
+	var opts transcribe.Options
+	var prompts []string
+	if tc := cfg.Transcription; tc != nil {
+		opts.Language = tc.Language
+		if tc.Prompt != "" {
+			prompts = append(prompts, tc.Prompt)
+		}
 	}
+	if info != nil && info.Vocabulary != "" {
+		prompts = append(prompts, info.Vocabulary)
+	}
+	opts.Prompt = strings.Join(prompts, " ")
+	return opts
+}
 
-	// Extract project name for tmux session (without host prefix)
-	_, projectName := parseSessionTarget(name)
-	tmuxName := tmuxSessionName(extractProjectName(projectName))
+// transcriptionMaxSeconds returns the longest voice message to transcribe
+func transcriptionMaxSeconds(cfg *Config) int {
+	if cfg.Transcription != nil && cfg.Transcription.MaxSeconds > 0 {
+		return cfg.Transcription.MaxSeconds
+	}
+	return defaultTranscriptionMaxSeconds
+}
 
-	// Kill tmux session (remote or local)
-	if sessionInfo != nil && sessionInfo.Host != "" {
-		address := getHostAddress(config, sessionInfo.Host)
-		if address != "" {
-			sshTmuxKillSession(address, tmuxName)
-		}
-	} else {
-		killTmuxSession(tmuxName)
+// transcribeAudio transcribes a voice message for a session
+func transcribeAudio(cfg *Config, info *SessionInfo, audioPath string) (string, error) {
+	provider, err := transcriptionProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	text, err := provider.Transcribe(ctx, audioPath, transcriptionOptions(cfg, info))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", provider.Name(), err)
 	}
+	return text, nil
+}
 
-	// Mark as deleted but keep in config to preserve topic mapping
-	sessionInfo.Deleted = true
-	saveConfig(config)
+// sendVoicePrompt records a (confirmed) transcription in history and types
+// it into the session
+func sendVoicePrompt(cfg *Config, chatID int64, threadID int64, sessionName string, text string, username string) {
+	appendHistory(threadID, HistoryMessage{
+		ID:            nextMessageID(),
+		Timestamp:     time.Now().Unix(),
+		From:          "human",
+		Type:          "voice",
+		Transcription: text,
+		Username:      username,
+	})
 
-	return nil
+	startContinuousTyping(cfg, chatID, threadID, sessionName)
+	err := typePrompt(cfg, sessionName, cfg.Sessions[sessionName], text, "voice", "")
+	recordAudit(cfg, telegramActor(cfg.ChatID, username), "voice", sessionName, text, auditResult(err))
+	startLiveProgress(cfg, chatID, threadID, sessionName)
 }
 
-func getSessionByTopic(cfg *Config, topicID int64) string { return config.GetSessionByTopic(cfg, topicID) }
+// Voice transcription confirmation: with transcription.confirm on, a
+// transcription is posted with Send / Edit / Cancel buttons (callback data
+// "vtx|<action>") and only typed into the session once confirmed. A reply
+// to the message replaces the text and sends it.
 
-// Client session management
+const voiceConfirmExpiry = time.Hour
 
-// startClientSession starts a claude session on the client
-// 1. Determines project path from args or cwd
-// 2. Registers session on server via SSH (creates Telegram topic)
-// 3. Creates/attaches tmux session with claude
-func startClientSession(config *Config, args []string) error {
-	// Check for -c flag
-	continueSession := false
-	filteredArgs := []string{}
-	for _, arg := range args {
-		if arg == "-c" {
-			continueSession = true
-		} else {
-			filteredArgs = append(filteredArgs, arg)
-		}
-	}
+// pendingVoice is a transcription waiting for confirmation
+type pendingVoice struct {
+	ChatID    int64
+	ThreadID  int64
+	Session   string
+	Text      string
+	Username  string
+	MessageID int // The message with the buttons
+	Created   time.Time
+}
 
-	// Determine project path
-	var projectPath string
-	if len(filteredArgs) > 0 && filteredArgs[0] != "" {
-		// Project name or path provided
-		arg := filteredArgs[0]
-		if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, "~") || strings.HasPrefix(arg, ".") {
-			// Absolute or relative path
-			projectPath = arg
-		} else {
-			// Just a name - create in home directory
-			home, _ := os.UserHomeDir()
-			projectPath = filepath.Join(home, arg)
-		}
-	} else {
-		// Use current directory
-		var err error
-		projectPath, err = os.Getwd()
-		if err != nil {
-			return fmt.Errorf("cannot get current directory: %v", err)
-		}
-	}
+// voiceMessage identifies a Telegram message; message IDs are per chat
+type voiceMessage struct {
+	ChatID    int64
+	MessageID int
+}
 
-	// Expand ~ if present
-	if strings.HasPrefix(projectPath, "~") {
-		home, _ := os.UserHomeDir()
-		projectPath = filepath.Join(home, projectPath[1:])
+// pendingVoices maps the confirmation message, and the prompt sent by Edit,
+// to their transcription. Only the listener's update loop uses it.
+var pendingVoices = map[voiceMessage]*pendingVoice{}
+
+// voiceConfirmText renders the confirmation message for a transcription
+func voiceConfirmText(text string, outcome string) string {
+	if outcome == "" {
+		return "📝 " + text + "\n\nSend to Claude? Reply to this message to correct it."
 	}
+	return "📝 " + text + "\n\n" + outcome
+}
 
-	// Make path absolute
-	absPath, err := filepath.Abs(projectPath)
+// askVoiceConfirmation posts a transcription with Send / Edit / Cancel buttons
+func askVoiceConfirmation(cfg *Config, p *pendingVoice) error {
+	id, err := sendMessageWithMarkup(cfg, p.ChatID, p.ThreadID, voiceConfirmText(p.Text, ""), map[string]interface{}{
+		"inline_keyboard": [][]InlineKeyboardButton{{
+			{Text: "✅ Send", CallbackData: "vtx|send"},
+			{Text: "✏️ Edit", CallbackData: "vtx|edit"},
+			{Text: "✖️ Cancel", CallbackData: "vtx|cancel"},
+		}},
+	})
 	if err != nil {
-		return fmt.Errorf("cannot resolve path: %v", err)
+		return err
 	}
-	projectPath = absPath
+	p.MessageID = id
+	p.Created = time.Now()
+	addPendingVoice(p, voiceMessage{p.ChatID, id}, time.Now())
+	return nil
+}
 
-	// Check if directory exists, create if not
-	if _, err := os.Stat(projectPath); os.IsNotExist(err) {
-		fmt.Printf("Creating directory: %s\n", projectPath)
-		if err := os.MkdirAll(projectPath, 0755); err != nil {
-			return fmt.Errorf("cannot create directory: %v", err)
+// addPendingVoice registers p under a message and drops expired entries
+func addPendingVoice(p *pendingVoice, msg voiceMessage, now time.Time) {
+	for key, other := range pendingVoices {
+		if now.Sub(other.Created) > voiceConfirmExpiry {
+			delete(pendingVoices, key)
 		}
 	}
+	pendingVoices[msg] = p
+}
 
-	// Session name for tmux
-	name := filepath.Base(projectPath)
-	tmuxName := tmuxSessionName(name)
-
-	// Register session on server (creates Telegram topic)
-	fmt.Printf("Registering session on server...\n")
-	cmd := fmt.Sprintf("ccc register-session %s %s",
-		shellQuote(config.HostName), shellQuote(projectPath))
-
-	output, err := runSSH(config.Server, cmd, 10*time.Second)
-	if err != nil {
-		return fmt.Errorf("failed to register session: %v", err)
+// takePendingVoice removes and returns the transcription behind a message,
+// or nil if there is none or it expired
+func takePendingVoice(msg voiceMessage, now time.Time) *pendingVoice {
+	p := pendingVoices[msg]
+	if p == nil {
+		return nil
+	}
+	for key, other := range pendingVoices {
+		if other == p {
+			delete(pendingVoices, key)
+		}
 	}
+	if now.Sub(p.Created) > voiceConfirmExpiry {
+		return nil
+	}
+	return p
+}
 
-	topicID := strings.TrimSpace(output)
-	fmt.Printf("Session registered (topic: %s)\n", topicID)
+// handleVoiceConfirmCallback handles the Send / Edit / Cancel buttons
+func handleVoiceConfirmCallback(cfg *Config, cb *CallbackQuery, action string) {
+	if cb.Message == nil {
+		return
+	}
+	chatID, msgID := cb.Message.Chat.ID, cb.Message.MessageID
+	key := voiceMessage{chatID, msgID}
+	if action == "edit" {
+		p := pendingVoices[key]
+		if p == nil || time.Since(p.Created) > voiceConfirmExpiry {
+			takePendingVoice(key, time.Now())
+			editMessageRemoveKeyboard(cfg, chatID, msgID, cb.Message.Text+"\n\n⌛ Expired")
+			return
+		}
+		id, err := sendMessageWithMarkup(cfg, p.ChatID, p.ThreadID, "✏️ Reply with the corrected text:\n\n"+p.Text, map[string]interface{}{
+			"force_reply":             true,
+			"input_field_placeholder": "Corrected transcription",
+		})
+		if err == nil {
+			addPendingVoice(p, voiceMessage{p.ChatID, id}, p.Created)
+		}
+		return
+	}
 
-	// Check if tmux session already exists
-	if tmuxSessionExists(tmuxName) {
-		fmt.Printf("Attaching to existing session: %s\n", tmuxName)
-		if os.Getenv("TMUX") != "" {
-			// Inside tmux: switch to the session
-			switchCmd := tmuxCmd( "switch-client", "-t", tmuxName)
-			switchCmd.Stdin = os.Stdin
-			switchCmd.Stdout = os.Stdout
-			switchCmd.Stderr = os.Stderr
-			return switchCmd.Run()
-		}
-		// Outside tmux: attach to existing session
-		attachCmd := tmuxCmd( "attach-session", "-t", tmuxName)
-		attachCmd.Stdin = os.Stdin
-		attachCmd.Stdout = os.Stdout
-		attachCmd.Stderr = os.Stderr
-		return attachCmd.Run()
-	}
-
-	// Create new tmux session
-	fmt.Printf("Creating session: %s\n", tmuxName)
-	if err := createTmuxSession(tmuxName, projectPath, continueSession); err != nil {
-		return err
+	p := takePendingVoice(key, time.Now())
+	if p == nil {
+		editMessageRemoveKeyboard(cfg, chatID, msgID, cb.Message.Text+"\n\n⌛ Expired")
+		return
+	}
+	if action == "send" {
+		editMessageRemoveKeyboard(cfg, chatID, msgID, voiceConfirmText(p.Text, "✅ Sent"))
+		logFor("voice").Info("transcription confirmed", logging.SessionKey, p.Session, "user", p.Username, "text", p.Text)
+		sendVoicePrompt(cfg, p.ChatID, p.ThreadID, p.Session, p.Text, p.Username)
+		return
 	}
+	editMessageRemoveKeyboard(cfg, chatID, msgID, voiceConfirmText(p.Text, "✖️ Cancelled"))
+}
 
-	// Attach to the session
-	if os.Getenv("TMUX") != "" {
-		attachCmd := tmuxCmd( "switch-client", "-t", tmuxName)
-		attachCmd.Stdin = os.Stdin
-		attachCmd.Stdout = os.Stdout
-		attachCmd.Stderr = os.Stderr
-		return attachCmd.Run()
+// handleVoiceCorrection sends a reply to a pending transcription in place
+// of the transcription. It reports whether the message was such a reply.
+func handleVoiceCorrection(cfg *Config, chatID int64, replyTo int, text string) bool {
+	key := voiceMessage{chatID, replyTo}
+	if pendingVoices[key] == nil || text == "" {
+		return false
+	}
+	p := takePendingVoice(key, time.Now())
+	if p == nil {
+		return false
 	}
-	attachCmd := tmuxCmd( "attach-session", "-t", tmuxName)
-	attachCmd.Stdin = os.Stdin
-	attachCmd.Stdout = os.Stdout
-	attachCmd.Stderr = os.Stderr
-	return attachCmd.Run()
+	editMessageRemoveKeyboard(cfg, p.ChatID, p.MessageID, voiceConfirmText(text, "✅ Sent (corrected)"))
+	logFor("voice").Info("transcription corrected", logging.SessionKey, p.Session, "user", p.Username, "text", text)
+	sendVoicePrompt(cfg, p.ChatID, p.ThreadID, p.Session, text, p.Username)
+	return true
 }
 
-// Hook handling
+// Voice replies: a spoken summary of each answer is sent as a voice message
+// after the Stop message
 
-// extractProjectDirFromTranscript extracts the encoded project directory from transcript path
-// e.g., "/home/user/.claude/projects/-home-user-Projects-myapp/transcript.json" -> "-home-user-Projects-myapp"
-func extractProjectDirFromTranscript(transcriptPath string) string {
-	if transcriptPath == "" {
-		return ""
+const defaultVoiceReplyChars = 600
+
+// voiceRepliesWanted reports whether a finished turn gets a voice reply. A
+// session override wins, then the global switch, then whether the turn's
+// prompt came from a user who turned voice replies on.
+func voiceRepliesWanted(cfg *Config, info *SessionInfo, username string) bool {
+	if info != nil && info.VoiceReplies != nil {
+		return *info.VoiceReplies
 	}
-	// Find "/projects/" in the path
-	idx := strings.Index(transcriptPath, "/projects/")
-	if idx == -1 {
-		return ""
+	vr := cfg.VoiceReplies
+	if vr == nil {
+		return false
 	}
-	// Get the part after "/projects/"
-	rest := transcriptPath[idx+len("/projects/"):]
-	// Take only the directory name (before next /)
-	if slashIdx := strings.Index(rest, "/"); slashIdx != -1 {
-		return rest[:slashIdx]
+	if vr.Enabled {
+		return true
 	}
-	return rest
+	for _, u := range vr.Users {
+		if username != "" && strings.EqualFold(u, username) {
+			return true
+		}
+	}
+	return false
 }
 
-// encodeProjectPath encodes a filesystem path the same way Claude Code does:
-// replaces both "/" and "_" with "-".
-func encodeProjectPath(path string) string {
-	s := strings.ReplaceAll(path, "/", "-")
-	s = strings.ReplaceAll(s, "_", "-")
-	return s
+// setVoiceReplyUser turns voice replies for a user's prompts on or off
+func setVoiceReplyUser(cfg *Config, username string, on bool) {
+	if cfg.VoiceReplies == nil {
+		cfg.VoiceReplies = &config.VoiceReplyConfig{}
+	}
+	var users []string
+	for _, u := range cfg.VoiceReplies.Users {
+		if !strings.EqualFold(u, username) {
+			users = append(users, u)
+		}
+	}
+	if on {
+		users = append(users, username)
+	}
+	cfg.VoiceReplies.Users = users
 }
 
-// resolveProjectPathFromTranscript finds the actual project path by matching
-// encoded transcript dir against progressively longer prefixes of cwd.
-// Claude Code encodes project paths by replacing "/" and "_" with "-".
-// Returns the project path if found, empty string otherwise.
-func resolveProjectPathFromTranscript(encodedProjectDir string, cwd string) string {
-	if encodedProjectDir == "" || cwd == "" {
-		return ""
+// voiceSynthesizer returns the configured text-to-speech
+func voiceSynthesizer(cfg *Config) (speech.Synthesizer, error) {
+	vr := cfg.VoiceReplies
+	if vr == nil || vr.Command == "" && vr.BaseURL == "" {
+		return nil, fmt.Errorf("no TTS configured (set voice_replies.command or voice_replies.base_url)")
 	}
-
-	// Normalize cwd (expand ~)
-	if strings.HasPrefix(cwd, "~") {
-		home, _ := os.UserHomeDir()
-		cwd = home + cwd[1:]
+	if vr.Command != "" {
+		return speech.Command{Cmd: vr.Command}, nil
 	}
-
-	// Split into segments and build path incrementally
-	segments := strings.Split(cwd, "/")
-	currentPath := ""
-	for _, segment := range segments {
-		if segment == "" {
-			continue
-		}
-		currentPath += "/" + segment
-
-		if encodeProjectPath(currentPath) == encodedProjectDir {
-			return currentPath
-		}
+	key := vr.APIKey
+	if key == "" {
+		key = os.Getenv("OPENAI_API_KEY")
 	}
+	return speech.HTTP{BaseURL: vr.BaseURL, APIKey: key, Model: vr.Model, Voice: vr.Voice}, nil
+}
 
-	return ""
+// lastPromptUser returns the Telegram username behind a topic's last prompt
+func lastPromptUser(topicID int64) string {
+	msgs, err := readHistory(topicID, 0, 1, "human")
+	if err != nil || len(msgs) == 0 {
+		return ""
+	}
+	return msgs[len(msgs)-1].Username
 }
 
-// logHook writes hook events to ~/.ccc/hooks.log for debugging
-func logHook(hookType string, format string, args ...interface{}) {
-	home, err := os.UserHomeDir()
-	if err != nil {
+// sendVoiceReply speaks a summary of a session's answer in its topic when
+// voice replies are on for it
+func sendVoiceReply(cfg *Config, sessionName string, topicID int64, response string) {
+	if !voiceRepliesWanted(cfg, cfg.Sessions[sessionName], lastPromptUser(topicID)) {
 		return
 	}
-
-	logDir := filepath.Join(home, ".ccc")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	maxChars := defaultVoiceReplyChars
+	if cfg.VoiceReplies != nil && cfg.VoiceReplies.MaxChars > 0 {
+		maxChars = cfg.VoiceReplies.MaxChars
+	}
+	if r := redactorFor(cfg); r != nil {
+		response, _ = r.Redact(response)
+	}
+	text := speech.Summarize(response, maxChars)
+	if text == "" {
 		return
 	}
 
-	logPath := filepath.Join(logDir, "hooks.log")
-	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	synth, err := voiceSynthesizer(cfg)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+		defer cancel()
+		var audio []byte
+		if audio, err = synth.Synthesize(ctx, text); err == nil {
+			if audio, err = speech.EncodeOpus(ctx, "", audio); err == nil {
+				err = sendVoice(cfg, cfg.GroupID, topicID, audio)
+			}
+		}
+	}
 	if err != nil {
-		return
+		logFor("voice").Error("voice reply failed", logging.SessionKey, sessionName, "error", err)
+		sendMessage(cfg, cfg.GroupID, topicID, fmt.Sprintf("⚠️ Voice reply failed: %v", err))
 	}
-	defer f.Close()
-
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	message := fmt.Sprintf(format, args...)
-	fmt.Fprintf(f, "[%s] [%s] %s\n", timestamp, hookType, message)
 }
 
-// forwardToServer forwards a message to the server in client mode
-// Returns true if forwarded (client mode), false otherwise
-func forwardToServer(config *Config, cwd string, transcriptPath string, message string) bool {
-	if config.Mode != "client" || config.Server == "" || config.HostName == "" {
-		return false
+// spawnVoiceReply runs sendVoiceReply in a detached "ccc voice-reply" so the
+// Stop hook doesn't wait for speech synthesis
+func spawnVoiceReply(cfg *Config, sessionName string, topicID int64, response string) {
+	if !voiceRepliesWanted(cfg, cfg.Sessions[sessionName], lastPromptUser(topicID)) {
+		return
 	}
-
-	// Extract encoded project dir from transcript path
-	projectDir := extractProjectDirFromTranscript(transcriptPath)
-
-	// Truncate message for log
-	logMsg := message
-	if len(logMsg) > 100 {
-		logMsg = logMsg[:100] + "..."
+	exe, err := os.Executable()
+	if err != nil {
+		logFor("voice").Warn("voice reply skipped", logging.SessionKey, sessionName, "error", err)
+		return
 	}
-	logHook("Forward", "server=%s cwd=%s project=%s msg=%s", config.Server, cwd, projectDir, logMsg)
-
-	// Forward to server via SSH
-	// Use base64 to safely encode the message
-	encoded := base64.StdEncoding.EncodeToString([]byte(message))
-	cmd := fmt.Sprintf("ccc --from=%s --cwd=%s --project=%s \"$(echo %s | base64 -d)\"",
-		shellQuote(config.HostName), shellQuote(cwd), shellQuote(projectDir), encoded)
-
-	fmt.Fprintf(os.Stderr, "hook: forwarding to server %s (project=%s)\n", config.Server, projectDir)
-	_, err := runSSH(config.Server, cmd, 10*time.Second)
+	f, err := os.CreateTemp("", "ccc-voice-*.txt")
 	if err != nil {
-		logHook("Forward", "ERROR: %v", err)
-		fmt.Fprintf(os.Stderr, "hook: forward error: %v\n", err)
-	} else {
-		logHook("Forward", "SUCCESS")
+		logFor("voice").Warn("voice reply skipped", logging.SessionKey, sessionName, "error", err)
+		return
 	}
-	return true
+	_, err = f.WriteString(response)
+	f.Close()
+	if err == nil {
+		cmd := exec.Command(exe, "voice-reply", sessionName, strconv.FormatInt(topicID, 10), f.Name())
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err = cmd.Start(); err == nil {
+			cmd.Process.Release()
+			return
+		}
+	}
+	os.Remove(f.Name())
+	logFor("voice").Warn("voice reply skipped", logging.SessionKey, sessionName, "error", err)
 }
 
-func handleHook() error {
-	logHook("Stop", "hook started")
+// expandPath expands ~ to home directory
+func expandPath(path string) string { return config.ExpandPath(path) }
 
-	config, err := loadConfig()
-	if err != nil {
-		logHook("Stop", "ERROR: no config")
-		fmt.Fprintf(os.Stderr, "hook: no config\n")
-		return nil
-	}
+// SSH utilities for remote host operations
 
-	// Read hook data from stdin
-	var hookData HookData
-	decoder := json.NewDecoder(os.Stdin)
-	if err := decoder.Decode(&hookData); err != nil {
-		logHook("Stop", "ERROR: decode error: %v", err)
-		fmt.Fprintf(os.Stderr, "hook: decode error: %v\n", err)
-		return nil
-	}
+const (
+	sshConnectTimeout = 5  // seconds
+	sshCommandTimeout = 10 // seconds
+)
 
-	logHook("Stop", "cwd=%s transcript=%s", hookData.Cwd, hookData.TranscriptPath)
-	fmt.Fprintf(os.Stderr, "hook: cwd=%s transcript=%s\n", hookData.Cwd, hookData.TranscriptPath)
+// runSSH executes a command on a remote host via SSH
+func runSSH(address string, command string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	// Delay to allow transcript file to be fully written
-	// (race condition: hook fires before final message is flushed to disk)
-	time.Sleep(2 * time.Second)
+	start := time.Now()
+	result := "error"
+	defer func() { sshDuration.Observe(time.Since(start).Seconds(), address, result) }()
 
-	// Read last message from transcript
-	lastMessage := "Session ended"
-	if hookData.TranscriptPath != "" {
-		if msg := getLastAssistantMessage(hookData.TranscriptPath); msg != "" {
-			lastMessage = msg
-		}
-	}
+	// Wrap command in interactive login shell for full environment (nvm, etc.)
+	wrappedCmd := fmt.Sprintf("bash -i -l -c %s", shellQuote(command))
 
-	// Truncate message for log (first 100 chars)
-	logMsg := lastMessage
-	if len(logMsg) > 100 {
-		logMsg = logMsg[:100] + "..."
-	}
-	logHook("Stop", "message=%s", logMsg)
+	cmd := exec.CommandContext(ctx, "ssh",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout),
+		address,
+		wrappedCmd,
+	)
 
-	// In client mode, forward to server
-	if forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, lastMessage) {
-		logHook("Stop", "forwarded to server %s", config.Server)
-		return nil
-	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 
-	// Find session by matching cwd with saved path
-	// Prefer local sessions (Host=="") over remote sessions with same path
-	var sessionName string
-	var topicID int64
-	var foundRemote string // Track remote match in case no local match
-	var remoteTopicID int64
-	for name, info := range config.Sessions {
-		if info == nil || info.Deleted {
-			continue
-		}
-		// Match against saved path, subdirectories of saved path, or suffix
-		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
-			if info.Host == "" {
-				// Local session - use immediately
-				sessionName = name
-				topicID = info.TopicID
-				break
-			} else if foundRemote == "" {
-				// Remote session - save as fallback
-				foundRemote = name
-				remoteTopicID = info.TopicID
-			}
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("timeout after %v", timeout)
+	}
+	if err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg != "" {
+			return "", fmt.Errorf("%s: %s", err, errMsg)
 		}
+		return "", err
 	}
-	// Use remote match if no local match found
-	if sessionName == "" && foundRemote != "" {
-		sessionName = foundRemote
-		topicID = remoteTopicID
+
+	result = "ok"
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// scpToHost copies a file to a remote host via scp
+func scpToHost(address string, localPath string, remotePath string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "scp",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", fmt.Sprintf("ConnectTimeout=%d", sshConnectTimeout),
+		localPath,
+		address+":"+remotePath,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout after %v", timeout)
 	}
-	if sessionName == "" || config.GroupID == 0 {
-		logHook("Stop", "ERROR: no session found for cwd=%s", hookData.Cwd)
-		fmt.Fprintf(os.Stderr, "hook: no session found for cwd=%s\n", hookData.Cwd)
-		return nil
+	if err != nil {
+		errMsg := strings.TrimSpace(stderr.String())
+		if errMsg != "" {
+			return fmt.Errorf("%s: %s", err, errMsg)
+		}
+		return err
 	}
 
-	logHook("Stop", "session=%s topic=%d, sending to telegram", sessionName, topicID)
-	fmt.Fprintf(os.Stderr, "hook: session=%s topic=%d\n", sessionName, topicID)
-	fmt.Fprintf(os.Stderr, "hook: sending message to telegram\n")
+	return nil
+}
 
-	// Stop typing indicator for this session
-	stopContinuousTyping(sessionName)
+// shellQuote quotes a string for safe shell usage
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
+}
 
-	// Store Claude's response in history
-	appendHistory(topicID, HistoryMessage{
-		ID:        nextMessageID(),
-		Timestamp: time.Now().Unix(),
-		From:      "claude",
-		Text:      lastMessage,
-	})
+// sshCheckConnection verifies SSH connection to a host
+func sshCheckConnection(address string) error {
+	_, err := runSSH(address, "echo ok", time.Duration(sshConnectTimeout+2)*time.Second)
+	return err
+}
 
-	return sendMessage(config, config.GroupID, topicID, fmt.Sprintf("✅ %s\n\n%s", sessionName, lastMessage))
+// sshCheckCommand checks if a command exists on remote host, returns path
+func sshCheckCommand(address string, cmdName string) (string, error) {
+	return runSSH(address, "which "+cmdName, time.Duration(sshCommandTimeout)*time.Second)
 }
 
-func handlePermissionHook() error {
-	// Recover from any panic - hooks must never crash
-	defer func() {
-		recover()
-	}()
+// sshResolvePath resolves a path on remote host (expands ~, gets absolute path)
+func sshResolvePath(address string, path string) (string, error) {
+	// Use eval to expand ~ and readlink to get absolute path
+	cmd := fmt.Sprintf("eval echo %s | xargs readlink -f 2>/dev/null || eval echo %s", path, path)
+	return runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
+}
 
-	// Read stdin with timeout
-	stdinData := make(chan []byte, 1)
-	go func() {
-		defer func() { recover() }()
-		data, _ := io.ReadAll(os.Stdin)
-		stdinData <- data
-	}()
+// sshMkdir creates a directory on remote host
+func sshMkdir(address string, path string) error {
+	// Use eval to expand ~ in path
+	cmd := fmt.Sprintf("mkdir -p \"$(eval echo %s)\"", shellQuote(path))
+	_, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
+	return err
+}
 
-	var rawData []byte
-	select {
-	case rawData = <-stdinData:
-	case <-time.After(2 * time.Second):
-		return nil // Timeout, exit silently
-	}
+// sshDirExists checks if a directory exists on remote host
+func sshDirExists(address string, path string) bool {
+	// Use eval to expand ~ in path
+	cmd := fmt.Sprintf("test -d \"$(eval echo %s)\"", shellQuote(path))
+	_, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second)
+	return err == nil
+}
 
-	if len(rawData) == 0 {
-		return nil
-	}
+// sshTmuxHasSession checks if a tmux session exists on remote host
+func sshTmuxHasSession(address string, sessionName string) bool {
+	_, err := runSSH(address, "tmux has-session -t "+shellQuote(sessionName), time.Duration(sshCommandTimeout)*time.Second)
+	return err == nil
+}
 
-	// Parse JSON - ignore errors
-	var hookData HookData
-	if err := json.Unmarshal(rawData, &hookData); err != nil {
-		return nil
+// sshTmuxNewSession creates a new tmux session on remote host
+func sshTmuxNewSession(address string, name string, workDir string, continueSession bool) error {
+	// Create session
+	cmd := fmt.Sprintf("tmux new-session -d -s %s -c %s", shellQuote(name), shellQuote(workDir))
+	if _, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
+		return fmt.Errorf("failed to create tmux session: %w", err)
 	}
 
-	// Load config - ignore errors
-	config, err := loadConfig()
-	if err != nil || config == nil {
-		return nil
-	}
+	// Enable mouse mode
+	runSSH(address, fmt.Sprintf("tmux set-option -t %s mouse on", shellQuote(name)), time.Duration(sshCommandTimeout)*time.Second)
 
-	// In client mode, forward to server
-	if config.Mode == "client" && config.Server != "" && config.HostName != "" {
-		if hookData.ToolName == "AskUserQuestion" && len(hookData.ToolInput.Questions) > 0 {
-			for _, q := range hookData.ToolInput.Questions {
-				if q.Question == "" {
-					continue
-				}
-				msg := fmt.Sprintf("❓ %s\n\n%s", q.Header, q.Question)
-				for i, opt := range q.Options {
-					msg += fmt.Sprintf("\n%d. %s", i+1, opt.Label)
-					if opt.Description != "" {
-						msg += fmt.Sprintf(" — %s", opt.Description)
-					}
-				}
-				forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg)
-			}
-			return nil
-		}
-		if hookData.ToolName == "ExitPlanMode" {
-			planText := readLatestPlanFile(hookData.Cwd)
-			if planText == "" {
-				planText = "(plan file not found)"
-			}
-			if len(planText) > 3900 {
-				planText = planText[:3900] + "\n\n... (truncated)"
-			}
-			msg := fmt.Sprintf("📋 Plan ready:\n\n%s", planText)
-			forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg)
-			return nil
-		}
+	// Start claude
+	claudeCmd := "claude --dangerously-skip-permissions"
+	if continueSession {
+		claudeCmd += " -c"
 	}
-
-	// Find session by matching cwd
-	var sessionName string
-	var topicID int64
-	for name, info := range config.Sessions {
-		if name == "" || info == nil {
-			continue
-		}
-		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
-			sessionName = name
-			topicID = info.TopicID
-			break
-		}
+	sendCmd := fmt.Sprintf("tmux send-keys -t %s %s C-m", shellQuote(name), shellQuote(claudeCmd))
+	if _, err := runSSH(address, sendCmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
+		return err
 	}
 
-	if sessionName == "" || config.GroupID == 0 {
-		return nil
-	}
+	// Confirm bypass permissions prompt (first run only)
+	// Default is "No, exit" so we need Down arrow to select "Yes", then Enter
+	// Down is safer than Up - if no prompt exists, Down does nothing
+	time.Sleep(2 * time.Second)
+	// Send Down arrow first
+	downCmd := fmt.Sprintf("tmux send-keys -t %s Down", shellQuote(name))
+	runSSH(address, downCmd, time.Duration(sshCommandTimeout)*time.Second)
+	time.Sleep(100 * time.Millisecond)
+	// Then send Enter
+	enterCmd := fmt.Sprintf("tmux send-keys -t %s Enter", shellQuote(name))
+	runSSH(address, enterCmd, time.Duration(sshCommandTimeout)*time.Second)
 
-	// Handle AskUserQuestion — send inline keyboard buttons to Telegram
-	logHook("Permission", "tool=%s session=%s questions=%d", hookData.ToolName, sessionName, len(hookData.ToolInput.Questions))
-	if hookData.ToolName == "AskUserQuestion" && len(hookData.ToolInput.Questions) > 0 {
-		totalQuestions := len(hookData.ToolInput.Questions)
+	return nil
+}
 
-		// Store in pending questions for API access
-		pqs := &PendingQuestionSet{
-			Session:   sessionName,
-			Timestamp: time.Now().Unix(),
-			TopicID:   topicID,
-		}
-		for _, q := range hookData.ToolInput.Questions {
-			pq := PendingQuestion{
-				Question:    q.Question,
-				Header:      q.Header,
-				MultiSelect: q.MultiSelect,
-			}
-			for _, opt := range q.Options {
-				pq.Options = append(pq.Options, PendingQuestionOption{
-					Label:       opt.Label,
-					Description: opt.Description,
-				})
-			}
-			pqs.Questions = append(pqs.Questions, pq)
-		}
-		pendingQuestions.Store(sessionName, pqs)
+// sshTmuxSendKeys sends text to a tmux session on remote host using Base64
+func sshTmuxSendKeys(address string, sessionName string, text string) error {
+	// Encode text as Base64 to avoid escaping issues
+	encoded := base64.StdEncoding.EncodeToString([]byte(text))
 
-		go func() {
-			defer func() { recover() }()
-			for qIdx, q := range hookData.ToolInput.Questions {
-				if q.Question == "" {
-					continue
-				}
-				// Build message with option descriptions
-				msg := fmt.Sprintf("❓ %s\n\n%s", q.Header, q.Question)
-
-				// Build inline keyboard buttons
-				var buttons [][]InlineKeyboardButton
-				for i, opt := range q.Options {
-					if opt.Label == "" {
-						continue
-					}
-					// Callback data format: session:questionIndex:totalQuestions:optionIndex
-					// Telegram limits callback_data to 64 bytes
-					callbackData := fmt.Sprintf("%s:%d:%d:%d", sessionName, qIdx, totalQuestions, i)
-					if len(callbackData) > 64 {
-						callbackData = callbackData[:64]
-					}
-					label := opt.Label
-					if opt.Description != "" {
-						label += " — " + opt.Description
-					}
-					// Telegram button label max ~200 chars
-					if len(label) > 120 {
-						label = label[:117] + "..."
-					}
-					buttons = append(buttons, []InlineKeyboardButton{
-						{Text: label, CallbackData: callbackData},
-					})
-				}
+	// Decode on remote and send to tmux
+	cmd := fmt.Sprintf(
+		"echo %s | base64 -d | xargs -0 tmux send-keys -t %s -l",
+		encoded, shellQuote(sessionName),
+	)
+	if _, err := runSSH(address, cmd, time.Duration(sshCommandTimeout)*time.Second); err != nil {
+		return err
+	}
 
-				if len(buttons) > 0 {
-					sendMessageWithKeyboard(config, config.GroupID, topicID, msg, buttons)
-				}
+	// Always wait 2 seconds before Enter to ensure text is fully processed
+	// Without this delay, Enter may be interpreted as newline instead of submit
+	time.Sleep(2 * time.Second)
 
-				// Store question in history
-				appendHistory(topicID, HistoryMessage{
-					ID:        nextMessageID(),
-					Timestamp: time.Now().Unix(),
-					From:      "claude",
-					Text:      msg,
-				})
-			}
-		}()
-		return nil
-	}
+	// Send Enter twice (Claude needs double Enter)
+	enterCmd := fmt.Sprintf(
+		"tmux send-keys -t %s C-m && sleep 0.05 && tmux send-keys -t %s C-m",
+		shellQuote(sessionName), shellQuote(sessionName),
+	)
+	_, err := runSSH(address, enterCmd, time.Duration(sshCommandTimeout)*time.Second)
+	return err
+}
 
-	// Handle ExitPlanMode — read plan file and forward to Telegram
-	if hookData.ToolName == "ExitPlanMode" {
-		go func() {
-			defer func() { recover() }()
-			planText := readLatestPlanFile(hookData.Cwd)
-			if planText == "" {
-				sendMessage(config, config.GroupID, topicID, "📋 Plan mode completed (plan file not found)")
-				return
-			}
-			// Truncate to Telegram's 4096 char limit (leave room for header)
-			if len(planText) > 3900 {
-				planText = planText[:3900] + "\n\n... (truncated)"
-			}
-			msg := fmt.Sprintf("📋 Plan ready:\n\n%s", planText)
-			sendMessage(config, config.GroupID, topicID, msg)
-			// Store in history
-			appendHistory(topicID, HistoryMessage{
-				ID:        nextMessageID(),
-				Timestamp: time.Now().Unix(),
-				From:      "claude",
-				Text:      msg,
-			})
-		}()
-		return nil
-	}
+// sshTmuxKillSession kills a tmux session on remote host
+func sshTmuxKillSession(address string, sessionName string) error {
+	_, err := runSSH(address, "tmux kill-session -t "+shellQuote(sessionName), time.Duration(sshCommandTimeout)*time.Second)
+	return err
+}
 
-	return nil
+// sshRunCommand executes an arbitrary command on remote host (for /rc)
+func sshRunCommand(address string, command string, timeout time.Duration) (string, error) {
+	return runSSH(address, command, timeout)
 }
 
-// getLastAssistantMessage reads a Claude Code transcript JSONL file and extracts
-// text blocks from the last assistant turn (after the last real user message).
-// Handles both nested (message.content) and flat (root-level content) JSONL formats.
-// Deduplicates streaming entries by requestId (last entry per requestId wins).
-func getLastAssistantMessage(transcriptPath string) string {
-	file, err := os.Open(transcriptPath)
-	if err != nil {
-		logHook("Parse", "failed to open transcript: %v", err)
-		return ""
-	}
-	defer file.Close()
+// Session name parsing utilities
 
-	// Typed structs for parsing JSONL entries
-	type contentBlock struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+// parseSessionTarget parses "host:name" or "name" format
+// Returns (host, name) where host is empty for local sessions
+func parseSessionTarget(input string) (host string, name string) {
+	// Check for host:name format
+	// But be careful: ~/path and /path are not host prefixes
+	if strings.HasPrefix(input, "~/") || strings.HasPrefix(input, "/") {
+		return "", input
 	}
 
-	type transcriptLine struct {
-		Type      string          `json:"type"`
-		RequestID string          `json:"requestId,omitempty"`
-		Role      string          `json:"role,omitempty"`
-		Content   json.RawMessage `json:"content,omitempty"`
-		Message   struct {
-			Role    string          `json:"role"`
-			Content json.RawMessage `json:"content"`
-		} `json:"message"`
+	idx := strings.Index(input, ":")
+	if idx > 0 {
+		host = input[:idx]
+		name = input[idx+1:]
+		return host, name
 	}
 
-	type parsedEntry struct {
-		entryType string
-		requestID string
-		role      string
-		content   json.RawMessage
+	return "", input
+}
+
+// fullSessionName creates full session name from host and name
+func fullSessionName(host string, name string) string {
+	if host == "" {
+		return name
 	}
+	return host + ":" + name
+}
 
-	var entries []parsedEntry
-	var linesProcessed int
-	scanner := bufio.NewScanner(file)
-	// 16MB buffer for large lines (transcripts with images/PDFs)
-	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+// getHostAddress returns SSH address for a host, or empty if local/not found
+func getHostAddress(cfg *Config, hostName string) string { return config.GetHostAddress(cfg, hostName) }
 
-	for scanner.Scan() {
-		linesProcessed++
-		line := scanner.Bytes()
-		if len(line) == 0 {
-			continue
-		}
-		var tl transcriptLine
-		if json.Unmarshal(line, &tl) != nil {
-			continue
-		}
-		// Use nested message fields if present, otherwise fall back to root-level
-		role := tl.Message.Role
-		content := tl.Message.Content
-		if role == "" {
-			role = tl.Role
+// getHostProjectsDir returns projects dir for a host
+func getHostProjectsDir(cfg *Config, hostName string) string { return config.GetHostProjectsDir(cfg, hostName) }
+
+// resolveSessionPath resolves project path for a session
+// For local: uses config.ProjectsDir
+// For remote: uses host's projects_dir and resolves via SSH
+func resolveSessionPath(config *Config, hostName string, nameOrPath string) (string, error) {
+	// Check if it's already an absolute or home-relative path
+	if strings.HasPrefix(nameOrPath, "/") || strings.HasPrefix(nameOrPath, "~/") {
+		if hostName == "" {
+			// Local: expand ~ and return
+			return expandPath(nameOrPath), nil
 		}
-		if len(content) == 0 {
-			content = tl.Content
+		// Remote: resolve via SSH
+		address := getHostAddress(config, hostName)
+		if address == "" {
+			return "", fmt.Errorf("host '%s' not found", hostName)
 		}
-		entries = append(entries, parsedEntry{
-			entryType: tl.Type,
-			requestID: tl.RequestID,
-			role:      role,
-			content:   content,
-		})
-	}
-	if err := scanner.Err(); err != nil {
-		logHook("Parse", "scanner error after %d lines: %v", linesProcessed, err)
-	}
-	if len(entries) == 0 {
-		return ""
+		return sshResolvePath(address, nameOrPath)
 	}
 
-	// Find the last real user message (not a tool_result)
-	lastUserIdx := -1
-	for i := len(entries) - 1; i >= 0; i-- {
-		e := entries[i]
-		if e.entryType != "user" && e.role != "user" {
-			continue
-		}
-		// Check if content is a tool_result
-		if isToolResultContent(e.content) {
-			continue
-		}
-		lastUserIdx = i
-		break
+	// Relative name - use projects_dir
+	projectsDir := getHostProjectsDir(config, hostName)
+	fullPath := filepath.Join(projectsDir, nameOrPath)
+
+	if hostName == "" {
+		// Local
+		return expandPath(fullPath), nil
 	}
 
-	// Collect assistant text blocks after the last user message.
-	// Streaming dedup: same requestId may have multiple entries with progressively
-	// updated text; for each requestId, the last entry's text blocks win.
-	startIdx := lastUserIdx + 1
-	if lastUserIdx < 0 {
-		startIdx = 0
+	// Remote: resolve via SSH
+	address := getHostAddress(config, hostName)
+	if address == "" {
+		return "", fmt.Errorf("host '%s' not found", hostName)
 	}
+	return sshResolvePath(address, fullPath)
+}
 
-	reqTexts := make(map[string][]string) // requestId -> text blocks from last entry
-	var orderedKeys []string              // preserve order of first appearance
-	var noIDTexts []string                // texts from entries without requestId
+// extractProjectName extracts project name from path
+func extractProjectName(path string) string {
+	return filepath.Base(path)
+}
 
-	for i := startIdx; i < len(entries); i++ {
-		e := entries[i]
-		if e.entryType != "assistant" && e.role != "assistant" {
-			continue
-		}
+// tmuxSessionName returns a safe tmux session name for a project
+// Replaces dots with underscores because tmux 3.5+ interprets dots as window/pane separators
+func tmuxSessionName(name string) string {
+	safeName := strings.ReplaceAll(name, ".", "_")
+	return "claude-" + safeName
+}
 
-		var blocks []contentBlock
-		if json.Unmarshal(e.content, &blocks) != nil {
-			continue
-		}
+func createForumTopic(config *Config, name string) (int64, error) {
+	if config.GroupID == 0 {
+		return 0, fmt.Errorf("no group configured. Add bot to a group with topics enabled and run: ccc setgroup")
+	}
 
-		var entryTexts []string
-		for _, b := range blocks {
-			if b.Type != "text" {
-				continue
-			}
-			text := strings.TrimSpace(b.Text)
-			if text != "" && text != "(no content)" {
-				entryTexts = append(entryTexts, text)
-			}
-		}
-		if len(entryTexts) == 0 {
-			continue
-		}
+	params := url.Values{
+		"chat_id": {fmt.Sprintf("%d", config.GroupID)},
+		"name":    {name},
+	}
 
-		if e.requestID == "" {
-			noIDTexts = append(noIDTexts, entryTexts...)
-		} else {
-			if _, seen := reqTexts[e.requestID]; !seen {
-				orderedKeys = append(orderedKeys, e.requestID)
-			}
-			reqTexts[e.requestID] = entryTexts // last entry with same requestId wins
-		}
+	result, err := telegramAPI(config, "createForumTopic", params)
+	if err != nil {
+		return 0, err
+	}
+	if !result.OK {
+		return 0, fmt.Errorf("failed to create topic: %s", result.Description)
 	}
 
-	var allTexts []string
-	for _, key := range orderedKeys {
-		allTexts = append(allTexts, reqTexts[key]...)
+	var topic TopicResult
+	if err := json.Unmarshal(result.Result, &topic); err != nil {
+		return 0, fmt.Errorf("failed to parse topic result: %w", err)
 	}
-	allTexts = append(allTexts, noIDTexts...)
 
-	logHook("Parse", "processed %d lines, %d entries, %d text blocks since last user msg", linesProcessed, len(entries), len(allTexts))
-	return strings.Join(allTexts, "\n\n")
+	return topic.MessageThreadID, nil
 }
 
-// readLatestPlanFile finds and reads the most recently modified plan file.
-// Claude Code stores plans in ~/.claude/plans/<slug>.md
-func readLatestPlanFile(cwd string) string {
-	homeDir, err := os.UserHomeDir()
+// editForumTopic renames a topic and verifies it exists
+func editForumTopic(config *Config, topicID int64, name string) error {
+	if config.GroupID == 0 {
+		return fmt.Errorf("no group configured")
+	}
+
+	params := url.Values{
+		"chat_id":           {fmt.Sprintf("%d", config.GroupID)},
+		"message_thread_id": {fmt.Sprintf("%d", topicID)},
+		"name":              {name},
+	}
+
+	result, err := telegramAPI(config, "editForumTopic", params)
 	if err != nil {
-		return ""
+		return err
 	}
-	planDir := filepath.Join(homeDir, ".claude", "plans")
-	files, err := filepath.Glob(filepath.Join(planDir, "*.md"))
-	if err != nil || len(files) == 0 {
-		return ""
+	if !result.OK {
+		return fmt.Errorf("failed to edit topic: %s", result.Description)
 	}
 
-	// Find the most recently modified file
-	var newest string
-	var newestTime time.Time
-	for _, f := range files {
-		info, err := os.Stat(f)
-		if err != nil {
-			continue
-		}
-		if info.ModTime().After(newestTime) {
-			newestTime = info.ModTime()
-			newest = f
-		}
+	return nil
+}
+
+// deleteForumTopic deletes a topic
+func deleteForumTopic(config *Config, topicID int64) error {
+	if config.GroupID == 0 {
+		return fmt.Errorf("no group configured")
 	}
 
-	if newest == "" || time.Since(newestTime) > 5*time.Minute {
-		return "" // Too old, probably not from the current session
+	params := url.Values{
+		"chat_id":           {fmt.Sprintf("%d", config.GroupID)},
+		"message_thread_id": {fmt.Sprintf("%d", topicID)},
 	}
 
-	data, err := os.ReadFile(newest)
+	result, err := telegramAPI(config, "deleteForumTopic", params)
 	if err != nil {
-		return ""
+		return err
 	}
-	return strings.TrimSpace(string(data))
+	if !result.OK {
+		return fmt.Errorf("failed to delete topic: %s", result.Description)
+	}
+
+	return nil
 }
 
-// isToolResultContent checks if a JSONL content field contains tool_result entries
-func isToolResultContent(content json.RawMessage) bool {
-	if len(content) == 0 {
-		return false
-	}
-	var blocks []struct {
-		Type string `json:"type"`
-	}
-	if json.Unmarshal(content, &blocks) == nil {
-		for _, b := range blocks {
-			if b.Type == "tool_result" {
-				return true
-			}
-		}
-	}
-	return false
+// closeForumTopic closes a topic; it stays readable but takes no new messages
+func closeForumTopic(config *Config, topicID int64) error {
+	return forumTopicAction(config, "closeForumTopic", topicID)
 }
 
-func handlePromptHook() error {
-	config, err := loadConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "hook-prompt: no config\n")
-		return nil
-	}
+// reopenForumTopic reopens a closed topic
+func reopenForumTopic(config *Config, topicID int64) error {
+	return forumTopicAction(config, "reopenForumTopic", topicID)
+}
 
-	var hookData HookData
-	decoder := json.NewDecoder(os.Stdin)
-	if err := decoder.Decode(&hookData); err != nil {
-		fmt.Fprintf(os.Stderr, "hook-prompt: decode error: %v\n", err)
-		return nil
+func forumTopicAction(config *Config, method string, topicID int64) error {
+	if config.GroupID == 0 {
+		return fmt.Errorf("no group configured")
 	}
 
-	if hookData.Prompt == "" {
-		fmt.Fprintf(os.Stderr, "hook-prompt: empty prompt\n")
-		return nil
+	params := url.Values{
+		"chat_id":           {fmt.Sprintf("%d", config.GroupID)},
+		"message_thread_id": {fmt.Sprintf("%d", topicID)},
 	}
 
-	// Prepare prompt message
-	prompt := hookData.Prompt
-	if len(prompt) > 500 {
-		prompt = prompt[:500] + "..."
+	result, err := telegramAPI(config, method, params)
+	if err != nil {
+		return err
 	}
-
-	// In client mode, forward to server
-	if forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, fmt.Sprintf("💬 %s", prompt)) {
-		return nil
+	if !result.OK {
+		return fmt.Errorf("%s failed: %s", method, result.Description)
 	}
 
-	// Find session by matching cwd suffix
-	var topicID int64
-	for name, info := range config.Sessions {
-		if info == nil {
-			continue
+	return nil
+}
+
+// getOrCreateTopic finds existing topic or creates new one
+// Also syncs topic name and updates path if changed
+func getOrCreateTopic(config *Config, fullName string, path string, host string) (int64, error) {
+	// Check if session exists in config (including deleted)
+	if info, exists := config.Sessions[fullName]; exists {
+		archived := info.Archived
+		if archived {
+			unarchiveSession(config, info)
 		}
-		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
-			topicID = info.TopicID
-			break
+		// Try to rename topic to verify it exists and sync name
+		err := editForumTopic(config, info.TopicID, fullName)
+		if err != nil {
+			errStr := err.Error()
+			// Check if error indicates topic doesn't exist vs just "not modified"
+			if strings.Contains(errStr, "not found") || strings.Contains(errStr, "TOPIC_CLOSED") ||
+				strings.Contains(errStr, "TOPIC_DELETED") || strings.Contains(errStr, "invalid") {
+				// Topic was deleted by user, create new one
+				fmt.Fprintf(os.Stderr, "Topic %d gone, creating new: %v\n", info.TopicID, err)
+				topicID, err := createForumTopic(config, fullName)
+				if err != nil {
+					return 0, err
+				}
+				info.TopicID = topicID
+			}
+			// Otherwise (e.g., "not modified"), topic exists - just continue
 		}
+		// Update path and undelete
+		topicID := info.TopicID
+		updateSession(config, fullName, func(info *SessionInfo) {
+			info.TopicID = topicID
+			info.Path = path
+			info.Deleted = false
+			if archived {
+				info.Archived = false
+			}
+		})
+		return info.TopicID, nil
 	}
 
-	if topicID == 0 || config.GroupID == 0 {
-		fmt.Fprintf(os.Stderr, "hook-prompt: no topic found for cwd=%s\n", hookData.Cwd)
-		return nil
-	}
-
-	// Check if this prompt was just sent from Telegram (cooldown 10s)
-	if wasTelegramSent(topicID) {
-		fmt.Fprintf(os.Stderr, "hook-prompt: skipping (telegram cooldown) topic=%d\n", topicID)
-		return nil
+	// Create new topic
+	topicID, err := createForumTopic(config, fullName)
+	if err != nil {
+		return 0, err
 	}
 
-	// This is a locally-typed prompt — save to history
-	appendHistory(topicID, HistoryMessage{
-		ID:        nextMessageID(),
-		Timestamp: time.Now().Unix(),
-		From:      "human",
-		Text:      hookData.Prompt,
-		Username:  "terminal",
+	// Save to config
+	addSession(config, fullName, &SessionInfo{
+		TopicID: topicID,
+		Path:    path,
+		Host:    host,
+		Deleted: false,
 	})
 
-	// Send typing action
-	sendTypingAction(config, config.GroupID, topicID)
-
-	fmt.Fprintf(os.Stderr, "hook-prompt: sending local prompt to topic %d\n", topicID)
-	return sendMessage(config, config.GroupID, topicID, fmt.Sprintf("💬 %s", prompt))
+	return topicID, nil
 }
 
-func handleOutputHook() error {
-	config, err := loadConfig()
-	if err != nil {
-		return nil
-	}
+// Tmux session management
 
-	rawData, _ := io.ReadAll(os.Stdin)
-	if len(rawData) == 0 {
-		return nil
-	}
+var (
+	tmuxSocket string
+	tmuxPath   string
+	cccPath    string
+	claudePath string
+)
 
-	var hookData HookData
-	if err := json.Unmarshal(rawData, &hookData); err != nil {
-		return nil
-	}
+func init() {
+	// Find tmux socket path using current UID
+	// macOS uses /private/tmp, Linux uses /tmp
+	uid := os.Getuid()
+	macOSSocket := fmt.Sprintf("/private/tmp/tmux-%d/default", uid)
+	linuxSocket := fmt.Sprintf("/tmp/tmux-%d/default", uid)
 
-	// Skip certain tools that don't produce interesting output
-	skipTools := map[string]bool{
-		"Read": true, "Glob": true, "Grep": true, "LSP": true,
-		"TodoWrite": true, "Task": true, "TaskOutput": true,
-	}
-	if skipTools[hookData.ToolName] {
-		return nil
+	// Check which socket exists, prefer Linux path first (more common in headless)
+	if _, err := os.Stat(linuxSocket); err == nil {
+		tmuxSocket = linuxSocket
+	} else if _, err := os.Stat(macOSSocket); err == nil {
+		tmuxSocket = macOSSocket
+	} else {
+		// Default based on OS
+		if _, err := os.Stat("/private"); err == nil {
+			tmuxSocket = macOSSocket
+		} else {
+			tmuxSocket = linuxSocket
+		}
 	}
 
-	// Get last message from transcript
-	var msg string
-	if hookData.TranscriptPath != "" {
-		msg = getLastAssistantMessage(hookData.TranscriptPath)
-	}
-	if msg == "" {
-		return nil
-	}
-
-	// Cap at 20000 chars (~5 Telegram messages); sendMessage handles splitting
-	if len(msg) > 20000 {
-		msg = msg[:20000] + "\n\n... (truncated)"
+	// Find tmux binary
+	if path, err := exec.LookPath("tmux"); err == nil {
+		tmuxPath = path
+	} else {
+		// Fallback paths for common installations
+		for _, p := range []string{"/opt/homebrew/bin/tmux", "/usr/local/bin/tmux", "/usr/bin/tmux"} {
+			if _, err := os.Stat(p); err == nil {
+				tmuxPath = p
+				break
+			}
+		}
 	}
 
-	// In client mode, forward to server
-	if forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg) {
-		return nil
+	// Find ccc binary (self)
+	if exe, err := os.Executable(); err == nil {
+		cccPath = exe
 	}
 
-	// Find session
-	var sessionName string
-	var topicID int64
-	for name, info := range config.Sessions {
-		if info == nil {
-			continue
+	// Find claude binary - first try PATH, then fallback paths
+	if path, err := exec.LookPath("claude"); err == nil {
+		claudePath = path
+	} else {
+		home, _ := os.UserHomeDir()
+		claudePaths := []string{
+			filepath.Join(home, ".claude", "local", "claude"),
+			"/usr/local/bin/claude",
 		}
-		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
-			sessionName = name
-			topicID = info.TopicID
-			break
+		for _, p := range claudePaths {
+			if _, err := os.Stat(p); err == nil {
+				claudePath = p
+				break
+			}
 		}
 	}
+}
 
-	if topicID == 0 || config.GroupID == 0 || sessionName == "" {
-		return nil
+// markTelegramSent creates a marker file indicating a message was just sent
+// from Telegram to this topic's tmux session. Used by hook-prompt to avoid
+// echoing Telegram-originated messages back to Telegram.
+func markTelegramSent(topicID int64) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
 	}
+	dir := filepath.Join(home, ".ccc", "telegram-sent")
+	os.MkdirAll(dir, 0755)
+	marker := filepath.Join(dir, fmt.Sprintf("%d", topicID))
+	os.WriteFile(marker, nil, 0644)
+}
 
-	// Check cache to avoid duplicate messages
-	cacheFile := filepath.Join(os.TempDir(), "ccc-cache-"+sessionName)
-	lastSent, _ := os.ReadFile(cacheFile)
-	if string(lastSent) == msg {
-		return nil // Skip duplicate
+// wasTelegramSent checks if a message was sent from Telegram to this topic
+// within the cooldown period (10 seconds).
+func wasTelegramSent(topicID int64) bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
 	}
-	os.WriteFile(cacheFile, []byte(msg), 0600)
+	marker := filepath.Join(home, ".ccc", "telegram-sent", fmt.Sprintf("%d", topicID))
+	info, err := os.Stat(marker)
+	if err != nil {
+		return false
+	}
+	return time.Since(info.ModTime()) < 10*time.Second
+}
 
-	sendMessage(config, config.GroupID, topicID, msg)
-	return nil
+// tmuxVerbose returns true if CCC_TMUX_VERBOSE env is set
+func tmuxVerbose() bool {
+	return os.Getenv("CCC_TMUX_VERBOSE") != ""
 }
 
-// handleQuestionHook is a legacy alias for handlePermissionHook.
-// Kept for backward compatibility with old hook registrations.
-func handleQuestionHook() error {
-	return handlePermissionHook()
+// tmuxBaseArgs returns base tmux arguments including socket and optional verbose flag
+func tmuxBaseArgs() []string {
+	args := []string{"-S", tmuxSocket}
+	if tmuxVerbose() {
+		args = append([]string{"-v"}, args...)
+	}
+	return args
 }
 
-// Install hook in Claude settings
+// tmuxLogDir returns the directory for tmux verbose logs (~/.ccc/tmux-logs/)
+// and ensures it exists.
+func tmuxLogDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Join(home, ".ccc", "tmux-logs")
+	os.MkdirAll(dir, 0755)
+	return dir
+}
 
-// addHookToEvent adds a hook command to an event without overwriting existing hooks.
-// Returns true if the hook was added, false if it already exists.
-func addHookToEvent(hooks map[string]interface{}, eventName string, command string) bool {
-	// Get existing entries for this event
-	entries, ok := hooks[eventName].([]interface{})
-	if !ok {
-		// No entries for this event - create new
-		hooks[eventName] = []interface{}{
-			map[string]interface{}{
-				"matcher": "",
-				"hooks": []interface{}{
-					map[string]interface{}{
-						"type":    "command",
-						"command": command,
-					},
-				},
-			},
+// tmuxCmd creates an exec.Cmd for tmux with proper base args
+func tmuxCmd(cmdArgs ...string) *exec.Cmd {
+	args := append(tmuxBaseArgs(), cmdArgs...)
+	cmd := exec.Command(tmuxPath, args...)
+	cmd.Env = sessionEnviron()
+	if tmuxVerbose() {
+		if dir := tmuxLogDir(); dir != "" {
+			cmd.Dir = dir
 		}
-		return true
 	}
+	return cmd
+}
 
-	// Find entry with empty matcher (global hook)
-	for _, entry := range entries {
-		entryMap, ok := entry.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		matcher, _ := entryMap["matcher"].(string)
-		if matcher != "" {
-			continue
+// ensureTmuxServer ensures tmux server is running by checking if socket exists
+// If not, starts a new tmux server. This handles the case after system reboot
+// when tmux hasn't been started yet and socket doesn't exist.
+func ensureTmuxServer() error {
+	// Check if socket directory exists
+	socketDir := filepath.Dir(tmuxSocket)
+	if _, err := os.Stat(socketDir); os.IsNotExist(err) {
+		// Socket directory doesn't exist - create it with proper permissions (700)
+		if err := os.MkdirAll(socketDir, 0700); err != nil {
+			return fmt.Errorf("failed to create tmux socket directory: %w", err)
 		}
 
-		// Found global entry, check if hook already exists
-		hooksList, ok := entryMap["hooks"].([]interface{})
-		if !ok {
-			hooksList = []interface{}{}
+		// Start tmux server by creating and immediately killing a temporary session
+		cmd := tmuxCmd("new-session", "-d", "-s", "ccc-init")
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to start tmux server: %w", err)
 		}
+		// Kill the temporary session
+		tmuxCmd("kill-session", "-t", "ccc-init").Run()
 
-		for _, h := range hooksList {
-			hookMap, ok := h.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			if hookMap["command"] == command {
-				// Hook already exists
-				return false
-			}
+		if tmuxVerbose() {
+			fmt.Fprintf(os.Stderr, "tmux verbose logging enabled, logs in current directory\n")
 		}
+	}
+	return nil
+}
 
-		// Add hook to existing entry
-		hooksList = append(hooksList, map[string]interface{}{
-			"type":    "command",
-			"command": command,
-		})
-		entryMap["hooks"] = hooksList
-		return true
+// scrubTmuxEnvironment drops the secrets from the global environment of a
+// tmux server started with them and points the sessions at the hook relay
+func scrubTmuxEnvironment() {
+	for _, name := range []string{config.BotTokenEnv, config.PassphraseEnv} {
+		tmuxCmd("set-environment", "-g", "-u", name).Run()
+	}
+	for _, e := range sessionEnviron() {
+		if name, value, _ := strings.Cut(e, "="); name == container.RelayEnv {
+			tmuxCmd("set-environment", "-g", name, value).Run()
+		}
 	}
+}
 
-	// No global entry found - create new one
-	entries = append(entries, map[string]interface{}{
-		"matcher": "",
-		"hooks": []interface{}{
-			map[string]interface{}{
-				"type":    "command",
-				"command": command,
-			},
-		},
-	})
-	hooks[eventName] = entries
-	return true
+func tmuxSessionExists(name string) bool {
+	// Ensure tmux server is running first
+	if err := ensureTmuxServer(); err != nil {
+		return false
+	}
+	cmd := tmuxCmd("has-session", "-t", name)
+	return cmd.Run() == nil
 }
 
-func installHook() error {
-	home, _ := os.UserHomeDir()
-	claudeDir := filepath.Join(home, ".claude")
-	settingsPath := filepath.Join(claudeDir, "settings.json")
-	cccPath := filepath.Join(home, "bin", "ccc")
+func createTmuxSession(name string, workDir string, continueSession bool) error {
+	// Ensure tmux server is running (handles post-reboot case)
+	if err := ensureTmuxServer(); err != nil {
+		return err
+	}
 
-	// Create .claude directory if it doesn't exist
-	if err := os.MkdirAll(claudeDir, 0755); err != nil {
-		return fmt.Errorf("failed to create .claude directory: %w", err)
+	// Build the command to run inside tmux
+	cccCmd := cccPath + " run"
+	if continueSession {
+		cccCmd += " -c"
 	}
 
-	var settings map[string]interface{}
+	scrubTmuxEnvironment()
 
-	data, err := os.ReadFile(settingsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Create empty settings if file doesn't exist
-			settings = make(map[string]interface{})
-		} else {
-			return fmt.Errorf("failed to read settings.json: %w", err)
-		}
-	} else {
-		if err := json.Unmarshal(data, &settings); err != nil {
-			return fmt.Errorf("failed to parse settings.json: %w", err)
-		}
+	// Create tmux session with a login shell (don't run command directly - it kills session on exit)
+	cmd := tmuxCmd("new-session", "-d", "-s", name, "-c", workDir)
+	if err := cmd.Run(); err != nil {
+		return err
 	}
 
-	hooks, ok := settings["hooks"].(map[string]interface{})
-	if !ok {
-		hooks = make(map[string]interface{})
-	}
+	// Enable mouse mode for this session (allows scrolling)
+	tmuxCmd("set-option", "-t", name, "mouse", "on").Run()
 
-	// Add Stop hook (doesn't overwrite existing hooks)
-	stopAdded := addHookToEvent(hooks, "Stop", cccPath+" hook")
+	// Send the command to the session via send-keys (preserves TTY properly)
+	time.Sleep(200 * time.Millisecond)
+	tmuxCmd( "send-keys", "-t", name, cccCmd, "C-m").Run()
 
-	// Add PreToolUse hook for AskUserQuestion forwarding
-	// Use timeout 300000ms (5min) to allow time for user to answer via Telegram
-	preToolAdded := addHookToEvent(hooks, "PreToolUse", cccPath+" hook-permission")
-
-	settings["hooks"] = hooks
+	return nil
+}
 
-	newData, err := json.MarshalIndent(settings, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal settings: %w", err)
+// runClaudeRaw runs claude directly (used inside tmux sessions)
+func runClaudeRaw(continueSession bool) error {
+	if claudePath == "" {
+		return fmt.Errorf("claude binary not found")
 	}
 
-	if err := os.WriteFile(settingsPath, newData, 0600); err != nil {
-		return fmt.Errorf("failed to write settings.json: %w", err)
+	args := []string{"--dangerously-skip-permissions"}
+	if continueSession {
+		args = append(args, "-c")
 	}
 
-	if stopAdded || preToolAdded {
-		fmt.Println("✅ Claude hooks installed!")
-		if stopAdded {
-			fmt.Println("  + Stop hook (response capture)")
-		}
-		if preToolAdded {
-			fmt.Println("  + PreToolUse hook (AskUserQuestion forwarding)")
-		}
-	} else {
-		fmt.Println("✅ Claude hooks already installed")
-	}
-	return nil
+	cmd := exec.Command(claudePath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
 }
 
-// findCCCSourceDir locates the CCC source directory by looking for go.mod + .git
-// in known locations. Returns empty string if not found.
-func findCCCSourceDir() string {
-	home, _ := os.UserHomeDir()
-	candidates := []string{
-		filepath.Join(home, "Projects", "teleclaude", "ccc"), // wagok-server
-		filepath.Join(home, "Projects", "ccc"),               // msi, XPS
-		filepath.Join(home, "Public", "Tools", "ccc"),        // dell17
+// startSession creates/attaches to a tmux session with Telegram topic
+func startSession(continueSession bool) error {
+	// Get current directory name as session name
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
 	}
-	// Also check directory of the running binary
-	if exe, err := os.Executable(); err == nil {
-		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
-			candidates = append([]string{filepath.Dir(resolved)}, candidates...)
-		}
+	name := filepath.Base(cwd)
+	tmuxName := tmuxSessionName(name)
+
+	// Load config to check/create topic
+	config, err := loadConfig()
+	if err != nil {
+		// No config, just run claude directly
+		return runClaudeRaw(continueSession)
 	}
-	for _, dir := range candidates {
-		goMod := filepath.Join(dir, "go.mod")
-		gitDir := filepath.Join(dir, ".git")
-		if _, err := os.Stat(goMod); err == nil {
-			if _, err := os.Stat(gitDir); err == nil {
-				return dir
+
+	// Create topic if it doesn't exist and we have a group configured
+	if config.GroupID != 0 {
+		if _, exists := config.Sessions[name]; !exists {
+			topicID, err := createForumTopic(config, name)
+			if err == nil {
+				addSession(config, name, &SessionInfo{
+					TopicID: topicID,
+					Path:    cwd,
+				})
+				fmt.Printf("📱 Created Telegram topic: %s\n", name)
 			}
 		}
 	}
-	return ""
-}
 
-// handleUpdateCmd performs git pull + go build + backup + replace + restart.
-// Runs in a goroutine to avoid blocking the Telegram polling loop.
-func handleUpdateCmd(cfg *Config, chatID int64, threadID int64) {
-	defer atomic.StoreInt32(&updateInProgress, 0)
+	// Attach to the existing session
+	if backend().Exists(tmuxName) {
+		return backend().Attach(tmuxName)
+	}
 
-	sourceDir := findCCCSourceDir()
-	if sourceDir == "" {
-		sendMessage(cfg, chatID, threadID, "❌ Source directory not found (need go.mod + .git)")
-		return
+	// Create new session and attach
+	if err := backend().Create(tmuxName, cwd, continueSession); err != nil {
+		return err
 	}
+	return backend().Attach(tmuxName)
+}
 
-	// Step 1: git pull
-	sendMessage(cfg, chatID, threadID, fmt.Sprintf("📦 Pulling from git...\n`%s`", sourceDir))
-	pullCmd := exec.Command("git", "-C", sourceDir, "pull")
-	pullOut, err := pullCmd.CombinedOutput()
-	pullText := strings.TrimSpace(string(pullOut))
-	if err != nil {
-		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ git pull failed:\n```\n%s\n```", pullText))
-		return
+func sendToTmuxWithDelay(session string, text string, delay time.Duration) error {
+	// Send text literally
+	cmd := tmuxCmd( "send-keys", "-t", session, "-l", text)
+	if err := cmd.Run(); err != nil {
+		return err
 	}
 
-	if pullText == "Already up to date." {
-		sendMessage(cfg, chatID, threadID, "✅ Already up to date, no rebuild needed.")
-		return
+	// Wait for content to load (e.g., images, long pasted text)
+	time.Sleep(delay)
+
+	// Send Enter twice (Claude Code needs double Enter)
+	cmd = tmuxCmd( "send-keys", "-t", session, "C-m")
+	if err := cmd.Run(); err != nil {
+		return err
 	}
+	time.Sleep(50 * time.Millisecond)
+	cmd = tmuxCmd( "send-keys", "-t", session, "C-m")
+	return cmd.Run()
+}
 
-	// Step 2: go build
-	sendMessage(cfg, chatID, threadID, "🔨 Building...")
-	newBinary := filepath.Join(sourceDir, "ccc-new")
-	buildCmd := exec.Command("go", "build", "-o", newBinary, ".")
-	buildCmd.Dir = sourceDir
-	buildOut, err := buildCmd.CombinedOutput()
+func killTmuxSession(name string) error {
+	cmd := tmuxCmd( "kill-session", "-t", name)
+	return cmd.Run()
+}
+
+func listTmuxSessions() ([]string, error) {
+	cmd := tmuxCmd( "list-sessions", "-F", "#{session_name}")
+	out, err := cmd.Output()
 	if err != nil {
-		buildText := strings.TrimSpace(string(buildOut))
-		if len(buildText) > 3000 {
-			buildText = buildText[:3000] + "\n..."
-		}
-		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Build failed:\n```\n%s\n```", buildText))
-		os.Remove(newBinary)
-		return
+		return nil, err
 	}
 
-	// Step 3: Validate new binary (must be > 1MB)
-	if stat, err := os.Stat(newBinary); err != nil || stat.Size() < 1*1024*1024 {
-		sendMessage(cfg, chatID, threadID, "❌ Built binary too small or missing, aborting")
-		os.Remove(newBinary)
-		return
+	var sessions []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		name := scanner.Text()
+		if strings.HasPrefix(name, "claude-") {
+			sessions = append(sessions, strings.TrimPrefix(name, "claude-"))
+		}
 	}
+	return sessions, nil
+}
 
-	// Step 4: Find current binary and backup
-	exe, err := os.Executable()
+// TmuxSessionInfo holds information about a tmux session
+type TmuxSessionInfo struct {
+	Created  time.Time
+	Activity time.Time
+	Path     string
+}
+
+// getTmuxSessionInfo returns detailed info about a tmux session
+func getTmuxSessionInfo(name string) (*TmuxSessionInfo, error) {
+	cmd := tmuxCmd( "list-sessions", "-F",
+		"#{session_name}\t#{session_created}\t#{session_activity}\t#{pane_current_path}",
+		"-f", fmt.Sprintf("#{==:#{session_name},%s}", name))
+	out, err := cmd.Output()
 	if err != nil {
-		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Cannot find current binary: %v", err))
-		os.Remove(newBinary)
-		return
+		return nil, err
 	}
-	exePath, _ := filepath.EvalSymlinks(exe)
-	backupPath := exePath + ".bak"
 
-	// Copy current binary as backup (ignore error if first run)
-	exec.Command("cp", exePath, backupPath).Run()
+	line := strings.TrimSpace(string(out))
+	if line == "" {
+		return nil, fmt.Errorf("session not found")
+	}
 
-	// Step 5: Replace binary (rm + cp to handle "text file busy")
-	os.Remove(exePath)
-	cpCmd := exec.Command("cp", newBinary, exePath)
-	if err := cpCmd.Run(); err != nil {
-		// Restore from backup
-		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to replace binary: %v\nRestoring backup...", err))
-		exec.Command("cp", backupPath, exePath).Run()
-		os.Remove(newBinary)
-		return
+	parts := strings.Split(line, "\t")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid tmux output")
 	}
-	os.Chmod(exePath, 0755)
-	os.Remove(newBinary)
 
-	sendMessage(cfg, chatID, threadID, fmt.Sprintf("✅ Updated!\n```\n%s\n```\n🔄 Restarting...", pullText))
+	created, _ := strconv.ParseInt(parts[1], 10, 64)
+	activity, _ := strconv.ParseInt(parts[2], 10, 64)
 
-	// Step 6: Restart (same as /restart)
-	cmd := exec.Command(exePath, "listen")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to restart: %v\nBinary updated but not restarted.", err))
-		return
-	}
-	os.Exit(0)
+	return &TmuxSessionInfo{
+		Created:  time.Unix(created, 0),
+		Activity: time.Unix(activity, 0),
+		Path:     parts[3],
+	}, nil
 }
 
-// Bot commands
+// Session backends
+//
+// Local sessions run either in tmux (default) or in a built-in pty server
+// ("session_backend": "pty"), which needs no external dependency. Remote
+// sessions on other hosts always use tmux over SSH.
+
+// SessionBackend runs local Claude sessions
+type SessionBackend interface {
+	Name() string
+	Exists(name string) bool
+	Create(name string, workDir string, continueSession bool) error
+	// SendText types text, waits for delay, then submits it
+	SendText(name string, text string, delay time.Duration) error
+	// SendKey sends one key by its tmux name (Enter, Down, Escape, C-c...)
+	SendKey(name string, key string) error
+	Capture(name string, lines int) (string, error)
+	// CaptureANSI is Capture with colors kept as escape sequences
+	CaptureANSI(name string, lines int) (string, error)
+	// ClaudeRunning reports whether Claude (not a bare shell) is running
+	ClaudeRunning(name string) bool
+	// Restart starts Claude again with -c after it exited
+	Restart(name string) error
+	Kill(name string) error
+	Rename(name string, newName string) error
+	List() ([]string, error)
+	Info(name string) (*TmuxSessionInfo, error)
+	Attach(name string) error
+}
 
-func setBotCommands(botToken string) {
-	commands := `{
-		"commands": [
-			{"command": "help", "description": "Show all commands"},
-			{"command": "new", "description": "Create session: /new [host:]<name>"},
-			{"command": "continue", "description": "Continue session: /continue [host:]<name>"},
-			{"command": "kill", "description": "Kill session: /kill <name>"},
-			{"command": "list", "description": "List sessions with status"},
-			{"command": "status", "description": "Show current session details"},
-			{"command": "host", "description": "Manage hosts: /host add|del|list|check"},
-			{"command": "rc", "description": "Remote command: /rc <host> <cmd>"},
-			{"command": "setdir", "description": "Set projects dir: /setdir [host:]<path>"},
-			{"command": "away", "description": "Toggle notifications"},
-			{"command": "c", "description": "Local command: /c <cmd>"},
-			{"command": "screenshot", "description": "Take screenshot of display"},
-			{"command": "ping", "description": "Check bot status"},
-			{"command": "update", "description": "Pull, build and restart CCC"},
-			{"command": "restart", "description": "Restart CCC process"}
-		]
-	}`
+var (
+	backendOnce  sync.Once
+	localBackend SessionBackend
+)
 
-	resp, err := http.Post(
-		fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", botToken),
-		"application/json",
-		strings.NewReader(commands),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to set bot commands: %v\n", redactTokenError(err, botToken))
-		return
-	}
-	resp.Body.Close()
+// backend returns the backend for local sessions, chosen once per process
+func backend() SessionBackend {
+	backendOnce.Do(func() {
+		name := ""
+		if config, err := loadConfig(); err == nil {
+			name = config.SessionBackend
+		}
+		localBackend = selectBackend(name, tmuxPath != "")
+	})
+	return localBackend
 }
 
-// Execute shell command
+// selectBackend picks a backend by config name. Without an explicit choice
+// the pty backend is used when tmux is not installed.
+func selectBackend(name string, haveTmux bool) SessionBackend {
+	if name == "pty" || (name == "" && !haveTmux) {
+		return ptyBackend{}
+	}
+	return tmuxBackend{}
+}
 
-func executeCommand(cmdStr string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
+// sendToSession types text into a local session and submits it
+func sendToSession(name string, text string) error {
+	// Always use 2 second delay before Enter to ensure text is fully processed
+	return backend().SendText(name, text, 2*time.Second)
+}
 
-	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
-	cmd.Dir, _ = os.UserHomeDir()
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
+// looksLikeClaude reports whether pane content shows the Claude Code UI
+func looksLikeClaude(content string) bool {
+	// Claude Code UI has distinctive elements:
+	// - Input prompt: ❯
+	// - Status bar: "bypass permissions" or "shift+tab to cycle"
+	// - Activity indicators: ●, ✽, ✻
+	// - Tool output markers: ⎿
+	// If none of these are present, Claude is probably not running
 
-	output := stdout.String()
-	if stderr.Len() > 0 {
-		if output != "" {
-			output += "\n"
-		}
-		output += stderr.String()
+	claudeIndicators := []string{
+		"❯",                   // Input prompt
+		"bypass permissions",  // Status bar
+		"shift+tab to cycle",  // Status bar variant
+		"ctrl+c to interrupt", // Activity indicator
+		"●",                   // Tool marker
+		"✽",                   // Spinner
+		"✻",                   // Spinner variant
+		"⎿",                   // Tool output
 	}
 
-	if output == "" {
-		if err != nil {
-			output = fmt.Sprintf("Error: %v", err)
-		} else {
-			output = "(no output)"
+	for _, indicator := range claudeIndicators {
+		if strings.Contains(content, indicator) {
+			return true
 		}
 	}
-
-	return strings.TrimSpace(output), err
+	return false
 }
 
-// One-shot Claude run (for private chat)
-
-func runClaude(prompt string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
+// tmuxBackend runs sessions in the local tmux server
+type tmuxBackend struct{}
 
-	home, _ := os.UserHomeDir()
-	workDir := home
+func (tmuxBackend) Name() string { return "tmux" }
 
-	words := strings.Fields(prompt)
-	if len(words) > 0 {
-		firstWord := words[0]
-		potentialDir := filepath.Join(home, firstWord)
-		if info, err := os.Stat(potentialDir); err == nil && info.IsDir() {
-			workDir = potentialDir
-			prompt = strings.TrimSpace(strings.TrimPrefix(prompt, firstWord))
-			if prompt == "" {
-				return "Error: no prompt provided after directory name", nil
-			}
-		}
-	}
+func (tmuxBackend) Exists(name string) bool { return tmuxSessionExists(name) }
 
-	if claudePath == "" {
-		return "Error: claude binary not found", fmt.Errorf("claude not found")
-	}
-	cmd := exec.CommandContext(ctx, claudePath, "--dangerously-skip-permissions", "-p", prompt)
-	cmd.Dir = workDir
+func (tmuxBackend) Create(name string, workDir string, continueSession bool) error {
+	return createTmuxSession(name, workDir, continueSession)
+}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+func (tmuxBackend) SendText(name string, text string, delay time.Duration) error {
+	return sendToTmuxWithDelay(name, text, delay)
+}
 
-	err := cmd.Run()
+func (tmuxBackend) SendKey(name string, key string) error {
+	return tmuxCmd("send-keys", "-t", name, key).Run()
+}
 
-	output := stdout.String()
-	if stderr.Len() > 0 {
-		if output != "" {
-			output += "\n"
-		}
-		output += stderr.String()
+func (tmuxBackend) Capture(name string, lines int) (string, error) {
+	out, err := tmuxCmd("capture-pane", "-t", name, "-p", "-S", fmt.Sprintf("-%d", lines)).Output()
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
 
-	if output == "" {
-		if err != nil {
-			output = fmt.Sprintf("Error: %v", err)
-		} else {
-			output = "(no output)"
-		}
+func (tmuxBackend) CaptureANSI(name string, lines int) (string, error) {
+	out, err := tmuxCmd("capture-pane", "-e", "-t", name, "-p", "-S", fmt.Sprintf("-%d", lines)).Output()
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
 
-	return strings.TrimSpace(output), err
+func (b tmuxBackend) ClaudeRunning(name string) bool {
+	content, err := b.Capture(name, 30)
+	return err == nil && looksLikeClaude(content)
 }
 
-// Setup - complete setup process
+func (tmuxBackend) Restart(name string) error {
+	// The pane keeps a login shell after Claude exits; start it again there
+	return tmuxCmd("send-keys", "-t", name, cccPath+" run -c", "C-m").Run()
+}
 
-func installService() error {
-	home, _ := os.UserHomeDir()
+func (tmuxBackend) Kill(name string) error { return killTmuxSession(name) }
 
-	// Detect OS and install appropriate service
-	if _, err := os.Stat("/Library"); err == nil {
-		// macOS - use launchd
-		return installLaunchdService(home)
+func (tmuxBackend) Rename(name string, newName string) error {
+	return tmuxCmd("rename-session", "-t", name, newName).Run()
+}
+
+func (tmuxBackend) List() ([]string, error) { return listTmuxSessions() }
+
+func (tmuxBackend) Info(name string) (*TmuxSessionInfo, error) { return getTmuxSessionInfo(name) }
+
+func (tmuxBackend) Attach(name string) error {
+	// Inside tmux switch the client, outside attach to the session
+	verb := "attach-session"
+	if os.Getenv("TMUX") != "" {
+		verb = "switch-client"
 	}
-	// Linux - use systemd
-	return installSystemdService(home)
+	cmd := tmuxCmd(verb, "-t", name)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
-func installLaunchdService(home string) error {
-	plistDir := filepath.Join(home, "Library", "LaunchAgents")
-	if err := os.MkdirAll(plistDir, 0755); err != nil {
-		return fmt.Errorf("failed to create LaunchAgents dir: %w", err)
+// ptyBackend runs each session in a detached "ccc pty-serve" process that
+// owns a pseudo-terminal and listens on ~/.ccc/pty/<name>.sock
+type ptyBackend struct{}
+
+// ptyKeys maps tmux key names to the bytes a terminal sends for them
+var ptyKeys = map[string]string{
+	"Enter":  "\r",
+	"C-m":    "\r",
+	"Escape": "\x1b",
+	"Tab":    "\t",
+	"BTab":   "\x1b[Z",
+	"Space":  " ",
+	"BSpace": "\x7f",
+	"Up":     "\x1b[A",
+	"Down":   "\x1b[B",
+	"Right":  "\x1b[C",
+	"Left":   "\x1b[D",
+	"C-c":    "\x03",
+	"C-d":    "\x04",
+}
+
+func ptyDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "pty")
+}
+
+func ptySocket(name string) string {
+	return filepath.Join(ptyDir(), name+".sock")
+}
+
+func (ptyBackend) Name() string { return "pty" }
+
+func (ptyBackend) Exists(name string) bool { return ptysession.Alive(ptySocket(name)) }
+
+func (ptyBackend) Create(name string, workDir string, continueSession bool) error {
+	if cccPath == "" {
+		return fmt.Errorf("ccc binary not found")
+	}
+	if err := os.MkdirAll(ptyDir(), 0700); err != nil {
+		return err
 	}
+	logFile, err := os.OpenFile(filepath.Join(ptyDir(), name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
 
-	plistPath := filepath.Join(plistDir, "com.ccc.plist")
-	logPath := filepath.Join(home, ".ccc.log")
+	args := []string{"pty-serve", name, workDir}
+	if continueSession {
+		args = append(args, "-c")
+	}
+	cmd := exec.Command(cccPath, args...)
+	cmd.Dir = workDir
+	cmd.Env = sessionEnviron()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Detach from our session so the server outlives the listener
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	cmd.Process.Release()
 
-	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-<dict>
-    <key>Label</key>
-    <string>com.ccc</string>
-    <key>ProgramArguments</key>
-    <array>
-        <string>%s</string>
-        <string>listen</string>
-    </array>
-    <key>RunAtLoad</key>
-    <true/>
-    <key>KeepAlive</key>
-    <true/>
-    <key>StandardOutPath</key>
-    <string>%s</string>
-    <key>StandardErrorPath</key>
-    <string>%s</string>
-</dict>
-</plist>
-`, cccPath, logPath, logPath)
+	socket := ptySocket(name)
+	for i := 0; i < 30; i++ {
+		if ptysession.Alive(socket) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("pty session %s did not start (see %s)", name, filepath.Join(ptyDir(), name+".log"))
+}
 
-	if err := os.WriteFile(plistPath, []byte(plist), 0644); err != nil {
-		return fmt.Errorf("failed to write plist: %w", err)
+func (ptyBackend) SendText(name string, text string, delay time.Duration) error {
+	socket := ptySocket(name)
+	if err := ptysession.Send(socket, text); err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	// Send Enter twice (Claude Code needs double Enter)
+	if err := ptysession.Send(socket, "\r"); err != nil {
+		return err
 	}
+	time.Sleep(50 * time.Millisecond)
+	return ptysession.Send(socket, "\r")
+}
 
-	// Unload if exists, then load
-	exec.Command("launchctl", "unload", plistPath).Run()
-	if err := exec.Command("launchctl", "load", plistPath).Run(); err != nil {
-		return fmt.Errorf("failed to load service: %w", err)
+func (ptyBackend) SendKey(name string, key string) error {
+	seq, ok := ptyKeys[key]
+	if !ok {
+		seq = key
 	}
+	return ptysession.Send(ptySocket(name), seq)
+}
 
-	fmt.Println("✅ Service installed and started (launchd)")
-	return nil
+func (ptyBackend) Capture(name string, lines int) (string, error) {
+	out, err := ptysession.Capture(ptySocket(name), lines)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.Join(out, "\n"), "\n"), nil
 }
 
-func installSystemdService(home string) error {
-	serviceDir := filepath.Join(home, ".config", "systemd", "user")
-	if err := os.MkdirAll(serviceDir, 0755); err != nil {
-		return fmt.Errorf("failed to create systemd dir: %w", err)
+func (ptyBackend) CaptureANSI(name string, lines int) (string, error) {
+	out, err := ptysession.CaptureANSI(ptySocket(name), lines)
+	if err != nil {
+		return "", err
 	}
+	return strings.Join(out, "\n"), nil
+}
 
-	servicePath := filepath.Join(serviceDir, "ccc.service")
-	service := fmt.Sprintf(`[Unit]
-Description=Claude Code Companion
-After=network.target
+func (ptyBackend) ClaudeRunning(name string) bool {
+	// The server runs Claude directly, so its process state is authoritative
+	info, err := ptysession.GetInfo(ptySocket(name))
+	return err == nil && info.Running
+}
 
-[Service]
-ExecStart=%s listen
-Restart=always
-RestartSec=10
+func (ptyBackend) Restart(name string) error { return ptysession.Restart(ptySocket(name)) }
 
-[Install]
-WantedBy=default.target
-`, cccPath)
+func (ptyBackend) Kill(name string) error { return ptysession.Kill(ptySocket(name)) }
 
-	if err := os.WriteFile(servicePath, []byte(service), 0600); err != nil {
-		return fmt.Errorf("failed to write service file: %w", err)
+func (ptyBackend) Rename(name string, newName string) error {
+	// The server keeps listening on the renamed socket and writing to the
+	// renamed log
+	err := os.Rename(filepath.Join(ptyDir(), name+".log"), filepath.Join(ptyDir(), newName+".log"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
 	}
+	return os.Rename(ptySocket(name), ptySocket(newName))
+}
 
-	// Reload and start
-	exec.Command("systemctl", "--user", "daemon-reload").Run()
-	exec.Command("systemctl", "--user", "enable", "ccc").Run()
-	if err := exec.Command("systemctl", "--user", "start", "ccc").Run(); err != nil {
-		return fmt.Errorf("failed to start service: %w", err)
+func (ptyBackend) List() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(ptyDir(), "claude-*.sock"))
+	if err != nil {
+		return nil, err
 	}
+	var sessions []string
+	for _, m := range matches {
+		if ptysession.Alive(m) {
+			name := strings.TrimSuffix(filepath.Base(m), ".sock")
+			sessions = append(sessions, strings.TrimPrefix(name, "claude-"))
+		}
+	}
+	return sessions, nil
+}
 
-	fmt.Println("✅ Service installed and started (systemd)")
-	return nil
+func (ptyBackend) Info(name string) (*TmuxSessionInfo, error) {
+	info, err := ptysession.GetInfo(ptySocket(name))
+	if err != nil {
+		return nil, err
+	}
+	return &TmuxSessionInfo{
+		Created:  info.Started,
+		Activity: info.Activity,
+		Path:     info.Dir,
+	}, nil
 }
 
-func setup(botToken string) error {
-	fmt.Println("🚀 Claude Code Companion Setup")
-	fmt.Println("==============================")
-	fmt.Println()
+func (ptyBackend) Attach(name string) error {
+	fmt.Printf("Attaching to %s (detach with Ctrl-])\n", name)
+	return ptysession.Attach(ptySocket(name), os.Stdin, os.Stdout)
+}
 
-	config := &Config{BotToken: botToken, Sessions: make(map[string]*SessionInfo)}
+// servePTYSession runs the pty server for a session (ccc pty-serve)
+func servePTYSession(name string, workDir string, continueSession bool) error {
+	command := []string{cccPath, "run"}
+	if continueSession {
+		command = append(command, "-c")
+	}
+	return ptysession.Serve(ptysession.Options{
+		Name:    name,
+		Socket:  ptySocket(name),
+		Dir:     workDir,
+		Command: command,
+		Restart: []string{cccPath, "run", "-c"},
+	})
+}
 
-	// Step 1: Get chat ID
-	fmt.Println("Step 1/4: Connecting to Telegram...")
-	fmt.Println("📱 Send any message to your bot in Telegram")
-	fmt.Println("   Waiting...")
+// Container sessions
+//
+// A session with Container set runs Claude in a Docker or Podman container.
+// The session itself (tmux pane or pty) stays on the host and runs "ccc run",
+// which starts the container attached to its terminal, so sending keys,
+// capturing the pane and state detection work unchanged. The Claude and ccc
+// state directories and the hook binary are mounted at their host paths so
+// the hooks inside the container behave like local ones.
 
-	offset := 0
-	for {
-		resp, err := telegramGet(botToken, fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", botToken, offset))
-		if err != nil {
-			return fmt.Errorf("failed to get updates: %w", err)
-		}
+// containerName returns the container name of a local session
+func containerName(tmuxName string) string {
+	return "ccc-" + tmuxName
+}
 
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		resp.Body.Close()
+// containerCIDFile returns the file holding the session's container ID
+func containerCIDFile(tmuxName string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "containers", tmuxName+".cid")
+}
 
-		var updates TelegramUpdate
-		if err := json.Unmarshal(body, &updates); err != nil {
-			return fmt.Errorf("failed to parse response: %w", err)
+// containerRuntime returns the configured runtime preference
+func containerRuntime(cfg *Config) string {
+	if cfg != nil && cfg.Container != nil {
+		return cfg.Container.Runtime
+	}
+	return ""
+}
+
+// containerSessionForDir returns the local container session at dir
+func containerSessionForDir(cfg *Config, dir string) string {
+	for name, info := range cfg.Sessions {
+		if info != nil && !info.Deleted && info.Host == "" && info.Container && info.Path == dir {
+			return name
 		}
+	}
+	return ""
+}
 
-		if !updates.OK {
-			return fmt.Errorf("telegram API error - check your bot token")
+// parseContainerFlag strips --container / --no-container from /new and
+// /continue arguments. want is nil when neither flag is present.
+func parseContainerFlag(arg string) (rest string, want *bool) {
+	var kept []string
+	for _, f := range strings.Fields(arg) {
+		switch f {
+		case "--container":
+			v := true
+			want = &v
+		case "--no-container":
+			v := false
+			want = &v
+		default:
+			kept = append(kept, f)
 		}
+	}
+	return strings.Join(kept, " "), want
+}
 
-		for _, update := range updates.Result {
-			offset = update.UpdateID + 1
-			if update.Message.Chat.ID != 0 {
-				config.ChatID = update.Message.Chat.ID
-				if err := saveConfig(config); err != nil {
-					return fmt.Errorf("failed to save config: %w", err)
+// runSessionClaude runs Claude for the session in the current directory,
+// inside a container when the session asks for one (ccc run)
+func runSessionClaude(continueSession bool) error {
+	if os.Getenv("CCC_CONTAINER") == "" {
+		cwd, _ := os.Getwd()
+		if cfg, err := loadConfig(); err == nil {
+			if name := containerSessionForDir(cfg, cwd); name != "" {
+				err := runClaudeInContainer(cfg, name, cwd, continueSession)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "❌ container: %v\n", err)
 				}
-				fmt.Printf("✅ Connected! (User: @%s)\n\n", update.Message.From.Username)
-				goto step2
+				return err
 			}
 		}
+	}
+	return runClaudeRaw(continueSession)
+}
 
-		time.Sleep(time.Second)
+// runClaudeInContainer runs Claude in a container attached to the current
+// terminal and records the container ID in the session info
+func runClaudeInContainer(cfg *Config, name string, workDir string, continueSession bool) error {
+	cc := cfg.Container
+	if cc == nil || cc.Image == "" {
+		return fmt.Errorf("no image configured (set container.image in ~/.ccc.json)")
+	}
+	runtime, err := container.FindRuntime(cc.Runtime)
+	if err != nil {
+		return err
 	}
 
-step2:
-	// Step 2: Group setup (optional)
-	fmt.Println("Step 2/4: Group setup (optional)")
-	fmt.Println("   For session topics, create a Telegram group with Topics enabled,")
-	fmt.Println("   add your bot as admin, and send a message there.")
-	fmt.Println("   Or press Enter to skip...")
+	network, err := containerNetwork(cc.Network)
+	if err != nil {
+		return err
+	}
 
-	// Non-blocking check for group message with timeout
-	fmt.Println("   Waiting 30 seconds for group message...")
+	home, _ := os.UserHomeDir()
+	tmuxName := tmuxSessionName(extractProjectName(name))
+	state := containerStateDir(tmuxName)
+	mounts, err := prepareContainerHome(state, home, workDir)
+	if err != nil {
+		return err
+	}
 
-	client := &http.Client{Timeout: 35 * time.Second}
-	deadline := time.Now().Add(30 * time.Second)
+	// Hooks inside the container reach the host only through this relay
+	relayDir := filepath.Join(state, "relay")
+	if err := os.MkdirAll(relayDir, 0700); err != nil {
+		return err
+	}
+	socket := filepath.Join(relayDir, "hook.sock")
+	os.Remove(socket)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	defer ln.Close()
+	transcripts := filepath.Join(home, ".claude", "projects", encodeProjectPath(workDir))
+	go container.ServeRelay(ln, func(req container.RelayRequest) container.RelayResponse {
+		return runRelayedHook(req, workDir, transcripts)
+	})
+	mounts = append(mounts, container.Mount{Source: relayDir, Target: containerRelayDir})
 
-	for time.Now().Before(deadline) {
-		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=5", config.BotToken, offset)
-		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+	// Hooks run "~/bin/ccc hook" inside the container
+	for _, p := range []string{filepath.Join(home, "bin", "ccc"), cccPath} {
+		if _, err := os.Stat(p); err == nil && (len(mounts) == 0 || mounts[len(mounts)-1].Source != p) {
+			mounts = append(mounts, container.Mount{Source: p, Target: p, ReadOnly: true})
+		}
+	}
+	for _, m := range cc.Mounts {
+		mount, err := container.ParseMount(m)
 		if err != nil {
-			continue
+			return err
 		}
+		mounts = append(mounts, mount)
+	}
 
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		resp.Body.Close()
-
-		var updates TelegramUpdate
-		json.Unmarshal(body, &updates)
-
-		for _, update := range updates.Result {
-			offset = update.UpdateID + 1
-			chat := update.Message.Chat
-			if chat.Type == "supergroup" {
-				config.GroupID = chat.ID
-				saveConfig(config)
-				fmt.Printf("✅ Group configured!\n\n")
-				goto step3
-			}
+	env := []string{"HOME=" + home, "TERM=xterm-256color", "CCC_CONTAINER=" + name, container.RelayEnv + "=" + containerRelayDir + "/hook.sock"}
+	for _, e := range append([]string{"ANTHROPIC_API_KEY"}, cc.Env...) {
+		if strings.Contains(e, "=") {
+			env = append(env, e)
+		} else if v, ok := os.LookupEnv(e); ok {
+			env = append(env, e+"="+v)
 		}
 	}
-	fmt.Println("⏭️  Skipped (you can run 'ccc setgroup' later)")
 
-step3:
-	// Step 3: Install Claude hook
-	fmt.Println("Step 3/4: Installing Claude hook...")
-	if err := installHook(); err != nil {
-		fmt.Printf("⚠️  Hook installation failed: %v\n", err)
-		fmt.Println("   You can install it later with: ccc install")
-	} else {
-		fmt.Println()
+	command := []string{"claude", "--dangerously-skip-permissions"}
+	if continueSession {
+		command = append(command, "-c")
 	}
 
-	// Step 4: Install service
-	fmt.Println("Step 4/4: Installing background service...")
-	if err := installService(); err != nil {
-		fmt.Printf("⚠️  Service installation failed: %v\n", err)
-		fmt.Println("   You can start manually with: ccc listen")
-	} else {
-		fmt.Println()
+	cidFile := containerCIDFile(tmuxName)
+	if err := os.MkdirAll(filepath.Dir(cidFile), 0700); err != nil {
+		return err
 	}
-
-	// Done!
-	fmt.Println("==============================")
-	fmt.Println("✅ Setup complete!")
-	fmt.Println()
-	fmt.Println("Usage:")
-	fmt.Println("  ccc           Start Claude Code in current directory")
-	fmt.Println("  ccc -c        Continue previous session")
-	fmt.Println()
-	if config.GroupID != 0 {
-		fmt.Println("Telegram commands (in your group):")
-		fmt.Println("  /new <name>   Create new session")
-		fmt.Println("  /list         List sessions")
-	} else {
-		fmt.Println("To enable Telegram session topics:")
-		fmt.Println("  1. Create a group with Topics enabled")
-		fmt.Println("  2. Add bot as admin")
-		fmt.Println("  3. Run: ccc setgroup")
+	// The runtime refuses to overwrite a CID file; clear leftovers of an
+	// earlier run along with its container
+	os.Remove(cidFile)
+	container.Remove(runtime, containerName(tmuxName))
+
+	cmd := exec.Command(runtime, container.RunArgs(container.Options{
+		Runtime:   runtime,
+		Name:      containerName(tmuxName),
+		Image:     cc.Image,
+		WorkDir:   workDir,
+		Mounts:    mounts,
+		Env:       env,
+		User:      fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		Network:   network,
+		CPUs:      cc.CPUs,
+		Memory:    cc.Memory,
+		PidsLimit: cc.PidsLimit,
+		CIDFile:   cidFile,
+		Labels:    map[string]string{"ccc.session": name},
+		Command:   command,
+	})...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if network == "none" {
+		fmt.Fprintln(os.Stderr, "ccc: the container has no network; set container.network to a network with limited egress (or \"open\") so Claude can reach its API")
+	}
+	if err := cmd.Start(); err != nil {
+		return err
 	}
 
-	return nil
+	go func() {
+		// Allow time for the image to be pulled on first use
+		id, err := container.WaitCID(cidFile, 5*time.Minute)
+		if err != nil {
+			return
+		}
+		updateConfig(func(cfg *Config) error {
+			if info := cfg.Sessions[name]; info != nil {
+				info.ContainerID = id
+			}
+			return nil
+		})
+	}()
+	return cmd.Wait()
+}
+
+// containerRelayDir is where the hook relay socket appears in containers
+const containerRelayDir = "/ccc-relay"
+
+// containerClaudeFiles are the parts of ~/.claude copied into a container's
+// own Claude home: login, settings (with the ccc hooks) and user-level
+// instructions, agents and commands
+var containerClaudeFiles = []string{".credentials.json", "settings.json", "CLAUDE.md", "agents", "commands"}
+
+// relayedHooks are the ccc subcommands containers may run on the host
+var relayedHooks = map[string]bool{"hook": true, "hook-permission": true, "hook-prompt": true, "hook-question": true, "hook-output": true}
+
+// containerNetwork maps container.network to the runtime's --network, "open"
+// for the runtime default. It must be set: without a network Claude can't
+// reach its API, and the default network may reach more than intended. The
+// host network is refused since it exposes the host's local services.
+func containerNetwork(network string) (string, error) {
+	switch network {
+	case "":
+		return "", fmt.Errorf("container.network is not set: set it to a network that can reach the Anthropic API, or to \"open\" for the runtime's default network")
+	case "open":
+		return "", nil
+	case "host":
+		return "", fmt.Errorf("container.network \"host\" is not allowed: it exposes the host's local services")
+	}
+	return network, nil
 }
 
-func setGroup(config *Config) error {
-	fmt.Println("Send a message in the group where you want to use topics...")
-	fmt.Println("(Make sure Topics are enabled in group settings)")
+// containerStateDir holds a container session's Claude home copy and relay
+func containerStateDir(tmuxName string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "containers", tmuxName)
+}
 
-	offset := 0
-	client := &http.Client{Timeout: 35 * time.Second}
+// prepareContainerHome refreshes the session's copy of the Claude config
+// and returns the mounts that replace ~/.claude and ~/.claude.json in the
+// container. Only the transcripts of this project are shared with the host,
+// so the listener still sees them.
+func prepareContainerHome(state string, home string, workDir string) ([]container.Mount, error) {
+	claudeDir := filepath.Join(state, "claude")
+	if err := os.MkdirAll(claudeDir, 0700); err != nil {
+		return nil, err
+	}
+	for _, name := range containerClaudeFiles {
+		src := filepath.Join(home, ".claude", name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(claudeDir, name)
+		os.RemoveAll(dst)
+		if err := copyTree(src, dst); err != nil {
+			return nil, err
+		}
+	}
+	claudeJSON := filepath.Join(state, "claude.json")
+	data, err := os.ReadFile(filepath.Join(home, ".claude.json"))
+	if err != nil {
+		data = []byte("{}")
+	}
+	if err := os.WriteFile(claudeJSON, data, 0600); err != nil {
+		return nil, err
+	}
+	transcripts := filepath.Join(home, ".claude", "projects", encodeProjectPath(workDir))
+	if err := os.MkdirAll(transcripts, 0700); err != nil {
+		return nil, err
+	}
+	return []container.Mount{
+		{Source: claudeDir, Target: filepath.Join(home, ".claude")},
+		{Source: claudeJSON, Target: filepath.Join(home, ".claude.json")},
+		{Source: transcripts, Target: transcripts},
+	}, nil
+}
 
-	for {
-		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", config.BotToken, offset)
-		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+// copyTree copies a file or directory; anything but regular files and
+// directories is skipped
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			return redactTokenError(err, config.BotToken)
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
 		}
+		return os.WriteFile(target, data, 0600)
+	})
+}
 
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		resp.Body.Close()
+// runRelayedHook runs a hook forwarded by a container session on the host.
+// The hook's cwd is pinned to the session's project and transcript paths
+// outside of its transcript directory are dropped, so a container can only
+// report for its own session.
+func runRelayedHook(req container.RelayRequest, workDir string, transcripts string) container.RelayResponse {
+	if !relayedHooks[req.Hook] {
+		return container.RelayResponse{Stderr: []byte("hook " + req.Hook + " is not relayed\n"), Code: 1}
+	}
+	input, err := sanitizeHookInput(req.Input, workDir, transcripts)
+	if err != nil {
+		return container.RelayResponse{Stderr: []byte(err.Error() + "\n"), Code: 1}
+	}
+	return runHookCommand(req.Hook, input, workDir, nil)
+}
 
-		var updates TelegramUpdate
-		if err := json.Unmarshal(body, &updates); err != nil {
-			continue
+// runHookCommand runs a ccc hook subcommand with input on stdin in dir; a
+// nil env keeps ours
+func runHookCommand(hook string, input []byte, dir string, env []string) container.RelayResponse {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = cccPath
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(exe, hook)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	code := 0
+	if err := cmd.Run(); err != nil {
+		code = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code = exitErr.ExitCode()
 		}
+	}
+	return container.RelayResponse{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), Code: code}
+}
 
-		for _, update := range updates.Result {
-			offset = update.UpdateID + 1
-			chat := update.Message.Chat
-			if chat.Type == "supergroup" && update.Message.From.ID == config.ChatID {
-				config.GroupID = chat.ID
-				if err := saveConfig(config); err != nil {
-					return err
-				}
-				fmt.Printf("Group set: %d\n", chat.ID)
-				fmt.Println("You can now create sessions with: /new <name>")
-				return nil
-			}
+// sanitizeHookInput pins a relayed hook's cwd and transcript path to the
+// container's session
+func sanitizeHookInput(input []byte, workDir string, transcripts string) ([]byte, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(input, &data); err != nil {
+		return nil, fmt.Errorf("invalid hook input: %v", err)
+	}
+	data["cwd"] = workDir
+	if tp, ok := data["transcript_path"].(string); ok && !strings.HasPrefix(filepath.Clean(tp), transcripts+"/") {
+		delete(data, "transcript_path")
+	}
+	return json.Marshal(data)
+}
+
+// sessionEnviron returns the environment of the sessions ccc starts: ours
+// without CCC_BOT_TOKEN and CCC_PASSPHRASE, so neither Claude nor the
+// commands it runs can read them. When either was set, hooks in the session
+// run in the listener through its hook relay instead.
+func sessionEnviron() []string {
+	var env []string
+	scrubbed := false
+	for _, e := range os.Environ() {
+		switch name, _, _ := strings.Cut(e, "="); name {
+		case config.BotTokenEnv, config.PassphraseEnv:
+			scrubbed = true
+		case container.RelayEnv:
+		default:
+			env = append(env, e)
 		}
 	}
+	if scrubbed {
+		env = append(env, container.RelayEnv+"="+hookRelaySocket())
+	} else if socket, ok := os.LookupEnv(container.RelayEnv); ok {
+		env = append(env, container.RelayEnv+"="+socket)
+	}
+	return env
 }
 
-// Doctor - check all dependencies
+// hookRelaySocket returns the path of the listener's hook relay
+func hookRelaySocket() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "relay", "hook.sock")
+}
 
-func doctor() {
-	fmt.Println("🩺 ccc doctor")
-	fmt.Println("=============")
-	fmt.Println()
+// startHookRelay serves the hooks of sessions started without the secrets
+// in their environment (see sessionEnviron). They run in the listener's
+// environment, which has them.
+func startHookRelay() error {
+	socket := hookRelaySocket()
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	os.Remove(socket)
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, e := range os.Environ() {
+		if name, _, _ := strings.Cut(e, "="); name != container.RelayEnv {
+			env = append(env, e)
+		}
+	}
+	go container.ServeRelay(ln, func(req container.RelayRequest) container.RelayResponse {
+		if !relayedHooks[req.Hook] {
+			return container.RelayResponse{Stderr: []byte("hook " + req.Hook + " is not relayed\n"), Code: 1}
+		}
+		var hookData HookData
+		json.Unmarshal(req.Input, &hookData)
+		return runHookCommand(req.Hook, req.Input, hookData.Cwd, env)
+	})
+	return nil
+}
 
-	allGood := true
+// relayHook forwards a hook to the relay of its container or of the listener
+// and returns its exit code
+func relayHook(socket string, hook string) int {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", hook, err)
+		return 1
+	}
+	resp, err := container.CallRelay(socket, container.RelayRequest{Hook: hook, Input: input})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: relay: %v\n", hook, err)
+		return 1
+	}
+	os.Stdout.Write(resp.Stdout)
+	os.Stderr.Write(resp.Stderr)
+	return resp.Code
+}
 
-	// Check tmux
-	fmt.Print("tmux.............. ")
-	if tmuxPath != "" {
-		fmt.Printf("✅ %s\n", tmuxPath)
-	} else {
-		fmt.Println("❌ not found")
-		fmt.Println("   Install: brew install tmux (macOS) or apt install tmux (Linux)")
-		allGood = false
+// removeSessionContainer force-removes the container of a local session and
+// returns its ID ("" if the session has none)
+func removeSessionContainer(tmuxName string) string {
+	cidFile := containerCIDFile(tmuxName)
+	data, err := os.ReadFile(cidFile)
+	if err != nil {
+		return ""
 	}
+	os.Remove(cidFile)
 
-	// Check claude
-	fmt.Print("claude............ ")
-	if claudePath != "" {
-		fmt.Printf("✅ %s\n", claudePath)
-	} else {
-		fmt.Println("❌ not found")
-		fmt.Println("   Install: npm install -g @anthropic-ai/claude-code")
-		allGood = false
+	cfg, _ := loadConfig()
+	runtime, err := container.FindRuntime(containerRuntime(cfg))
+	if err != nil {
+		return ""
+	}
+	id := strings.TrimSpace(string(data))
+	if err := container.Remove(runtime, id); err != nil {
+		// Already gone (the container is started with --rm)
+		return ""
 	}
+	return id
+}
 
-	// Check ccc is in ~/bin (for hooks)
-	fmt.Print("ccc in ~/bin...... ")
-	home, _ := os.UserHomeDir()
-	expectedCccPath := filepath.Join(home, "bin", "ccc")
-	if _, err := os.Stat(expectedCccPath); err == nil {
-		fmt.Printf("✅ %s\n", expectedCccPath)
-	} else {
-		fmt.Println("❌ not found")
-		fmt.Println("   Run: mkdir -p ~/bin && cp ccc ~/bin/")
-		allGood = false
+// killLocalSession stops a local session and removes its container
+func killLocalSession(tmuxName string) {
+	backend().Kill(tmuxName)
+	if id := removeSessionContainer(tmuxName); id != "" {
+		logFor("container").Info("removed container", "id", container.ShortID(id), "tmux", tmuxName)
 	}
+}
 
-	// Check config
-	fmt.Print("config............ ")
-	config, err := loadConfig()
+// sshGetTmuxSessionInfo returns detailed info about a remote tmux session
+func sshGetTmuxSessionInfo(address string, name string) (*TmuxSessionInfo, error) {
+	cmd := fmt.Sprintf("tmux list-sessions -F '#{session_name}\t#{session_created}\t#{session_activity}\t#{pane_current_path}' -f '#{==:#{session_name},%s}'", name)
+	out, err := runSSH(address, cmd, 10*time.Second)
 	if err != nil {
-		fmt.Println("❌ not found")
-		fmt.Println("   Run: ccc setup <bot_token>")
-		allGood = false
-	} else {
-		fmt.Printf("✅ %s\n", getConfigPath())
+		return nil, err
+	}
 
-		// Check bot token
-		fmt.Print("  bot_token....... ")
-		if config.BotToken != "" {
-			fmt.Println("✅ configured")
-		} else {
-			fmt.Println("❌ missing")
-			allGood = false
-		}
+	line := strings.TrimSpace(out)
+	if line == "" {
+		return nil, fmt.Errorf("session not found")
+	}
 
-		// Check chat ID
-		fmt.Print("  chat_id......... ")
-		if config.ChatID != 0 {
-			fmt.Printf("✅ %d\n", config.ChatID)
-		} else {
-			fmt.Println("❌ missing")
-			allGood = false
-		}
+	parts := strings.Split(line, "\t")
+	if len(parts) < 4 {
+		return nil, fmt.Errorf("invalid tmux output")
+	}
 
-		// Check group ID (optional)
-		fmt.Print("  group_id........ ")
-		if config.GroupID != 0 {
-			fmt.Printf("✅ %d\n", config.GroupID)
-		} else {
-			fmt.Println("⚠️  not set (optional, run: ccc setgroup)")
+	created, _ := strconv.ParseInt(parts[1], 10, 64)
+	activity, _ := strconv.ParseInt(parts[2], 10, 64)
+
+	return &TmuxSessionInfo{
+		Created:  time.Unix(created, 0),
+		Activity: time.Unix(activity, 0),
+		Path:     parts[3],
+	}, nil
+}
+
+// formatDuration formats a duration in human-readable format
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	if d < 24*time.Hour {
+		hours := int(d.Hours())
+		mins := int(d.Minutes()) % 60
+		if mins > 0 {
+			return fmt.Sprintf("%dh %dm", hours, mins)
 		}
+		return fmt.Sprintf("%dh", hours)
+	}
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	if hours > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
 	}
+	return fmt.Sprintf("%dd", days)
+}
 
-	// Check Claude hook
-	fmt.Print("claude hook....... ")
-	settingsPath := filepath.Join(home, ".claude", "settings.json")
-	if data, err := os.ReadFile(settingsPath); err == nil {
-		var settings map[string]interface{}
-		if json.Unmarshal(data, &settings) == nil {
-			if hooks, ok := settings["hooks"].(map[string]interface{}); ok {
-				if _, hasStop := hooks["Stop"]; hasStop {
-					fmt.Println("✅ installed")
-				} else {
-					fmt.Println("❌ not installed")
-					fmt.Println("   Run: ccc install")
-					allGood = false
+// Pinned status messages: the listener keeps one pinned message per session
+// topic describing the session and edits it whenever the state changes
+
+const statusInterval = 20 * time.Second
+
+// sessionStatus is a snapshot of a session for its pinned status message
+type sessionStatus struct {
+	State        string // busy, idle, waiting, stopped, sleeping
+	Host         string
+	Path         string
+	Started      time.Time
+	LastActivity time.Time
+	Questions    []string
+}
+
+var statusLabels = map[string]string{
+	"busy":     "⚙️ Busy",
+	"idle":     "🟢 Idle",
+	"waiting":  "❓ Waiting for answer",
+	"stopped":  "⚪ Stopped",
+	"sleeping": "💤 Sleeping",
+}
+
+var (
+	lastStatusKeys sync.Map // session name -> key of the last published status
+	lastTopicState sync.Map // session name -> state shown in the topic name
+)
+
+// startStatusMonitor refreshes pinned status messages and topic names
+// in the background while pinned_status or status_topic_names is enabled
+func startStatusMonitor() {
+	go func() {
+		for {
+			cfg, err := loadConfig()
+			if err == nil && cfg.GroupID != 0 && (cfg.PinnedStatus || cfg.StatusTopicNames) {
+				for name, info := range cfg.Sessions {
+					if info == nil || info.TopicID == 0 || (info.Deleted && info.StatusMessageID == 0) {
+						continue
+					}
+					updateSessionStatus(cfg, name, info)
 				}
-			} else {
-				fmt.Println("❌ not installed")
-				fmt.Println("   Run: ccc install")
-				allGood = false
 			}
-		} else {
-			fmt.Println("⚠️  settings.json parse error")
+			time.Sleep(statusInterval)
 		}
-	} else {
-		fmt.Println("⚠️  ~/.claude/settings.json not found")
+	}()
+}
+
+// updateSessionStatus publishes a session's status if it changed since the
+// last update
+func updateSessionStatus(cfg *Config, name string, info *SessionInfo) {
+	st := collectSessionStatus(cfg, name, info)
+	key := fmt.Sprintf("%s|%d|%s|%s", st.State, st.LastActivity.Unix(), st.Started, strings.Join(st.Questions, "\n"))
+	if prev, ok := lastStatusKeys.Load(name); ok && prev == key {
+		return
 	}
 
-	// Check service
-	fmt.Print("service........... ")
-	if _, err := os.Stat("/Library"); err == nil {
-		// macOS - check launchd
-		plistPath := filepath.Join(home, "Library", "LaunchAgents", "com.ccc.plist")
-		if _, err := os.Stat(plistPath); err == nil {
-			// Check if loaded
-			cmd := exec.Command("launchctl", "list", "com.ccc")
-			if cmd.Run() == nil {
-				fmt.Println("✅ running (launchd)")
-			} else {
-				fmt.Println("⚠️  installed but not running")
-				fmt.Println("   Run: launchctl load ~/Library/LaunchAgents/com.ccc.plist")
-			}
-		} else {
-			fmt.Println("❌ not installed")
-			fmt.Println("   Run: ccc setup <token> (or manually create plist)")
-			allGood = false
+	if cfg.PinnedStatus {
+		if err := publishStatusMessage(cfg, name, info, renderSessionStatus(name, st, time.Now())); err != nil {
+			logFor("status").Warn("publishing status message failed", logging.SessionKey, name, "error", err)
+			return
 		}
-	} else {
-		// Linux - check systemd
-		cmd := exec.Command("systemctl", "--user", "is-active", "ccc")
-		if output, err := cmd.Output(); err == nil && strings.TrimSpace(string(output)) == "active" {
-			fmt.Println("✅ running (systemd)")
-		} else {
-			servicePath := filepath.Join(home, ".config", "systemd", "user", "ccc.service")
-			if _, err := os.Stat(servicePath); err == nil {
-				fmt.Println("⚠️  installed but not running")
-				fmt.Println("   Run: systemctl --user start ccc")
-			} else {
-				fmt.Println("❌ not installed")
-				fmt.Println("   Run: ccc setup <token> (or manually create service)")
-				allGood = false
+	}
+	if cfg.StatusTopicNames {
+		if prev, _ := lastTopicState.Load(name); prev != st.State {
+			emoji, _, _ := strings.Cut(statusLabels[st.State], " ")
+			if err := editForumTopic(cfg, info.TopicID, emoji+" "+name); err == nil || strings.Contains(err.Error(), "NOT_MODIFIED") {
+				lastTopicState.Store(name, st.State)
 			}
 		}
 	}
+	lastStatusKeys.Store(name, key)
+}
 
-	// Check transcription (optional)
-	fmt.Print("transcription..... ")
-	if config != nil && config.TranscriptionCmd != "" {
-		cmdPath := expandPath(config.TranscriptionCmd)
-		if _, err := os.Stat(cmdPath); err == nil {
-			fmt.Printf("✅ %s\n", cmdPath)
-		} else if _, err := exec.LookPath(config.TranscriptionCmd); err == nil {
-			fmt.Printf("✅ %s (in PATH)\n", config.TranscriptionCmd)
-		} else {
-			fmt.Printf("❌ %s not found\n", config.TranscriptionCmd)
-			fmt.Println("   Check transcription_cmd in ~/.ccc.json")
+// collectSessionStatus gathers state, uptime, last activity and pending
+// questions for a session
+func collectSessionStatus(cfg *Config, name string, info *SessionInfo) sessionStatus {
+	st := sessionStatus{State: "stopped", Host: "local", Path: info.Path}
+	if info.Host != "" {
+		st.Host = info.Host
+	}
+	if last := readLastHistoryMessage(info.TopicID); last != nil {
+		st.LastActivity = time.Unix(last.Timestamp, 0)
+	}
+	st.Questions = pendingQuestionSummaries(name, info.TopicID)
+	if info.Deleted {
+		return st
+	}
+
+	_, projectName := parseSessionTarget(name)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	sshAddress := getHostAddress(cfg, info.Host)
+
+	var tmuxInfo *TmuxSessionInfo
+	var err error
+	if info.Host != "" {
+		if sshAddress == "" {
+			return st
 		}
-	} else if whisperPath, err := exec.LookPath("whisper"); err == nil {
-		fmt.Printf("✅ %s (fallback)\n", whisperPath)
-	} else if _, err := os.Stat("/opt/homebrew/bin/whisper"); err == nil {
-		fmt.Println("✅ /opt/homebrew/bin/whisper (fallback)")
+		tmuxInfo, err = sshGetTmuxSessionInfo(sshAddress, tmuxName)
 	} else {
-		fmt.Println("⚠️  not configured (optional, for voice messages)")
-		fmt.Println("   Set transcription_cmd in ~/.ccc.json or install whisper")
+		tmuxInfo, err = backend().Info(tmuxName)
+	}
+	if err != nil || tmuxInfo == nil {
+		if info.Sleeping {
+			st.State = "sleeping"
+		}
+		return st
 	}
+	st.Started = tmuxInfo.Created
 
-	fmt.Println()
-	if allGood {
-		fmt.Println("✅ All checks passed!")
-	} else {
-		fmt.Println("❌ Some issues found. Fix them and run 'ccc doctor' again.")
+	switch {
+	case len(st.Questions) > 0:
+		st.State = "waiting"
+	case checkClaudeState(tmuxName, sshAddress) == "busy":
+		st.State = "busy"
+	default:
+		st.State = "idle"
 	}
+	return st
 }
 
-// Send notification (only if away)
-
-func send(message string) error {
-	config, err := loadConfig()
-	if err != nil {
-		return fmt.Errorf("not configured. Run: ccc setup <bot_token>")
+// renderSessionStatus builds the pinned status message text
+func renderSessionStatus(name string, st sessionStatus, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("📌 %s\n%s\n\n", name, statusLabels[st.State]))
+	b.WriteString(fmt.Sprintf("🖥️ Host: %s\n", st.Host))
+	b.WriteString(fmt.Sprintf("📁 Path: %s\n", st.Path))
+	if !st.Started.IsZero() {
+		b.WriteString(fmt.Sprintf("⏱️ Uptime: %s (since %s)\n", formatDuration(now.Sub(st.Started)), st.Started.Format("01-02 15:04")))
 	}
-
-	if !config.Away {
-		fmt.Println("Away mode off, skipping notification.")
-		return nil
+	if !st.LastActivity.IsZero() {
+		b.WriteString(fmt.Sprintf("🕐 Last activity: %s (%s ago)\n", st.LastActivity.Format("01-02 15:04"), formatDuration(now.Sub(st.LastActivity))))
 	}
-
-	// Try to send to session topic if we're in a session directory
-	if config.GroupID != 0 {
-		cwd, _ := os.Getwd()
-		for name, info := range config.Sessions {
-			if info == nil {
-				continue
-			}
-			if cwd == info.Path || strings.HasPrefix(cwd, info.Path+"/") || strings.HasSuffix(cwd, "/"+name) {
-				return sendMessage(config, config.GroupID, info.TopicID, message)
-			}
+	if len(st.Questions) > 0 {
+		b.WriteString("\n❓ Pending questions:\n")
+		for _, q := range st.Questions {
+			b.WriteString("• " + q + "\n")
 		}
 	}
-
-	// Fallback to private chat
-	return sendMessage(config, config.ChatID, 0, message)
+	b.WriteString(fmt.Sprintf("\nUpdated %s", now.Format("15:04")))
+	return b.String()
 }
 
-// handleRemoteMessage handles messages forwarded from remote clients via --from flag
-func handleRemoteMessage(fromHost string, cwd string, encodedProjectDir string, message string) error {
-	// Truncate message for log
-	logMsg := message
-	if len(logMsg) > 100 {
-		logMsg = logMsg[:100] + "..."
+// publishStatusMessage edits the topic's pinned status message, or sends and
+// pins a new one if there is none yet (or it was deleted)
+func publishStatusMessage(cfg *Config, name string, info *SessionInfo, text string) error {
+	if info.StatusMessageID != 0 {
+		err := editMessageText(cfg, cfg.GroupID, info.StatusMessageID, text)
+		if err == nil || !strings.Contains(err.Error(), "message to edit not found") {
+			return err
+		}
+	}
+	if info.Deleted {
+		return nil
 	}
-	logHook("Remote", "from=%s cwd=%s project=%s msg=%s", fromHost, cwd, encodedProjectDir, logMsg)
 
-	config, err := loadConfig()
+	msgID, err := sendMessageGetID(cfg, cfg.GroupID, info.TopicID, text)
 	if err != nil {
-		logHook("Remote", "ERROR: not configured: %v", err)
-		return fmt.Errorf("not configured: %v", err)
+		return err
 	}
-
-	// cwd is passed from remote client via --cwd flag
-	if cwd == "" {
-		logHook("Remote", "ERROR: missing --cwd parameter")
-		return fmt.Errorf("missing --cwd parameter")
+	if err := pinChatMessage(cfg, cfg.GroupID, msgID); err != nil {
+		logFor("status").Warn("pin failed (bot needs pin rights)", logging.SessionKey, name, "error", err)
 	}
 
-	// Resolve actual project path from encoded project dir
-	// This handles cases where Claude cd'd into a subdirectory
-	projectPath := cwd
-	if encodedProjectDir != "" {
-		if resolved := resolveProjectPathFromTranscript(encodedProjectDir, cwd); resolved != "" {
-			projectPath = resolved
-			fmt.Printf("[remote] resolved project path: %s (from cwd=%s)\n", projectPath, cwd)
+	// Update a fresh copy so changes made meanwhile by other code paths aren't lost
+	info.StatusMessageID = msgID
+	_, err = updateConfig(func(fresh *Config) error {
+		if fi := fresh.Sessions[name]; fi != nil {
+			fi.StatusMessageID = msgID
+		}
+		return nil
+	})
+	return err
+}
+
+// Pending question markers: the permission hook runs in its own process, so
+// it records open AskUserQuestion prompts on disk for the listener
+
+func questionsMarkerPath(topicID int64) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "questions", fmt.Sprintf("%d.json", topicID))
+}
+
+func markQuestionsPending(topicID int64, pqs *PendingQuestionSet) {
+	path := questionsMarkerPath(topicID)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	data, _ := json.Marshal(pqs)
+	os.WriteFile(path, data, 0600)
+}
+
+func clearQuestionsPending(topicID int64) {
+	os.Remove(questionsMarkerPath(topicID))
+}
+
+// pendingQuestionSummaries lists unanswered questions for a session, from
+// the API's in-memory set or the hook's marker file (ignored after an hour)
+func pendingQuestionSummaries(sessionName string, topicID int64) []string {
+	var set *PendingQuestionSet
+	if v, ok := pendingQuestions.Load(sessionName); ok {
+		set = v.(*PendingQuestionSet)
+	} else if data, err := os.ReadFile(questionsMarkerPath(topicID)); err == nil {
+		var fromFile PendingQuestionSet
+		if json.Unmarshal(data, &fromFile) == nil && time.Since(time.Unix(fromFile.Timestamp, 0)) < time.Hour {
+			set = &fromFile
 		}
 	}
+	if set == nil {
+		return nil
+	}
 
-	// Find session matching fromHost and path (exact match first, then subdirectory)
-	var subdirMatch string
-	var subdirInfo *SessionInfo
-	for name, info := range config.Sessions {
-		if info == nil || info.Host != fromHost {
+	var out []string
+	for _, q := range set.Questions {
+		if q.Answered {
 			continue
 		}
-		// Exact match
-		if info.Path == projectPath {
-			// Skip prompt messages that were just sent from Telegram (cooldown 10s)
-			if strings.HasPrefix(message, "💬") && wasTelegramSent(info.TopicID) {
-				logHook("Remote", "skipping prompt (telegram cooldown) session=%s topic=%d", name, info.TopicID)
-				return nil
-			}
-			logHook("Remote", "matched session=%s topic=%d, sending", name, info.TopicID)
-			fmt.Printf("[remote] from=%s session=%s\n", fromHost, name)
-			histFrom, histText := parseRemoteMessagePrefix(message)
-			appendHistoryDedup(info.TopicID, histFrom, histText)
-			return sendMessage(config, config.GroupID, info.TopicID, message)
-		}
-		// Subdirectory match: projectPath is under this session's path
-		if strings.HasPrefix(projectPath, info.Path+"/") {
-			// Pick the longest (most specific) parent path
-			if subdirInfo == nil || len(info.Path) > len(subdirInfo.Path) {
-				subdirMatch = name
-				subdirInfo = info
-			}
+		summary := firstLine(q.Question, 80)
+		if q.Header != "" {
+			summary = q.Header + ": " + summary
 		}
+		out = append(out, summary)
 	}
+	return out
+}
 
-	// Use subdirectory match if found (cwd is inside an existing session's project)
-	if subdirInfo != nil {
-		if strings.HasPrefix(message, "💬") && wasTelegramSent(subdirInfo.TopicID) {
-			logHook("Remote", "skipping prompt (telegram cooldown) session=%s topic=%d", subdirMatch, subdirInfo.TopicID)
-			return nil
-		}
-		logHook("Remote", "subdir match session=%s topic=%d (cwd=%s)", subdirMatch, subdirInfo.TopicID, projectPath)
-		fmt.Printf("[remote] from=%s session=%s (subdir match)\n", fromHost, subdirMatch)
-		histFrom, histText := parseRemoteMessagePrefix(message)
-		appendHistoryDedup(subdirInfo.TopicID, histFrom, histText)
-		return sendMessage(config, config.GroupID, subdirInfo.TopicID, message)
-	}
+// Token usage and cost: the Stop hook ingests new transcript lines into
+// ~/.ccc/usage/usage.json (locked, since hooks of several sessions can run at
+// once). Remote clients keep their own store and send the turn's usage to the
+// server as trailer lines on the Stop message.
 
-	// No matching session found - auto-create topic (fallback for client-initiated sessions)
-	logHook("Remote", "no session for path=%s, creating topic", projectPath)
-	fmt.Printf("[remote] from=%s no session for path=%s, creating topic\n", fromHost, projectPath)
+func usageStorePath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "usage", "usage.json")
+}
 
-	// Generate session name: host:projectDir
-	fullName := fromHost + ":" + filepath.Base(projectPath)
+func modelPrices(cfg *Config) map[string]usage.Price {
+	prices := make(map[string]usage.Price, len(cfg.ModelPrices))
+	for model, p := range cfg.ModelPrices {
+		prices[model] = usage.Price{Input: p.Input, Output: p.Output, CacheWrite: p.CacheWrite, CacheRead: p.CacheRead}
+	}
+	return prices
+}
 
-	topicID, err := getOrCreateTopic(config, fullName, projectPath, fromHost)
+// withUsageStore runs fn on the usage store under an exclusive lock and saves it
+func withUsageStore(fn func(s *usage.Store)) error {
+	path := usageStorePath()
+	os.MkdirAll(filepath.Dir(path), 0755)
+	f, err := os.OpenFile(strings.TrimSuffix(path, ".json")+".lock", os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		// Fallback to private chat if topic creation fails
-		fmt.Fprintf(os.Stderr, "Failed to create topic: %v\n", err)
-		return sendMessage(config, config.ChatID, 0, fmt.Sprintf("[%s] %s", fromHost, message))
+		return err
 	}
+	defer f.Close()
+	syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 
-	fmt.Printf("[remote] created/reused topic %d for session %s\n", topicID, fullName)
-	// Store forwarded message in history (with dedup)
-	histFrom, histText := parseRemoteMessagePrefix(message)
-	appendHistoryDedup(topicID, histFrom, histText)
-	return sendMessage(config, config.GroupID, topicID, message)
+	s, err := usage.Load(path)
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return s.Save(path)
 }
 
-// parseRemoteMessagePrefix determines the sender and clean text from a
-// forwarded remote message. Messages from client-mode hooks have prefixes:
-//   - "✅ sessionName\n\n..." → from claude (stop hook = response)
-//   - "💬 ..." → from human (prompt hook)
-//   - everything else → from claude (output hook / tool output)
-func parseRemoteMessagePrefix(message string) (from string, text string) {
-	if strings.HasPrefix(message, "✅") {
-		// Stop hook: "✅ sessionName\n\n<response>"
-		if idx := strings.Index(message, "\n\n"); idx != -1 {
-			return "claude", message[idx+2:]
-		}
-		return "claude", message
+// loadUsageStore reads the usage store without locking (saves are atomic)
+func loadUsageStore() *usage.Store {
+	s, err := usage.Load(usageStorePath())
+	if err != nil {
+		s, _ = usage.Load("")
 	}
-	if strings.HasPrefix(message, "💬") {
-		// Prompt hook: "💬 <user message>"
-		text = strings.TrimPrefix(message, "💬 ")
-		text = strings.TrimPrefix(text, "💬")
-		return "human", strings.TrimSpace(text)
+	return s
+}
+
+// recordTurnUsage ingests new transcript lines for a session and returns
+// the trailer describing the latest turn ("" if there's nothing to report)
+func recordTurnUsage(cfg *Config, sessionName string, transcriptPath string) string {
+	if transcriptPath == "" {
+		return ""
 	}
-	// Output hook or other → claude
-	return "claude", message
+	var turn map[string]usage.Tokens
+	err := withUsageStore(func(s *usage.Store) {
+		var err error
+		if turn, err = s.Ingest(sessionName, transcriptPath, modelPrices(cfg)); err != nil {
+			logFor("usage").Error("ingest failed", logging.SessionKey, sessionName, "transcript", transcriptPath, "error", err)
+		}
+	})
+	if err != nil {
+		logFor("usage").Error("usage store unavailable", "error", err)
+		return ""
+	}
+	return usage.FormatTrailer(turn)
 }
 
-// appendHistoryDedup stores a message in history, but skips if the last
-// message with the same "from" already has identical text. This prevents
-// duplicates when both handleAskCmd (inline) and handleRemoteMessage
-// (stop hook forwarding) store the same response.
-func appendHistoryDedup(topicID int64, from string, text string) {
-	msgs, err := readHistory(topicID, 0, 1, from)
-	if err == nil && len(msgs) > 0 && msgs[len(msgs)-1].Text == text {
-		fmt.Printf("[history] dedup: skipping duplicate %s message for topic=%d\n", from, topicID)
-		return
+// withUsageTrailer appends a usage trailer to a Stop message body
+func withUsageTrailer(body string, trailer string) string {
+	if trailer == "" {
+		return body
 	}
-	appendHistory(topicID, HistoryMessage{
-		ID:        nextMessageID(),
-		Timestamp: time.Now().Unix(),
-		From:      from,
-		Text:      text,
+	return body + "\n\n" + trailer
+}
+
+// recordRemoteUsage stores a turn reported by a remote client
+func recordRemoteUsage(sessionName string, turn map[string]usage.Tokens) {
+	day := time.Now().Format("2006-01-02")
+	err := withUsageStore(func(s *usage.Store) {
+		for model, t := range turn {
+			s.Record(sessionName, day, model, t)
+		}
 	})
+	if err != nil {
+		logFor("usage").Error("usage store unavailable", "error", err)
+	}
 }
 
-// handleHostCommand handles /host subcommands
-func handleHostCommand(config *Config, chatID int64, threadID int64, text string) {
-	args := strings.Fields(text)
-	if len(args) < 2 {
-		sendMessage(config, chatID, threadID, `Host management commands:
-/host add <name> <address> [projects_dir]
-/host set <name> <address>
-/host del <name>
-/host list
-/host check <name>`)
+// budgetStatus returns today's spend and whether new prompts should be held
+func budgetStatus(cfg *Config) (spent float64, paused bool) {
+	if cfg.DailyBudget <= 0 {
+		return 0, false
+	}
+	today := time.Now().Format("2006-01-02")
+	s := loadUsageStore()
+	spent = s.DayCost(today)
+	paused = cfg.BudgetAction == "pause" && spent >= cfg.DailyBudget && s.BudgetResumed != today
+	return spent, paused
+}
+
+// checkBudget sends the once-a-day notice when today's spend crosses the budget
+func checkBudget(cfg *Config) {
+	if cfg.DailyBudget <= 0 {
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+	var spent float64
+	notify := false
+	withUsageStore(func(s *usage.Store) {
+		spent = s.DayCost(today)
+		if spent >= cfg.DailyBudget && s.BudgetWarned != today {
+			s.BudgetWarned = today
+			notify = true
+		}
+	})
+	if !notify {
 		return
 	}
 
-	subCmd := args[1]
+	msg := fmt.Sprintf("⚠️ Daily budget exceeded: $%.2f of $%.2f spent today", spent, cfg.DailyBudget)
+	if cfg.BudgetAction == "pause" {
+		msg += "\n\n⏸ New prompts are held until tomorrow. Use /budget resume to send them now."
+	}
+	sendMessage(cfg, cfg.ChatID, 0, msg)
+}
 
-	switch subCmd {
-	case "add":
-		// /host add <name> <address> [projects_dir]
-		if len(args) < 4 {
-			sendMessage(config, chatID, threadID, "Usage: /host add <name> <address> [projects_dir]\nExample: /host add laptop wlad@192.168.1.50 ~/Dev")
-			return
-		}
-		name := args[2]
-		address := args[3]
-		projectsDir := "~"
-		if len(args) >= 5 {
-			projectsDir = args[4]
-		}
+// heldPrompt is a topic prompt held back while the daily budget is exceeded
+type heldPrompt struct {
+	ChatID   int64
+	ThreadID int64
+	Session  string
+	Text     string
+	Username string
+}
 
-		// Check if host already exists
-		if config.Hosts != nil {
-			if _, exists := config.Hosts[name]; exists {
-				sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' already exists. Use /host set to update.", name))
-				return
-			}
-		}
+var (
+	heldPrompts   []heldPrompt
+	heldPromptsMu sync.Mutex
+)
 
-		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking connection to %s...", address))
+func holdPrompt(p heldPrompt) int {
+	heldPromptsMu.Lock()
+	defer heldPromptsMu.Unlock()
+	heldPrompts = append(heldPrompts, p)
+	return len(heldPrompts)
+}
 
-		// Check SSH connection
-		if err := sshCheckConnection(address); err != nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Cannot connect to %s: %v\nCheck SSH key setup.", address, err))
-			return
+// heldPromptCount returns the number of prompts held for a session
+func heldPromptCount(session string) int {
+	heldPromptsMu.Lock()
+	defer heldPromptsMu.Unlock()
+	n := 0
+	for _, p := range heldPrompts {
+		if p.Session == session {
+			n++
 		}
+	}
+	return n
+}
 
-		// Check tmux
-		tmuxPath, err := sshCheckCommand(address, "tmux")
-		if err != nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("⚠️ tmux not found on %s", name))
-			tmuxPath = "not found"
-		}
+// releaseHeldPrompts sends held prompts once the budget no longer pauses them
+func releaseHeldPrompts(cfg *Config) {
+	if _, paused := budgetStatus(cfg); paused {
+		return
+	}
+	heldPromptsMu.Lock()
+	prompts := heldPrompts
+	heldPrompts = nil
+	heldPromptsMu.Unlock()
 
-		// Check claude
-		claudePath, err := sshCheckCommand(address, "claude")
-		if err != nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("⚠️ claude not found on %s", name))
-			claudePath = "not found"
-		}
+	for _, p := range prompts {
+		sendMessage(cfg, p.ChatID, p.ThreadID, "▶️ Sending held prompt: "+firstLine(p.Text, 60))
+		deliverPrompt(cfg, p.ChatID, p.ThreadID, p.Session, p.Text, p.Username)
+	}
+}
 
-		// Save host
-		if config.Hosts == nil {
-			config.Hosts = make(map[string]*HostInfo)
-		}
-		config.Hosts[name] = &HostInfo{
-			Address:     address,
-			ProjectsDir: projectsDir,
+// startBudgetMonitor releases held prompts when a new day starts
+func startBudgetMonitor() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			heldPromptsMu.Lock()
+			n := len(heldPrompts)
+			heldPromptsMu.Unlock()
+			if n == 0 {
+				continue
+			}
+			if cfg, err := loadConfig(); err == nil {
+				releaseHeldPrompts(cfg)
+			}
 		}
-		saveConfig(config)
+	}()
+}
 
-		msg := fmt.Sprintf(`✅ Host '%s' added!
+// Idle shutdown: sessions nobody has used for idle_timeout_hours are stopped
+// and marked sleeping; the next prompt restarts them with -c
 
-Address: %s
-Projects dir: %s
-tmux: %s
-claude: %s`, name, address, projectsDir, tmuxPath, claudePath)
-		sendMessage(config, chatID, threadID, msg)
+const idleCheckInterval = 5 * time.Minute
 
-	case "set":
-		// /host set <name> <address>
-		if len(args) < 4 {
-			sendMessage(config, chatID, threadID, "Usage: /host set <name> <address>")
-			return
-		}
-		name := args[2]
-		address := args[3]
+// idleTimeout returns how long a session may stay idle before it is put to
+// sleep (0 = never)
+func idleTimeout(cfg *Config, info *SessionInfo) time.Duration {
+	hours := cfg.IdleTimeoutHours
+	if info.IdleTimeoutHours != nil {
+		hours = *info.IdleTimeoutHours
+	}
+	return time.Duration(hours) * time.Hour
+}
 
-		if config.Hosts == nil || config.Hosts[name] == nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found", name))
-			return
+// idleExpired reports whether a session in state st has been idle for at
+// least timeout. Activity is the newer of the last history message and the
+// session's start, so a freshly woken session gets a full timeout again.
+func idleExpired(st sessionStatus, timeout time.Duration, now time.Time) bool {
+	if timeout <= 0 || st.State != "idle" {
+		return false
+	}
+	last := st.LastActivity
+	if st.Started.After(last) {
+		last = st.Started
+	}
+	return !last.IsZero() && now.Sub(last) >= timeout
+}
+
+// startIdleMonitor puts idle sessions to sleep in the background
+func startIdleMonitor() {
+	go func() {
+		for range time.Tick(idleCheckInterval) {
+			cfg, err := loadConfig()
+			if err != nil {
+				continue
+			}
+			for name, info := range cfg.Sessions {
+				if info == nil || info.Deleted || info.Sleeping {
+					continue
+				}
+				timeout := idleTimeout(cfg, info)
+				if timeout <= 0 {
+					continue
+				}
+				if idleExpired(collectSessionStatus(cfg, name, info), timeout, time.Now()) {
+					sleepSession(cfg, name, info, timeout)
+				}
+			}
 		}
+	}()
+}
 
-		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking connection to %s...", address))
+// sleepSession asks Claude to exit, kills the tmux session and marks the
+// session sleeping; the topic is kept
+func sleepSession(cfg *Config, name string, info *SessionInfo, idleFor time.Duration) {
+	_, projectName := parseSessionTarget(name)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	address := getHostAddress(cfg, info.Host)
+	if info.Host != "" && address == "" {
+		return
+	}
 
-		// Check SSH connection
-		if err := sshCheckConnection(address); err != nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Cannot connect to %s: %v", address, err))
+	// Let Claude save the conversation before the session goes away
+	if address != "" {
+		sshTmuxSendKeys(address, tmuxName, "/exit")
+	} else {
+		sendToSession(tmuxName, "/exit")
+	}
+	for i := 0; i < 10 && isClaudeRunning(tmuxName, address); i++ {
+		time.Sleep(time.Second)
+	}
+	if address != "" {
+		if err := sshTmuxKillSession(address, tmuxName); err != nil {
+			logFor("idle").Error("stopping idle session failed", logging.SessionKey, name, "error", err)
 			return
 		}
+	} else {
+		killLocalSession(tmuxName)
+	}
 
-		config.Hosts[name].Address = address
-		saveConfig(config)
-		sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Host '%s' updated to %s", name, address))
+	setSessionSleeping(name, true)
+	logFor("idle").Info("stopped idle session", logging.SessionKey, name, "idle", formatDuration(idleFor))
+	if cfg.GroupID != 0 && info.TopicID != 0 {
+		sendMessage(cfg, cfg.GroupID, info.TopicID, fmt.Sprintf("💤 Session stopped after %s idle. Send a message to wake it up.", formatDuration(idleFor)))
+	}
+}
 
-	case "del":
-		// /host del <name>
-		if len(args) < 3 {
-			sendMessage(config, chatID, threadID, "Usage: /host del <name>")
-			return
+// setSessionSleeping records the sleeping flag in a freshly loaded config so
+// concurrent changes are not overwritten
+func setSessionSleeping(name string, sleeping bool) {
+	updateConfig(func(cfg *Config) error {
+		if info := cfg.Sessions[name]; info != nil {
+			info.Sleeping = sleeping
 		}
-		name := args[2]
+		return nil
+	})
+}
 
-		if config.Hosts == nil || config.Hosts[name] == nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found", name))
-			return
-		}
+// Session supervisor: the listener restarts Claude with -c in sessions whose
+// pane dropped back to a shell, backing off and giving up on crash loops
 
-		// Check if there are active sessions on this host
-		for sessName, info := range config.Sessions {
-			if info != nil && info.Host == name {
-				sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Cannot delete: session '%s' uses this host", sessName))
-				return
-			}
-		}
+const supervisorInterval = 30 * time.Second
 
-		delete(config.Hosts, name)
-		saveConfig(config)
-		sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Host '%s' deleted", name))
+// crashTrackers is only touched by the supervisor goroutine
+var crashTrackers = make(map[string]*supervisor.Tracker)
 
-	case "list":
-		// /host list
-		if config.Hosts == nil || len(config.Hosts) == 0 {
-			sendMessage(config, chatID, threadID, "No hosts configured.\nUse /host add <name> <address> to add one.")
-			return
-		}
+// restartPolicy returns the session's restart policy (its own or the global
+// one) and whether automatic restarts are enabled
+func restartPolicy(cfg *Config, info *SessionInfo) (supervisor.Policy, bool) {
+	rp := cfg.AutoRestart
+	if info.AutoRestart != nil {
+		rp = info.AutoRestart
+	}
+	if rp == nil {
+		return supervisor.Policy{}.WithDefaults(), true
+	}
+	p := supervisor.Policy{
+		MaxRestarts: rp.MaxRestarts,
+		Window:      time.Duration(rp.WindowMinutes) * time.Minute,
+		Backoff:     time.Duration(rp.BackoffSeconds) * time.Second,
+		MaxBackoff:  time.Duration(rp.MaxBackoffSeconds) * time.Second,
+	}
+	return p.WithDefaults(), !rp.Disabled
+}
 
-		var lines []string
-		for name, info := range config.Hosts {
-			lines = append(lines, fmt.Sprintf("• %s → %s (%s)", name, info.Address, info.ProjectsDir))
+// startSupervisor checks every session's pane in the background
+func startSupervisor() {
+	go func() {
+		for range time.Tick(supervisorInterval) {
+			cfg, err := loadConfig()
+			if err != nil {
+				continue
+			}
+			for name, info := range cfg.Sessions {
+				if info == nil || info.Deleted || info.Sleeping {
+					delete(crashTrackers, name)
+					continue
+				}
+				superviseSession(cfg, name, info)
+			}
 		}
-		sendMessage(config, chatID, threadID, "Configured hosts:\n"+strings.Join(lines, "\n"))
+	}()
+}
 
-	case "check":
-		// /host check <name>
-		if len(args) < 3 {
-			sendMessage(config, chatID, threadID, "Usage: /host check <name>")
-			return
-		}
-		name := args[2]
+// superviseSession restarts Claude in a session whose tmux session exists
+// but no longer shows Claude, following the session's restart policy
+func superviseSession(cfg *Config, name string, info *SessionInfo) {
+	policy, enabled := restartPolicy(cfg, info)
+	if !enabled {
+		delete(crashTrackers, name)
+		return
+	}
+	_, projectName := parseSessionTarget(name)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	address := getHostAddress(cfg, info.Host)
+	if info.Host != "" && address == "" {
+		return
+	}
 
-		if config.Hosts == nil || config.Hosts[name] == nil {
-			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found", name))
-			return
-		}
+	// A missing tmux session was stopped on purpose, not crashed
+	if address != "" && !sshTmuxHasSession(address, tmuxName) || address == "" && !backend().Exists(tmuxName) {
+		delete(crashTrackers, name)
+		return
+	}
 
-		host := config.Hosts[name]
-		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking %s (%s)...", name, host.Address))
+	tr := crashTrackers[name]
+	if tr == nil {
+		tr = &supervisor.Tracker{}
+		crashTrackers[name] = tr
+	}
+	if isClaudeRunning(tmuxName, address) {
+		tr.Running()
+		return
+	}
 
-		var results []string
+	action := tr.Crashed(policy, time.Now())
+	if action == supervisor.None {
+		return
+	}
+	pane, _ := captureTmuxPane(tmuxName, address, 50)
 
-		// Check SSH
-		if err := sshCheckConnection(host.Address); err != nil {
-			results = append(results, fmt.Sprintf("❌ SSH connection: %v", err))
+	var notice string
+	if action == supervisor.GiveUp {
+		notice = fmt.Sprintf("🛑 Claude crashed %d times within %s; auto-restart paused. Use /continue to restart it.", tr.Attempts(), formatDuration(policy.Window))
+		logFor("supervisor").Error("crash loop, giving up", logging.SessionKey, name)
+	} else {
+		tr.Restarted(time.Now())
+		if restartClaudeInSession(tmuxName, address) {
+			notice = fmt.Sprintf("🔁 Claude exited unexpectedly and was restarted with -c (attempt %d/%d)", tr.Attempts(), policy.MaxRestarts)
 		} else {
-			results = append(results, "✅ SSH connection: OK")
+			notice = fmt.Sprintf("⚠️ Claude exited unexpectedly and the restart failed (attempt %d/%d); retrying in %s", tr.Attempts(), policy.MaxRestarts, formatDuration(policy.Delay(tr.Attempts())))
 		}
+		logFor("supervisor").Warn("restarting crashed session", logging.SessionKey, name, "attempt", tr.Attempts())
+	}
+	if cfg.GroupID != 0 && info.TopicID != 0 {
+		sendHTMLMessage(cfg, cfg.GroupID, info.TopicID, formatCrashNotice(notice, pane, 15))
+	}
+}
 
-		// Check tmux
-		if tmuxPath, err := sshCheckCommand(host.Address, "tmux"); err != nil {
-			results = append(results, "❌ tmux: not found")
-		} else {
-			results = append(results, fmt.Sprintf("✅ tmux: %s", tmuxPath))
-		}
+// formatCrashNotice returns notice as Telegram HTML followed by the last
+// non-empty lines of the pane in a preformatted block
+func formatCrashNotice(notice string, pane string, lines int) string {
+	all := strings.Split(strings.TrimRight(pane, " \n"), "\n")
+	for len(all) > 0 && strings.TrimSpace(all[0]) == "" {
+		all = all[1:]
+	}
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	if len(all) == 0 {
+		return html.EscapeString(notice)
+	}
+	return fmt.Sprintf("%s\n\nLast output:\n<pre>%s</pre>", html.EscapeString(notice), html.EscapeString(strings.Join(all, "\n")))
+}
 
-		// Check claude
-		if claudePath, err := sshCheckCommand(host.Address, "claude"); err != nil {
-			results = append(results, "❌ claude: not found")
-		} else {
-			results = append(results, fmt.Sprintf("✅ claude: %s", claudePath))
-		}
+// Session recording: tmux pipe-pane streams a session's output into
+// "ccc record-pipe", which appends it to an asciicast v2 file under
+// ~/.ccc/recordings on the machine running the session
 
-		// Check projects_dir
-		if sshDirExists(host.Address, host.ProjectsDir) {
-			results = append(results, fmt.Sprintf("✅ projects_dir: %s (exists)", host.ProjectsDir))
-		} else {
-			results = append(results, fmt.Sprintf("⚠️ projects_dir: %s (will be created)", host.ProjectsDir))
-		}
+const (
+	recordingCheckInterval       = time.Minute
+	recordingRemotePruneInterval = time.Hour
+	recordingKeepRecent          = 10 * time.Minute // Files written to recently belong to sessions being recorded
+	defaultRecordingMaxDays      = 30
+	defaultRecordingMaxMB        = 1024
+	maxRecordingUpload           = 50 << 20 // Telegram's limit for bot uploads
+)
 
-		sendMessage(config, chatID, threadID, strings.Join(results, "\n"))
+// recordingsDir returns the local recordings directory
+func recordingsDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "recordings")
+}
 
-	default:
-		sendMessage(config, chatID, threadID, fmt.Sprintf("Unknown subcommand: %s\nUse /host for help.", subCmd))
-	}
+// recordingPath returns the local recording file of a tmux session
+func recordingPath(tmuxName string) string {
+	return filepath.Join(recordingsDir(), tmuxName+".cast")
 }
 
-// Main listen loop
+// remoteRecordingPath is recordingPath on a remote host, as a shell word
+func remoteRecordingPath(tmuxName string) string {
+	return `"$HOME"/.ccc/recordings/` + shellQuote(tmuxName+".cast")
+}
 
-func listen() error {
-	// Acquire exclusive lock to prevent multiple instances
-	lockPath := filepath.Join(os.Getenv("HOME"), ".ccc.lock")
-	var lockErr error
-	lockFile, lockErr = os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
-	if lockErr != nil {
-		return fmt.Errorf("failed to open lock file: %w", lockErr)
+// paneInfoFormat asks tmux for the pane size and whether it is piped
+const paneInfoFormat = "#{pane_width} #{pane_height} #{pane_pipe}"
+
+// parsePaneInfo parses the output of paneInfoFormat
+func parsePaneInfo(out string) (width int, height int, piped bool, err error) {
+	var pipe int
+	if _, err := fmt.Sscanf(strings.TrimSpace(out), "%d %d %d", &width, &height, &pipe); err != nil {
+		return 0, 0, false, fmt.Errorf("unexpected pane info %q", strings.TrimSpace(out))
 	}
-	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
-		lockFile.Close()
-		lockFile = nil
-		return fmt.Errorf("another ccc listen instance is already running (lock: %s)", lockPath)
+	return width, height, pipe == 1, nil
+}
+
+// startRecording pipes a tmux pane into its recording file unless it is
+// already piped
+func startRecording(tmuxName string, sshAddress string) error {
+	if sshAddress != "" {
+		out, err := runSSH(sshAddress, fmt.Sprintf("tmux display-message -p -t %s %s", shellQuote(tmuxName), shellQuote(paneInfoFormat)), time.Duration(sshCommandTimeout)*time.Second)
+		if err != nil {
+			return err
+		}
+		width, height, piped, err := parsePaneInfo(out)
+		if err != nil || piped {
+			return err
+		}
+		pipe := fmt.Sprintf(`exec "$(command -v ccc || echo ~/bin/ccc)" record-pipe %s %d %d`, remoteRecordingPath(tmuxName), width, height)
+		cmd := fmt.Sprintf("mkdir -p ~/.ccc/recordings && tmux pipe-pane -o -t %s %s", shellQuote(tmuxName), shellQuote(pipe))
+		_, err = runSSH(sshAddress, cmd, time.Duration(sshCommandTimeout)*time.Second)
+		return err
 	}
-	// lockFile is kept open at package level — lock released when process exits
 
-	config, err := loadConfig()
+	if backend().Name() != "tmux" {
+		return fmt.Errorf("recording needs the tmux backend")
+	}
+	out, err := tmuxCmd("display-message", "-p", "-t", tmuxName, paneInfoFormat).Output()
 	if err != nil {
-		return fmt.Errorf("not configured. Run: ccc setup <bot_token>")
+		return err
+	}
+	width, height, piped, err := parsePaneInfo(string(out))
+	if err != nil || piped {
+		return err
+	}
+	if err := os.MkdirAll(recordingsDir(), 0700); err != nil {
+		return err
 	}
+	pipe := fmt.Sprintf("exec %s record-pipe %s %d %d", shellQuote(cccPath), shellQuote(recordingPath(tmuxName)), width, height)
+	return tmuxCmd("pipe-pane", "-o", "-t", tmuxName, pipe).Run()
+}
 
-	fmt.Printf("Bot listening... (chat: %d, group: %d)\n", config.ChatID, config.GroupID)
-	fmt.Printf("Active sessions: %d\n", len(config.Sessions))
-	fmt.Println("Press Ctrl+C to stop")
+// stopRecording closes a tmux pane's pipe
+func stopRecording(tmuxName string, sshAddress string) error {
+	if sshAddress != "" {
+		_, err := runSSH(sshAddress, "tmux pipe-pane -t "+shellQuote(tmuxName), time.Duration(sshCommandTimeout)*time.Second)
+		return err
+	}
+	if backend().Name() != "tmux" {
+		return nil
+	}
+	return tmuxCmd("pipe-pane", "-t", tmuxName).Run()
+}
 
-	// Initialize message ID counter from history
-	initMessageIDCounter()
+// readRecording returns the recording file of a tmux session
+func readRecording(tmuxName string, sshAddress string) ([]byte, error) {
+	if sshAddress != "" {
+		out, err := runSSH(sshAddress, "cat "+remoteRecordingPath(tmuxName), 2*time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("no recording found: %v", err)
+		}
+		return []byte(out), nil
+	}
+	data, err := os.ReadFile(recordingPath(tmuxName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording found")
+	}
+	return data, err
+}
 
-	// Start Unix socket API server
-	if err := startSocketServer(config); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to start API socket: %v\n", err)
+// trimRecording drops the output of a session's recording before since
+func trimRecording(tmuxName string, sshAddress string, since time.Time) error {
+	if sshAddress != "" {
+		cmd := fmt.Sprintf(`"$(command -v ccc || echo ~/bin/ccc)" record-trim %s %d`, remoteRecordingPath(tmuxName), since.Unix())
+		_, err := runSSH(sshAddress, cmd, time.Minute)
+		return err
 	}
+	return recording.Trim(recordingPath(tmuxName), since)
+}
 
-	setBotCommands(config.BotToken)
+// moveRecording renames a session's recording after the session is renamed,
+// restarting the pipe so it writes to the new file
+func moveRecording(tmuxName string, newTmux string, sshAddress string, active bool) {
+	if active {
+		stopRecording(newTmux, sshAddress)
+	}
+	if sshAddress != "" {
+		runSSH(sshAddress, fmt.Sprintf("mv %s %s 2>/dev/null; true", remoteRecordingPath(tmuxName), remoteRecordingPath(newTmux)), time.Duration(sshCommandTimeout)*time.Second)
+	} else {
+		os.Rename(recordingPath(tmuxName), recordingPath(newTmux))
+	}
+	if active {
+		startRecording(newTmux, sshAddress)
+	}
+}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// removeRecording deletes a session's recording
+func removeRecording(tmuxName string, sshAddress string) {
+	if sshAddress != "" {
+		runSSH(sshAddress, "rm -f "+remoteRecordingPath(tmuxName), time.Duration(sshCommandTimeout)*time.Second)
+		return
+	}
+	os.Remove(recordingPath(tmuxName))
+}
 
-	offset := 0
-	client := &http.Client{Timeout: 35 * time.Second}
+// describeRecording summarizes a recording for /recording
+func describeRecording(data []byte) string {
+	h, events, err := recording.Read(bytes.NewReader(data))
+	if err != nil {
+		return err.Error()
+	}
+	start := time.Unix(h.Timestamp, 0)
+	return fmt.Sprintf("%.1f MB, %s of output since %s (%dx%d)",
+		float64(len(data))/(1<<20), formatDuration(recording.Duration(events)), start.Format("Jan 2 15:04"), h.Width, h.Height)
+}
 
-	go func() {
-		<-sigChan
-		fmt.Println("\nShutting down...")
-		stopSocketServer()
-		os.Exit(0)
-	}()
+// recordingRetention returns the configured age and size limits for recordings
+func recordingRetention(cfg *Config) (time.Duration, int64) {
+	maxDays, maxMB := cfg.RecordingMaxDays, cfg.RecordingMaxMB
+	if maxDays == 0 {
+		maxDays = defaultRecordingMaxDays
+	}
+	if maxMB == 0 {
+		maxMB = defaultRecordingMaxMB
+	}
+	return time.Duration(maxDays) * 24 * time.Hour, int64(maxMB) << 20
+}
 
-	for {
-		// Reload config from disk to pick up sessions created by external
-		// processes (hook CLIs, handleRemoteMessage, etc.)
-		if freshCfg, err := loadConfig(); err == nil {
-			config = freshCfg
+// pruneRemoteRecordings applies the retention limits on every host with
+// sessions, through "ccc record-prune" on the host
+func pruneRemoteRecordings(cfg *Config) {
+	maxAge, maxBytes := recordingRetention(cfg)
+	hosts := make(map[string]bool)
+	for _, info := range cfg.Sessions {
+		if info != nil && info.Host != "" {
+			hosts[info.Host] = true
 		}
-
-		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", config.BotToken, offset)
-		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+	}
+	for host := range hosts {
+		address := getHostAddress(cfg, host)
+		if address == "" {
+			continue
+		}
+		cmd := fmt.Sprintf(`"$(command -v ccc || echo ~/bin/ccc)" record-prune %d %d`, int64(maxAge/time.Second), maxBytes)
+		out, err := runSSH(address, cmd, time.Minute)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Network error: %v (retrying...)\n", err)
-			time.Sleep(5 * time.Second)
+			logFor("recording").Warn("pruning remote recordings failed", "host", host, "error", err)
 			continue
 		}
+		for _, name := range strings.Fields(out) {
+			logFor("recording").Info("removed recording (retention)", "host", host, "file", name)
+		}
+	}
+}
 
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
-		resp.Body.Close()
+// startRecordingMonitor keeps recording sessions piped (new tmux sessions
+// start without a pipe) and applies the retention limits to recordings, on
+// remote hosts once every recordingRemotePruneInterval
+func startRecordingMonitor() {
+	go func() {
+		var remotePruned time.Time
+		for range time.Tick(recordingCheckInterval) {
+			cfg, err := loadConfig()
+			if err != nil {
+				continue
+			}
+			for name, info := range cfg.Sessions {
+				if info == nil || !info.Record || info.Deleted || info.Sleeping {
+					continue
+				}
+				_, projectName := parseSessionTarget(name)
+				tmuxName := tmuxSessionName(extractProjectName(projectName))
+				address := getHostAddress(cfg, info.Host)
+				if info.Host != "" && (address == "" || !sshTmuxHasSession(address, tmuxName)) || info.Host == "" && !backend().Exists(tmuxName) {
+					continue
+				}
+				if err := startRecording(tmuxName, address); err != nil {
+					logFor("recording").Warn("starting recording failed", logging.SessionKey, name, "error", err)
+				}
+			}
 
-		var updates TelegramUpdate
-		if err := json.Unmarshal(body, &updates); err != nil {
-			fmt.Fprintf(os.Stderr, "Parse error: %v\n", err)
-			time.Sleep(time.Second)
-			continue
+			maxAge, maxBytes := recordingRetention(cfg)
+			removed, _ := recording.Prune(recordingsDir(), maxAge, maxBytes, recordingKeepRecent, time.Now())
+			for _, path := range removed {
+				logFor("recording").Info("removed recording (retention)", "file", filepath.Base(path))
+			}
+			if time.Since(remotePruned) >= recordingRemotePruneInterval {
+				remotePruned = time.Now()
+				pruneRemoteRecordings(cfg)
+			}
 		}
+	}()
+}
 
-		if !updates.OK {
-			fmt.Fprintf(os.Stderr, "Telegram API error: %s\n", updates.Description)
-			time.Sleep(5 * time.Second)
-			continue
+// replayRecording plays a session's recording (or a .cast file) in the terminal
+func replayRecording(target string, since string, speed float64) error {
+	var data []byte
+	var err error
+	if strings.HasSuffix(target, ".cast") {
+		data, err = os.ReadFile(target)
+	} else {
+		cfg, cfgErr := loadConfig()
+		if cfgErr != nil {
+			return cfgErr
+		}
+		_, projectName := parseSessionTarget(target)
+		tmuxName := tmuxSessionName(extractProjectName(projectName))
+		address := ""
+		if info := cfg.Sessions[target]; info != nil && info.Host != "" {
+			if address = getHostAddress(cfg, info.Host); address == "" {
+				return fmt.Errorf("host '%s' not configured", info.Host)
+			}
 		}
+		data, err = readRecording(tmuxName, address)
+	}
+	if err != nil {
+		return err
+	}
 
-		for _, update := range updates.Result {
-			offset = update.UpdateID + 1
+	h, events, err := recording.Read(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	opts := recording.PlayOptions{Speed: speed}
+	if since != "" {
+		if opts.Since, err = recording.ParseSince(since, time.Now()); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %s (%dx%d, %s). Ctrl-C to stop.\n", target, h.Width, h.Height, formatDuration(recording.Duration(events)))
+	fmt.Print("\033[H\033[2J")
+	err = recording.Play(os.Stdout, h, events, opts)
+	fmt.Print("\033[0m\n")
+	return err
+}
+
+// parseUsageArgs splits "/usage [session] [period]" arguments. A single
+// argument is a period if it parses as one, otherwise a session name.
+// Session "all" (or an empty default) reports every session.
+func parseUsageArgs(args []string, defaultSession string) (session string, period string) {
+	session = defaultSession
+	switch len(args) {
+	case 0:
+	case 1:
+		if _, _, err := usage.PeriodRange(args[0], time.Now()); err == nil {
+			period = args[0]
+		} else {
+			session = args[0]
+		}
+	default:
+		session, period = args[0], args[1]
+	}
+	if session == "all" {
+		session = ""
+	}
+	if period == "" {
+		period = "today"
+	}
+	return session, period
+}
+
+// renderUsageReport formats a usage report for Telegram
+func renderUsageReport(r usage.Report, period string) string {
+	var b strings.Builder
+	scope := r.Session
+	if scope == "" {
+		scope = "all sessions"
+	}
+	b.WriteString(fmt.Sprintf("📊 Usage · %s · %s\n", scope, period))
+	if r.Total.Messages == 0 {
+		b.WriteString("\nNo usage recorded")
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("\n💰 $%.4f · %d messages\n", r.Total.Cost, r.Total.Messages))
+	b.WriteString(fmt.Sprintf("🔤 %s in / %s out / %s cache\n",
+		usage.Comma(r.Total.Input), usage.Comma(r.Total.Output), usage.Comma(r.Total.CacheWrite+r.Total.CacheRead)))
+
+	section := func(title string, totals map[string]usage.Tokens, byCost bool) {
+		if len(totals) < 2 {
+			return
+		}
+		keys := make([]string, 0, len(totals))
+		for k := range totals {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if byCost {
+				return totals[keys[i]].Cost > totals[keys[j]].Cost
+			}
+			return keys[i] > keys[j]
+		})
+		b.WriteString("\n" + title + "\n")
+		for _, k := range keys {
+			b.WriteString(fmt.Sprintf("• %s: $%.4f\n", k, totals[k].Cost))
+		}
+	}
+	if r.Session == "" {
+		section("By session:", r.BySession, true)
+	}
+	section("By model:", r.ByModel, true)
+	section("By day:", r.ByDay, false)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// deliverPrompt sends a prompt typed in a session topic to its tmux pane,
+// starting or restarting the session first if needed
+func deliverPrompt(config *Config, chatID int64, threadID int64, sessionName string, text string, username string) {
+	sessionInfo := config.Sessions[sessionName]
+
+	// Ensure session is running (auto-start if stopped, auto-restart if crashed)
+	if sessionInfo != nil && sessionInfo.Sleeping {
+		sendMessage(config, chatID, threadID, "⏰ Waking up session…")
+	}
+	if errMsg := ensureSessionRunning(config, sessionName, sessionInfo); errMsg != "" {
+		sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %s", errMsg))
+		return
+	}
+
+	startContinuousTyping(config, chatID, threadID, sessionName)
+	// Store in history
+	appendHistory(threadID, HistoryMessage{
+		ID:        nextMessageID(),
+		Timestamp: time.Now().Unix(),
+		From:      "human",
+		Text:      text,
+		Username:  username,
+	})
+	markTelegramSent(threadID)
+
+	// Send to tmux (remote or local)
+	sendErr := typePrompt(config, sessionName, sessionInfo, text, "telegram", "")
+	recordAudit(config, telegramActor(config.ChatID, username), "prompt", sessionName, text, auditResult(sendErr))
+	if sendErr != nil {
+		stopContinuousTyping(sessionName)
+		sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to send: %v", sendErr))
+	} else {
+		startLiveProgress(config, chatID, threadID, sessionName)
+	}
+	// Background capture for remote sessions (fallback if client-mode forwarding is inactive)
+	captureResponseAsync(config, sessionName, sessionInfo)
+}
+
+// Session management
+
+func createSession(config *Config, name string) error {
+	// Check if session already exists
+	if _, exists := config.Sessions[name]; exists {
+		return fmt.Errorf("session '%s' already exists", name)
+	}
+
+	// Create Telegram topic
+	topicID, err := createForumTopic(config, name)
+	if err != nil {
+		return fmt.Errorf("failed to create topic: %w", err)
+	}
+
+	// Create tmux session
+	workDir := resolveProjectPath(config, name)
+	if _, err := os.Stat(workDir); os.IsNotExist(err) {
+		// Create project directory
+		os.MkdirAll(workDir, 0755)
+	}
+
+	if err := backend().Create(tmuxSessionName(name), workDir, false); err != nil {
+		return fmt.Errorf("failed to create tmux session: %w", err)
+	}
+
+	// Save mapping with full path
+	if err := addSession(config, name, &SessionInfo{
+		TopicID: topicID,
+		Path:    workDir,
+	}); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	return nil
+}
+
+func killSession(config *Config, name string) error {
+	sessionInfo, exists := config.Sessions[name]
+	if !exists {
+		return fmt.Errorf("session '%s' not found", name)
+	}
+
+	// Extract project name for tmux session (without host prefix)
+	_, projectName := parseSessionTarget(name)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+	// Kill tmux session (remote or local)
+	if sessionInfo != nil && sessionInfo.Host != "" {
+		address := getHostAddress(config, sessionInfo.Host)
+		if address != "" {
+			sshTmuxKillSession(address, tmuxName)
+		}
+	} else {
+		killLocalSession(tmuxName)
+	}
+
+	stopLiveProgress(name)
+
+	// Mark as deleted but keep in config to preserve topic mapping
+	updateSession(config, name, func(info *SessionInfo) {
+		info.Deleted = true
+		info.ContainerID = ""
+	})
+
+	return nil
+}
+
+func getSessionByTopic(cfg *Config, topicID int64) string { return config.GetSessionByTopic(cfg, topicID) }
+
+// Rename, archive and purge
+
+// renameSession renames a session: its config entry, tmux session, topic
+// title and pipeline references. The project directory stays where it is.
+func renameSession(cfg *Config, oldName string, newName string) error {
+	info, exists := cfg.Sessions[oldName]
+	if !exists {
+		return fmt.Errorf("session '%s' not found", oldName)
+	}
+	newHost, newProject := parseSessionTarget(newName)
+	if newHost == "" && info.Host != "" {
+		newName = fullSessionName(info.Host, newProject)
+	} else if newHost != info.Host {
+		return fmt.Errorf("can't move a session between hosts")
+	}
+	if newProject == "" || strings.ContainsAny(newProject, "/ ") {
+		return fmt.Errorf("invalid session name '%s'", newName)
+	}
+	if _, taken := cfg.Sessions[newName]; taken {
+		return fmt.Errorf("session '%s' already exists", newName)
+	}
+
+	_, oldProject := parseSessionTarget(oldName)
+	oldTmux := tmuxSessionName(extractProjectName(oldProject))
+	newTmux := tmuxSessionName(newProject)
+	if newTmux != oldTmux {
+		address := getHostAddress(cfg, info.Host)
+		if info.Host != "" {
+			if address == "" {
+				return fmt.Errorf("host '%s' not configured", info.Host)
+			}
+			if sshTmuxHasSession(address, oldTmux) {
+				cmd := fmt.Sprintf("tmux rename-session -t %s %s", shellQuote(oldTmux), shellQuote(newTmux))
+				if out, err := runSSH(address, cmd, 10*time.Second); err != nil {
+					return fmt.Errorf("failed to rename tmux session: %v %s", err, strings.TrimSpace(out))
+				}
+			}
+		} else if backend().Exists(oldTmux) {
+			if backend().Exists(newTmux) {
+				return fmt.Errorf("a %s session named %s already exists", backend().Name(), newTmux)
+			}
+			if err := backend().Rename(oldTmux, newTmux); err != nil {
+				return fmt.Errorf("failed to rename %s session: %w", backend().Name(), err)
+			}
+		}
+		os.Rename(containerCIDFile(oldTmux), containerCIDFile(newTmux))
+		moveRecording(oldTmux, newTmux, address, info.Record)
+	}
+
+	delete(cfg.Sessions, oldName)
+	cfg.Sessions[newName] = info
+	renamePipelineRefs(cfg.Pipelines, oldName, newName)
+	if _, err := updateConfig(func(fresh *Config) error {
+		if moved := fresh.Sessions[oldName]; moved != nil {
+			delete(fresh.Sessions, oldName)
+			fresh.Sessions[newName] = moved
+		}
+		renamePipelineRefs(fresh.Pipelines, oldName, newName)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := withUsageStore(func(s *usage.Store) { s.Rename(oldName, newName) }); err != nil {
+		logFor("usage").Warn("moving usage to the new session name failed", logging.SessionKey, newName, "error", err)
+	}
+
+	// Status monitor state is keyed by name; let it republish under the new one
+	lastStatusKeys.Delete(oldName)
+	lastTopicState.Delete(oldName)
+	if info.TopicID != 0 {
+		if err := editForumTopic(cfg, info.TopicID, newName); err != nil && !strings.Contains(err.Error(), "NOT_MODIFIED") {
+			return fmt.Errorf("renamed, but the topic title was not updated: %v", err)
+		}
+	}
+	return nil
+}
+
+// renamePipelineRefs replaces exact references to a session in pipeline
+// sources and targets and returns how many pipelines changed
+func renamePipelineRefs(pipelines []Pipeline, oldName string, newName string) int {
+	changed := 0
+	for i := range pipelines {
+		p := &pipelines[i]
+		items := strings.Split(p.From, ",")
+		touched := false
+		for j, item := range items {
+			if strings.TrimSpace(item) == oldName {
+				items[j] = newName
+				touched = true
+			}
+		}
+		if touched {
+			p.From = strings.Join(items, ",")
+		}
+		if p.To == oldName {
+			p.To = newName
+			touched = true
+		}
+		if touched {
+			changed++
+		}
+	}
+	return changed
+}
+
+// archiveSession stops a session, closes its topic and compresses its
+// history. /new or /continue with the same name brings it back.
+func archiveSession(cfg *Config, name string) (int, error) {
+	info, exists := cfg.Sessions[name]
+	if !exists {
+		return 0, fmt.Errorf("session '%s' not found", name)
+	}
+	if err := killSession(cfg, name); err != nil {
+		return 0, err
+	}
+	if info.TopicID != 0 {
+		if err := closeForumTopic(cfg, info.TopicID); err != nil && !strings.Contains(err.Error(), "TOPIC_NOT_MODIFIED") {
+			return 0, err
+		}
+	}
+	files, err := compressHistory(info.TopicID)
+	updateSession(cfg, name, func(info *SessionInfo) { info.Archived = true })
+	return files, err
+}
+
+// unarchiveSession reopens the topic of an archived session and restores
+// its history
+func unarchiveSession(cfg *Config, info *SessionInfo) {
+	if err := reopenForumTopic(cfg, info.TopicID); err != nil && !strings.Contains(err.Error(), "TOPIC_NOT_MODIFIED") {
+		logFor("archive").Warn("reopening topic failed", "topic", info.TopicID, "error", err)
+	}
+	if err := restoreHistory(info.TopicID); err != nil {
+		logFor("archive").Warn("restoring history failed", "topic", info.TopicID, "error", err)
+	}
+	info.Archived = false
+}
+
+// compressHistory gzips the topic's history files in place and returns how
+// many were compressed
+func compressHistory(topicID int64) (int, error) {
+	files, err := filepath.Glob(filepath.Join(getHistoryDir(topicID), "*.jsonl"))
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range files {
+		if err := gzipFile(path, path+".gz"); err != nil {
+			return i, err
+		}
+		os.Remove(path)
+	}
+	return len(files), nil
+}
+
+// restoreHistory undoes compressHistory
+func restoreHistory(topicID int64) error {
+	files, err := filepath.Glob(filepath.Join(getHistoryDir(topicID), "*.jsonl.gz"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if err := gunzipFile(path, strings.TrimSuffix(path, ".gz")); err != nil {
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
+func gzipFile(src string, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0600)
+}
+
+func gunzipFile(src string, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0600)
+}
+
+// purgeSession stops a session and deletes its topic, its history and its
+// config entry
+func purgeSession(cfg *Config, name string) error {
+	info, exists := cfg.Sessions[name]
+	if !exists {
+		return fmt.Errorf("session '%s' not found", name)
+	}
+	killSession(cfg, name)
+	if address := getHostAddress(cfg, info.Host); info.Host == "" || address != "" {
+		_, projectName := parseSessionTarget(name)
+		removeRecording(tmuxSessionName(extractProjectName(projectName)), address)
+	}
+
+	var topicErr error
+	if info.TopicID != 0 {
+		topicErr = deleteForumTopic(cfg, info.TopicID)
+		os.RemoveAll(filepath.Dir(getHistoryDir(info.TopicID)))
+		clearQuestionsPending(info.TopicID)
+	}
+	delete(cfg.Sessions, name)
+	if _, err := updateConfig(func(fresh *Config) error {
+		delete(fresh.Sessions, name)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if topicErr != nil {
+		return fmt.Errorf("purged, but the topic was not deleted: %v", topicErr)
+	}
+	return nil
+}
+
+// Confirmation buttons: callback data "cfm|<action>|<session>"
+
+// parseConfirmCallback splits confirmation callback data
+func parseConfirmCallback(data string) (action string, session string, ok bool) {
+	rest, ok := strings.CutPrefix(data, "cfm|")
+	if !ok {
+		return "", "", false
+	}
+	action, session, ok = strings.Cut(rest, "|")
+	return action, session, ok
+}
+
+// askConfirmation sends a message with confirm and cancel buttons for action
+func askConfirmation(cfg *Config, chatID int64, threadID int64, text string, action string, session string, confirmLabel string) {
+	sendMessageWithKeyboard(cfg, chatID, threadID, text, [][]InlineKeyboardButton{{
+		{Text: confirmLabel, CallbackData: "cfm|" + action + "|" + session},
+		{Text: "Cancel", CallbackData: "cfm|cancel|" + session},
+	}})
+}
+
+// handleConfirmCallback runs a confirmed action (or drops a cancelled one)
+// and replaces the buttons with the outcome
+func handleConfirmCallback(cfg *Config, cb *CallbackQuery, action string, session string) {
+	var result string
+	var run func()
+	actor := telegramActor(cb.From.ID, cb.From.Username)
+	if action != "cancel" && cb.Message != nil && cb.Message.Date > 0 && time.Since(time.Unix(cb.Message.Date, 0)) > confirmTTL {
+		pendingCommands.Delete(session)
+		action = "expired"
+	}
+	switch action {
+	case "cancel":
+		pendingCommands.Delete(session)
+		result = "✖️ Cancelled"
+	case "expired":
+		result = "⌛ Expired, send the command again"
+	case "run":
+		sc, ok := loadPendingCommand(session, time.Now())
+		if !ok {
+			result = "❌ This command is no longer pending"
+			break
+		}
+		if sc.UserID != cb.From.ID {
+			result = "❌ Only the user who sent the command can confirm it"
+			break
+		}
+		pendingCommands.Delete(session)
+		result = "▶️ Confirmed"
+		run = func() { runShellCommand(cfg, cb.Message.Chat.ID, cb.Message.MessageThreadID, sc, true) }
+	case "purge":
+		topicID := int64(0)
+		if info := cfg.Sessions[session]; info != nil {
+			topicID = info.TopicID
+		}
+		err := purgeSession(cfg, session)
+		recordAudit(cfg, actor, "/purge", session, "", auditResult(err))
+		if err != nil {
+			result = "❌ " + err.Error()
+		} else {
+			result = fmt.Sprintf("🔥 Session '%s' purged", session)
+		}
+		// The confirmation lived in the deleted topic; report privately
+		if cb.Message != nil && topicID != 0 && cb.Message.MessageThreadID == topicID {
+			sendMessage(cfg, cfg.ChatID, 0, result)
+			return
+		}
+	default:
+		if !destructiveActions[action] {
+			result = "❌ Unknown action"
+			break
+		}
+		result = "✔️ Confirmed"
+		run = func() { runDestructive(cfg, cb.Message.Chat.ID, cb.Message.MessageThreadID, actor, action, session) }
+	}
+	if cb.Message != nil {
+		editMessageRemoveKeyboard(cfg, cb.Message.Chat.ID, cb.Message.MessageID, cb.Message.Text+"\n\n"+result)
+		if run != nil {
+			run()
+		}
+	}
+}
+
+// Destructive commands (/kill, /movehere, /host del, /update, /restart) ask
+// first unless the user turned confirmations off with /confirm off. /purge
+// and dangerous /c commands always ask.
+
+// confirmTTL is how long confirmation buttons stay valid
+const confirmTTL = 2 * time.Minute
+
+// destructiveActions are the confirmation actions run by runDestructive
+var destructiveActions = map[string]bool{"kill": true, "movehere": true, "hostdel": true, "update": true, "restart": true}
+
+// skipsConfirmation reports whether a user turned confirmations off
+func skipsConfirmation(cfg *Config, userID int64) bool {
+	for _, id := range cfg.ConfirmSkip {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// setSkipConfirmation turns confirmations off (skip) or back on for a user
+func setSkipConfirmation(cfg *Config, userID int64, skip bool) {
+	ids := []int64{}
+	for _, id := range cfg.ConfirmSkip {
+		if id != userID {
+			ids = append(ids, id)
+		}
+	}
+	if skip {
+		ids = append(ids, userID)
+	}
+	cfg.ConfirmSkip = ids
+	if len(ids) == 0 {
+		cfg.ConfirmSkip = nil
+	}
+}
+
+// requestDestructive asks the user to confirm a destructive action, or runs
+// it right away when they skip confirmations
+func requestDestructive(cfg *Config, chatID int64, threadID int64, userID int64, username string, text string, action string, target string, confirmLabel string) {
+	if skipsConfirmation(cfg, userID) {
+		runDestructive(cfg, chatID, threadID, telegramActor(userID, username), action, target)
+		return
+	}
+	text += fmt.Sprintf("\n\nExpires in %d minutes. /confirm off skips these questions.", int(confirmTTL.Minutes()))
+	askConfirmation(cfg, chatID, threadID, text, action, target, confirmLabel)
+}
+
+// runDestructive runs a confirmed destructive action
+func runDestructive(cfg *Config, chatID int64, threadID int64, actor string, action string, target string) {
+	switch action {
+	case "kill":
+		err := killSession(cfg, target)
+		recordAudit(cfg, actor, "/kill", target, "", auditResult(err))
+		if err != nil {
+			sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ %v", err))
+		} else {
+			sendMessage(cfg, chatID, threadID, fmt.Sprintf("🗑️ Session '%s' killed", target))
+		}
+	case "movehere":
+		moveSessionHere(cfg, chatID, threadID, actor, target)
+	case "hostdel":
+		if err := checkHostDelete(cfg, target); err != nil {
+			sendMessage(cfg, chatID, threadID, "❌ "+err.Error())
+			return
+		}
+		delete(cfg.Hosts, target)
+		_, err := updateConfig(func(fresh *Config) error {
+			delete(fresh.Hosts, target)
+			return nil
+		})
+		recordAudit(cfg, actor, "/host del", target, "", auditResult(err))
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("✅ Host '%s' deleted", target))
+	case "update":
+		if !atomic.CompareAndSwapInt32(&updateInProgress, 0, 1) {
+			sendMessage(cfg, chatID, threadID, "⏳ Update already in progress...")
+			return
+		}
+		recordAudit(cfg, actor, "/update", "", "", "started")
+		go handleUpdateCmd(cfg, chatID, threadID)
+	case "restart":
+		sendMessage(cfg, chatID, threadID, "🔄 Restarting...")
+		exe, err := os.Executable()
+		if err != nil {
+			recordAudit(cfg, actor, "/restart", "", "", auditResult(err))
+			sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed: %v", err))
+			return
+		}
+		cmd := exec.Command(exe, "listen")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Start()
+		recordAudit(cfg, actor, "/restart", "", "", auditResult(err))
+		if err != nil {
+			sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to start: %v", err))
+			return
+		}
+		os.Exit(0)
+	}
+}
+
+// killSummary describes what killing a session loses, for its confirmation
+func killSummary(cfg *Config, name string) string {
+	info := cfg.Sessions[name]
+	st := collectSessionStatus(cfg, name, info)
+	var b strings.Builder
+	fmt.Fprintf(&b, "⚠️ Kill session '%s'?\n\n%s\n", name, statusLabels[st.State])
+	if !st.Started.IsZero() {
+		fmt.Fprintf(&b, "⏱️ Uptime: %s\n", formatDuration(time.Since(st.Started)))
+	}
+	held, queued := heldPromptCount(name), 0
+	if info.TopicID != 0 {
+		queued = outbox.Queued(cfg.GroupID, info.TopicID)
+	}
+	if held+queued == 0 {
+		b.WriteString("📤 Nothing unsent\n")
+	} else {
+		fmt.Fprintf(&b, "📤 Unsent: %d held prompts, %d queued messages\n", held, queued)
+	}
+	if len(st.Questions) == 0 {
+		b.WriteString("❓ No pending questions\n")
+	} else {
+		fmt.Fprintf(&b, "❓ %d pending questions:\n", len(st.Questions))
+		for _, q := range st.Questions {
+			b.WriteString("• " + q + "\n")
+		}
+	}
+	b.WriteString("\nThe topic and project folder are kept.")
+	return b.String()
+}
+
+// checkMoveHere reports why a session can't be moved into a topic
+func checkMoveHere(cfg *Config, name string, threadID int64) error {
+	info, exists := cfg.Sessions[name]
+	if !exists {
+		return fmt.Errorf("session '%s' not found", name)
+	}
+	if info.TopicID == threadID {
+		return fmt.Errorf("session '%s' is already in this topic", name)
+	}
+	return nil
+}
+
+// moveSessionHere points a session at the current topic and deletes its
+// old one
+func moveSessionHere(cfg *Config, chatID int64, threadID int64, actor string, name string) {
+	if err := checkMoveHere(cfg, name, threadID); err != nil {
+		sendMessage(cfg, chatID, threadID, "❌ "+err.Error())
+		return
+	}
+	info := cfg.Sessions[name]
+	oldTopicID := info.TopicID
+
+	// Rename current topic to session name
+	if err := editForumTopic(cfg, threadID, name); err != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("⚠️ Could not rename topic: %v", err))
+	}
+
+	// Update session to point to current topic
+	err := updateSession(cfg, name, func(info *SessionInfo) {
+		info.TopicID = threadID
+		info.Deleted = false
+	})
+	recordAudit(cfg, actor, "/movehere", name, fmt.Sprintf("topic %d → %d", oldTopicID, threadID), auditResult(err))
+	if err != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+		return
+	}
+
+	// Try to delete the old topic
+	deleteErr := deleteForumTopic(cfg, oldTopicID)
+	if deleteErr != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("✅ Session '%s' moved here\n⚠️ Old topic %d not deleted: %v", name, oldTopicID, deleteErr))
+	} else {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("✅ Session '%s' moved here\n🗑️ Old topic deleted", name))
+	}
+}
+
+// checkHostDelete reports why a host can't be deleted
+func checkHostDelete(cfg *Config, name string) error {
+	if cfg.Hosts == nil || cfg.Hosts[name] == nil {
+		return fmt.Errorf("host '%s' not found", name)
+	}
+	for sessName, info := range cfg.Sessions {
+		if info != nil && info.Host == name {
+			return fmt.Errorf("cannot delete: session '%s' uses this host", sessName)
+		}
+	}
+	return nil
+}
+
+// Client session management
+
+// startClientSession starts a claude session on the client
+// 1. Determines project path from args or cwd
+// 2. Registers session on server via SSH (creates Telegram topic)
+// 3. Creates/attaches tmux session with claude
+func startClientSession(config *Config, args []string) error {
+	// Check for -c flag
+	continueSession := false
+	filteredArgs := []string{}
+	for _, arg := range args {
+		if arg == "-c" {
+			continueSession = true
+		} else {
+			filteredArgs = append(filteredArgs, arg)
+		}
+	}
+
+	// Determine project path
+	var projectPath string
+	if len(filteredArgs) > 0 && filteredArgs[0] != "" {
+		// Project name or path provided
+		arg := filteredArgs[0]
+		if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, "~") || strings.HasPrefix(arg, ".") {
+			// Absolute or relative path
+			projectPath = arg
+		} else {
+			// Just a name - create in home directory
+			home, _ := os.UserHomeDir()
+			projectPath = filepath.Join(home, arg)
+		}
+	} else {
+		// Use current directory
+		var err error
+		projectPath, err = os.Getwd()
+		if err != nil {
+			return fmt.Errorf("cannot get current directory: %v", err)
+		}
+	}
+
+	// Expand ~ if present
+	if strings.HasPrefix(projectPath, "~") {
+		home, _ := os.UserHomeDir()
+		projectPath = filepath.Join(home, projectPath[1:])
+	}
+
+	// Make path absolute
+	absPath, err := filepath.Abs(projectPath)
+	if err != nil {
+		return fmt.Errorf("cannot resolve path: %v", err)
+	}
+	projectPath = absPath
+
+	// Check if directory exists, create if not
+	if _, err := os.Stat(projectPath); os.IsNotExist(err) {
+		fmt.Printf("Creating directory: %s\n", projectPath)
+		if err := os.MkdirAll(projectPath, 0755); err != nil {
+			return fmt.Errorf("cannot create directory: %v", err)
+		}
+	}
+
+	// Session name for tmux
+	name := filepath.Base(projectPath)
+	tmuxName := tmuxSessionName(name)
+
+	// Register session on server (creates Telegram topic)
+	fmt.Printf("Registering session on server...\n")
+	cmd := fmt.Sprintf("ccc register-session %s %s",
+		shellQuote(config.HostName), shellQuote(projectPath))
+
+	output, err := runSSH(config.Server, cmd, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to register session: %v", err)
+	}
+
+	topicID := strings.TrimSpace(output)
+	fmt.Printf("Session registered (topic: %s)\n", topicID)
+
+	// Check if the session already exists
+	if backend().Exists(tmuxName) {
+		fmt.Printf("Attaching to existing session: %s\n", tmuxName)
+		return backend().Attach(tmuxName)
+	}
+
+	// Create new session
+	fmt.Printf("Creating session: %s\n", tmuxName)
+	if err := backend().Create(tmuxName, projectPath, continueSession); err != nil {
+		return err
+	}
+	return backend().Attach(tmuxName)
+}
+
+// Hook handling
+
+// extractProjectDirFromTranscript extracts the encoded project directory from transcript path
+// e.g., "/home/user/.claude/projects/-home-user-Projects-myapp/transcript.json" -> "-home-user-Projects-myapp"
+func extractProjectDirFromTranscript(transcriptPath string) string {
+	if transcriptPath == "" {
+		return ""
+	}
+	// Find "/projects/" in the path
+	idx := strings.Index(transcriptPath, "/projects/")
+	if idx == -1 {
+		return ""
+	}
+	// Get the part after "/projects/"
+	rest := transcriptPath[idx+len("/projects/"):]
+	// Take only the directory name (before next /)
+	if slashIdx := strings.Index(rest, "/"); slashIdx != -1 {
+		return rest[:slashIdx]
+	}
+	return rest
+}
+
+// encodeProjectPath encodes a filesystem path the same way Claude Code does:
+// replaces both "/" and "_" with "-".
+func encodeProjectPath(path string) string {
+	s := strings.ReplaceAll(path, "/", "-")
+	s = strings.ReplaceAll(s, "_", "-")
+	return s
+}
+
+// resolveProjectPathFromTranscript finds the actual project path by matching
+// encoded transcript dir against progressively longer prefixes of cwd.
+// Claude Code encodes project paths by replacing "/" and "_" with "-".
+// Returns the project path if found, empty string otherwise.
+func resolveProjectPathFromTranscript(encodedProjectDir string, cwd string) string {
+	if encodedProjectDir == "" || cwd == "" {
+		return ""
+	}
+
+	// Normalize cwd (expand ~)
+	if strings.HasPrefix(cwd, "~") {
+		home, _ := os.UserHomeDir()
+		cwd = home + cwd[1:]
+	}
+
+	// Split into segments and build path incrementally
+	segments := strings.Split(cwd, "/")
+	currentPath := ""
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		currentPath += "/" + segment
+
+		if encodeProjectPath(currentPath) == encodedProjectDir {
+			return currentPath
+		}
+	}
+
+	return ""
+}
+
+// Logging: every ccc process (listener, hooks, CLI) writes JSON entries to
+// ~/.ccc/ccc.log through log/slog, rotated by size. Each entry names the
+// component that wrote it. Prompts get a correlation ID ("cid") when they
+// reach a session, from Telegram or the API; hooks pick it up from the
+// session's topic so the answer, tool calls and forwards log the same ID.
+
+var (
+	logOnce    sync.Once
+	baseLogger *slog.Logger
+)
+
+// logPath returns the log file (~/.ccc/ccc.log)
+func logPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "ccc.log")
+}
+
+// initLogging sets up the process logger from config. The listener passes
+// its stdout as console so entries also reach the service journal.
+func initLogging(console io.Writer) {
+	logOnce.Do(func() {
+		opts := logging.Options{Path: logPath(), Console: console}
+		if cfg, err := loadConfig(); err == nil {
+			opts.Level, _ = logging.ParseLevel(cfg.LogLevel)
+			opts.MaxBytes = int64(cfg.LogMaxMB) << 20
+			opts.Files = cfg.LogFiles
+		}
+		os.MkdirAll(filepath.Dir(opts.Path), 0755)
+		baseLogger = logging.New(opts)
+	})
+}
+
+// logFor returns the logger for a component
+func logFor(component string) *slog.Logger {
+	initLogging(nil)
+	return baseLogger.With(logging.ComponentKey, component)
+}
+
+// setCorrelation records the correlation ID of the prompt last sent to a
+// topic's session, for the hooks that handle its answer
+func setCorrelation(topicID int64, cid string) {
+	home, err := os.UserHomeDir()
+	if err != nil || topicID == 0 {
+		return
+	}
+	dir := filepath.Join(home, ".ccc", "correlation")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d", topicID)), []byte(cid), 0644)
+}
+
+// topicCorrelation returns the correlation ID of the prompt last sent to a
+// topic's session ("" if none)
+func topicCorrelation(topicID int64) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".ccc", "correlation", fmt.Sprintf("%d", topicID)))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// typePrompt types a prompt into a local or remote session and logs it
+// under a correlation ID, which is recorded for the session's hooks. An
+// empty cid gets a new one.
+func typePrompt(cfg *Config, sessionName string, info *SessionInfo, text string, source string, cid string) error {
+	if cid == "" {
+		cid = logging.NewID()
+	}
+	_, projectName := parseSessionTarget(sessionName)
+	tmuxName := tmuxSessionName(extractProjectName(projectName))
+	if info != nil {
+		setCorrelation(info.TopicID, cid)
+	}
+	if !strings.HasPrefix(source, "api:pipeline:") {
+		resetPipelineChain(cfg, sessionName)
+	}
+
+	var err error
+	if info != nil && info.Host != "" {
+		err = sshTmuxSendKeys(getHostAddress(cfg, info.Host), tmuxName, text)
+	} else {
+		err = sendToSession(tmuxName, text)
+	}
+
+	log := logFor("tmux").With(logging.SessionKey, sessionName, logging.IDKey, cid, "source", source)
+	if err != nil {
+		log.Error("prompt not sent", "error", err)
+	} else {
+		log.Info("prompt sent", "chars", len(text))
+	}
+	return err
+}
+
+// showLogs prints log entries for `ccc logs`, then with --follow keeps
+// printing new ones until interrupted
+func showLogs(args []string) error {
+	var filter logging.Filter
+	follow := false
+	limit := 100
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch arg {
+		case "-f", "--follow":
+			follow = true
+		case "--session":
+			filter.Session, err = value()
+		case "--component":
+			filter.Component, err = value()
+		case "--id":
+			filter.ID, err = value()
+		case "--level":
+			var v string
+			if v, err = value(); err == nil {
+				filter.Level, err = logging.ParseLevel(v)
+			}
+		case "--since":
+			var v string
+			if v, err = value(); err == nil {
+				filter.Since, err = recording.ParseSince(v, time.Now())
+			}
+		case "-n":
+			var v string
+			if v, err = value(); err == nil {
+				limit, err = strconv.Atoi(v)
+			}
+		default:
+			err = fmt.Errorf("unknown option %s", arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	files := 0
+	if cfg, err := loadConfig(); err == nil {
+		files = cfg.LogFiles
+	}
+	for _, e := range logging.ReadFiles(logging.Files(logPath(), files), filter, limit) {
+		fmt.Println(e.Format())
+	}
+	if !follow {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+	logging.Follow(logPath(), 500*time.Millisecond, stop, func(line []byte) {
+		if e, ok := logging.ParseEntry(line); ok && filter.Match(e) {
+			fmt.Println(e.Format())
+		}
+	})
+	return nil
+}
+
+// Audit log: actions taken through ccc (commands from Telegram, API calls
+// that drive sessions, prompts from any source) are appended to
+// ~/.ccc/audit.jsonl with who did what to which session or host and how it
+// went. With audit_hash_chain each entry is chained to the previous one.
+
+// auditPath returns the audit log (~/.ccc/audit.jsonl)
+func auditPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ccc", "audit.jsonl")
+}
+
+// recordAudit appends an action to the audit log
+func recordAudit(cfg *Config, actor string, command string, target string, args string, result string) {
+	l := audit.Log{Path: auditPath(), Chain: cfg != nil && cfg.AuditHashChain}
+	os.MkdirAll(filepath.Dir(l.Path), 0755)
+	err := l.Append(audit.Entry{Actor: actor, Command: command, Target: target, Args: args, Result: result})
+	if err != nil {
+		logFor("audit").Error("audit log write failed", "command", command, "error", err)
+	}
+}
+
+// auditResult describes the outcome of an action for the audit log
+func auditResult(err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
+
+// telegramActor names a Telegram user for the audit log
+func telegramActor(id int64, username string) string {
+	if username != "" {
+		return "telegram:@" + username
+	}
+	return fmt.Sprintf("telegram:%d", id)
+}
+
+// apiActor names a local API client for the audit log
+func apiActor(from string) string {
+	if from == "" {
+		return "api"
+	}
+	return "api:" + from
+}
+
+// formatAudit renders the last entries of the audit log for /audit
+func formatAudit(entries []audit.Entry) string {
+	if len(entries) == 0 {
+		return "📜 Audit log is empty"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "📜 Last %d audited actions\n", len(entries))
+	for _, e := range entries {
+		b.WriteString("\n" + e.Format())
+	}
+	return b.String()
+}
+
+// showAudit prints the audit log for `ccc audit [n] [--verify]`
+func showAudit(args []string) error {
+	n := 50
+	for _, arg := range args {
+		if arg == "--verify" {
+			count, err := audit.Verify(auditPath())
+			if err != nil {
+				return fmt.Errorf("audit log tampered: %v (%d entries verified before)", err, count)
+			}
+			if count == 0 {
+				fmt.Println("No hash-chained entries (set audit_hash_chain in ~/.ccc.json)")
+			} else {
+				fmt.Printf("✅ Hash chain intact (%d entries)\n", count)
+			}
+			return nil
+		}
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("unknown option %s", arg)
+		}
+		n = v
+	}
+	entries, err := audit.Read(auditPath(), n)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Println(e.Format())
+	}
+	return nil
+}
+
+// Secrets: the bot token can come from $CCC_BOT_TOKEN, bot_token_file, a
+// passphrase-encrypted secrets section or the OS keyring instead of
+// plaintext bot_token (see config.resolveSecrets). `ccc secrets` moves
+// plaintext values out of ~/.ccc.json and ccc doctor warns about them.
+
+// missingTokenError explains why no bot token was loaded
+func missingTokenError(cfg *Config) error {
+	if err := cfg.SecretsError(); err != nil {
+		return fmt.Errorf("no bot token: %v", err)
+	}
+	return fmt.Errorf("no bot token. Run: ccc setup <bot_token> or set %s", config.BotTokenEnv)
+}
+
+// tokenSourceName describes a Config.TokenSource for ccc doctor
+func tokenSourceName(source string) string {
+	switch source {
+	case "env":
+		return "from $" + config.BotTokenEnv
+	case "file":
+		return "from bot_token_file"
+	case "secrets":
+		return "encrypted in ~/.ccc.json"
+	case "keyring":
+		return "from the OS keyring"
+	}
+	return "plaintext in ~/.ccc.json"
+}
+
+// secretWarnings lists plaintext secrets and secret files readable by
+// other users
+func secretWarnings(cfg *Config) []string {
+	var warnings []string
+	if names := cfg.Plaintext(); len(names) > 0 {
+		fix := "ccc secrets encrypt, bot_token_file or $" + config.BotTokenEnv
+		if secrets.KeyringAvailable() {
+			fix = "ccc secrets keyring, " + fix
+		}
+		warnings = append(warnings, fmt.Sprintf("plaintext %s in ~/.ccc.json (use %s)", strings.Join(names, ", "), fix))
+	}
+	if w := fileModeWarning(getConfigPath()); w != "" {
+		warnings = append(warnings, w)
+	}
+	if cfg.BotTokenFile != "" {
+		if w := fileModeWarning(config.ExpandPath(cfg.BotTokenFile)); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
+}
+
+// fileModeWarning warns when a secret file can be read or written by group
+// or others
+func fileModeWarning(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		return fmt.Sprintf("%s has mode %04o (run: chmod 600 %s)", path, mode, path)
+	}
+	return ""
+}
+
+// readHidden reads a line from stdin, without echo on a terminal
+func readHidden(prompt string) (string, error) {
+	info, _ := os.Stdin.Stat()
+	tty := info != nil && info.Mode()&os.ModeCharDevice != 0
+	if tty {
+		fmt.Fprint(os.Stderr, prompt)
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// secretsPassphrase returns $CCC_PASSPHRASE or asks for the passphrase,
+// twice when a new secrets section is created
+func secretsPassphrase(cfg *Config) (string, error) {
+	if p := os.Getenv(config.PassphraseEnv); p != "" {
+		return p, nil
+	}
+	p, err := readHidden("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	if cfg.Secrets == nil {
+		again, err := readHidden("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", fmt.Errorf("passphrases don't match")
+		}
+	}
+	return p, nil
+}
+
+// manageSecrets implements `ccc secrets encrypt|set <name>|list|keyring`
+func manageSecrets(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand")
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("not configured. Run: ccc setup <bot_token>")
+	}
+
+	switch args[0] {
+	case "encrypt", "set":
+		set := map[string]string{}
+		if args[0] == "set" {
+			if len(args) != 2 {
+				return fmt.Errorf("usage: ccc secrets set <name>")
+			}
+			value, err := readHidden(args[1] + ": ")
+			if err != nil {
+				return err
+			}
+			if value = strings.TrimSpace(value); value == "" {
+				return fmt.Errorf("empty value")
+			}
+			set[args[1]] = value
+		} else if len(cfg.Plaintext()) == 0 {
+			fmt.Println("No plaintext secrets in ~/.ccc.json")
+			return nil
+		}
+		passphrase, err := secretsPassphrase(cfg)
+		if err != nil {
+			return err
+		}
+		names, err := cfg.SealSecrets(passphrase, set)
+		if err != nil {
+			return err
+		}
+		if err := saveConfig(cfg); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Encrypted: %s\n", strings.Join(names, ", "))
+		fmt.Printf("Set %s for ccc listen (e.g. Environment= in the systemd unit)\n", config.PassphraseEnv)
+
+	case "list":
+		if cfg.Secrets == nil {
+			fmt.Println("No encrypted secrets")
+			return nil
+		}
+		passphrase, err := secretsPassphrase(cfg)
+		if err != nil {
+			return err
+		}
+		values, err := cfg.OpenSecrets(passphrase)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+
+	case "keyring":
+		if cfg.BotToken == "" {
+			return missingTokenError(cfg)
+		}
+		if err := secrets.KeyringStore(secrets.KeyringAccount, cfg.BotToken); err != nil {
+			return fmt.Errorf("keyring: %v", err)
+		}
+		cfg.ForgetPlaintext("bot_token")
+		if err := saveConfig(cfg); err != nil {
+			return err
+		}
+		fmt.Println("✅ Bot token stored in the OS keyring and removed from ~/.ccc.json")
+
+	default:
+		return fmt.Errorf("unknown subcommand %s", args[0])
+	}
+	return nil
+}
+
+// clientMode reports whether hooks should forward to a server
+func clientMode(config *Config) bool {
+	return config.Mode == "client" && config.Server != "" && config.HostName != ""
+}
+
+// forwardToServer forwards a message to the server in client mode
+// Returns true if forwarded (client mode), false otherwise
+func forwardToServer(config *Config, cwd string, transcriptPath string, message string) bool {
+	if !clientMode(config) {
+		return false
+	}
+
+	// Extract encoded project dir from transcript path
+	projectDir := extractProjectDirFromTranscript(transcriptPath)
+
+	// Truncate message for log
+	logMsg := message
+	if len(logMsg) > 100 {
+		logMsg = logMsg[:100] + "..."
+	}
+	log := logFor("hook").With("hook", "forward", "server", config.Server)
+	log.Info("forwarding to server", "cwd", cwd, "project", projectDir, "text", logMsg)
+
+	// Forward to server via SSH
+	// Use base64 to safely encode the message
+	encoded := base64.StdEncoding.EncodeToString([]byte(message))
+	cmd := fmt.Sprintf("ccc --from=%s --cwd=%s --project=%s \"$(echo %s | base64 -d)\"",
+		shellQuote(config.HostName), shellQuote(cwd), shellQuote(projectDir), encoded)
+
+	fmt.Fprintf(os.Stderr, "hook: forwarding to server %s (project=%s)\n", config.Server, projectDir)
+	_, err := runSSH(config.Server, cmd, 10*time.Second)
+	if err != nil {
+		log.Error("forward failed", "error", err)
+		fmt.Fprintf(os.Stderr, "hook: forward error: %v\n", err)
+	} else {
+		log.Debug("forwarded")
+	}
+	return true
+}
+
+func handleHook() error {
+	log := logFor("hook").With("hook", "stop")
+	log.Debug("hook started")
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Error("no config")
+		fmt.Fprintf(os.Stderr, "hook: no config\n")
+		return nil
+	}
+
+	// Read hook data from stdin
+	var hookData HookData
+	decoder := json.NewDecoder(os.Stdin)
+	if err := decoder.Decode(&hookData); err != nil {
+		log.Error("decode error", "error", err)
+		fmt.Fprintf(os.Stderr, "hook: decode error: %v\n", err)
+		return nil
+	}
+
+	log.Debug("hook data", "cwd", hookData.Cwd, "transcript", hookData.TranscriptPath)
+	fmt.Fprintf(os.Stderr, "hook: cwd=%s transcript=%s\n", hookData.Cwd, hookData.TranscriptPath)
+
+	// Delay to allow transcript file to be fully written
+	// (race condition: hook fires before final message is flushed to disk)
+	time.Sleep(2 * time.Second)
+
+	// Read last message from transcript
+	lastMessage := "Session ended"
+	if hookData.TranscriptPath != "" {
+		if msg := getLastAssistantMessage(hookData.TranscriptPath); msg != "" {
+			lastMessage = msg
+		}
+	}
+
+	// Truncate message for log (first 100 chars)
+	logMsg := lastMessage
+	if len(logMsg) > 100 {
+		logMsg = logMsg[:100] + "..."
+	}
+	log.Debug("last message", "text", logMsg)
+
+	// In client mode, forward to server with the same "✅ name" header the
+	// server uses, so it can tell the response apart from tool output
+	if clientMode(config) {
+		name := filepath.Base(hookData.Cwd)
+		body := withUsageTrailer(lastMessage, recordTurnUsage(config, name, hookData.TranscriptPath))
+		forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, fmt.Sprintf("✅ %s\n\n%s", name, body))
+		log.Info("forwarded to server", "server", config.Server)
+		return nil
+	}
+
+	// Find session by matching cwd with saved path
+	// Prefer local sessions (Host=="") over remote sessions with same path
+	var sessionName string
+	var topicID int64
+	var foundRemote string // Track remote match in case no local match
+	var remoteTopicID int64
+	for name, info := range config.Sessions {
+		if info == nil || info.Deleted {
+			continue
+		}
+		// Match against saved path, subdirectories of saved path, or suffix
+		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
+			if info.Host == "" {
+				// Local session - use immediately
+				sessionName = name
+				topicID = info.TopicID
+				break
+			} else if foundRemote == "" {
+				// Remote session - save as fallback
+				foundRemote = name
+				remoteTopicID = info.TopicID
+			}
+		}
+	}
+	// Use remote match if no local match found
+	if sessionName == "" && foundRemote != "" {
+		sessionName = foundRemote
+		topicID = remoteTopicID
+	}
+	if sessionName == "" || config.GroupID == 0 {
+		log.Warn("no session for cwd", "cwd", hookData.Cwd)
+		fmt.Fprintf(os.Stderr, "hook: no session found for cwd=%s\n", hookData.Cwd)
+		return nil
+	}
+
+	log = log.With(logging.SessionKey, sessionName, logging.IDKey, topicCorrelation(topicID))
+	log.Info("sending response", "topic", topicID, "chars", len(lastMessage))
+	fmt.Fprintf(os.Stderr, "hook: session=%s topic=%d\n", sessionName, topicID)
+	fmt.Fprintf(os.Stderr, "hook: sending message to telegram\n")
+
+	// Stop typing indicator for this session
+	stopContinuousTyping(sessionName)
+	clearQuestionsPending(topicID)
+
+	// Store Claude's response in history
+	appendHistory(topicID, HistoryMessage{
+		ID:        nextMessageID(),
+		Timestamp: time.Now().Unix(),
+		From:      "claude",
+		Text:      lastMessage,
+	})
+
+	body := withUsageTrailer(lastMessage, recordTurnUsage(config, sessionName, hookData.TranscriptPath))
+	err = deliverFinalMessage(config, config.GroupID, topicID, "✅ "+sessionName, body)
+	spawnVoiceReply(config, sessionName, topicID, lastMessage)
+	checkBudget(config)
+	spawnPipelines(config, sessionName, lastMessage)
+	return err
+}
+
+func handlePermissionHook() error {
+	// Recover from any panic - hooks must never crash
+	defer func() {
+		recover()
+	}()
+
+	// Read stdin with timeout
+	stdinData := make(chan []byte, 1)
+	go func() {
+		defer func() { recover() }()
+		data, _ := io.ReadAll(os.Stdin)
+		stdinData <- data
+	}()
+
+	var rawData []byte
+	select {
+	case rawData = <-stdinData:
+	case <-time.After(2 * time.Second):
+		return nil // Timeout, exit silently
+	}
+
+	if len(rawData) == 0 {
+		return nil
+	}
+
+	// Parse JSON - ignore errors
+	var hookData HookData
+	if err := json.Unmarshal(rawData, &hookData); err != nil {
+		return nil
+	}
+
+	// Load config - ignore errors
+	config, err := loadConfig()
+	if err != nil || config == nil {
+		return nil
+	}
+
+	// In client mode, forward to server
+	if config.Mode == "client" && config.Server != "" && config.HostName != "" {
+		if hookData.ToolName == "AskUserQuestion" && len(hookData.ToolInput.Questions) > 0 {
+			for _, q := range hookData.ToolInput.Questions {
+				if q.Question == "" {
+					continue
+				}
+				msg := fmt.Sprintf("❓ %s\n\n%s", q.Header, q.Question)
+				for i, opt := range q.Options {
+					msg += fmt.Sprintf("\n%d. %s", i+1, opt.Label)
+					if opt.Description != "" {
+						msg += fmt.Sprintf(" — %s", opt.Description)
+					}
+				}
+				forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg)
+			}
+			return nil
+		}
+		if hookData.ToolName == "ExitPlanMode" {
+			planText := readLatestPlanFile(hookData.Cwd)
+			if planText == "" {
+				planText = "(plan file not found)"
+			}
+			if len(planText) > 3900 {
+				planText = planText[:3900] + "\n\n... (truncated)"
+			}
+			msg := fmt.Sprintf("📋 Plan ready:\n\n%s", planText)
+			forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg)
+			return nil
+		}
+	}
+
+	// Find session by matching cwd
+	var sessionName string
+	var topicID int64
+	for name, info := range config.Sessions {
+		if name == "" || info == nil {
+			continue
+		}
+		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
+			sessionName = name
+			topicID = info.TopicID
+			break
+		}
+	}
+
+	if sessionName == "" || config.GroupID == 0 {
+		return nil
+	}
+
+	// Handle AskUserQuestion — send inline keyboard buttons to Telegram
+	logFor("hook").Info("permission request", "hook", "permission", logging.SessionKey, sessionName, logging.IDKey, topicCorrelation(topicID), "tool", hookData.ToolName, "questions", len(hookData.ToolInput.Questions))
+	if hookData.ToolName == "AskUserQuestion" && len(hookData.ToolInput.Questions) > 0 {
+		totalQuestions := len(hookData.ToolInput.Questions)
+
+		// Store in pending questions for API access
+		pqs := &PendingQuestionSet{
+			Session:   sessionName,
+			Timestamp: time.Now().Unix(),
+			TopicID:   topicID,
+		}
+		for _, q := range hookData.ToolInput.Questions {
+			pq := PendingQuestion{
+				Question:    q.Question,
+				Header:      q.Header,
+				MultiSelect: q.MultiSelect,
+			}
+			for _, opt := range q.Options {
+				pq.Options = append(pq.Options, PendingQuestionOption{
+					Label:       opt.Label,
+					Description: opt.Description,
+				})
+			}
+			pqs.Questions = append(pqs.Questions, pq)
+		}
+		pendingQuestions.Store(sessionName, pqs)
+		markQuestionsPending(topicID, pqs)
+
+		go func() {
+			defer func() { recover() }()
+			for qIdx, q := range hookData.ToolInput.Questions {
+				if q.Question == "" {
+					continue
+				}
+				// Build message with option descriptions
+				msg := fmt.Sprintf("❓ %s\n\n%s", q.Header, q.Question)
+
+				// Build inline keyboard buttons
+				var buttons [][]InlineKeyboardButton
+				for i, opt := range q.Options {
+					if opt.Label == "" {
+						continue
+					}
+					// Callback data format: session:questionIndex:totalQuestions:optionIndex
+					// Telegram limits callback_data to 64 bytes
+					callbackData := fmt.Sprintf("%s:%d:%d:%d", sessionName, qIdx, totalQuestions, i)
+					if len(callbackData) > 64 {
+						callbackData = callbackData[:64]
+					}
+					label := opt.Label
+					if opt.Description != "" {
+						label += " — " + opt.Description
+					}
+					// Telegram button label max ~200 chars
+					if len(label) > 120 {
+						label = label[:117] + "..."
+					}
+					buttons = append(buttons, []InlineKeyboardButton{
+						{Text: label, CallbackData: callbackData},
+					})
+				}
+
+				if len(buttons) > 0 {
+					sendMessageWithKeyboard(config, config.GroupID, topicID, msg, buttons)
+				}
+
+				// Store question in history
+				appendHistory(topicID, HistoryMessage{
+					ID:        nextMessageID(),
+					Timestamp: time.Now().Unix(),
+					From:      "claude",
+					Text:      msg,
+				})
+			}
+		}()
+		return nil
+	}
+
+	// Handle ExitPlanMode — read plan file and forward to Telegram
+	if hookData.ToolName == "ExitPlanMode" {
+		go func() {
+			defer func() { recover() }()
+			planText := readLatestPlanFile(hookData.Cwd)
+			if planText == "" {
+				sendMessage(config, config.GroupID, topicID, "📋 Plan mode completed (plan file not found)")
+				return
+			}
+			// Truncate to Telegram's 4096 char limit (leave room for header)
+			if len(planText) > 3900 {
+				planText = planText[:3900] + "\n\n... (truncated)"
+			}
+			msg := fmt.Sprintf("📋 Plan ready:\n\n%s", planText)
+			sendMessage(config, config.GroupID, topicID, msg)
+			// Store in history
+			appendHistory(topicID, HistoryMessage{
+				ID:        nextMessageID(),
+				Timestamp: time.Now().Unix(),
+				From:      "claude",
+				Text:      msg,
+			})
+		}()
+		return nil
+	}
+
+	return nil
+}
+
+// getLastAssistantMessage reads a Claude Code transcript JSONL file and extracts
+// text blocks from the last assistant turn (after the last real user message).
+// Handles both nested (message.content) and flat (root-level content) JSONL formats.
+// Deduplicates streaming entries by requestId (last entry per requestId wins).
+func getLastAssistantMessage(transcriptPath string) string {
+	file, err := os.Open(transcriptPath)
+	if err != nil {
+		logFor("hook").Warn("failed to open transcript", "error", err)
+		return ""
+	}
+	defer file.Close()
+
+	// Typed structs for parsing JSONL entries
+	type contentBlock struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+
+	type transcriptLine struct {
+		Type      string          `json:"type"`
+		RequestID string          `json:"requestId,omitempty"`
+		Role      string          `json:"role,omitempty"`
+		Content   json.RawMessage `json:"content,omitempty"`
+		Message   struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
+	}
+
+	type parsedEntry struct {
+		entryType string
+		requestID string
+		role      string
+		content   json.RawMessage
+	}
+
+	var entries []parsedEntry
+	var linesProcessed int
+	scanner := bufio.NewScanner(file)
+	// 16MB buffer for large lines (transcripts with images/PDFs)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		linesProcessed++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var tl transcriptLine
+		if json.Unmarshal(line, &tl) != nil {
+			continue
+		}
+		// Use nested message fields if present, otherwise fall back to root-level
+		role := tl.Message.Role
+		content := tl.Message.Content
+		if role == "" {
+			role = tl.Role
+		}
+		if len(content) == 0 {
+			content = tl.Content
+		}
+		entries = append(entries, parsedEntry{
+			entryType: tl.Type,
+			requestID: tl.RequestID,
+			role:      role,
+			content:   content,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		logFor("hook").Warn("transcript read error", "lines", linesProcessed, "error", err)
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+
+	// Find the last real user message (not a tool_result)
+	lastUserIdx := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.entryType != "user" && e.role != "user" {
+			continue
+		}
+		// Check if content is a tool_result
+		if isToolResultContent(e.content) {
+			continue
+		}
+		lastUserIdx = i
+		break
+	}
+
+	// Collect assistant text blocks after the last user message.
+	// Streaming dedup: same requestId may have multiple entries with progressively
+	// updated text; for each requestId, the last entry's text blocks win.
+	startIdx := lastUserIdx + 1
+	if lastUserIdx < 0 {
+		startIdx = 0
+	}
+
+	reqTexts := make(map[string][]string) // requestId -> text blocks from last entry
+	var orderedKeys []string              // preserve order of first appearance
+	var noIDTexts []string                // texts from entries without requestId
+
+	for i := startIdx; i < len(entries); i++ {
+		e := entries[i]
+		if e.entryType != "assistant" && e.role != "assistant" {
+			continue
+		}
+
+		var blocks []contentBlock
+		if json.Unmarshal(e.content, &blocks) != nil {
+			continue
+		}
+
+		var entryTexts []string
+		for _, b := range blocks {
+			if b.Type != "text" {
+				continue
+			}
+			text := strings.TrimSpace(b.Text)
+			if text != "" && text != "(no content)" {
+				entryTexts = append(entryTexts, text)
+			}
+		}
+		if len(entryTexts) == 0 {
+			continue
+		}
+
+		if e.requestID == "" {
+			noIDTexts = append(noIDTexts, entryTexts...)
+		} else {
+			if _, seen := reqTexts[e.requestID]; !seen {
+				orderedKeys = append(orderedKeys, e.requestID)
+			}
+			reqTexts[e.requestID] = entryTexts // last entry with same requestId wins
+		}
+	}
+
+	var allTexts []string
+	for _, key := range orderedKeys {
+		allTexts = append(allTexts, reqTexts[key]...)
+	}
+	allTexts = append(allTexts, noIDTexts...)
+
+	logFor("hook").Debug("parsed transcript", "lines", linesProcessed, "entries", len(entries), "text_blocks", len(allTexts))
+	return strings.Join(allTexts, "\n\n")
+}
+
+// readLatestPlanFile finds and reads the most recently modified plan file.
+// Claude Code stores plans in ~/.claude/plans/<slug>.md
+func readLatestPlanFile(cwd string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	planDir := filepath.Join(homeDir, ".claude", "plans")
+	files, err := filepath.Glob(filepath.Join(planDir, "*.md"))
+	if err != nil || len(files) == 0 {
+		return ""
+	}
+
+	// Find the most recently modified file
+	var newest string
+	var newestTime time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newestTime) {
+			newestTime = info.ModTime()
+			newest = f
+		}
+	}
+
+	if newest == "" || time.Since(newestTime) > 5*time.Minute {
+		return "" // Too old, probably not from the current session
+	}
+
+	data, err := os.ReadFile(newest)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// isToolResultContent checks if a JSONL content field contains tool_result entries
+func isToolResultContent(content json.RawMessage) bool {
+	if len(content) == 0 {
+		return false
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(content, &blocks) == nil {
+		for _, b := range blocks {
+			if b.Type == "tool_result" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func handlePromptHook() error {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hook-prompt: no config\n")
+		return nil
+	}
+
+	var hookData HookData
+	decoder := json.NewDecoder(os.Stdin)
+	if err := decoder.Decode(&hookData); err != nil {
+		fmt.Fprintf(os.Stderr, "hook-prompt: decode error: %v\n", err)
+		return nil
+	}
+
+	if hookData.Prompt == "" {
+		fmt.Fprintf(os.Stderr, "hook-prompt: empty prompt\n")
+		return nil
+	}
+
+	// Prepare prompt message
+	prompt := hookData.Prompt
+	if len(prompt) > 500 {
+		prompt = prompt[:500] + "..."
+	}
+
+	// In client mode, forward to server
+	if forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, fmt.Sprintf("💬 %s", prompt)) {
+		return nil
+	}
+
+	// Find session by matching cwd suffix
+	var sessionName string
+	var topicID int64
+	for name, info := range config.Sessions {
+		if info == nil {
+			continue
+		}
+		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
+			sessionName = name
+			topicID = info.TopicID
+			break
+		}
+	}
+
+	if topicID == 0 || config.GroupID == 0 {
+		fmt.Fprintf(os.Stderr, "hook-prompt: no topic found for cwd=%s\n", hookData.Cwd)
+		return nil
+	}
+	clearQuestionsPending(topicID)
+
+	// Check if this prompt was just sent from Telegram (cooldown 10s)
+	if wasTelegramSent(topicID) {
+		fmt.Fprintf(os.Stderr, "hook-prompt: skipping (telegram cooldown) topic=%d\n", topicID)
+		logFor("hook").Debug("prompt received", "hook", "prompt", logging.SessionKey, sessionName, logging.IDKey, topicCorrelation(topicID))
+		return nil
+	}
+
+	// A prompt typed in the terminal starts a new correlation and pipeline chain
+	cid := logging.NewID()
+	setCorrelation(topicID, cid)
+	resetPipelineChain(config, sessionName)
+	logFor("hook").Info("local prompt", "hook", "prompt", logging.SessionKey, sessionName, logging.IDKey, cid, "chars", len(hookData.Prompt))
+	recordAudit(config, "terminal", "prompt", sessionName, hookData.Prompt, "ok")
+
+	// This is a locally-typed prompt — save to history
+	appendHistory(topicID, HistoryMessage{
+		ID:        nextMessageID(),
+		Timestamp: time.Now().Unix(),
+		From:      "human",
+		Text:      hookData.Prompt,
+		Username:  "terminal",
+	})
+
+	// Send typing action
+	sendTypingAction(config, config.GroupID, topicID)
+
+	fmt.Fprintf(os.Stderr, "hook-prompt: sending local prompt to topic %d\n", topicID)
+	return sendMessage(config, config.GroupID, topicID, fmt.Sprintf("💬 %s", prompt))
+}
+
+func handleOutputHook() error {
+	config, err := loadConfig()
+	if err != nil {
+		return nil
+	}
+
+	rawData, _ := io.ReadAll(os.Stdin)
+	if len(rawData) == 0 {
+		return nil
+	}
+
+	var hookData HookData
+	if err := json.Unmarshal(rawData, &hookData); err != nil {
+		return nil
+	}
+
+	// Skip certain tools that don't produce interesting output
+	skipTools := map[string]bool{
+		"Read": true, "Glob": true, "Grep": true, "LSP": true,
+		"TodoWrite": true, "Task": true, "TaskOutput": true,
+	}
+	if skipTools[hookData.ToolName] {
+		return nil
+	}
+
+	// Get last message from transcript
+	var msg string
+	if hookData.TranscriptPath != "" {
+		msg = getLastAssistantMessage(hookData.TranscriptPath)
+	}
+	if msg == "" {
+		return nil
+	}
+
+	// Cap at 20000 chars (~5 Telegram messages); sendMessage handles splitting
+	if len(msg) > 20000 {
+		msg = msg[:20000] + "\n\n... (truncated)"
+	}
+
+	// In client mode, forward to server
+	if forwardToServer(config, hookData.Cwd, hookData.TranscriptPath, msg) {
+		return nil
+	}
+
+	// Find session
+	var sessionName string
+	var topicID int64
+	for name, info := range config.Sessions {
+		if info == nil {
+			continue
+		}
+		if hookData.Cwd == info.Path || strings.HasPrefix(hookData.Cwd, info.Path+"/") || strings.HasSuffix(hookData.Cwd, "/"+name) {
+			sessionName = name
+			topicID = info.TopicID
+			break
+		}
+	}
+
+	if topicID == 0 || config.GroupID == 0 || sessionName == "" {
+		return nil
+	}
+
+	// Live progress message already shows tool activity for this turn
+	if progressActive(topicID) {
+		return nil
+	}
+
+	// Check cache to avoid duplicate messages
+	cacheFile := filepath.Join(os.TempDir(), "ccc-cache-"+sessionName)
+	lastSent, _ := os.ReadFile(cacheFile)
+	if string(lastSent) == msg {
+		return nil // Skip duplicate
+	}
+	os.WriteFile(cacheFile, []byte(msg), 0600)
+
+	logFor("hook").Debug("tool output", "hook", "output", logging.SessionKey, sessionName, logging.IDKey, topicCorrelation(topicID), "tool", hookData.ToolName, "chars", len(msg))
+	sendClaudeMessage(config, config.GroupID, topicID, "", msg)
+	return nil
+}
+
+// handleQuestionHook is a legacy alias for handlePermissionHook.
+// Kept for backward compatibility with old hook registrations.
+func handleQuestionHook() error {
+	return handlePermissionHook()
+}
+
+// Install hook in Claude settings
+
+// addHookToEvent adds a hook command to an event without overwriting existing hooks.
+// Returns true if the hook was added, false if it already exists.
+func addHookToEvent(hooks map[string]interface{}, eventName string, command string) bool {
+	// Get existing entries for this event
+	entries, ok := hooks[eventName].([]interface{})
+	if !ok {
+		// No entries for this event - create new
+		hooks[eventName] = []interface{}{
+			map[string]interface{}{
+				"matcher": "",
+				"hooks": []interface{}{
+					map[string]interface{}{
+						"type":    "command",
+						"command": command,
+					},
+				},
+			},
+		}
+		return true
+	}
+
+	// Find entry with empty matcher (global hook)
+	for _, entry := range entries {
+		entryMap, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		matcher, _ := entryMap["matcher"].(string)
+		if matcher != "" {
+			continue
+		}
+
+		// Found global entry, check if hook already exists
+		hooksList, ok := entryMap["hooks"].([]interface{})
+		if !ok {
+			hooksList = []interface{}{}
+		}
+
+		for _, h := range hooksList {
+			hookMap, ok := h.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if hookMap["command"] == command {
+				// Hook already exists
+				return false
+			}
+		}
+
+		// Add hook to existing entry
+		hooksList = append(hooksList, map[string]interface{}{
+			"type":    "command",
+			"command": command,
+		})
+		entryMap["hooks"] = hooksList
+		return true
+	}
+
+	// No global entry found - create new one
+	entries = append(entries, map[string]interface{}{
+		"matcher": "",
+		"hooks": []interface{}{
+			map[string]interface{}{
+				"type":    "command",
+				"command": command,
+			},
+		},
+	})
+	hooks[eventName] = entries
+	return true
+}
+
+func installHook() error {
+	home, _ := os.UserHomeDir()
+	claudeDir := filepath.Join(home, ".claude")
+	settingsPath := filepath.Join(claudeDir, "settings.json")
+	cccPath := filepath.Join(home, "bin", "ccc")
+
+	// Create .claude directory if it doesn't exist
+	if err := os.MkdirAll(claudeDir, 0755); err != nil {
+		return fmt.Errorf("failed to create .claude directory: %w", err)
+	}
+
+	var settings map[string]interface{}
+
+	data, err := os.ReadFile(settingsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Create empty settings if file doesn't exist
+			settings = make(map[string]interface{})
+		} else {
+			return fmt.Errorf("failed to read settings.json: %w", err)
+		}
+	} else {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("failed to parse settings.json: %w", err)
+		}
+	}
+
+	hooks, ok := settings["hooks"].(map[string]interface{})
+	if !ok {
+		hooks = make(map[string]interface{})
+	}
+
+	// Add Stop hook (doesn't overwrite existing hooks)
+	stopAdded := addHookToEvent(hooks, "Stop", cccPath+" hook")
+
+	// Add PreToolUse hook for AskUserQuestion forwarding
+	// Use timeout 300000ms (5min) to allow time for user to answer via Telegram
+	preToolAdded := addHookToEvent(hooks, "PreToolUse", cccPath+" hook-permission")
+
+	settings["hooks"] = hooks
+
+	newData, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+
+	if err := os.WriteFile(settingsPath, newData, 0600); err != nil {
+		return fmt.Errorf("failed to write settings.json: %w", err)
+	}
+
+	if stopAdded || preToolAdded {
+		fmt.Println("✅ Claude hooks installed!")
+		if stopAdded {
+			fmt.Println("  + Stop hook (response capture)")
+		}
+		if preToolAdded {
+			fmt.Println("  + PreToolUse hook (AskUserQuestion forwarding)")
+		}
+	} else {
+		fmt.Println("✅ Claude hooks already installed")
+	}
+	return nil
+}
+
+// findCCCSourceDir locates the CCC source directory by looking for go.mod + .git
+// in known locations. Returns empty string if not found.
+func findCCCSourceDir() string {
+	home, _ := os.UserHomeDir()
+	candidates := []string{
+		filepath.Join(home, "Projects", "teleclaude", "ccc"), // wagok-server
+		filepath.Join(home, "Projects", "ccc"),               // msi, XPS
+		filepath.Join(home, "Public", "Tools", "ccc"),        // dell17
+	}
+	// Also check directory of the running binary
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			candidates = append([]string{filepath.Dir(resolved)}, candidates...)
+		}
+	}
+	for _, dir := range candidates {
+		goMod := filepath.Join(dir, "go.mod")
+		gitDir := filepath.Join(dir, ".git")
+		if _, err := os.Stat(goMod); err == nil {
+			if _, err := os.Stat(gitDir); err == nil {
+				return dir
+			}
+		}
+	}
+	return ""
+}
+
+// handleUpdateCmd performs git pull + go build + backup + replace + restart.
+// Runs in a goroutine to avoid blocking the Telegram polling loop.
+func handleUpdateCmd(cfg *Config, chatID int64, threadID int64) {
+	defer atomic.StoreInt32(&updateInProgress, 0)
+
+	sourceDir := findCCCSourceDir()
+	if sourceDir == "" {
+		sendMessage(cfg, chatID, threadID, "❌ Source directory not found (need go.mod + .git)")
+		return
+	}
+
+	// Step 1: git pull
+	sendMessage(cfg, chatID, threadID, fmt.Sprintf("📦 Pulling from git...\n`%s`", sourceDir))
+	pullCmd := exec.Command("git", "-C", sourceDir, "pull")
+	pullOut, err := pullCmd.CombinedOutput()
+	pullText := strings.TrimSpace(string(pullOut))
+	if err != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ git pull failed:\n```\n%s\n```", pullText))
+		return
+	}
+
+	if pullText == "Already up to date." {
+		sendMessage(cfg, chatID, threadID, "✅ Already up to date, no rebuild needed.")
+		return
+	}
+
+	// Step 2: go build
+	sendMessage(cfg, chatID, threadID, "🔨 Building...")
+	newBinary := filepath.Join(sourceDir, "ccc-new")
+	buildCmd := exec.Command("go", "build", "-o", newBinary, ".")
+	buildCmd.Dir = sourceDir
+	buildOut, err := buildCmd.CombinedOutput()
+	if err != nil {
+		buildText := strings.TrimSpace(string(buildOut))
+		if len(buildText) > 3000 {
+			buildText = buildText[:3000] + "\n..."
+		}
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Build failed:\n```\n%s\n```", buildText))
+		os.Remove(newBinary)
+		return
+	}
+
+	// Step 3: Validate new binary (must be > 1MB)
+	if stat, err := os.Stat(newBinary); err != nil || stat.Size() < 1*1024*1024 {
+		sendMessage(cfg, chatID, threadID, "❌ Built binary too small or missing, aborting")
+		os.Remove(newBinary)
+		return
+	}
+
+	// Step 4: Find current binary and backup
+	exe, err := os.Executable()
+	if err != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Cannot find current binary: %v", err))
+		os.Remove(newBinary)
+		return
+	}
+	exePath, _ := filepath.EvalSymlinks(exe)
+	backupPath := exePath + ".bak"
+
+	// Copy current binary as backup (ignore error if first run)
+	exec.Command("cp", exePath, backupPath).Run()
+
+	// Step 5: Replace binary (rm + cp to handle "text file busy")
+	os.Remove(exePath)
+	cpCmd := exec.Command("cp", newBinary, exePath)
+	if err := cpCmd.Run(); err != nil {
+		// Restore from backup
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to replace binary: %v\nRestoring backup...", err))
+		exec.Command("cp", backupPath, exePath).Run()
+		os.Remove(newBinary)
+		return
+	}
+	os.Chmod(exePath, 0755)
+	os.Remove(newBinary)
+
+	sendMessage(cfg, chatID, threadID, fmt.Sprintf("✅ Updated!\n```\n%s\n```\n🔄 Restarting...", pullText))
+
+	// Step 6: Restart (same as /restart)
+	cmd := exec.Command(exePath, "listen")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Failed to restart: %v\nBinary updated but not restarted.", err))
+		return
+	}
+	os.Exit(0)
+}
+
+// Bot commands
+
+func setBotCommands(botToken string) {
+	commands := `{
+		"commands": [
+			{"command": "help", "description": "Show all commands"},
+			{"command": "new", "description": "Create session: /new [host:]<name>"},
+			{"command": "continue", "description": "Continue session: /continue [host:]<name>"},
+			{"command": "kill", "description": "Kill session: /kill <name>"},
+			{"command": "rename", "description": "Rename session: /rename [old] <new>"},
+			{"command": "archive", "description": "Archive session: /archive [name]"},
+			{"command": "purge", "description": "Delete session for good: /purge [name]"},
+			{"command": "list", "description": "List sessions: /list [tag:<t>] [host:<h>] [sort:…] [group:…]"},
+			{"command": "tag", "description": "Tag a session: /tag [session] <tag>..."},
+			{"command": "untag", "description": "Remove tags: /untag [session] <tag>..."},
+			{"command": "describe", "description": "Describe a session: /describe [session] <text>"},
+			{"command": "status", "description": "Show current session details"},
+			{"command": "progress", "description": "Live progress: /progress [on|off|default]"},
+			{"command": "idle", "description": "Idle shutdown: /idle [hours|off|default]"},
+			{"command": "autorestart", "description": "Crash auto-restart: /autorestart [on|off|default]"},
+			{"command": "vocabulary", "description": "Words that guide voice transcription"},
+			{"command": "voicereply", "description": "Spoken replies: /voicereply [on|off|default]"},
+			{"command": "recording", "description": "Terminal recording: /recording [on|off|get|trim]"},
+			{"command": "broadcast", "description": "Prompt several sessions: /broadcast <selector> <prompt>"},
+			{"command": "pipelines", "description": "Pipelines: /pipelines [enable|disable <name>]"},
+			{"command": "usage", "description": "Token usage and cost: /usage [session] [period]"},
+			{"command": "budget", "description": "Daily budget: /budget [<usd>|off|resume]"},
+			{"command": "audit", "description": "Audited actions: /audit [n]"},
+			{"command": "host", "description": "Manage hosts: /host add|del|list|check"},
+			{"command": "rc", "description": "Remote command: /rc <host> <cmd>"},
+			{"command": "setdir", "description": "Set projects dir: /setdir [host:]<path>"},
+			{"command": "away", "description": "Toggle notifications"},
+			{"command": "confirm", "description": "Confirm destructive commands: /confirm [on|off]"},
+			{"command": "c", "description": "Local command: /c <cmd>"},
+			{"command": "screenshot", "description": "Screenshot of the session's screen: /screenshot [text]"},
+			{"command": "ping", "description": "Check bot status"},
+			{"command": "update", "description": "Pull, build and restart CCC"},
+			{"command": "restart", "description": "Restart CCC process"}
+		]
+	}`
+
+	resp, err := http.Post(
+		fmt.Sprintf("https://api.telegram.org/bot%s/setMyCommands", botToken),
+		"application/json",
+		strings.NewReader(commands),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set bot commands: %v\n", redactTokenError(err, botToken))
+		return
+	}
+	resp.Body.Close()
+}
+
+// Execute shell command
+
+func executeCommand(cmdStr string) (string, error) {
+	return runLocalCommand(cmdStr, "", nil, 2*time.Minute)
+}
+
+// runLocalCommand runs a shell command in dir (home when empty) with env
+// (the listener's environment when nil)
+func runLocalCommand(cmdStr string, dir string, env []string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", cmdStr)
+	cmd.Dir = dir
+	if dir == "" {
+		cmd.Dir, _ = os.UserHomeDir()
+	}
+	cmd.Env = env
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		if output != "" {
+			output += "\n"
+		}
+		output += stderr.String()
+	}
+
+	if output == "" {
+		if err != nil {
+			output = fmt.Sprintf("Error: %v", err)
+		} else {
+			output = "(no output)"
+		}
+	}
+
+	return strings.TrimSpace(output), err
+}
+
+// Command policy: with command_policy set, /c and /rc commands are checked
+// against allow and deny patterns and a directory jail, run with a scrubbed
+// environment and capped output, and commands matching a dangerous pattern
+// wait for a confirmation. Users listed in command_policy.users may run /c
+// and /rc, and nothing else, under their role.
+
+var policyCache struct {
+	sync.Mutex
+	key string
+	p   *cmdpolicy.Policy
+	err error
+}
+
+// commandPolicy returns the compiled command policy: nil when none is
+// configured, an error when it does not compile
+func commandPolicy(cfg *Config) (*cmdpolicy.Policy, error) {
+	cp := cfg.CommandPolicy
+	if cp == nil {
+		return nil, nil
+	}
+	opts := cmdpolicy.Options{
+		Allow:     cp.Allow,
+		Deny:      cp.Deny,
+		Dangerous: cp.Dangerous,
+		Jail:      cp.Jail,
+		KeepEnv:   cp.KeepEnv,
+		MaxOutput: cp.MaxOutput,
+		Timeout:   time.Duration(cp.Timeout) * time.Second,
+		Roles:     map[string]cmdpolicy.Role{},
+	}
+	for name, r := range cp.Roles {
+		if r == nil {
+			continue
+		}
+		opts.Roles[name] = cmdpolicy.Role{
+			Allow:     r.Allow,
+			Deny:      r.Deny,
+			Hosts:     r.Hosts,
+			Jail:      r.Jail,
+			MaxOutput: r.MaxOutput,
+			Timeout:   time.Duration(r.Timeout) * time.Second,
+			PerHour:   r.PerHour,
+		}
+	}
+
+	c := &policyCache
+	c.Lock()
+	defer c.Unlock()
+	key := fmt.Sprintf("%#v", opts)
+	if c.key != key {
+		c.p, c.err = cmdpolicy.New(opts)
+		c.key = key
+	}
+	return c.p, c.err
+}
+
+// commandRole returns the policy role of a Telegram user and whether they
+// may use /c and /rc at all: the owner always may, others when their numeric
+// ID is listed in command_policy.users. Usernames are not matched since they
+// can be changed and then claimed by someone else.
+func commandRole(cfg *Config, userID int64) (string, bool) {
+	cp := cfg.CommandPolicy
+	if userID == cfg.ChatID {
+		if cp == nil {
+			return "", true
+		}
+		return cp.OwnerRole, true
+	}
+	if cp == nil {
+		return "", false
+	}
+	if role := cp.Users[strconv.FormatInt(userID, 10)]; role != "" {
+		return role, true
+	}
+	return "", false
+}
+
+// invalidCommandUser returns a command_policy.users key that is not a
+// numeric Telegram user ID ("" if none)
+func invalidCommandUser(cp *config.CommandPolicy) string {
+	for key := range cp.Users {
+		if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+			return key
+		}
+	}
+	return ""
+}
+
+// undefinedCommandRole returns a role assigned to the owner or a user but
+// missing from the policy's roles ("" if none)
+func undefinedCommandRole(cp *config.CommandPolicy) string {
+	if cp.OwnerRole != "" && cp.Roles[cp.OwnerRole] == nil {
+		return cp.OwnerRole
+	}
+	for _, role := range cp.Users {
+		if cp.Roles[role] == nil {
+			return role
+		}
+	}
+	return ""
+}
+
+// shellCommand is a /c or /rc command and who sent it
+type shellCommand struct {
+	UserID  int64
+	Actor   string
+	Role    string
+	Host    string // cmdpolicy.Local for /c
+	Command string
+	Created time.Time // When it started waiting for confirmation
+}
+
+// pendingCommands holds dangerous commands waiting for confirmation
+// (callback data "cfm|run|<id>") for up to confirmTTL
+var pendingCommands sync.Map // id -> *shellCommand
+
+// storePendingCommand holds sc for confirmation, dropping expired commands
+// nobody answered, and returns its id
+func storePendingCommand(sc *shellCommand, now time.Time) string {
+	pendingCommands.Range(func(k, v interface{}) bool {
+		if now.Sub(v.(*shellCommand).Created) > confirmTTL {
+			pendingCommands.Delete(k)
+		}
+		return true
+	})
+	sc.Created = now
+	id := logging.NewID()
+	pendingCommands.Store(id, sc)
+	return id
+}
+
+// loadPendingCommand returns the command waiting under id unless it expired
+func loadPendingCommand(id string, now time.Time) (*shellCommand, bool) {
+	v, ok := pendingCommands.Load(id)
+	if !ok {
+		return nil, false
+	}
+	sc := v.(*shellCommand)
+	if now.Sub(sc.Created) > confirmTTL {
+		pendingCommands.Delete(id)
+		return nil, false
+	}
+	return sc, true
+}
+
+// handleShellCommand parses "/c <cmd>" and "/rc <host> <cmd>" and runs the
+// command for the user who sent it
+func handleShellCommand(cfg *Config, chatID int64, threadID int64, userID int64, username string, text string) {
+	role, _ := commandRole(cfg, userID)
+	sc := &shellCommand{UserID: userID, Actor: telegramActor(userID, username), Role: role, Host: cmdpolicy.Local}
+	if rest, ok := strings.CutPrefix(text, "/c "); ok {
+		sc.Command = rest
+	} else {
+		remainder := strings.TrimSpace(strings.TrimPrefix(text, "/rc "))
+		parts := strings.SplitN(remainder, " ", 2)
+		if len(parts) < 2 || parts[0] == "" {
+			sendMessage(cfg, chatID, threadID, "Usage: /rc <host> <command>")
+			return
+		}
+		if parts[0] == cmdpolicy.Local {
+			sendMessage(cfg, chatID, threadID, "❌ Use /c for local commands")
+			return
+		}
+		sc.Host, sc.Command = parts[0], strings.TrimSpace(parts[1])
+	}
+	runShellCommand(cfg, chatID, threadID, sc, false)
+}
+
+// runShellCommand runs a /c or /rc command, applying the command policy
+// when one is set, and sends the output. The command runs in the background
+// so it does not hold up the update loop for its timeout.
+func runShellCommand(cfg *Config, chatID int64, threadID int64, sc *shellCommand, confirmed bool) {
+	local := sc.Host == cmdpolicy.Local
+	name, address, timeout := "/c", "", 2*time.Minute
+	if !local {
+		name, timeout = "/rc", 30*time.Second
+		if cfg.Hosts == nil || cfg.Hosts[sc.Host] == nil {
+			sendMessage(cfg, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found. Use /host add to configure it.", sc.Host))
+			return
+		}
+		address = cfg.Hosts[sc.Host].Address
+	}
+
+	policy, err := commandPolicy(cfg)
+	if err != nil {
+		recordAudit(cfg, sc.Actor, name, sc.Host, sc.Command, "denied: invalid command_policy")
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("🚫 Command rejected\nRule: command_policy is invalid (%v)", err))
+		return
+	}
+
+	var execute func() (string, error)
+	if policy == nil {
+		execute = func() (string, error) {
+			if local {
+				return executeCommand(sc.Command)
+			}
+			return sshRunCommand(address, sc.Command, timeout)
+		}
+	} else {
+		d := policy.Check(sc.Role, sc.Host, sc.Command)
+		if !d.Allowed {
+			logFor("policy").Info("command rejected", "actor", sc.Actor, "host", sc.Host, "rule", d.Rule)
+			recordAudit(cfg, sc.Actor, name, sc.Host, sc.Command, "denied: "+d.Rule)
+			sendMessage(cfg, chatID, threadID, "🚫 Command rejected\nRule: "+d.Rule)
+			return
+		}
+		if d.Confirm && !confirmed {
+			id := storePendingCommand(sc, time.Now())
+			askConfirmation(cfg, chatID, threadID, fmt.Sprintf("⚠️ This command matches %s. Run it on %s?\n\n%s", d.Rule, sc.Host, sc.Command), "run", id, "Run")
+			return
+		}
+		if err := policy.Spend(strconv.FormatInt(sc.UserID, 10), sc.Role, time.Now()); err != nil {
+			recordAudit(cfg, sc.Actor, name, sc.Host, sc.Command, "denied: "+err.Error())
+			sendMessage(cfg, chatID, threadID, "🚫 Command rejected\nRule: "+err.Error())
+			return
+		}
+		if d.Timeout > 0 {
+			timeout = d.Timeout
+		}
+		execute = func() (string, error) {
+			var output string
+			var err error
+			if local {
+				dir := ""
+				if d.Jail != "" {
+					dir = expandPath(d.Jail)
+				}
+				output, err = runLocalCommand(sc.Command, dir, policy.Env(os.Environ()), timeout)
+			} else {
+				output, err = sshRunCommand(address, policy.RemoteCommand(sc.Command, d.Jail), timeout)
+			}
+			return cmdpolicy.Cap(output, d.MaxOutput), err
+		}
+	}
+
+	go func() {
+		output, err := execute()
+		recordAudit(cfg, sc.Actor, name, sc.Host, sc.Command, auditResult(err))
+		if err != nil {
+			output = fmt.Sprintf("⚠️ %s\n\nExit: %v", output, err)
+		}
+		if local {
+			sendMessage(cfg, chatID, threadID, output)
+			return
+		}
+		if output == "" {
+			output = "(no output)"
+		}
+		sendMessage(cfg, chatID, threadID, fmt.Sprintf("📤 %s:\n%s", sc.Host, output))
+	}()
+}
+
+// ownPendingCommand reports whether a callback confirms or cancels a
+// command its sender is waiting on, which teammates may press
+func ownPendingCommand(cb *CallbackQuery) bool {
+	action, id, ok := parseConfirmCallback(cb.Data)
+	if !ok || (action != "run" && action != "cancel") {
+		return false
+	}
+	sc, ok := loadPendingCommand(id, time.Now())
+	return ok && sc.UserID == cb.From.ID
+}
+
+// One-shot Claude run (for private chat)
+
+func runClaude(prompt string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	home, _ := os.UserHomeDir()
+	workDir := home
+
+	words := strings.Fields(prompt)
+	if len(words) > 0 {
+		firstWord := words[0]
+		potentialDir := filepath.Join(home, firstWord)
+		if info, err := os.Stat(potentialDir); err == nil && info.IsDir() {
+			workDir = potentialDir
+			prompt = strings.TrimSpace(strings.TrimPrefix(prompt, firstWord))
+			if prompt == "" {
+				return "Error: no prompt provided after directory name", nil
+			}
+		}
+	}
+
+	if claudePath == "" {
+		return "Error: claude binary not found", fmt.Errorf("claude not found")
+	}
+	cmd := exec.CommandContext(ctx, claudePath, "--dangerously-skip-permissions", "-p", prompt)
+	cmd.Dir = workDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+
+	output := stdout.String()
+	if stderr.Len() > 0 {
+		if output != "" {
+			output += "\n"
+		}
+		output += stderr.String()
+	}
+
+	if output == "" {
+		if err != nil {
+			output = fmt.Sprintf("Error: %v", err)
+		} else {
+			output = "(no output)"
+		}
+	}
+
+	return strings.TrimSpace(output), err
+}
+
+// Setup - complete setup process
+
+func installService() error {
+	home, _ := os.UserHomeDir()
+
+	// Detect OS and install appropriate service
+	if _, err := os.Stat("/Library"); err == nil {
+		// macOS - use launchd
+		return installLaunchdService(home)
+	}
+	// Linux - use systemd
+	return installSystemdService(home)
+}
+
+func installLaunchdService(home string) error {
+	plistDir := filepath.Join(home, "Library", "LaunchAgents")
+	if err := os.MkdirAll(plistDir, 0755); err != nil {
+		return fmt.Errorf("failed to create LaunchAgents dir: %w", err)
+	}
+
+	plistPath := filepath.Join(plistDir, "com.ccc.plist")
+	logPath := filepath.Join(home, ".ccc.log")
+
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>com.ccc</string>
+    <key>ProgramArguments</key>
+    <array>
+        <string>%s</string>
+        <string>listen</string>
+    </array>
+    <key>RunAtLoad</key>
+    <true/>
+    <key>KeepAlive</key>
+    <true/>
+    <key>StandardOutPath</key>
+    <string>%s</string>
+    <key>StandardErrorPath</key>
+    <string>%s</string>
+</dict>
+</plist>
+`, cccPath, logPath, logPath)
+
+	if err := os.WriteFile(plistPath, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("failed to write plist: %w", err)
+	}
+
+	// Unload if exists, then load
+	exec.Command("launchctl", "unload", plistPath).Run()
+	if err := exec.Command("launchctl", "load", plistPath).Run(); err != nil {
+		return fmt.Errorf("failed to load service: %w", err)
+	}
+
+	fmt.Println("✅ Service installed and started (launchd)")
+	return nil
+}
+
+func installSystemdService(home string) error {
+	serviceDir := filepath.Join(home, ".config", "systemd", "user")
+	if err := os.MkdirAll(serviceDir, 0755); err != nil {
+		return fmt.Errorf("failed to create systemd dir: %w", err)
+	}
+
+	servicePath := filepath.Join(serviceDir, "ccc.service")
+	service := fmt.Sprintf(`[Unit]
+Description=Claude Code Companion
+After=network.target
+
+[Service]
+ExecStart=%s listen
+Restart=always
+RestartSec=10
+
+[Install]
+WantedBy=default.target
+`, cccPath)
+
+	if err := os.WriteFile(servicePath, []byte(service), 0600); err != nil {
+		return fmt.Errorf("failed to write service file: %w", err)
+	}
+
+	// Reload and start
+	exec.Command("systemctl", "--user", "daemon-reload").Run()
+	exec.Command("systemctl", "--user", "enable", "ccc").Run()
+	if err := exec.Command("systemctl", "--user", "start", "ccc").Run(); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+
+	fmt.Println("✅ Service installed and started (systemd)")
+	return nil
+}
+
+func setup(botToken string) error {
+	fmt.Println("🚀 Claude Code Companion Setup")
+	fmt.Println("==============================")
+	fmt.Println()
+
+	config := &Config{BotToken: botToken, Sessions: make(map[string]*SessionInfo)}
+
+	// Step 1: Get chat ID
+	fmt.Println("Step 1/4: Connecting to Telegram...")
+	fmt.Println("📱 Send any message to your bot in Telegram")
+	fmt.Println("   Waiting...")
+
+	offset := 0
+	for {
+		resp, err := telegramGet(botToken, fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", botToken, offset))
+		if err != nil {
+			return fmt.Errorf("failed to get updates: %w", err)
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+
+		var updates TelegramUpdate
+		if err := json.Unmarshal(body, &updates); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		if !updates.OK {
+			return fmt.Errorf("telegram API error - check your bot token")
+		}
+
+		for _, update := range updates.Result {
+			offset = update.UpdateID + 1
+			if update.Message.Chat.ID != 0 {
+				config.ChatID = update.Message.Chat.ID
+				if err := saveConfig(config); err != nil {
+					return fmt.Errorf("failed to save config: %w", err)
+				}
+				fmt.Printf("✅ Connected! (User: @%s)\n\n", update.Message.From.Username)
+				goto step2
+			}
+		}
+
+		time.Sleep(time.Second)
+	}
+
+step2:
+	// Step 2: Group setup (optional)
+	fmt.Println("Step 2/4: Group setup (optional)")
+	fmt.Println("   For session topics, create a Telegram group with Topics enabled,")
+	fmt.Println("   add your bot as admin, and send a message there.")
+	fmt.Println("   Or press Enter to skip...")
+
+	// Non-blocking check for group message with timeout
+	fmt.Println("   Waiting 30 seconds for group message...")
+
+	client := &http.Client{Timeout: 35 * time.Second}
+	deadline := time.Now().Add(30 * time.Second)
+
+	for time.Now().Before(deadline) {
+		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=5", config.BotToken, offset)
+		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+		if err != nil {
+			continue
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+
+		var updates TelegramUpdate
+		json.Unmarshal(body, &updates)
+
+		for _, update := range updates.Result {
+			offset = update.UpdateID + 1
+			chat := update.Message.Chat
+			if chat.Type == "supergroup" {
+				config.GroupID = chat.ID
+				saveConfig(config)
+				fmt.Printf("✅ Group configured!\n\n")
+				goto step3
+			}
+		}
+	}
+	fmt.Println("⏭️  Skipped (you can run 'ccc setgroup' later)")
+
+step3:
+	// Step 3: Install Claude hook
+	fmt.Println("Step 3/4: Installing Claude hook...")
+	if err := installHook(); err != nil {
+		fmt.Printf("⚠️  Hook installation failed: %v\n", err)
+		fmt.Println("   You can install it later with: ccc install")
+	} else {
+		fmt.Println()
+	}
+
+	// Step 4: Install service
+	fmt.Println("Step 4/4: Installing background service...")
+	if err := installService(); err != nil {
+		fmt.Printf("⚠️  Service installation failed: %v\n", err)
+		fmt.Println("   You can start manually with: ccc listen")
+	} else {
+		fmt.Println()
+	}
+
+	// Done!
+	fmt.Println("==============================")
+	fmt.Println("✅ Setup complete!")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  ccc           Start Claude Code in current directory")
+	fmt.Println("  ccc -c        Continue previous session")
+	fmt.Println()
+	if config.GroupID != 0 {
+		fmt.Println("Telegram commands (in your group):")
+		fmt.Println("  /new <name>   Create new session")
+		fmt.Println("  /list         List sessions")
+	} else {
+		fmt.Println("To enable Telegram session topics:")
+		fmt.Println("  1. Create a group with Topics enabled")
+		fmt.Println("  2. Add bot as admin")
+		fmt.Println("  3. Run: ccc setgroup")
+	}
+
+	return nil
+}
+
+func setGroup(config *Config) error {
+	fmt.Println("Send a message in the group where you want to use topics...")
+	fmt.Println("(Make sure Topics are enabled in group settings)")
+
+	offset := 0
+	client := &http.Client{Timeout: 35 * time.Second}
+
+	for {
+		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", config.BotToken, offset)
+		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+		if err != nil {
+			return redactTokenError(err, config.BotToken)
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+
+		var updates TelegramUpdate
+		if err := json.Unmarshal(body, &updates); err != nil {
+			continue
+		}
+
+		for _, update := range updates.Result {
+			offset = update.UpdateID + 1
+			chat := update.Message.Chat
+			if chat.Type == "supergroup" && update.Message.From.ID == config.ChatID {
+				config.GroupID = chat.ID
+				if err := saveConfig(config); err != nil {
+					return err
+				}
+				fmt.Printf("Group set: %d\n", chat.ID)
+				fmt.Println("You can now create sessions with: /new <name>")
+				return nil
+			}
+		}
+	}
+}
+
+// Doctor - check all dependencies
+
+func doctor() {
+	fmt.Println("🩺 ccc doctor")
+	fmt.Println("=============")
+	fmt.Println()
+
+	allGood := true
+
+	// Check tmux
+	fmt.Print("tmux.............. ")
+	if tmuxPath != "" {
+		fmt.Printf("✅ %s\n", tmuxPath)
+	} else if backend().Name() == "pty" {
+		fmt.Println("⚠️  not found (using the built-in pty backend)")
+	} else {
+		fmt.Println("❌ not found")
+		fmt.Println("   Install: brew install tmux (macOS) or apt install tmux (Linux)")
+		fmt.Println("   Or set \"session_backend\": \"pty\" in ~/.ccc.json")
+		allGood = false
+	}
+
+	// Check claude
+	fmt.Print("claude............ ")
+	if claudePath != "" {
+		fmt.Printf("✅ %s\n", claudePath)
+	} else {
+		fmt.Println("❌ not found")
+		fmt.Println("   Install: npm install -g @anthropic-ai/claude-code")
+		allGood = false
+	}
+
+	// Check ccc is in ~/bin (for hooks)
+	fmt.Print("ccc in ~/bin...... ")
+	home, _ := os.UserHomeDir()
+	expectedCccPath := filepath.Join(home, "bin", "ccc")
+	if _, err := os.Stat(expectedCccPath); err == nil {
+		fmt.Printf("✅ %s\n", expectedCccPath)
+	} else {
+		fmt.Println("❌ not found")
+		fmt.Println("   Run: mkdir -p ~/bin && cp ccc ~/bin/")
+		allGood = false
+	}
+
+	// Check config
+	fmt.Print("config............ ")
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println("❌ not found")
+		fmt.Println("   Run: ccc setup <bot_token>")
+		allGood = false
+	} else {
+		fmt.Printf("✅ %s\n", getConfigPath())
+
+		// Check bot token
+		fmt.Print("  bot_token....... ")
+		if config.BotToken != "" {
+			fmt.Printf("✅ configured (%s)\n", tokenSourceName(config.TokenSource()))
+		} else {
+			fmt.Println("❌ missing")
+			allGood = false
+		}
+
+		// Check secrets: plaintext tokens, unreadable sources, loose permissions
+		fmt.Print("  secrets......... ")
+		warnings := secretWarnings(config)
+		if err := config.SecretsError(); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			allGood = false
+		} else if len(warnings) == 0 {
+			fmt.Println("✅ no plaintext secrets")
+		} else {
+			fmt.Println("⚠️  " + warnings[0])
+			warnings = warnings[1:]
+		}
+		for _, w := range warnings {
+			fmt.Println("   ⚠️  " + w)
+		}
+
+		// Check chat ID
+		fmt.Print("  chat_id......... ")
+		if config.ChatID != 0 {
+			fmt.Printf("✅ %d\n", config.ChatID)
+		} else {
+			fmt.Println("❌ missing")
+			allGood = false
+		}
+
+		// Check group ID (optional)
+		fmt.Print("  group_id........ ")
+		if config.GroupID != 0 {
+			fmt.Printf("✅ %d\n", config.GroupID)
+		} else {
+			fmt.Println("⚠️  not set (optional, run: ccc setgroup)")
+		}
+	}
+
+	// Check Claude hook
+	fmt.Print("claude hook....... ")
+	settingsPath := filepath.Join(home, ".claude", "settings.json")
+	if data, err := os.ReadFile(settingsPath); err == nil {
+		var settings map[string]interface{}
+		if json.Unmarshal(data, &settings) == nil {
+			if hooks, ok := settings["hooks"].(map[string]interface{}); ok {
+				if _, hasStop := hooks["Stop"]; hasStop {
+					fmt.Println("✅ installed")
+				} else {
+					fmt.Println("❌ not installed")
+					fmt.Println("   Run: ccc install")
+					allGood = false
+				}
+			} else {
+				fmt.Println("❌ not installed")
+				fmt.Println("   Run: ccc install")
+				allGood = false
+			}
+		} else {
+			fmt.Println("⚠️  settings.json parse error")
+		}
+	} else {
+		fmt.Println("⚠️  ~/.claude/settings.json not found")
+	}
+
+	// Check service
+	fmt.Print("service........... ")
+	if _, err := os.Stat("/Library"); err == nil {
+		// macOS - check launchd
+		plistPath := filepath.Join(home, "Library", "LaunchAgents", "com.ccc.plist")
+		if _, err := os.Stat(plistPath); err == nil {
+			// Check if loaded
+			cmd := exec.Command("launchctl", "list", "com.ccc")
+			if cmd.Run() == nil {
+				fmt.Println("✅ running (launchd)")
+			} else {
+				fmt.Println("⚠️  installed but not running")
+				fmt.Println("   Run: launchctl load ~/Library/LaunchAgents/com.ccc.plist")
+			}
+		} else {
+			fmt.Println("❌ not installed")
+			fmt.Println("   Run: ccc setup <token> (or manually create plist)")
+			allGood = false
+		}
+	} else {
+		// Linux - check systemd
+		cmd := exec.Command("systemctl", "--user", "is-active", "ccc")
+		if output, err := cmd.Output(); err == nil && strings.TrimSpace(string(output)) == "active" {
+			fmt.Println("✅ running (systemd)")
+		} else {
+			servicePath := filepath.Join(home, ".config", "systemd", "user", "ccc.service")
+			if _, err := os.Stat(servicePath); err == nil {
+				fmt.Println("⚠️  installed but not running")
+				fmt.Println("   Run: systemctl --user start ccc")
+			} else {
+				fmt.Println("❌ not installed")
+				fmt.Println("   Run: ccc setup <token> (or manually create service)")
+				allGood = false
+			}
+		}
+	}
+
+	// Check transcription (optional)
+	fmt.Print("transcription..... ")
+	doctorCfg := config
+	if doctorCfg == nil {
+		doctorCfg = &Config{}
+	}
+	if provider, err := transcriptionProvider(doctorCfg); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("   Check transcription in ~/.ccc.json")
+	} else if err := provider.Check(); err == nil {
+		fmt.Printf("✅ %s\n", provider.Name())
+	} else if doctorCfg.TranscriptionCmd == "" && doctorCfg.Transcription == nil {
+		fmt.Println("⚠️  not configured (optional, for voice messages)")
+		fmt.Println("   Set transcription in ~/.ccc.json or install whisper")
+	} else {
+		fmt.Printf("❌ %s: %v\n", provider.Name(), err)
+		fmt.Println("   Check transcription in ~/.ccc.json")
+	}
+
+	// Check secret redaction
+	fmt.Print("redaction......... ")
+	if doctorCfg.Redaction != nil && doctorCfg.Redaction.Disabled {
+		fmt.Println("⚠️  disabled (secrets in output are sent to Telegram as is)")
+	} else if err := checkRedaction(doctorCfg); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("   Fix redaction.patterns / redaction.allow in ~/.ccc.json")
+		allGood = false
+	} else {
+		fmt.Println("✅ on")
+	}
+
+	// Check the /c and /rc command policy
+	fmt.Print("command policy.... ")
+	if doctorCfg.CommandPolicy == nil {
+		fmt.Println("✅ not set (/c and /rc are owner only)")
+	} else if _, err := commandPolicy(doctorCfg); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("   Fix command_policy in ~/.ccc.json (until then /c and /rc reject everything)")
+		allGood = false
+	} else if role := undefinedCommandRole(doctorCfg.CommandPolicy); role != "" {
+		fmt.Printf("❌ role %q is not defined in command_policy.roles\n", role)
+		allGood = false
+	} else if key := invalidCommandUser(doctorCfg.CommandPolicy); key != "" {
+		fmt.Printf("❌ command_policy.users: %q is not a numeric Telegram user ID (it is ignored)\n", key)
+		allGood = false
+	} else {
+		fmt.Printf("✅ %d roles, %d users\n", len(doctorCfg.CommandPolicy.Roles), len(doctorCfg.CommandPolicy.Users))
+	}
+
+	fmt.Println()
+	if allGood {
+		fmt.Println("✅ All checks passed!")
+	} else {
+		fmt.Println("❌ Some issues found. Fix them and run 'ccc doctor' again.")
+	}
+}
+
+// Send notification (only if away)
+
+func send(message string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("not configured. Run: ccc setup <bot_token>")
+	}
+
+	if !config.Away {
+		fmt.Println("Away mode off, skipping notification.")
+		return nil
+	}
+
+	// Try to send to session topic if we're in a session directory
+	if config.GroupID != 0 {
+		cwd, _ := os.Getwd()
+		for name, info := range config.Sessions {
+			if info == nil {
+				continue
+			}
+			if cwd == info.Path || strings.HasPrefix(cwd, info.Path+"/") || strings.HasSuffix(cwd, "/"+name) {
+				return sendMessage(config, config.GroupID, info.TopicID, message)
+			}
+		}
+	}
+
+	// Fallback to private chat
+	return sendMessage(config, config.ChatID, 0, message)
+}
+
+// handleRemoteMessage handles messages forwarded from remote clients via --from flag
+func handleRemoteMessage(fromHost string, cwd string, encodedProjectDir string, message string) error {
+	// Truncate message for log
+	logMsg := message
+	if len(logMsg) > 100 {
+		logMsg = logMsg[:100] + "..."
+	}
+	log := logFor("remote").With("host", fromHost)
+	log.Info("message from client", "cwd", cwd, "project", encodedProjectDir, "text", logMsg)
+	reportHook("remote")
+
+	config, err := loadConfig()
+	if err != nil {
+		log.Error("not configured", "error", err)
+		return fmt.Errorf("not configured: %v", err)
+	}
+
+	// cwd is passed from remote client via --cwd flag
+	if cwd == "" {
+		log.Error("missing --cwd parameter")
+		return fmt.Errorf("missing --cwd parameter")
+	}
+
+	// Resolve actual project path from encoded project dir
+	// This handles cases where Claude cd'd into a subdirectory
+	projectPath := cwd
+	if encodedProjectDir != "" {
+		if resolved := resolveProjectPathFromTranscript(encodedProjectDir, cwd); resolved != "" {
+			projectPath = resolved
+			log.Debug("resolved project path", "path", projectPath, "cwd", cwd)
+		}
+	}
+
+	// Find session matching fromHost and path (exact match first, then subdirectory)
+	var subdirMatch string
+	var subdirInfo *SessionInfo
+	for name, info := range config.Sessions {
+		if info == nil || info.Host != fromHost {
+			continue
+		}
+		// Exact match
+		if info.Path == projectPath {
+			// Skip prompt messages that were just sent from Telegram (cooldown 10s)
+			if strings.HasPrefix(message, "💬") && wasTelegramSent(info.TopicID) {
+				log.Debug("skipping prompt (telegram cooldown)", logging.SessionKey, name, "topic", info.TopicID)
+				return nil
+			}
+			log.Info("matched session", logging.SessionKey, name, logging.IDKey, topicCorrelation(info.TopicID), "topic", info.TopicID)
+			histFrom, histText := parseRemoteMessagePrefix(message)
+			appendHistoryDedup(info.TopicID, histFrom, histText)
+			return sendRemoteMessage(config, name, info.TopicID, message)
+		}
+		// Subdirectory match: projectPath is under this session's path
+		if strings.HasPrefix(projectPath, info.Path+"/") {
+			// Pick the longest (most specific) parent path
+			if subdirInfo == nil || len(info.Path) > len(subdirInfo.Path) {
+				subdirMatch = name
+				subdirInfo = info
+			}
+		}
+	}
+
+	// Use subdirectory match if found (cwd is inside an existing session's project)
+	if subdirInfo != nil {
+		if strings.HasPrefix(message, "💬") && wasTelegramSent(subdirInfo.TopicID) {
+			log.Debug("skipping prompt (telegram cooldown)", logging.SessionKey, subdirMatch, "topic", subdirInfo.TopicID)
+			return nil
+		}
+		log.Info("matched session (subdirectory)", logging.SessionKey, subdirMatch, logging.IDKey, topicCorrelation(subdirInfo.TopicID), "topic", subdirInfo.TopicID, "path", projectPath)
+		histFrom, histText := parseRemoteMessagePrefix(message)
+		appendHistoryDedup(subdirInfo.TopicID, histFrom, histText)
+		return sendRemoteMessage(config, subdirMatch, subdirInfo.TopicID, message)
+	}
+
+	// No matching session found - auto-create topic (fallback for client-initiated sessions)
+	log.Info("no session for path, creating topic", "path", projectPath)
+
+	// Generate session name: host:projectDir
+	fullName := fromHost + ":" + filepath.Base(projectPath)
+
+	topicID, err := getOrCreateTopic(config, fullName, projectPath, fromHost)
+	if err != nil {
+		// Fallback to private chat if topic creation fails
+		fmt.Fprintf(os.Stderr, "Failed to create topic: %v\n", err)
+		return sendMessage(config, config.ChatID, 0, fmt.Sprintf("[%s] %s", fromHost, message))
+	}
+
+	log.Info("created or reused topic", logging.SessionKey, fullName, "topic", topicID)
+	// Store forwarded message in history (with dedup)
+	histFrom, histText := parseRemoteMessagePrefix(message)
+	appendHistoryDedup(topicID, histFrom, histText)
+	return sendRemoteMessage(config, fullName, topicID, message)
+}
+
+// sendRemoteMessage posts a forwarded hook message to its topic. Stop
+// messages replace the live progress message and have their usage trailer
+// recorded; tool output is dropped while a progress message is active, since
+// it already reflects it.
+func sendRemoteMessage(config *Config, sessionName string, topicID int64, message string) error {
+	if strings.HasPrefix(message, "💬") {
+		return sendMessage(config, config.GroupID, topicID, message)
+	}
+	if strings.HasPrefix(message, "✅") {
+		body := ""
+		if idx := strings.Index(message, "\n\n"); idx != -1 {
+			body = message[idx+2:]
+		}
+		if _, turn := usage.SplitTrailer(body); turn != nil {
+			recordRemoteUsage(sessionName, turn)
+		}
+		err := deliverFinalMessage(config, config.GroupID, topicID, "✅ "+sessionName, body)
+		response, _ := usage.SplitTrailer(body)
+		go sendVoiceReply(config, sessionName, topicID, response)
+		checkBudget(config)
+		go runPipelines(config, sessionName, response)
+		return err
+	}
+	if progressActive(topicID) {
+		logFor("remote").Debug("skipping output (live progress active)", logging.SessionKey, sessionName, "topic", topicID)
+		return nil
+	}
+	return sendClaudeMessage(config, config.GroupID, topicID, "", message)
+}
+
+// parseRemoteMessagePrefix determines the sender and clean text from a
+// forwarded remote message. Messages from client-mode hooks have prefixes:
+//   - "✅ sessionName\n\n..." → from claude (stop hook = response)
+//   - "💬 ..." → from human (prompt hook)
+//   - everything else → from claude (output hook / tool output)
+func parseRemoteMessagePrefix(message string) (from string, text string) {
+	if strings.HasPrefix(message, "✅") {
+		// Stop hook: "✅ sessionName\n\n<response>"
+		if idx := strings.Index(message, "\n\n"); idx != -1 {
+			text, _ = usage.SplitTrailer(message[idx+2:])
+			return "claude", text
+		}
+		return "claude", message
+	}
+	if strings.HasPrefix(message, "💬") {
+		// Prompt hook: "💬 <user message>"
+		text = strings.TrimPrefix(message, "💬 ")
+		text = strings.TrimPrefix(text, "💬")
+		return "human", strings.TrimSpace(text)
+	}
+	// Output hook or other → claude
+	return "claude", message
+}
+
+// appendHistoryDedup stores a message in history, but skips if the last
+// message with the same "from" already has identical text. This prevents
+// duplicates when both handleAskCmd (inline) and handleRemoteMessage
+// (stop hook forwarding) store the same response.
+func appendHistoryDedup(topicID int64, from string, text string) {
+	msgs, err := readHistory(topicID, 0, 1, from)
+	if err == nil && len(msgs) > 0 && msgs[len(msgs)-1].Text == text {
+		logFor("history").Debug("skipping duplicate message", "from", from, "topic", topicID)
+		return
+	}
+	appendHistory(topicID, HistoryMessage{
+		ID:        nextMessageID(),
+		Timestamp: time.Now().Unix(),
+		From:      from,
+		Text:      text,
+	})
+}
+
+// handleHostCommand handles /host subcommands
+func handleHostCommand(config *Config, chatID int64, threadID int64, text string, userID int64, username string) {
+	actor := telegramActor(userID, username)
+	args := strings.Fields(text)
+	if len(args) < 2 {
+		sendMessage(config, chatID, threadID, `Host management commands:
+/host add <name> <address> [projects_dir]
+/host set <name> <address>
+/host del <name>
+/host list
+/host check <name>`)
+		return
+	}
+
+	subCmd := args[1]
+
+	switch subCmd {
+	case "add":
+		// /host add <name> <address> [projects_dir]
+		if len(args) < 4 {
+			sendMessage(config, chatID, threadID, "Usage: /host add <name> <address> [projects_dir]\nExample: /host add laptop wlad@192.168.1.50 ~/Dev")
+			return
+		}
+		name := args[2]
+		address := args[3]
+		projectsDir := "~"
+		if len(args) >= 5 {
+			projectsDir = args[4]
+		}
+
+		// Check if host already exists
+		if config.Hosts != nil {
+			if _, exists := config.Hosts[name]; exists {
+				sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' already exists. Use /host set to update.", name))
+				return
+			}
+		}
+
+		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking connection to %s...", address))
+
+		// Check SSH connection
+		if err := sshCheckConnection(address); err != nil {
+			recordAudit(config, actor, "/host add", name, address, auditResult(err))
+			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Cannot connect to %s: %v\nCheck SSH key setup.", address, err))
+			return
+		}
+
+		// Check tmux
+		tmuxPath, err := sshCheckCommand(address, "tmux")
+		if err != nil {
+			sendMessage(config, chatID, threadID, fmt.Sprintf("⚠️ tmux not found on %s", name))
+			tmuxPath = "not found"
+		}
+
+		// Check claude
+		claudePath, err := sshCheckCommand(address, "claude")
+		if err != nil {
+			sendMessage(config, chatID, threadID, fmt.Sprintf("⚠️ claude not found on %s", name))
+			claudePath = "not found"
+		}
+
+		// Save host
+		_, err = updateConfig(func(cfg *Config) error {
+			if cfg.Hosts == nil {
+				cfg.Hosts = make(map[string]*HostInfo)
+			}
+			cfg.Hosts[name] = &HostInfo{
+				Address:     address,
+				ProjectsDir: projectsDir,
+			}
+			return nil
+		})
+		recordAudit(config, actor, "/host add", name, address, auditResult(err))
+
+		msg := fmt.Sprintf(`✅ Host '%s' added!
+
+Address: %s
+Projects dir: %s
+tmux: %s
+claude: %s`, name, address, projectsDir, tmuxPath, claudePath)
+		sendMessage(config, chatID, threadID, msg)
+
+	case "set":
+		// /host set <name> <address>
+		if len(args) < 4 {
+			sendMessage(config, chatID, threadID, "Usage: /host set <name> <address>")
+			return
+		}
+		name := args[2]
+		address := args[3]
+
+		if config.Hosts == nil || config.Hosts[name] == nil {
+			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found", name))
+			return
+		}
+
+		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking connection to %s...", address))
+
+		// Check SSH connection
+		if err := sshCheckConnection(address); err != nil {
+			recordAudit(config, actor, "/host set", name, address, auditResult(err))
+			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Cannot connect to %s: %v", address, err))
+			return
+		}
+
+		_, err := updateConfig(func(cfg *Config) error {
+			if cfg.Hosts[name] == nil {
+				return fmt.Errorf("host '%s' not found", name)
+			}
+			cfg.Hosts[name].Address = address
+			return nil
+		})
+		recordAudit(config, actor, "/host set", name, address, auditResult(err))
+		sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Host '%s' updated to %s", name, address))
+
+	case "del":
+		// /host del <name>
+		if len(args) < 3 {
+			sendMessage(config, chatID, threadID, "Usage: /host del <name>")
+			return
+		}
+		name := args[2]
+		if err := checkHostDelete(config, name); err != nil {
+			sendMessage(config, chatID, threadID, "❌ "+err.Error())
+			return
+		}
+		requestDestructive(config, chatID, threadID, userID, username,
+			fmt.Sprintf("⚠️ Delete host '%s' (%s)?", name, config.Hosts[name].Address), "hostdel", name, "🗑️ Delete")
+
+	case "list":
+		// /host list
+		if config.Hosts == nil || len(config.Hosts) == 0 {
+			sendMessage(config, chatID, threadID, "No hosts configured.\nUse /host add <name> <address> to add one.")
+			return
+		}
+
+		var lines []string
+		for name, info := range config.Hosts {
+			lines = append(lines, fmt.Sprintf("• %s → %s (%s)", name, info.Address, info.ProjectsDir))
+		}
+		sendMessage(config, chatID, threadID, "Configured hosts:\n"+strings.Join(lines, "\n"))
+
+	case "check":
+		// /host check <name>
+		if len(args) < 3 {
+			sendMessage(config, chatID, threadID, "Usage: /host check <name>")
+			return
+		}
+		name := args[2]
+
+		if config.Hosts == nil || config.Hosts[name] == nil {
+			sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found", name))
+			return
+		}
+
+		host := config.Hosts[name]
+		sendMessage(config, chatID, threadID, fmt.Sprintf("🔄 Checking %s (%s)...", name, host.Address))
+
+		var results []string
+
+		// Check SSH
+		if err := sshCheckConnection(host.Address); err != nil {
+			results = append(results, fmt.Sprintf("❌ SSH connection: %v", err))
+		} else {
+			results = append(results, "✅ SSH connection: OK")
+		}
+
+		// Check tmux
+		if tmuxPath, err := sshCheckCommand(host.Address, "tmux"); err != nil {
+			results = append(results, "❌ tmux: not found")
+		} else {
+			results = append(results, fmt.Sprintf("✅ tmux: %s", tmuxPath))
+		}
+
+		// Check claude
+		if claudePath, err := sshCheckCommand(host.Address, "claude"); err != nil {
+			results = append(results, "❌ claude: not found")
+		} else {
+			results = append(results, fmt.Sprintf("✅ claude: %s", claudePath))
+		}
+
+		// Check projects_dir
+		if sshDirExists(host.Address, host.ProjectsDir) {
+			results = append(results, fmt.Sprintf("✅ projects_dir: %s (exists)", host.ProjectsDir))
+		} else {
+			results = append(results, fmt.Sprintf("⚠️ projects_dir: %s (will be created)", host.ProjectsDir))
+		}
+
+		sendMessage(config, chatID, threadID, strings.Join(results, "\n"))
+
+	default:
+		sendMessage(config, chatID, threadID, fmt.Sprintf("Unknown subcommand: %s\nUse /host for help.", subCmd))
+	}
+}
+
+// Main listen loop
+
+func listen() error {
+	// Acquire exclusive lock to prevent multiple instances
+	lockPath := filepath.Join(os.Getenv("HOME"), ".ccc.lock")
+	var lockErr error
+	lockFile, lockErr = os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if lockErr != nil {
+		return fmt.Errorf("failed to open lock file: %w", lockErr)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		lockFile.Close()
+		lockFile = nil
+		return fmt.Errorf("another ccc listen instance is already running (lock: %s)", lockPath)
+	}
+	// lockFile is kept open at package level — lock released when process exits
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("not configured. Run: ccc setup <bot_token>")
+	}
+	if config.BotToken == "" {
+		return missingTokenError(config)
+	}
+
+	initLogging(os.Stdout)
+	fmt.Printf("Bot listening... (chat: %d, group: %d)\n", config.ChatID, config.GroupID)
+	fmt.Printf("Active sessions: %d\n", len(config.Sessions))
+	fmt.Println("Press Ctrl+C to stop")
+
+	// Initialize message ID counter from history
+	initMessageIDCounter()
+
+	// Start Unix socket API server
+	if err := startSocketServer(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to start API socket: %v\n", err)
+	}
+	if err := startHookRelay(); err != nil {
+		logFor("listen").Warn("failed to start hook relay", "error", err)
+	}
+
+	setBotCommands(config.BotToken)
+	startStatusMonitor()
+	startBudgetMonitor()
+	startIdleMonitor()
+	startSupervisor()
+	startRecordingMonitor()
+	if config.MetricsListen != "" {
+		if err := startMetricsServer(config.MetricsListen); err != nil {
+			logFor("metrics").Warn("failed to start metrics server", "error", err)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	offset := 0
+	client := &http.Client{Timeout: 35 * time.Second}
+
+	go func() {
+		<-sigChan
+		fmt.Println("\nShutting down...")
+		stopSocketServer()
+		os.Exit(0)
+	}()
+
+	for {
+		// Reload config from disk to pick up sessions created by external
+		// processes (hook CLIs, handleRemoteMessage, etc.)
+		if freshCfg, err := loadConfig(); err == nil {
+			config = freshCfg
+		}
+
+		reqURL := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d&timeout=30", config.BotToken, offset)
+		resp, err := telegramClientGet(client, config.BotToken, reqURL)
+		if err != nil {
+			markPoll(false)
+			logFor("telegram").Warn("getUpdates failed, retrying", "error", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+
+		var updates TelegramUpdate
+		if err := json.Unmarshal(body, &updates); err != nil {
+			markPoll(false)
+			logFor("telegram").Warn("getUpdates returned invalid JSON", "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		markPoll(updates.OK)
+		if !updates.OK {
+			logFor("telegram").Warn("getUpdates failed", "error", updates.Description)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, update := range updates.Result {
+			offset = update.UpdateID + 1
+
+			// Handle callback queries (button presses from inline keyboards)
+			if update.CallbackQuery != nil {
+				telegramUpdatesTotal.Inc("callback")
+				cb := update.CallbackQuery
+				// Only accept from authorized user (teammates may confirm
+				// their own /c and /rc commands)
+				if cb.From.ID != config.ChatID && !ownPendingCommand(cb) {
+					continue
+				}
+
+				answerCallbackQuery(config, cb.ID)
+
+				if action, session, ok := parseConfirmCallback(cb.Data); ok {
+					handleConfirmCallback(config, cb, action, session)
+					config, _ = loadConfig()
+					continue
+				}
+				if action, ok := strings.CutPrefix(cb.Data, "vtx|"); ok {
+					handleVoiceConfirmCallback(config, cb, action)
+					continue
+				}
+
+				// Parse callback data: session:questionIndex:totalQuestions:optionIndex
+				// Legacy format (3 parts): session:questionIndex:optionIndex
+				parts := strings.Split(cb.Data, ":")
+				if len(parts) >= 3 {
+					sessionName := parts[0]
+					questionIndex, _ := strconv.Atoi(parts[1])
+					var totalQuestions, optionIndex int
+					if len(parts) == 4 {
+						totalQuestions, _ = strconv.Atoi(parts[2])
+						optionIndex, _ = strconv.Atoi(parts[3])
+					} else {
+						optionIndex, _ = strconv.Atoi(parts[2])
+					}
+
+					// Edit message to show selection and remove buttons
+					if cb.Message != nil {
+						originalText := cb.Message.Text
+						newText := fmt.Sprintf("%s\n\n✓ Selected option %d", originalText, optionIndex+1)
+						editMessageRemoveKeyboard(config, cb.Message.Chat.ID, cb.Message.MessageID, newText)
+					}
+
+					// Store answer in history
+					if cb.Message != nil {
+						appendHistoryDedup(cb.Message.MessageThreadID, "human", fmt.Sprintf("Selected option %d", optionIndex+1))
+					}
+
+					// Resolve tmux session name and check local/remote
+					info, exists := config.Sessions[sessionName]
+					tmuxName := tmuxSessionName(sessionName)
+
+					sendTmuxKeys := func(keys ...string) {
+						if exists && info.Host != "" {
+							// Remote session — send via SSH
+							address := getHostAddress(config, info.Host)
+							for _, key := range keys {
+								cmd := fmt.Sprintf("tmux send-keys -t %s %s", shellQuote(tmuxName), key)
+								runSSH(address, cmd, 5*time.Second)
+							}
+						} else {
+							// Local session
+							for _, key := range keys {
+								backend().SendKey(tmuxName, key)
+							}
+						}
+					}
+
+					// Check session exists
+					sessionExists := false
+					if exists && info.Host != "" {
+						address := getHostAddress(config, info.Host)
+						sessionExists = sshTmuxHasSession(address, tmuxName)
+					} else {
+						sessionExists = backend().Exists(tmuxName)
+					}
+
+					if sessionExists {
+						// Send arrow down keys to select option, then Enter
+						for i := 0; i < optionIndex; i++ {
+							sendTmuxKeys("Down")
+							time.Sleep(50 * time.Millisecond)
+						}
+						sendTmuxKeys("Enter")
+						logFor("telegram").Info("option selected", logging.SessionKey, sessionName, "option", optionIndex, "question", questionIndex+1, "questions", totalQuestions)
+
+						// Mark answered in pending questions (for API sync)
+						if val, ok := pendingQuestions.Load(sessionName); ok {
+							pqs := val.(*PendingQuestionSet)
+							if questionIndex < len(pqs.Questions) {
+								pqs.Questions[questionIndex].Answered = true
+								pqs.Questions[questionIndex].AnswerIndex = optionIndex
+							}
+						}
+
+						// After the last question, send Enter to confirm "Submit answers"
+						if totalQuestions > 0 && questionIndex == totalQuestions-1 {
+							time.Sleep(300 * time.Millisecond)
+							sendTmuxKeys("Enter")
+							pendingQuestions.Delete(sessionName)
+							if exists {
+								clearQuestionsPending(info.TopicID)
+							}
+							logFor("telegram").Info("auto-submitted answers", logging.SessionKey, sessionName)
+						}
+					}
+				}
+				continue
+			}
+
+			msg := update.Message
+			telegramUpdatesTotal.Inc("message")
+
+			// Only accept from authorized user; teammates with a command
+			// policy role may use /c and /rc
+			teammate := msg.From.ID != config.ChatID
+			if teammate {
+				if _, ok := commandRole(config, msg.From.ID); !ok {
+					if strings.HasPrefix(msg.Text, "/c ") || strings.HasPrefix(msg.Text, "/rc ") {
+						logFor("policy").Info("ignored command from a user not in command_policy.users", "user_id", msg.From.ID, "username", msg.From.Username)
+					}
+					continue
+				}
+			}
+
+			// Deduplicate: Telegram forum groups can send two updates with
+			// different update_id but the same message_id for a single message.
+			if isMessageProcessed(msg.MessageID) {
+				continue
+			}
+
+			chatID := msg.Chat.ID
+			threadID := msg.MessageThreadID
+			isGroup := msg.Chat.Type == "supergroup"
+
+			if teammate {
+				text := strings.TrimSpace(msg.Text)
+				if strings.HasPrefix(text, "/c ") || strings.HasPrefix(text, "/rc ") {
+					logFor("telegram").Info("teammate command", "user", msg.From.Username, "text", text)
+					handleShellCommand(config, chatID, threadID, msg.From.ID, msg.From.Username, text)
+				} else if strings.HasPrefix(text, "/") {
+					sendMessage(config, chatID, threadID, "🚫 Only /c and /rc are available to you")
+				}
+				continue
+			}
+
+			// A reply to a transcription awaiting confirmation corrects it
+			if msg.ReplyToMessage != nil && isGroup && handleVoiceCorrection(config, chatID, msg.ReplyToMessage.MessageID, strings.TrimSpace(msg.Text)) {
+				continue
+			}
+
+			// Handle voice messages
+			if msg.Voice != nil && isGroup && threadID > 0 {
+				config, _ = loadConfig()
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName != "" {
+					// Get session info to check if remote
+					sessionInfo := config.Sessions[sessionName]
+					hostName := ""
+					if sessionInfo != nil {
+						hostName = sessionInfo.Host
+					}
+
+					// Extract project name for tmux session
+					_, projectName := parseSessionTarget(sessionName)
+					tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+					// Check if session is running
+					sessionRunning := false
+					var address string
+					if hostName != "" {
+						address = getHostAddress(config, hostName)
+						if address != "" {
+							sessionRunning = sshTmuxHasSession(address, tmuxName)
+						}
+					} else {
+						sessionRunning = backend().Exists(tmuxName)
+					}
+
+					if sessionRunning {
+						// Check if Claude is actually running (not crashed to bash)
+						sshAddr := ""
+						if hostName != "" {
+							sshAddr = address
+						}
+						if !isClaudeRunning(tmuxName, sshAddr) {
+							// Auto-restart Claude
+							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
+							if !restartClaudeInSession(tmuxName, sshAddr) {
+								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
+								continue
+							}
+							sendMessage(config, chatID, threadID, "✅ Session restarted")
+						}
+
+						if max := transcriptionMaxSeconds(config); msg.Voice.Duration > max {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Voice message too long (%s, limit %s)", formatDuration(time.Duration(msg.Voice.Duration)*time.Second), formatDuration(time.Duration(max)*time.Second)))
+							continue
+						}
+						sendMessage(config, chatID, threadID, "🎤 Transcribing...")
+						// Download and transcribe
+						audioPath := filepath.Join(os.TempDir(), fmt.Sprintf("voice_%d.ogg", time.Now().UnixNano()))
+						if err := downloadTelegramFile(config, msg.Voice.FileID, audioPath); err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Download failed: %v", err))
+						} else {
+							transcription, err := transcribeAudio(config, sessionInfo, audioPath)
+							os.Remove(audioPath)
+							if err != nil {
+								sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Transcription failed: %v", err))
+							} else if transcription != "" {
+								logFor("voice").Info("transcribed", logging.SessionKey, sessionName, "user", msg.From.Username, "text", transcription)
+								if config.Transcription != nil && config.Transcription.Confirm {
+									p := &pendingVoice{ChatID: chatID, ThreadID: threadID, Session: sessionName, Text: transcription, Username: msg.From.Username}
+									if err := askVoiceConfirmation(config, p); err != nil {
+										sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to post transcription: %v", err))
+									}
+								} else {
+									sendMessage(config, chatID, threadID, fmt.Sprintf("📝 %s", transcription))
+									sendVoicePrompt(config, chatID, threadID, sessionName, transcription, msg.From.Username)
+								}
+							}
+						}
+					}
+				}
+				continue
+			}
+
+			// Handle photo messages
+			if len(msg.Photo) > 0 && isGroup && threadID > 0 {
+				config, _ = loadConfig()
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName != "" {
+					// Get session info to check if remote
+					sessionInfo := config.Sessions[sessionName]
+					hostName := ""
+					if sessionInfo != nil {
+						hostName = sessionInfo.Host
+					}
+
+					// Extract project name for tmux session
+					_, projectName := parseSessionTarget(sessionName)
+					tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+					// Get largest photo (last in array)
+					photo := msg.Photo[len(msg.Photo)-1]
+					imgPath := filepath.Join(os.TempDir(), fmt.Sprintf("telegram_%d.jpg", time.Now().UnixNano()))
+					if err := downloadTelegramFile(config, photo.FileID, imgPath); err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Download failed: %v", err))
+						continue
+					}
+
+					caption := msg.Caption
+					if caption == "" {
+						caption = "Analyze this image:"
+					}
+
+					// Handle remote sessions
+					if hostName != "" {
+						hostInfo := config.Hosts[hostName]
+						if hostInfo == nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host %s not found in config", hostName))
+							continue
+						}
+
+						// Check if Claude is actually running
+						if !isClaudeRunning(tmuxName, hostInfo.Address) {
+							// Auto-restart Claude
+							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
+							if !restartClaudeInSession(tmuxName, hostInfo.Address) {
+								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
+								continue
+							}
+							sendMessage(config, chatID, threadID, "✅ Session restarted")
+						}
+
+						// SCP file to remote host
+						sendMessage(config, chatID, threadID, "📷 Transferring image to remote host...")
+						remotePath := imgPath // Use same path on remote
+						if err := scpToHost(hostInfo.Address, imgPath, remotePath, 30*time.Second); err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ SCP failed: %v", err))
+							continue
+						}
+
+						// Send to remote tmux
+						prompt := fmt.Sprintf("%s %s", caption, remotePath)
+						// Store in history
+						appendHistory(threadID, HistoryMessage{
+							ID:        nextMessageID(),
+							Timestamp: time.Now().Unix(),
+							From:      "human",
+							Type:      "photo",
+							Path:      remotePath,
+							Caption:   caption,
+							Username:  msg.From.Username,
+						})
+						startContinuousTyping(config, chatID, threadID, sessionName)
+						err := typePrompt(config, sessionName, config.Sessions[sessionName], prompt, "photo", "")
+						recordAudit(config, telegramActor(msg.From.ID, msg.From.Username), "photo", sessionName, prompt, auditResult(err))
+						startLiveProgress(config, chatID, threadID, sessionName)
+						// Clean up local file
+						os.Remove(imgPath)
+						continue
+					}
+
+					// Local session
+					if backend().Exists(tmuxName) {
+						// Check if Claude is actually running
+						if !isClaudeRunning(tmuxName, "") {
+							// Auto-restart Claude
+							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
+							if !restartClaudeInSession(tmuxName, "") {
+								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
+								continue
+							}
+							sendMessage(config, chatID, threadID, "✅ Session restarted")
+						}
+						prompt := fmt.Sprintf("%s %s", caption, imgPath)
+						// Store in history
+						appendHistory(threadID, HistoryMessage{
+							ID:        nextMessageID(),
+							Timestamp: time.Now().Unix(),
+							From:      "human",
+							Type:      "photo",
+							Path:      imgPath,
+							Caption:   caption,
+							Username:  msg.From.Username,
+						})
+						sendMessage(config, chatID, threadID, "📷 Image saved, sending to Claude...")
+						startContinuousTyping(config, chatID, threadID, sessionName)
+						// Send text first, wait for image to load, then send Enter
+						err := typePrompt(config, sessionName, config.Sessions[sessionName], prompt, "photo", "")
+						recordAudit(config, telegramActor(msg.From.ID, msg.From.Username), "photo", sessionName, prompt, auditResult(err))
+						startLiveProgress(config, chatID, threadID, sessionName)
+					}
+				}
+				continue
+			}
+
+			text := strings.TrimSpace(msg.Text)
+			if text == "" {
+				continue
+			}
+
+			// Strip bot mention from commands (e.g., /ping@botname -> /ping)
+			if strings.HasPrefix(text, "/") {
+				if idx := strings.Index(text, "@"); idx != -1 {
+					spaceIdx := strings.Index(text, " ")
+					if spaceIdx == -1 || idx < spaceIdx {
+						text = text[:idx] + text[strings.Index(text+" ", " "):]
+					}
+				}
+				text = strings.TrimSpace(text)
+			}
+
+			logFor("telegram").Info("message received", "chat", msg.Chat.Type, "topic", threadID, "msg_id", msg.MessageID, "user", msg.From.Username, "text", text)
+			actor := telegramActor(msg.From.ID, msg.From.Username)
+
+			// Handle commands
+			if text == "/help" || text == "/start" {
+				helpText := `📚 *CCC Commands*
+
+*Session Management:*
+• /new \[host:\]<name> — Create new session
+• /new ~/path/name — Create with custom path
+• /new — Restart session in current topic
+• /new --container <name> — Run Claude in a container
+• /continue \[host:\]<name> — Create with history
+• /continue — Restart with -c flag
+• /kill <name> — Kill session (keeps topic)
+• /list \[filters\] — List sessions (🟢 running, ⚪ stopped)
+• /tag \[session\] <tag>... — Tag a session (/untag removes)
+• /describe \[session\] <text> — Describe a session (- clears)
+• /vocabulary \[session\] <words> — Words for voice transcription (- clears)
+• /voicereply \[on|off|default\] — Spoken replies (private chat: for your prompts)
+• /status — Show current session details
+• /screenshot \[text\] — Image of the session's screen
+• /progress \[on|off\] — Live progress message per turn
+• /idle \[hours|off|default\] — Stop this session when idle
+• /autorestart \[on|off|default\] — Restart Claude after crashes
+• /recording \[on|off|get \[since\]|trim <since>\] — Record the terminal
+• /broadcast <selector> <prompt> — Same prompt to many sessions
+• /pipelines \[enable|disable <name>\] — Session-to-session forwarding
+• /usage \[session\] \[period\] — Token usage and cost
+• /movehere <name> — Move session to this topic
+• /rename \[old\] <new> — Rename session, tmux and topic
+• /archive \[name\] — Close topic, compress history
+• /purge \[name\] — Delete topic, history and config entry
+
+*Remote Hosts:*
+• /host add <name> <addr> \[dir\] — Add host
+• /host del <name> — Remove host
+• /host list — List hosts
+• /host check <name> — Check connectivity
+• /rc <host> <cmd> — Run command on host
+
+*Settings:*
+• /setdir \[host:\]<path> — Set projects directory
+• /away — Toggle notifications
+• /confirm \[on|off\] — Ask before /kill, /movehere, /host del, /update, /restart
+• /budget \[<usd>|off|resume\] — Daily spend limit
+• /audit \[n\] — Last audited actions
+• /c <cmd> — Run local command
+• /ping — Check bot status
+• /update — Pull, build and restart CCC
+• /restart — Restart CCC process`
+				sendMessage(config, chatID, threadID, helpText)
+				continue
+			}
+
+			if text == "/ping" {
+				if depth := outbox.Depth(); depth > 0 {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("pong! (📤 %d queued)", depth))
+				} else {
+					sendMessage(config, chatID, threadID, "pong!")
+				}
+				continue
+			}
+
+			if text == "/restart" {
+				requestDestructive(config, chatID, threadID, msg.From.ID, msg.From.Username,
+					"⚠️ Restart the ccc listener?", "restart", "", "🔄 Restart")
+				continue
+			}
+
+			if text == "/update" {
+				requestDestructive(config, chatID, threadID, msg.From.ID, msg.From.Username,
+					"⚠️ Pull, build and restart ccc?", "update", "", "⬆️ Update")
+				continue
+			}
+
+			// /confirm [on|off] - confirmations of destructive commands
+			if text == "/confirm" || strings.HasPrefix(text, "/confirm ") {
+				switch strings.TrimSpace(strings.TrimPrefix(text, "/confirm")) {
+				case "":
+					if skipsConfirmation(config, msg.From.ID) {
+						sendMessage(config, chatID, threadID, "⚡ Confirmations are OFF: /kill, /movehere, /host del, /update and /restart run right away")
+					} else {
+						sendMessage(config, chatID, threadID, "✅ Confirmations are ON: /kill, /movehere, /host del, /update and /restart ask first")
+					}
+				case "on", "off":
+					skip := strings.HasSuffix(text, "off")
+					fresh, err := updateConfig(func(cfg *Config) error {
+						setSkipConfirmation(cfg, msg.From.ID, skip)
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
+					if skip {
+						sendMessage(config, chatID, threadID, "⚡ Confirmations OFF (/purge and dangerous /c commands still ask)")
+					} else {
+						sendMessage(config, chatID, threadID, "✅ Confirmations ON")
+					}
+				default:
+					sendMessage(config, chatID, threadID, "Usage: /confirm [on|off]")
+				}
+				continue
+			}
+
+			if text == "/away" {
+				fresh, err := updateConfig(func(cfg *Config) error {
+					cfg.Away = !cfg.Away
+					return nil
+				})
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+					continue
+				}
+				config = fresh
+				if config.Away {
+					sendMessage(config, chatID, threadID, "🚶 Away mode ON")
+				} else {
+					sendMessage(config, chatID, threadID, "🏠 Away mode OFF")
+				}
+				continue
+			}
+
+			// Handle /host commands
+			if strings.HasPrefix(text, "/host") {
+				handleHostCommand(config, chatID, threadID, text, msg.From.ID, msg.From.Username)
+				config, _ = loadConfig() // Reload after potential changes
+				continue
+			}
+
+			if text == "/list" || strings.HasPrefix(text, "/list ") {
+				opts, err := parseListArgs(strings.Fields(strings.TrimPrefix(text, "/list")))
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error())
+					continue
+				}
+				sendMessage(config, chatID, threadID, formatSessionList(collectSessionList(config, opts), opts))
+				continue
+			}
 
-			// Handle callback queries (button presses from inline keyboards)
-			if update.CallbackQuery != nil {
-				cb := update.CallbackQuery
-				// Only accept from authorized user
-				if cb.From.ID != config.ChatID {
+			// /tag, /untag - label sessions for /list, /broadcast and pipelines
+			untagArgs, untag := strings.CutPrefix(text, "/untag")
+			untag = untag && (untagArgs == "" || untagArgs[0] == ' ')
+			if cmd, ok := strings.CutPrefix(text, "/tag"); ok && (cmd == "" || cmd[0] == ' ') || untag {
+				args := strings.Fields(text)[1:]
+				sessionName, tags, err := sessionCommandTarget(config, threadID, args, false)
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error()+"\nUsage: /tag [session] <tag>...")
 					continue
 				}
+				if len(tags) > 0 {
+					fresh, err := updateConfig(func(cfg *Config) error {
+						info := cfg.Sessions[sessionName]
+						if info == nil {
+							return fmt.Errorf("session '%s' not found", sessionName)
+						}
+						if untag {
+							info.Tags = removeTags(info.Tags, tags)
+						} else {
+							info.Tags = normalizeTags(append(info.Tags, tags...))
+						}
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
+				}
+				info := config.Sessions[sessionName]
+				if len(info.Tags) == 0 {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🏷 %s has no tags", sessionName))
+				} else {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🏷 %s: %s", sessionName, formatTags(info.Tags)))
+				}
+				continue
+			}
 
-				answerCallbackQuery(config, cb.ID)
+			// /describe - set (or "-" to clear) a session's description
+			if text == "/describe" || strings.HasPrefix(text, "/describe ") {
+				args := strings.Fields(text)[1:]
+				sessionName, rest, err := sessionCommandTarget(config, threadID, args, false)
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error()+"\nUsage: /describe [session] <text>")
+					continue
+				}
+				info := config.Sessions[sessionName]
+				desc := strings.Join(rest, " ")
+				switch desc {
+				case "":
+					if info.Description == "" {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("📝 %s has no description", sessionName))
+					} else {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("📝 %s: %s", sessionName, info.Description))
+					}
+					continue
+				case "-":
+					desc = ""
+				}
+				fresh, err := updateConfig(func(cfg *Config) error {
+					info := cfg.Sessions[sessionName]
+					if info == nil {
+						return fmt.Errorf("session '%s' not found", sessionName)
+					}
+					info.Description = desc
+					return nil
+				})
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+					continue
+				}
+				config = fresh
+				sendMessage(config, chatID, threadID, fmt.Sprintf("📝 Description of %s updated", sessionName))
+				continue
+			}
 
-				// Parse callback data: session:questionIndex:totalQuestions:optionIndex
-				// Legacy format (3 parts): session:questionIndex:optionIndex
-				parts := strings.Split(cb.Data, ":")
-				if len(parts) >= 3 {
-					sessionName := parts[0]
-					questionIndex, _ := strconv.Atoi(parts[1])
-					var totalQuestions, optionIndex int
-					if len(parts) == 4 {
-						totalQuestions, _ = strconv.Atoi(parts[2])
-						optionIndex, _ = strconv.Atoi(parts[3])
+			// /vocabulary [session] <words> - transcription prompt for voice messages
+			if text == "/vocabulary" || strings.HasPrefix(text, "/vocabulary ") {
+				args := strings.Fields(text)[1:]
+				sessionName, rest, err := sessionCommandTarget(config, threadID, args, false)
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error()+"\nUsage: /vocabulary [session] <words>")
+					continue
+				}
+				info := config.Sessions[sessionName]
+				vocab := strings.Join(rest, " ")
+				switch vocab {
+				case "":
+					if info.Vocabulary == "" {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("🎤 %s has no vocabulary", sessionName))
 					} else {
-						optionIndex, _ = strconv.Atoi(parts[2])
+						sendMessage(config, chatID, threadID, fmt.Sprintf("🎤 %s: %s", sessionName, info.Vocabulary))
+					}
+					continue
+				case "-":
+					vocab = ""
+				}
+				fresh, err := updateConfig(func(cfg *Config) error {
+					info := cfg.Sessions[sessionName]
+					if info == nil {
+						return fmt.Errorf("session '%s' not found", sessionName)
 					}
+					info.Vocabulary = vocab
+					return nil
+				})
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+					continue
+				}
+				config = fresh
+				sendMessage(config, chatID, threadID, fmt.Sprintf("🎤 Vocabulary of %s updated", sessionName))
+				continue
+			}
 
-					// Edit message to show selection and remove buttons
-					if cb.Message != nil {
-						originalText := cb.Message.Text
-						newText := fmt.Sprintf("%s\n\n✓ Selected option %d", originalText, optionIndex+1)
-						editMessageRemoveKeyboard(config, cb.Message.Chat.ID, cb.Message.MessageID, newText)
+			// /voicereply [on|off|default] - spoken replies for this topic's session,
+			// or for your own prompts when used in the private chat
+			if text == "/voicereply" || strings.HasPrefix(text, "/voicereply ") {
+				arg := strings.TrimSpace(strings.TrimPrefix(text, "/voicereply"))
+				if !isGroup {
+					username := msg.From.Username
+					if username == "" {
+						sendMessage(config, chatID, threadID, "❌ Per-user voice replies need a Telegram username")
+						continue
+					}
+					switch arg {
+					case "":
+					case "on", "off":
+						fresh, err := updateConfig(func(cfg *Config) error {
+							setVoiceReplyUser(cfg, username, arg == "on")
+							return nil
+						})
+						if err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+							continue
+						}
+						config = fresh
+					default:
+						sendMessage(config, chatID, threadID, "Usage: /voicereply [on|off]")
+						continue
 					}
+					state := "OFF"
+					if voiceRepliesWanted(config, nil, username) {
+						state = "ON"
+					}
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔊 Voice replies %s for your prompts (sessions can override with /voicereply in their topic)", state))
+					continue
+				}
 
-					// Store answer in history
-					if cb.Message != nil {
-						appendHistoryDedup(cb.Message.MessageThreadID, "human", fmt.Sprintf("Selected option %d", optionIndex+1))
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
+				switch arg {
+				case "", "on", "off", "default":
+				default:
+					sendMessage(config, chatID, threadID, "Usage: /voicereply [on|off|default]")
+					continue
+				}
+				if arg != "" {
+					fresh, err := updateConfig(func(cfg *Config) error {
+						info := cfg.Sessions[sessionName]
+						if info == nil {
+							return fmt.Errorf("session '%s' not found", sessionName)
+						}
+						info.VoiceReplies = nil
+						if arg != "default" {
+							v := arg == "on"
+							info.VoiceReplies = &v
+						}
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
 					}
+					config = fresh
+				}
+				sessionInfo := config.Sessions[sessionName]
+				switch {
+				case sessionInfo.VoiceReplies != nil && *sessionInfo.VoiceReplies:
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔊 Voice replies ON for %s (session override)", sessionName))
+				case sessionInfo.VoiceReplies != nil:
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔊 Voice replies OFF for %s (session override)", sessionName))
+				case voiceRepliesWanted(config, sessionInfo, ""):
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔊 Voice replies ON for %s (global setting)", sessionName))
+				default:
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔊 Voice replies OFF for %s (global setting; users can turn them on for their own prompts with /voicereply in the private chat)", sessionName))
+				}
+				continue
+			}
 
-					// Resolve tmux session name and check local/remote
-					info, exists := config.Sessions[sessionName]
-					tmuxName := tmuxSessionName(sessionName)
+			// /status - show detailed session info for current topic
+			if text == "/status" && isGroup {
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
 
-					sendTmuxKeys := func(keys ...string) {
-						if exists && info.Host != "" {
-							// Remote session — send via SSH
-							address := getHostAddress(config, info.Host)
-							for _, key := range keys {
-								cmd := fmt.Sprintf("tmux send-keys -t %s %s", shellQuote(tmuxName), key)
-								runSSH(address, cmd, 5*time.Second)
-							}
-						} else {
-							// Local session
-							for _, key := range keys {
-								tmuxCmd("send-keys", "-t", tmuxName, key).Run()
-							}
-						}
+				sessionInfo := config.Sessions[sessionName]
+				if sessionInfo == nil {
+					sendMessage(config, chatID, threadID, "❌ Session info not found")
+					continue
+				}
+
+				_, projectName := parseSessionTarget(sessionName)
+				tmuxName := tmuxSessionName(extractProjectName(projectName))
+
+				var msg strings.Builder
+				msg.WriteString(fmt.Sprintf("📊 *Session: %s*\n\n", sessionName))
+
+				// Get tmux session info
+				var tmuxInfo *TmuxSessionInfo
+				var err error
+
+				if sessionInfo.Host != "" {
+					address := getHostAddress(config, sessionInfo.Host)
+					if address != "" {
+						tmuxInfo, err = sshGetTmuxSessionInfo(address, tmuxName)
+						msg.WriteString(fmt.Sprintf("🖥️ Host: %s\n", sessionInfo.Host))
 					}
+				} else {
+					tmuxInfo, err = backend().Info(tmuxName)
+					msg.WriteString("🖥️ Host: local\n")
+				}
 
-					// Check session exists
-					sessionExists := false
-					if exists && info.Host != "" {
-						address := getHostAddress(config, info.Host)
-						sessionExists = sshTmuxHasSession(address, tmuxName)
+				msg.WriteString(fmt.Sprintf("📁 Path: %s\n", sessionInfo.Path))
+				if sessionInfo.Description != "" {
+					msg.WriteString(fmt.Sprintf("📝 %s\n", sessionInfo.Description))
+				}
+				if len(sessionInfo.Tags) > 0 {
+					msg.WriteString(fmt.Sprintf("🏷 Tags: %s\n", formatTags(sessionInfo.Tags)))
+				}
+				if sessionInfo.Container {
+					image := ""
+					if config.Container != nil {
+						image = config.Container.Image
+					}
+					if sessionInfo.ContainerID != "" {
+						msg.WriteString(fmt.Sprintf("📦 Container: %s (%s)\n", container.ShortID(sessionInfo.ContainerID), image))
 					} else {
-						sessionExists = tmuxSessionExists(tmuxName)
+						msg.WriteString(fmt.Sprintf("📦 Container: %s\n", image))
 					}
+				}
 
-					if sessionExists {
-						// Send arrow down keys to select option, then Enter
-						for i := 0; i < optionIndex; i++ {
-							sendTmuxKeys("Down")
-							time.Sleep(50 * time.Millisecond)
-						}
-						sendTmuxKeys("Enter")
-						fmt.Printf("[callback] Selected option %d for %s (question %d/%d)\n", optionIndex, sessionName, questionIndex+1, totalQuestions)
+				if err != nil || tmuxInfo == nil {
+					if sessionInfo.Sleeping {
+						msg.WriteString("\n💤 Status: sleeping (next message wakes it)\n")
+					} else {
+						msg.WriteString("\n⚪ Status: stopped\n")
+					}
+				} else {
+					msg.WriteString("\n🟢 Status: running\n")
+					msg.WriteString(fmt.Sprintf("📂 CWD: %s\n", tmuxInfo.Path))
 
-						// Mark answered in pending questions (for API sync)
-						if val, ok := pendingQuestions.Load(sessionName); ok {
-							pqs := val.(*PendingQuestionSet)
-							if questionIndex < len(pqs.Questions) {
-								pqs.Questions[questionIndex].Answered = true
-								pqs.Questions[questionIndex].AnswerIndex = optionIndex
-							}
-						}
+					now := time.Now()
+					uptime := now.Sub(tmuxInfo.Created)
+					idle := now.Sub(tmuxInfo.Activity)
 
-						// After the last question, send Enter to confirm "Submit answers"
-						if totalQuestions > 0 && questionIndex == totalQuestions-1 {
-							time.Sleep(300 * time.Millisecond)
-							sendTmuxKeys("Enter")
-							pendingQuestions.Delete(sessionName)
-							fmt.Printf("[callback] Auto-submitted answers for %s\n", sessionName)
-						}
-					}
+					msg.WriteString(fmt.Sprintf("⏱️ Uptime: %s\n", formatDuration(uptime)))
+					msg.WriteString(fmt.Sprintf("💤 Idle: %s\n", formatDuration(idle)))
+					msg.WriteString(fmt.Sprintf("🕐 Started: %s\n", tmuxInfo.Created.Format("2006-01-02 15:04")))
 				}
+
+				sendMessage(config, chatID, threadID, msg.String())
 				continue
 			}
 
-			msg := update.Message
+			// /progress [on|off|default] - per-session live progress override
+			if (text == "/progress" || strings.HasPrefix(text, "/progress ")) && isGroup {
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
 
-			// Only accept from authorized user
-			if msg.From.ID != config.ChatID {
-				continue
-			}
+				switch arg := strings.TrimSpace(strings.TrimPrefix(text, "/progress")); arg {
+				case "":
+				case "on", "off", "default":
+					fresh, err := updateConfig(func(cfg *Config) error {
+						info := cfg.Sessions[sessionName]
+						if info == nil {
+							return fmt.Errorf("session '%s' not found", sessionName)
+						}
+						info.LiveProgress = nil
+						if arg != "default" {
+							enabled := arg == "on"
+							info.LiveProgress = &enabled
+						}
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
+				default:
+					sendMessage(config, chatID, threadID, "Usage: /progress [on|off|default]")
+					continue
+				}
+				sessionInfo := config.Sessions[sessionName]
 
-			// Deduplicate: Telegram forum groups can send two updates with
-			// different update_id but the same message_id for a single message.
-			if isMessageProcessed(msg.MessageID) {
+				state := "OFF"
+				if liveProgressEnabled(config, sessionInfo) {
+					state = "ON"
+				}
+				source := "global setting"
+				if sessionInfo.LiveProgress != nil {
+					source = "session override"
+				}
+				sendMessage(config, chatID, threadID, fmt.Sprintf("⏳ Live progress %s for %s (%s)", state, sessionName, source))
 				continue
 			}
 
-			chatID := msg.Chat.ID
-			threadID := msg.MessageThreadID
-			isGroup := msg.Chat.Type == "supergroup"
-
-			// Handle voice messages
-			if msg.Voice != nil && isGroup && threadID > 0 {
-				config, _ = loadConfig()
+			// /idle [hours|off|default] - per-session idle shutdown override
+			if (text == "/idle" || strings.HasPrefix(text, "/idle ")) && isGroup {
 				sessionName := getSessionByTopic(config, threadID)
-				if sessionName != "" {
-					// Get session info to check if remote
-					sessionInfo := config.Sessions[sessionName]
-					hostName := ""
-					if sessionInfo != nil {
-						hostName = sessionInfo.Host
-					}
-
-					// Extract project name for tmux session
-					_, projectName := parseSessionTarget(sessionName)
-					tmuxName := tmuxSessionName(extractProjectName(projectName))
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
+				sessionInfo := config.Sessions[sessionName]
 
-					// Check if session is running
-					sessionRunning := false
-					var address string
-					if hostName != "" {
-						address = getHostAddress(config, hostName)
-						if address != "" {
-							sessionRunning = sshTmuxHasSession(address, tmuxName)
+				arg := strings.TrimSpace(strings.TrimPrefix(text, "/idle"))
+				if arg != "" {
+					var override *int
+					switch arg {
+					case "off":
+						never := 0
+						override = &never
+					case "default":
+					default:
+						hours, err := strconv.Atoi(strings.TrimSuffix(arg, "h"))
+						if err != nil || hours <= 0 {
+							sendMessage(config, chatID, threadID, "Usage: /idle [<hours>|off|default]")
+							continue
 						}
-					} else {
-						sessionRunning = tmuxSessionExists(tmuxName)
+						override = &hours
 					}
+					// Update a fresh copy so changes made meanwhile by the idle checker aren't lost
+					fresh, err := updateConfig(func(cfg *Config) error {
+						if info := cfg.Sessions[sessionName]; info != nil {
+							info.IdleTimeoutHours = override
+						}
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
+					if sessionInfo = config.Sessions[sessionName]; sessionInfo == nil {
+						continue
+					}
+				}
+
+				source := "global setting"
+				if sessionInfo.IdleTimeoutHours != nil {
+					source = "session override"
+				}
+				if timeout := idleTimeout(config, sessionInfo); timeout > 0 {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("💤 %s sleeps after %s idle (%s)", sessionName, formatDuration(timeout), source))
+				} else {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("💤 Idle shutdown OFF for %s (%s)", sessionName, source))
+				}
+				continue
+			}
+
+			// /autorestart [on|off|default] - per-session crash restart policy
+			if (text == "/autorestart" || strings.HasPrefix(text, "/autorestart ")) && isGroup {
+				sessionName := getSessionByTopic(config, threadID)
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
+				sessionInfo := config.Sessions[sessionName]
 
-					if sessionRunning {
-						// Check if Claude is actually running (not crashed to bash)
-						sshAddr := ""
-						if hostName != "" {
-							sshAddr = address
+				arg := strings.TrimSpace(strings.TrimPrefix(text, "/autorestart"))
+				if arg != "" {
+					if arg != "on" && arg != "off" && arg != "default" {
+						sendMessage(config, chatID, threadID, "Usage: /autorestart [on|off|default]")
+						continue
+					}
+					// Update a fresh copy so changes made meanwhile by the supervisor aren't lost
+					fresh, err := updateConfig(func(cfg *Config) error {
+						info := cfg.Sessions[sessionName]
+						if info == nil {
+							return nil
 						}
-						if !isClaudeRunning(tmuxName, sshAddr) {
-							// Auto-restart Claude
-							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
-							if !restartClaudeInSession(tmuxName, sshAddr) {
-								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
-								continue
-							}
-							sendMessage(config, chatID, threadID, "✅ Session restarted")
+						if arg == "default" {
+							info.AutoRestart = nil
+							return nil
 						}
-
-						sendMessage(config, chatID, threadID, "🎤 Transcribing...")
-						// Download and transcribe
-						audioPath := filepath.Join(os.TempDir(), fmt.Sprintf("voice_%d.ogg", time.Now().UnixNano()))
-						if err := downloadTelegramFile(config, msg.Voice.FileID, audioPath); err != nil {
-							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Download failed: %v", err))
-						} else {
-							transcription, err := transcribeAudio(config, audioPath)
-							os.Remove(audioPath)
-							if err != nil {
-								sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Transcription failed: %v", err))
-							} else if transcription != "" {
-								fmt.Printf("[voice] @%s: %s\n", msg.From.Username, transcription)
-								sendMessage(config, chatID, threadID, fmt.Sprintf("📝 %s", transcription))
-								// Store in history
-								appendHistory(threadID, HistoryMessage{
-									ID:            nextMessageID(),
-									Timestamp:     time.Now().Unix(),
-									From:          "human",
-									Type:          "voice",
-									Transcription: transcription,
-									Username:      msg.From.Username,
-								})
-								// Start typing indicator and send to appropriate tmux
-								startContinuousTyping(config, chatID, threadID, sessionName)
-								if hostName != "" {
-									sshTmuxSendKeys(address, tmuxName, transcription)
-								} else {
-									sendToTmux(tmuxName, transcription)
-								}
-							}
+						// Start from the global limits so only the switch differs
+						rp := RestartPolicy{}
+						if cfg.AutoRestart != nil {
+							rp = *cfg.AutoRestart
 						}
+						rp.Disabled = arg == "off"
+						info.AutoRestart = &rp
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
+					if sessionInfo = config.Sessions[sessionName]; sessionInfo == nil {
+						continue
 					}
 				}
+
+				source := "global setting"
+				if sessionInfo.AutoRestart != nil {
+					source = "session override"
+				}
+				if policy, enabled := restartPolicy(config, sessionInfo); enabled {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔁 Auto-restart ON for %s (%s): up to %d restarts in %s, first after %s", sessionName, source, policy.MaxRestarts, formatDuration(policy.Window), formatDuration(policy.Backoff)))
+				} else {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("🔁 Auto-restart OFF for %s (%s)", sessionName, source))
+				}
 				continue
 			}
 
-			// Handle photo messages
-			if len(msg.Photo) > 0 && isGroup && threadID > 0 {
-				config, _ = loadConfig()
+			// /recording [on|off|get [since]|trim <since>] - terminal recording of this session
+			if (text == "/recording" || strings.HasPrefix(text, "/recording ")) && isGroup {
 				sessionName := getSessionByTopic(config, threadID)
-				if sessionName != "" {
-					// Get session info to check if remote
-					sessionInfo := config.Sessions[sessionName]
-					hostName := ""
-					if sessionInfo != nil {
-						hostName = sessionInfo.Host
-					}
+				if sessionName == "" {
+					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+					continue
+				}
+				sessionInfo := config.Sessions[sessionName]
+				_, projectName := parseSessionTarget(sessionName)
+				tmuxName := tmuxSessionName(extractProjectName(projectName))
+				address := getHostAddress(config, sessionInfo.Host)
+				if sessionInfo.Host != "" && address == "" {
+					sendMessage(config, chatID, threadID, "❌ Host not found: "+sessionInfo.Host)
+					continue
+				}
 
-					// Extract project name for tmux session
-					_, projectName := parseSessionTarget(sessionName)
-					tmuxName := tmuxSessionName(extractProjectName(projectName))
+				args := strings.Fields(strings.TrimPrefix(text, "/recording"))
+				if len(args) == 0 {
+					state := "OFF"
+					if sessionInfo.Record {
+						state = "ON"
+					}
+					msg := fmt.Sprintf("🎥 Recording %s for %s", state, sessionName)
+					if data, err := readRecording(tmuxName, address); err == nil {
+						msg += "\n" + describeRecording(data)
+					}
+					sendMessage(config, chatID, threadID, msg+"\n\nUsage: /recording [on|off|get [since]|trim <since>]")
+					continue
+				}
 
-					// Get largest photo (last in array)
-					photo := msg.Photo[len(msg.Photo)-1]
-					imgPath := filepath.Join(os.TempDir(), fmt.Sprintf("telegram_%d.jpg", time.Now().UnixNano()))
-					if err := downloadTelegramFile(config, photo.FileID, imgPath); err != nil {
-						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Download failed: %v", err))
+				if args[0] == "on" || args[0] == "off" {
+					fresh, err := updateConfig(func(cfg *Config) error {
+						info := cfg.Sessions[sessionName]
+						if info == nil {
+							return fmt.Errorf("session '%s' not found", sessionName)
+						}
+						info.Record = args[0] == "on"
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
 						continue
 					}
-
-					caption := msg.Caption
-					if caption == "" {
-						caption = "Analyze this image:"
+					config = fresh
+				}
+				switch args[0] {
+				case "on":
+					// A stopped session starts recording the next time it runs
+					if err := startRecording(tmuxName, address); err != nil && (address != "" && sshTmuxHasSession(address, tmuxName) || address == "" && backend().Exists(tmuxName)) {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to start recording: %v", err))
+						continue
 					}
-
-					// Handle remote sessions
-					if hostName != "" {
-						hostInfo := config.Hosts[hostName]
-						if hostInfo == nil {
-							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host %s not found in config", hostName))
+					sendMessage(config, chatID, threadID, "🎥 Recording ON for "+sessionName)
+				case "off":
+					stopRecording(tmuxName, address)
+					sendMessage(config, chatID, threadID, "🎥 Recording OFF for "+sessionName+" (the file is kept)")
+				case "get", "trim":
+					var since time.Time
+					if len(args) > 1 {
+						var err error
+						if since, err = recording.ParseSince(strings.Join(args[1:], " "), time.Now()); err != nil {
+							sendMessage(config, chatID, threadID, "❌ "+err.Error())
 							continue
 						}
+					} else if args[0] == "trim" {
+						sendMessage(config, chatID, threadID, "Usage: /recording trim <since> (e.g. 2h, 3d, 15:04)")
+						continue
+					}
 
-						// Check if Claude is actually running
-						if !isClaudeRunning(tmuxName, hostInfo.Address) {
-							// Auto-restart Claude
-							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
-							if !restartClaudeInSession(tmuxName, hostInfo.Address) {
-								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
-								continue
-							}
-							sendMessage(config, chatID, threadID, "✅ Session restarted")
-						}
-
-						// SCP file to remote host
-						sendMessage(config, chatID, threadID, "📷 Transferring image to remote host...")
-						remotePath := imgPath // Use same path on remote
-						if err := scpToHost(hostInfo.Address, imgPath, remotePath, 30*time.Second); err != nil {
-							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ SCP failed: %v", err))
+					if args[0] == "trim" {
+						if err := trimRecording(tmuxName, address, since); err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to trim: %v", err))
 							continue
 						}
-
-						// Send to remote tmux
-						prompt := fmt.Sprintf("%s %s", caption, remotePath)
-						// Store in history
-						appendHistory(threadID, HistoryMessage{
-							ID:        nextMessageID(),
-							Timestamp: time.Now().Unix(),
-							From:      "human",
-							Type:      "photo",
-							Path:      remotePath,
-							Caption:   caption,
-							Username:  msg.From.Username,
-						})
-						startContinuousTyping(config, chatID, threadID, sessionName)
-						sshTmuxSendKeys(hostInfo.Address, tmuxName, prompt)
-						// Clean up local file
-						os.Remove(imgPath)
+						msg := "✂️ Recording trimmed to output since " + since.Format("Jan 2 15:04")
+						if data, err := readRecording(tmuxName, address); err == nil {
+							msg += "\n" + describeRecording(data)
+						}
+						sendMessage(config, chatID, threadID, msg)
 						continue
 					}
 
-					// Local session
-					if tmuxSessionExists(tmuxName) {
-						// Check if Claude is actually running
-						if !isClaudeRunning(tmuxName, "") {
-							// Auto-restart Claude
-							sendMessage(config, chatID, threadID, "🔄 Session interrupted, restarting...")
-							if !restartClaudeInSession(tmuxName, "") {
-								sendMessage(config, chatID, threadID, "❌ Failed to restart Claude. Use /continue to restart manually.")
-								continue
-							}
-							sendMessage(config, chatID, threadID, "✅ Session restarted")
+					data, err := readRecording(tmuxName, address)
+					if err != nil {
+						sendMessage(config, chatID, threadID, "❌ "+err.Error())
+						continue
+					}
+					if !since.IsZero() {
+						h, events, err := recording.Read(bytes.NewReader(data))
+						if err != nil {
+							sendMessage(config, chatID, threadID, "❌ "+err.Error())
+							continue
 						}
-						prompt := fmt.Sprintf("%s %s", caption, imgPath)
-						// Store in history
-						appendHistory(threadID, HistoryMessage{
-							ID:        nextMessageID(),
-							Timestamp: time.Now().Unix(),
-							From:      "human",
-							Type:      "photo",
-							Path:      imgPath,
-							Caption:   caption,
-							Username:  msg.From.Username,
-						})
-						sendMessage(config, chatID, threadID, "📷 Image saved, sending to Claude...")
-						startContinuousTyping(config, chatID, threadID, sessionName)
-						// Send text first, wait for image to load, then send Enter
-						sendToTmuxWithDelay(tmuxName, prompt, 2*time.Second)
+						h, events = recording.Since(h, events, since)
+						var b bytes.Buffer
+						recording.Write(&b, h, events)
+						data = b.Bytes()
 					}
-				}
-				continue
-			}
-
-			text := strings.TrimSpace(msg.Text)
-			if text == "" {
-				continue
-			}
-
-			// Strip bot mention from commands (e.g., /ping@botname -> /ping)
-			if strings.HasPrefix(text, "/") {
-				if idx := strings.Index(text, "@"); idx != -1 {
-					spaceIdx := strings.Index(text, " ")
-					if spaceIdx == -1 || idx < spaceIdx {
-						text = text[:idx] + text[strings.Index(text+" ", " "):]
+					if len(data) > maxRecordingUpload {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Recording is %.0f MB, over Telegram's 50 MB limit. Use /recording get <since> or /recording trim <since>.", float64(len(data))/(1<<20)))
+						continue
+					}
+					caption := fmt.Sprintf("🎥 %s — play with: asciinema play %s.cast", sessionName, tmuxName)
+					if err := sendDocument(config, chatID, threadID, tmuxName+".cast", data, caption); err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to send recording: %v", err))
 					}
+				default:
+					sendMessage(config, chatID, threadID, "Usage: /recording [on|off|get [since]|trim <since>]")
 				}
-				text = strings.TrimSpace(text)
-			}
-
-			fmt.Printf("[%s] @%s: %s\n", msg.Chat.Type, msg.From.Username, text)
-
-			// Handle commands
-			if text == "/help" || text == "/start" {
-				helpText := `📚 *CCC Commands*
-
-*Session Management:*
-• /new \[host:\]<name> — Create new session
-• /new ~/path/name — Create with custom path
-• /new — Restart session in current topic
-• /continue \[host:\]<name> — Create with history
-• /continue — Restart with -c flag
-• /kill <name> — Kill session (keeps topic)
-• /list — List sessions (🟢 running, ⚪ stopped)
-• /status — Show current session details
-• /movehere <name> — Move session to this topic
-
-*Remote Hosts:*
-• /host add <name> <addr> \[dir\] — Add host
-• /host del <name> — Remove host
-• /host list — List hosts
-• /host check <name> — Check connectivity
-• /rc <host> <cmd> — Run command on host
-
-*Settings:*
-• /setdir \[host:\]<path> — Set projects directory
-• /away — Toggle notifications
-• /c <cmd> — Run local command
-• /ping — Check bot status
-• /update — Pull, build and restart CCC
-• /restart — Restart CCC process`
-				sendMessage(config, chatID, threadID, helpText)
-				continue
-			}
-
-			if text == "/ping" {
-				sendMessage(config, chatID, threadID, "pong!")
 				continue
 			}
 
-			if text == "/restart" {
-				sendMessage(config, chatID, threadID, "🔄 Restarting...")
-				exe, err := os.Executable()
-				if err != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed: %v", err))
+			// /pipelines [enable|disable <name>] - session-to-session forwarding rules
+			if text == "/pipelines" || strings.HasPrefix(text, "/pipelines ") {
+				config, _ = loadConfig()
+				args := strings.Fields(strings.TrimPrefix(text, "/pipelines"))
+				if len(args) == 0 {
+					sendMessage(config, chatID, threadID, formatPipelines(config.Pipelines))
 					continue
 				}
-				cmd := exec.Command(exe, "listen")
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Start(); err != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to start: %v", err))
+				if len(args) != 2 || (args[0] != "enable" && args[0] != "disable") {
+					sendMessage(config, chatID, threadID, "Usage: /pipelines [enable|disable <name>]")
 					continue
 				}
-				os.Exit(0)
-			}
-
-			if text == "/update" {
-				if !atomic.CompareAndSwapInt32(&updateInProgress, 0, 1) {
-					sendMessage(config, chatID, threadID, "⏳ Update already in progress...")
+				fresh, err := updateConfig(func(cfg *Config) error {
+					found := false
+					for i := range cfg.Pipelines {
+						if cfg.Pipelines[i].Name == args[1] {
+							cfg.Pipelines[i].Disabled = args[0] == "disable"
+							found = true
+						}
+					}
+					if !found {
+						return fmt.Errorf("pipeline '%s' not found", args[1])
+					}
+					return nil
+				})
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error())
 					continue
 				}
-				go handleUpdateCmd(config, chatID, threadID)
+				config = fresh
+				sendMessage(config, chatID, threadID, fmt.Sprintf("🔗 Pipeline '%s' %sd", args[1], args[0]))
 				continue
 			}
 
-			if text == "/away" {
-				config.Away = !config.Away
-				saveConfig(config)
-				if config.Away {
-					sendMessage(config, chatID, threadID, "🚶 Away mode ON")
-				} else {
-					sendMessage(config, chatID, threadID, "🏠 Away mode OFF")
+			// /broadcast <selector> <prompt> - same prompt to several sessions
+			if text == "/broadcast" || strings.HasPrefix(text, "/broadcast ") {
+				parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(text, "/broadcast")), " ", 2)
+				if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+					sendMessage(config, chatID, threadID, "Usage: /broadcast <selector> <prompt>\n\nSelector: a,b,c · api-* · host:<name> · tag:<name> · all")
+					continue
 				}
+				sessions, err := selectSessions(config, parts[0])
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %v", err))
+					continue
+				}
+				sendMessage(config, chatID, threadID, fmt.Sprintf("📣 Broadcasting to %d sessions: %s\nA summary will be posted to the private chat.", len(sessions), strings.Join(sessions, ", ")))
+				recordAudit(config, actor, "/broadcast", parts[0], strings.TrimSpace(parts[1]), fmt.Sprintf("started (%d sessions)", len(sessions)))
+				go runBroadcast(config, sessions, strings.TrimSpace(parts[1]), "broadcast", 0, "")
 				continue
 			}
 
-			// Handle /host commands
-			if strings.HasPrefix(text, "/host") {
-				handleHostCommand(config, chatID, threadID, text)
-				config, _ = loadConfig() // Reload after potential changes
-				continue
-			}
-
-			if text == "/list" {
-				var lines []string
-
-				// List configured sessions with status (skip deleted)
-				for name, info := range config.Sessions {
-					if info == nil || info.Deleted {
+			// /audit [n] - last entries of the audit log
+			if text == "/audit" || strings.HasPrefix(text, "/audit ") {
+				n := 20
+				if arg := strings.TrimSpace(strings.TrimPrefix(text, "/audit")); arg != "" {
+					v, err := strconv.Atoi(arg)
+					if err != nil || v <= 0 {
+						sendMessage(config, chatID, threadID, "Usage: /audit [n]")
 						continue
 					}
-
-					// Check if tmux session is running
-					_, projectName := parseSessionTarget(name)
-					tmuxName := tmuxSessionName(extractProjectName(projectName))
-
-					var status string
-					if info.Host != "" {
-						// Remote session
-						address := getHostAddress(config, info.Host)
-						if address != "" && sshTmuxHasSession(address, tmuxName) {
-							status = "🟢"
-						} else {
-							status = "⚪"
-						}
-					} else {
-						// Local session
-						if tmuxSessionExists(tmuxName) {
-							status = "🟢"
-						} else {
-							status = "⚪"
-						}
+					n = v
+					if n > 100 {
+						n = 100
 					}
-
-					lines = append(lines, fmt.Sprintf("%s %s", status, name))
 				}
-
-				if len(lines) == 0 {
-					sendMessage(config, chatID, threadID, "No sessions configured")
-				} else {
-					sendMessage(config, chatID, threadID, "Sessions:\n"+strings.Join(lines, "\n"))
+				entries, err := audit.Read(auditPath(), n)
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %v", err))
+					continue
 				}
+				sendMessage(config, chatID, threadID, formatAudit(entries))
 				continue
 			}
 
-			// /status - show detailed session info for current topic
-			if text == "/status" && isGroup {
-				sessionName := getSessionByTopic(config, threadID)
-				if sessionName == "" {
-					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
+			// /usage [session] [period] - token usage and cost
+			if text == "/usage" || strings.HasPrefix(text, "/usage ") {
+				defaultSession := ""
+				if isGroup && threadID > 0 {
+					defaultSession = getSessionByTopic(config, threadID)
+				}
+				session, period := parseUsageArgs(strings.Fields(strings.TrimPrefix(text, "/usage")), defaultSession)
+				from, to, err := usage.PeriodRange(period, time.Now())
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %v", err))
 					continue
 				}
-
-				sessionInfo := config.Sessions[sessionName]
-				if sessionInfo == nil {
-					sendMessage(config, chatID, threadID, "❌ Session info not found")
+				if session != "" && config.Sessions[session] == nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Session '%s' not found", session))
 					continue
 				}
+				report := renderUsageReport(loadUsageStore().Report(session, from, to), period)
+				if config.DailyBudget > 0 {
+					spent, _ := budgetStatus(config)
+					report += fmt.Sprintf("\n\n🎯 Today: $%.2f of $%.2f budget", spent, config.DailyBudget)
+				}
+				sendMessage(config, chatID, threadID, report)
+				continue
+			}
 
-				_, projectName := parseSessionTarget(sessionName)
-				tmuxName := tmuxSessionName(extractProjectName(projectName))
-
-				var msg strings.Builder
-				msg.WriteString(fmt.Sprintf("📊 *Session: %s*\n\n", sessionName))
-
-				// Get tmux session info
-				var tmuxInfo *TmuxSessionInfo
-				var err error
-
-				if sessionInfo.Host != "" {
-					address := getHostAddress(config, sessionInfo.Host)
-					if address != "" {
-						tmuxInfo, err = sshGetTmuxSessionInfo(address, tmuxName)
-						msg.WriteString(fmt.Sprintf("🖥️ Host: %s\n", sessionInfo.Host))
+			// /budget [<usd>|off|resume] - daily spend limit
+			if text == "/budget" || strings.HasPrefix(text, "/budget ") {
+				switch arg := strings.TrimSpace(strings.TrimPrefix(text, "/budget")); arg {
+				case "":
+				case "off":
+					fresh, err := updateConfig(func(cfg *Config) error {
+						cfg.DailyBudget = 0
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
 					}
-				} else {
-					tmuxInfo, err = getTmuxSessionInfo(tmuxName)
-					msg.WriteString("🖥️ Host: local\n")
+					config = fresh
+				case "resume":
+					today := time.Now().Format("2006-01-02")
+					withUsageStore(func(s *usage.Store) { s.BudgetResumed = today })
+				default:
+					amount, err := strconv.ParseFloat(strings.TrimPrefix(arg, "$"), 64)
+					if err != nil || amount <= 0 {
+						sendMessage(config, chatID, threadID, "Usage: /budget [<usd>|off|resume]")
+						continue
+					}
+					fresh, err := updateConfig(func(cfg *Config) error {
+						cfg.DailyBudget = amount
+						return nil
+					})
+					if err != nil {
+						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+						continue
+					}
+					config = fresh
 				}
 
-				msg.WriteString(fmt.Sprintf("📁 Path: %s\n", sessionInfo.Path))
-
-				if err != nil || tmuxInfo == nil {
-					msg.WriteString("\n⚪ Status: stopped\n")
+				if config.DailyBudget <= 0 {
+					sendMessage(config, chatID, threadID, "🎯 No daily budget set")
 				} else {
-					msg.WriteString("\n🟢 Status: running\n")
-					msg.WriteString(fmt.Sprintf("📂 CWD: %s\n", tmuxInfo.Path))
-
-					now := time.Now()
-					uptime := now.Sub(tmuxInfo.Created)
-					idle := now.Sub(tmuxInfo.Activity)
-
-					msg.WriteString(fmt.Sprintf("⏱️ Uptime: %s\n", formatDuration(uptime)))
-					msg.WriteString(fmt.Sprintf("💤 Idle: %s\n", formatDuration(idle)))
-					msg.WriteString(fmt.Sprintf("🕐 Started: %s\n", tmuxInfo.Created.Format("2006-01-02 15:04")))
+					spent, paused := budgetStatus(config)
+					action := config.BudgetAction
+					if action == "" {
+						action = "warn"
+					}
+					status := fmt.Sprintf("🎯 Daily budget: $%.2f (%s)\n💰 Spent today: $%.2f", config.DailyBudget, action, spent)
+					if paused {
+						status += "\n⏸ Prompts are held"
+					}
+					sendMessage(config, chatID, threadID, status)
 				}
-
-				sendMessage(config, chatID, threadID, msg.String())
+				releaseHeldPrompts(config)
 				continue
 			}
 
-			// /screenshot - capture last 50 lines from tmux session
-			if text == "/screenshot" && isGroup {
+			// /screenshot [text] - image of the session's screen (or its last 50 lines as text)
+			if (text == "/screenshot" || text == "/screenshot text") && isGroup {
 				sessionName := getSessionByTopic(config, threadID)
 				if sessionName == "" {
 					sendMessage(config, chatID, threadID, "❌ No session mapped to this topic")
@@ -5506,6 +11406,21 @@ func listen() error {
 					}
 				}
 
+				if text == "/screenshot" {
+					data, masked, err := screenshotPNG(tmuxName, sshAddress, 0, redactorFor(config))
+					if err == nil {
+						caption := "📸 " + sessionName
+						if masked > 0 {
+							caption += "\n\n" + redact.Notice(masked)
+						}
+						err = sendPhoto(config, chatID, threadID, tmuxName+".png", data, caption)
+					}
+					if err == nil {
+						continue
+					}
+					logFor("screenshot").Warn("image screenshot failed, sending text", logging.SessionKey, sessionName, "error", err)
+				}
+
 				content, err := captureTmuxPane(tmuxName, sshAddress, 50)
 				if err != nil {
 					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to capture: %v", err))
@@ -5552,13 +11467,32 @@ func listen() error {
 							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found. Use /host add to configure it.", hostName))
 							continue
 						}
-						config.Hosts[hostName].ProjectsDir = dirPath
-						saveConfig(config)
+						fresh, err := updateConfig(func(cfg *Config) error {
+							if cfg.Hosts[hostName] == nil {
+								return fmt.Errorf("host '%s' not found", hostName)
+							}
+							cfg.Hosts[hostName].ProjectsDir = dirPath
+							return nil
+						})
+						recordAudit(config, actor, "/setdir", hostName, dirPath, auditResult(err))
+						if err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+							continue
+						}
+						config = fresh
 						sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Projects directory for %s set to: %s", hostName, dirPath))
 					} else {
 						// Set for local
-						config.ProjectsDir = arg
-						saveConfig(config)
+						fresh, err := updateConfig(func(cfg *Config) error {
+							cfg.ProjectsDir = arg
+							return nil
+						})
+						recordAudit(config, actor, "/setdir", "local", arg, auditResult(err))
+						if err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+							continue
+						}
+						config = fresh
 						resolvedPath := getProjectsDir(config)
 						sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Projects directory set to: %s", resolvedPath))
 					}
@@ -5567,94 +11501,93 @@ func listen() error {
 			}
 
 			if strings.HasPrefix(text, "/kill ") {
-				name := strings.TrimPrefix(text, "/kill ")
-				name = strings.TrimSpace(name)
-				if err := killSession(config, name); err != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %v", err))
-				} else {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("🗑️ Session '%s' killed", name))
-					config, _ = loadConfig()
+				name := strings.TrimSpace(strings.TrimPrefix(text, "/kill "))
+				if config.Sessions[name] == nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ session '%s' not found", name))
+					continue
 				}
+				requestDestructive(config, chatID, threadID, msg.From.ID, msg.From.Username,
+					killSummary(config, name), "kill", name, "🗑️ Kill")
+				config, _ = loadConfig()
 				continue
 			}
 
-			// /movehere <session> - move session to current topic (fix duplicates)
-			if strings.HasPrefix(text, "/movehere ") {
-				name := strings.TrimPrefix(text, "/movehere ")
-				name = strings.TrimSpace(name)
-
-				info, exists := config.Sessions[name]
-				if !exists {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Session '%s' not found", name))
-					continue
-				}
-
-				oldTopicID := info.TopicID
-				if oldTopicID == threadID {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("ℹ️ Session '%s' is already in this topic", name))
+			// /rename [old] <new> - rename a session (in a topic, its session)
+			if strings.HasPrefix(text, "/rename ") {
+				// Deleted sessions can be renamed to free their name
+				oldName, rest, err := sessionCommandTarget(config, threadID, strings.Fields(strings.TrimPrefix(text, "/rename ")), true)
+				if err != nil || len(rest) != 1 {
+					usage := "Usage: /rename <old> <new> (or /rename <new> in a session topic)"
+					if err != nil {
+						usage = "❌ " + err.Error() + "\n" + usage
+					}
+					sendMessage(config, chatID, threadID, usage)
 					continue
 				}
-
-				// Rename current topic to session name
-				if err := editForumTopic(config, threadID, name); err != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("⚠️ Could not rename topic: %v", err))
+				newName := rest[0]
+				err = renameSession(config, oldName, newName)
+				recordAudit(config, actor, "/rename", oldName, newName, auditResult(err))
+				if err != nil {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %v", err))
+				} else {
+					sendMessage(config, chatID, threadID, fmt.Sprintf("✏️ Session '%s' renamed to '%s'", oldName, newName))
 				}
+				config, _ = loadConfig()
+				continue
+			}
 
-				// Update session to point to current topic
-				info.TopicID = threadID
-				info.Deleted = false
-				if err := saveConfig(config); err != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+			// /archive [name] - stop a session, close its topic, compress its history
+			if text == "/archive" || strings.HasPrefix(text, "/archive ") {
+				sessionName, _, err := sessionCommandTarget(config, threadID, strings.Fields(text)[1:], false)
+				if err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error()+"\nUsage: /archive [session]")
 					continue
 				}
-
-				// Try to delete the old topic
-				deleteErr := deleteForumTopic(config, oldTopicID)
-				if deleteErr != nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Session '%s' moved here\n⚠️ Old topic %d not deleted: %v", name, oldTopicID, deleteErr))
+				// A closed topic takes no messages, so report to the private chat
+				replyChat, replyThread := chatID, threadID
+				if info := config.Sessions[sessionName]; info.TopicID == threadID {
+					replyChat, replyThread = config.ChatID, 0
+				}
+				files, err := archiveSession(config, sessionName)
+				recordAudit(config, actor, "/archive", sessionName, "", auditResult(err))
+				if err != nil {
+					sendMessage(config, replyChat, replyThread, fmt.Sprintf("❌ Archiving '%s': %v", sessionName, err))
 				} else {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("✅ Session '%s' moved here\n🗑️ Old topic deleted", name))
+					sendMessage(config, replyChat, replyThread, fmt.Sprintf("📦 Session '%s' archived (%d history files compressed). /continue %s brings it back.", sessionName, files, sessionName))
 				}
 				config, _ = loadConfig()
 				continue
 			}
 
-			if strings.HasPrefix(text, "/c ") {
-				cmdStr := strings.TrimPrefix(text, "/c ")
-				output, err := executeCommand(cmdStr)
+			// /purge [name] - delete a session's topic, history and config entry
+			if text == "/purge" || strings.HasPrefix(text, "/purge ") {
+				sessionName, _, err := sessionCommandTarget(config, threadID, strings.Fields(text)[1:], false)
 				if err != nil {
-					output = fmt.Sprintf("⚠️ %s\n\nExit: %v", output, err)
+					sendMessage(config, chatID, threadID, "❌ "+err.Error()+"\nUsage: /purge [session]")
+					continue
 				}
-				sendMessage(config, chatID, threadID, output)
+				askConfirmation(config, chatID, threadID,
+					fmt.Sprintf("⚠️ Purge session '%s'? This deletes its topic, its history and its config entry and can't be undone.", sessionName),
+					"purge", sessionName, "🔥 Purge")
 				continue
 			}
 
-			// /rc <host> <cmd> - remote command
-			if strings.HasPrefix(text, "/rc ") {
-				remainder := strings.TrimSpace(strings.TrimPrefix(text, "/rc "))
-				parts := strings.SplitN(remainder, " ", 2)
-				if len(parts) < 2 || parts[0] == "" {
-					sendMessage(config, chatID, threadID, "Usage: /rc <host> <command>")
-					continue
-				}
-				hostName := parts[0]
-				cmdStr := strings.TrimSpace(parts[1])
-
-				// Get host address
-				if config.Hosts == nil || config.Hosts[hostName] == nil {
-					sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Host '%s' not found. Use /host add to configure it.", hostName))
+			// /movehere <session> - move session to current topic (fix duplicates)
+			if strings.HasPrefix(text, "/movehere ") {
+				name := strings.TrimSpace(strings.TrimPrefix(text, "/movehere "))
+				if err := checkMoveHere(config, name, threadID); err != nil {
+					sendMessage(config, chatID, threadID, "❌ "+err.Error())
 					continue
 				}
-				address := config.Hosts[hostName].Address
+				requestDestructive(config, chatID, threadID, msg.From.ID, msg.From.Username,
+					fmt.Sprintf("⚠️ Move session '%s' to this topic? Its current topic will be deleted.", name), "movehere", name, "📥 Move here")
+				config, _ = loadConfig()
+				continue
+			}
 
-				output, err := sshRunCommand(address, cmdStr, 30*time.Second)
-				if err != nil {
-					output = fmt.Sprintf("⚠️ %s\n\nExit: %v", output, err)
-				}
-				if output == "" {
-					output = "(no output)"
-				}
-				sendMessage(config, chatID, threadID, fmt.Sprintf("📤 %s:\n%s", hostName, output))
+			// /c <cmd> - local command, /rc <host> <cmd> - remote command
+			if strings.HasPrefix(text, "/c ") || strings.HasPrefix(text, "/rc ") {
+				handleShellCommand(config, chatID, threadID, msg.From.ID, msg.From.Username, text)
 				continue
 			}
 
@@ -5674,6 +11607,17 @@ func listen() error {
 				if continueSession {
 					cmdName = "/continue"
 				}
+				arg, wantContainer := parseContainerFlag(arg)
+				if wantContainer != nil && *wantContainer && (config.Container == nil || config.Container.Image == "") {
+					sendMessage(config, chatID, threadID, "❌ No container image configured. Set container.image in ~/.ccc.json")
+					continue
+				}
+				if wantContainer != nil && *wantContainer {
+					if _, err := containerNetwork(config.Container.Network); err != nil {
+						sendMessage(config, chatID, threadID, "❌ "+err.Error())
+						continue
+					}
+				}
 
 				// /new <name> or /continue <name> - create brand new session + topic
 				// Supports host:name format for remote sessions
@@ -5689,6 +11633,11 @@ func listen() error {
 						}
 					}
 
+					if hostName != "" && wantContainer != nil && *wantContainer {
+						sendMessage(config, chatID, threadID, "❌ Container sessions are only supported on the local host")
+						continue
+					}
+
 					// Build full session name (host:name or just name)
 					fullName := fullSessionName(hostName, projectName)
 
@@ -5700,6 +11649,19 @@ func listen() error {
 						// Reuse existing topic
 						topicID = existingSession.TopicID
 						workDir = existingSession.Path
+						if wantContainer != nil {
+							fresh, err := updateConfig(func(cfg *Config) error {
+								if info := cfg.Sessions[fullName]; info != nil {
+									info.Container = *wantContainer
+								}
+								return nil
+							})
+							if err != nil {
+								sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+								continue
+							}
+							config = fresh
+						}
 					} else {
 						// Create new Telegram topic
 						var err error
@@ -5717,12 +11679,23 @@ func listen() error {
 						}
 
 						// Save mapping with full path
-						config.Sessions[fullName] = &SessionInfo{
-							TopicID: topicID,
-							Path:    workDir,
-							Host:    hostName,
+						fresh, err := updateConfig(func(cfg *Config) error {
+							if cfg.Sessions == nil {
+								cfg.Sessions = make(map[string]*SessionInfo)
+							}
+							cfg.Sessions[fullName] = &SessionInfo{
+								TopicID:   topicID,
+								Path:      workDir,
+								Host:      hostName,
+								Container: wantContainer != nil && *wantContainer,
+							}
+							return nil
+						})
+						if err != nil {
+							sendMessage(config, config.GroupID, topicID, fmt.Sprintf("❌ Failed to save: %v", err))
+							continue
 						}
-						saveConfig(config)
+						config = fresh
 					}
 
 					// Create work directory and tmux session
@@ -5736,8 +11709,8 @@ func listen() error {
 							time.Sleep(300 * time.Millisecond)
 						}
 					} else {
-						if tmuxSessionExists(tmuxName) {
-							killTmuxSession(tmuxName)
+						if backend().Exists(tmuxName) {
+							killLocalSession(tmuxName)
 							time.Sleep(300 * time.Millisecond)
 						}
 					}
@@ -5753,7 +11726,9 @@ func listen() error {
 						}
 
 						// Create tmux session on remote host
-						if err := sshTmuxNewSession(address, tmuxName, workDir, continueSession); err != nil {
+						err := sshTmuxNewSession(address, tmuxName, workDir, continueSession)
+						recordAudit(config, actor, cmdName, fullName, "", auditResult(err))
+						if err != nil {
 							sendMessage(config, config.GroupID, topicID, fmt.Sprintf("❌ Failed to start tmux: %v", err))
 						} else {
 							time.Sleep(500 * time.Millisecond)
@@ -5769,11 +11744,13 @@ func listen() error {
 							os.MkdirAll(workDir, 0755)
 						}
 
-						if err := createTmuxSession(tmuxName, workDir, continueSession); err != nil {
+						err := backend().Create(tmuxName, workDir, continueSession)
+						recordAudit(config, actor, cmdName, fullName, "", auditResult(err))
+						if err != nil {
 							sendMessage(config, config.GroupID, topicID, fmt.Sprintf("❌ Failed to start tmux: %v", err))
 						} else {
 							time.Sleep(500 * time.Millisecond)
-							if tmuxSessionExists(tmuxName) {
+							if backend().Exists(tmuxName) {
 								sendMessage(config, config.GroupID, topicID, fmt.Sprintf("🚀 Session '%s' started!\n\nSend messages here to interact with Claude.", fullName))
 							} else {
 								sendMessage(config, config.GroupID, topicID, fmt.Sprintf("⚠️ Session '%s' created but died immediately. Check if ~/bin/ccc works.", fullName))
@@ -5797,6 +11774,24 @@ func listen() error {
 					if sessionInfo != nil {
 						hostName = sessionInfo.Host
 					}
+					if wantContainer != nil && sessionInfo != nil {
+						if hostName != "" && *wantContainer {
+							sendMessage(config, chatID, threadID, "❌ Container sessions are only supported on the local host")
+							continue
+						}
+						fresh, err := updateConfig(func(cfg *Config) error {
+							if info := cfg.Sessions[sessionName]; info != nil {
+								info.Container = *wantContainer
+							}
+							return nil
+						})
+						if err != nil {
+							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to save: %v", err))
+							continue
+						}
+						config = fresh
+						sessionInfo = config.Sessions[sessionName]
+					}
 
 					// Extract project name for tmux session (without host prefix)
 					_, projectName := parseSessionTarget(sessionName)
@@ -5828,7 +11823,9 @@ func listen() error {
 						}
 
 						// Create tmux session on remote
-						if err := sshTmuxNewSession(address, tmuxName, workDir, continueSession); err != nil {
+						err := sshTmuxNewSession(address, tmuxName, workDir, continueSession)
+						recordAudit(config, actor, cmdName, sessionName, "", auditResult(err))
+						if err != nil {
 							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to start: %v", err))
 						} else {
 							time.Sleep(500 * time.Millisecond)
@@ -5845,8 +11842,8 @@ func listen() error {
 					} else {
 						// Local session
 						// Kill existing session if running
-						if tmuxSessionExists(tmuxName) {
-							killTmuxSession(tmuxName)
+						if backend().Exists(tmuxName) {
+							killLocalSession(tmuxName)
 							time.Sleep(300 * time.Millisecond)
 						}
 
@@ -5858,11 +11855,13 @@ func listen() error {
 							os.MkdirAll(workDir, 0755)
 						}
 
-						if err := createTmuxSession(tmuxName, workDir, continueSession); err != nil {
+						err := backend().Create(tmuxName, workDir, continueSession)
+						recordAudit(config, actor, cmdName, sessionName, "", auditResult(err))
+						if err != nil {
 							sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to start: %v", err))
 						} else {
 							time.Sleep(500 * time.Millisecond)
-							if tmuxSessionExists(tmuxName) {
+							if backend().Exists(tmuxName) {
 								action := "restarted"
 								if continueSession {
 									action = "continued"
@@ -5884,50 +11883,14 @@ func listen() error {
 				// Reload config to get latest sessions
 				config, _ = loadConfig()
 				sessionName := getSessionByTopic(config, threadID)
-				fmt.Fprintf(os.Stderr, "[msg] threadID=%d sessionName=%q\n", threadID, sessionName)
+				logFor("listen").Debug("topic message", "topic", threadID, logging.SessionKey, sessionName)
 				if sessionName != "" {
-					// Get session info to check if remote
-					sessionInfo := config.Sessions[sessionName]
-					hostName := ""
-					if sessionInfo != nil {
-						hostName = sessionInfo.Host
-					}
-
-					// Extract project name for tmux session (without host prefix)
-					_, projectName := parseSessionTarget(sessionName)
-					tmuxName := tmuxSessionName(extractProjectName(projectName))
-
-					// Ensure session is running (auto-start if stopped, auto-restart if crashed)
-					if errMsg := ensureSessionRunning(config, sessionName, sessionInfo); errMsg != "" {
-						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ %s", errMsg))
+					if spent, paused := budgetStatus(config); paused {
+						n := holdPrompt(heldPrompt{chatID, threadID, sessionName, text, msg.From.Username})
+						sendMessage(config, chatID, threadID, fmt.Sprintf("⏸ Daily budget reached ($%.2f of $%.2f). Prompt held (%d waiting), /budget resume to send now.", spent, config.DailyBudget, n))
 						continue
 					}
-
-					startContinuousTyping(config, chatID, threadID, sessionName)
-					// Store in history
-					appendHistory(threadID, HistoryMessage{
-						ID:        nextMessageID(),
-						Timestamp: time.Now().Unix(),
-						From:      "human",
-						Text:      text,
-						Username:  msg.From.Username,
-					})
-					markTelegramSent(threadID)
-
-					// Send to tmux (remote or local)
-					var sendErr error
-					if hostName != "" {
-						address := getHostAddress(config, hostName)
-						sendErr = sshTmuxSendKeys(address, tmuxName, text)
-					} else {
-						sendErr = sendToTmux(tmuxName, text)
-					}
-					if sendErr != nil {
-						stopContinuousTyping(sessionName)
-						sendMessage(config, chatID, threadID, fmt.Sprintf("❌ Failed to send: %v", sendErr))
-					}
-					// Background capture for remote sessions (fallback if client-mode forwarding is inactive)
-					captureResponseAsync(config, sessionName, sessionInfo)
+					deliverPrompt(config, chatID, threadID, sessionName, text, msg.From.Username)
 					continue
 				}
 			}
@@ -5964,7 +11927,7 @@ func listen() error {
 							output = fmt.Sprintf("⚠️ %s\n\nExit: %v", output, err)
 						}
 					}
-					sendMessage(config, cid, 0, output)
+					sendClaudeMessage(config, cid, 0, "", output)
 				}(prompt, chatID)
 			}
 		}
@@ -5989,8 +11952,17 @@ COMMANDS:
     setgroup                Configure Telegram group for topics (if skipped during setup)
     listen                  Start the Telegram bot listener manually
     install                 Install Claude hook manually
+    attach <name>           Attach to a local session (Ctrl-] detaches pty sessions)
+    list [--tag t] [--host h] [filters]  List sessions (same filters as /list)
+    replay <session> [--since t] [--speed n]  Play a session's recording in the terminal
+    logs [--follow] [--session s] [--component c]  Show the log (also --level, --since, --id, -n)
+    audit [n] [--verify]             Show the last audited actions or check the hash chain
+    secrets encrypt|set <name>|list|keyring
+                                     Move the bot token and API keys out of plaintext
     run                     Run Claude directly (used by tmux sessions)
     hook                    Handle Claude hook (internal)
+    pty-serve               Serve a pty backend session (internal)
+    record-pipe             Append pane output to a recording (internal)
 
 HOST MANAGEMENT (for remote sessions):
     host add <name> <addr> [dir]  Add remote host
@@ -6010,11 +11982,25 @@ TELEGRAM COMMANDS:
     /away                   Toggle away mode (notifications)
     /new [host:]<name>      Create new session (remote or local)
     /new ~/path/name        Create session with custom path
+    /new --container <name> Create session running Claude in a container
     /new                    Restart session in current topic
     /continue [host:]<name> Create session with conversation history
     /continue               Restart with -c flag in current topic
     /kill <name>            Kill a session (keeps topic)
-    /list                   List sessions with status (🟢/⚪)
+    /rename [old] <new>     Rename a session, its tmux session and its topic
+    /archive [name]         Stop a session, close its topic, compress its history
+    /purge [name]           Delete a session's topic, history and config entry (asks first)
+    /broadcast <sel> <text> Send a prompt to several sessions, summary to private chat
+    /pipelines              List pipelines (enable|disable <name>)
+    /list [filters]         List sessions with status (🟢/⚪); filters: tag:<t> host:<h>
+                            <glob> <text>, sort:name|activity|status, group:host|tag|none
+    /tag [session] <tag>... Tag a session (/untag removes tags)
+    /describe [session] <text>  Set a session description ("-" clears)
+    /vocabulary [session] <words>  Names and terms that guide voice transcription ("-" clears)
+    /voicereply [on|off|default]   Spoken summaries of answers in a topic (private chat: for your prompts)
+    /idle [hours|off|default]   Stop the topic's session after hours idle
+    /autorestart [on|off|default]  Restart Claude in the topic's session after crashes
+    /recording [on|off|get [since]|trim <since>]  Record the topic's terminal (asciicast)
     /setdir [host:]<path>   Set projects directory
     /c <cmd>                Execute local shell command
     /rc <host> <cmd>        Execute command on remote host
@@ -6078,14 +12064,197 @@ func main() {
 		return
 	}
 
+	// Inside a container session, or one started without the secrets, hooks
+	// run through a relay
+	if socket := os.Getenv(container.RelayEnv); socket != "" && strings.HasPrefix(os.Args[1], "hook") {
+		os.Exit(relayHook(socket, os.Args[1]))
+	}
+
 	switch os.Args[1] {
 	case "run":
 		// Run claude directly (used inside tmux sessions)
 		continueSession := len(os.Args) > 2 && os.Args[2] == "-c"
-		if err := runClaudeRaw(continueSession); err != nil {
+		if err := runSessionClaude(continueSession); err != nil {
+			os.Exit(1)
+		}
+		return
+	case "pty-serve":
+		// Serve a pty backend session (started detached by ptyBackend.Create)
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: ccc pty-serve <tmux-name> <dir> [-c]")
+			os.Exit(1)
+		}
+		continueSession := len(os.Args) > 4 && os.Args[4] == "-c"
+		if err := servePTYSession(os.Args[2], os.Args[3], continueSession); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "voice-reply":
+		// Speak an answer in its topic (started detached by the Stop hook)
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: ccc voice-reply <session> <topic-id> <file>")
+			os.Exit(1)
+		}
+		topicID, _ := strconv.ParseInt(os.Args[3], 10, 64)
+		data, err := os.ReadFile(os.Args[4])
+		os.Remove(os.Args[4])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sendVoiceReply(cfg, os.Args[2], topicID, string(data))
+		return
+	case "pipeline-run":
+		// Forward a finished turn to its pipelines (started detached by the Stop hook)
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: ccc pipeline-run <session> <file>")
+			os.Exit(1)
+		}
+		data, err := os.ReadFile(os.Args[3])
+		os.Remove(os.Args[3])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		runPipelines(cfg, os.Args[2], string(data))
+		return
+	case "record-pipe":
+		// Append a pane's output to a recording (started by tmux pipe-pane)
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: ccc record-pipe <file> <width> <height>")
+			os.Exit(1)
+		}
+		width, _ := strconv.Atoi(os.Args[3])
+		height, _ := strconv.Atoi(os.Args[4])
+		if err := recording.Record(os.Stdin, os.Args[2], width, height, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "record-trim":
+		// Trim a recording on this machine (run over SSH by /recording trim)
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: ccc record-trim <file> <unix-time>")
+			os.Exit(1)
+		}
+		since, err := strconv.ParseInt(os.Args[3], 10, 64)
+		if err == nil {
+			err = recording.Trim(os.Args[2], time.Unix(since, 0))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	case "record-prune":
+		// Apply recording retention on this machine (run over SSH by the listener)
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: ccc record-prune <max-age-seconds> <max-bytes>")
+			os.Exit(1)
+		}
+		maxAge, err1 := strconv.ParseInt(os.Args[2], 10, 64)
+		maxBytes, err2 := strconv.ParseInt(os.Args[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			fmt.Println("Usage: ccc record-prune <max-age-seconds> <max-bytes>")
+			os.Exit(1)
+		}
+		removed, err := recording.Prune(recordingsDir(), time.Duration(maxAge)*time.Second, maxBytes, recordingKeepRecent, time.Now())
+		for _, path := range removed {
+			fmt.Println(filepath.Base(path))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
+	case "replay":
+		var target, since string
+		speed := 1.0
+		for i := 2; i < len(os.Args); i++ {
+			switch {
+			case os.Args[i] == "--since" && i+1 < len(os.Args):
+				since = os.Args[i+1]
+				i++
+			case os.Args[i] == "--speed" && i+1 < len(os.Args):
+				speed, _ = strconv.ParseFloat(os.Args[i+1], 64)
+				i++
+			default:
+				target = os.Args[i]
+			}
+		}
+		if target == "" {
+			fmt.Println("Usage: ccc replay <session|file.cast> [--since 30m|15:04] [--speed 2]")
+			os.Exit(1)
+		}
+		if err := replayRecording(target, since, speed); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "logs":
+		if err := showLogs(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintln(os.Stderr, "Usage: ccc logs [--follow] [--session name] [--component hook] [--level warn] [--since 1h] [--id cid] [-n 100]")
+			os.Exit(1)
+		}
+	case "secrets":
+		if err := manageSecrets(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintln(os.Stderr, "Usage: ccc secrets encrypt|set <name>|list|keyring")
+			os.Exit(1)
+		}
+	case "audit":
+		if err := showAudit(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprintln(os.Stderr, "Usage: ccc audit [n] [--verify]")
+			os.Exit(1)
+		}
+	case "attach":
+		if len(os.Args) < 3 {
+			fmt.Println("Usage: ccc attach <name>")
+			os.Exit(1)
+		}
+		tmuxName := tmuxSessionName(os.Args[2])
+		if !backend().Exists(tmuxName) {
+			fmt.Fprintf(os.Stderr, "Error: no %s session named %s\n", backend().Name(), os.Args[2])
+			os.Exit(1)
+		}
+		if err := backend().Attach(tmuxName); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "list":
+		var args []string
+		for i := 2; i < len(os.Args); i++ {
+			flag := strings.TrimPrefix(os.Args[i], "--")
+			if (flag == "tag" || flag == "host" || flag == "sort" || flag == "group") && flag != os.Args[i] && i+1 < len(os.Args) {
+				args = append(args, flag+":"+os.Args[i+1])
+				i++
+				continue
+			}
+			args = append(args, os.Args[i])
+		}
+		opts, err := parseListArgs(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(formatSessionList(collectSessionList(cfg, opts), opts))
 	case "setup":
 		if len(os.Args) < 3 {
 			fmt.Println("Usage: ccc setup <bot_token>")
@@ -6156,24 +12325,28 @@ func main() {
 		}
 
 	case "hook":
+		reportHook("stop")
 		if err := handleHook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
 	case "hook-permission":
+		reportHook("permission")
 		if err := handlePermissionHook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
 	case "hook-prompt":
+		reportHook("prompt")
 		if err := handlePromptHook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
 	case "hook-question":
+		reportHook("question")
 		if err := handleQuestionHook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -6209,6 +12382,7 @@ func main() {
 		fmt.Println(topicID)
 
 	case "hook-output":
+		reportHook("output")
 		if err := handleOutputHook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)